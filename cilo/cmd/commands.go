@@ -6,13 +6,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/sharedco/cilo/pkg/config"
 	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
 	"github.com/sharedco/cilo/pkg/runtime"
-	"github.com/sharedco/cilo/pkg/runtime/docker"
 	"github.com/sharedco/cilo/pkg/state"
 	"github.com/spf13/cobra"
 )
@@ -58,10 +60,18 @@ Use --all to see all environments across all projects.`,
 					}
 				}
 				envs = projectEnvs
-				fmt.Printf("Environments for project: %s\n\n", currentProject)
+				fmt.Fprintf(messageOut, "Environments for project: %s\n\n", currentProject)
 			}
 		}
 
+		if structuredOutput() {
+			items := make([]output.Environment, 0, len(envs))
+			for _, env := range envs {
+				items = append(items, output.NewEnvironment(env, state.GetEnvStoragePath(env.Project, env.Name)))
+			}
+			return writeDocument(output.NewEnvironmentList(items))
+		}
+
 		if len(envs) == 0 {
 			if allFlag {
 				fmt.Println("No environments found")
@@ -93,22 +103,54 @@ var statusCmd = &cobra.Command{
 			return err
 		}
 
+		checkLookups()
 		env, err := state.GetEnvironment(project, name)
 		if err != nil {
 			return err
 		}
 
+		if structuredOutput() {
+			return writeEnvironment(env)
+		}
+
 		fmt.Printf("Environment: %s\n", env.Name)
 		fmt.Printf("Status: %s\n", env.Status)
 		fmt.Printf("Created: %s\n", env.CreatedAt.Format("2006-01-02 15:04:05"))
+		fmt.Printf("Last active: %s\n", describeLastActivity(env.LastActivity))
+		if !env.ExpiresAt.IsZero() {
+			fmt.Printf("Expires: %s\n", env.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+		}
+		if env.Host != "" && env.Host != state.LocalHostID {
+			fmt.Printf("Host: %s\n", env.Host)
+		}
 		fmt.Printf("Subnet: %s\n", env.Subnet)
+		if env.Profile != "" {
+			fmt.Printf("Profile: %s\n", env.Profile)
+		}
+		if len(env.Images) > 0 {
+			services := make([]string, 0, len(env.Images))
+			for svc := range env.Images {
+				services = append(services, svc)
+			}
+			sort.Strings(services)
+			fmt.Printf("Images:\n")
+			for _, svc := range services {
+				fmt.Printf("  %s: %s\n", svc, env.Images[svc])
+			}
+		}
 		if env.Source != "" {
 			fmt.Printf("Source: %s\n", env.Source)
 		}
+		if len(env.SourceRepos) > 0 {
+			fmt.Printf("Source repos (at creation):\n")
+			for _, repo := range env.SourceRepos {
+				fmt.Printf("  %s\n", describeRepoSnapshot(repo))
+			}
+		}
 
 		dnsSuffix := ".test"
 		workspace := config.GetEnvPath(project, name)
-		projectConfig, _ := models.LoadProjectConfigFromPath(workspace)
+		projectConfig, _ := models.LoadEnvironmentConfig(env)
 		if projectConfig != nil && projectConfig.DNSSuffix != "" {
 			dnsSuffix = projectConfig.DNSSuffix
 		}
@@ -150,12 +192,14 @@ var logsCmd = &cobra.Command{
 		follow, _ := cmd.Flags().GetBool("follow")
 		tail, _ := cmd.Flags().GetInt("tail")
 
-		if _, err := state.GetEnvironment(project, name); err != nil {
+		provider, err := newEngine().ProviderFor(project, name)
+		if err != nil {
 			return err
 		}
-
-		provider := docker.NewProvider()
 		ctx := context.Background()
+		if err := provider.Ping(ctx); err != nil {
+			return err
+		}
 		return provider.Logs(ctx, project, name, service, runtime.LogOptions{
 			Follow: follow,
 			Tail:   tail,
@@ -183,12 +227,20 @@ var execCmd = &cobra.Command{
 		interactive, _ := cmd.Flags().GetBool("interactive")
 		tty, _ := cmd.Flags().GetBool("tty")
 
-		if _, err := state.GetEnvironment(project, name); err != nil {
+		// Exec counts as use, and brings back a suspended env
+		ctx := context.Background()
+		e := newEngine()
+		if _, err := e.Resume(ctx, project, name); err != nil {
 			return err
 		}
 
-		provider := docker.NewProvider()
-		ctx := context.Background()
+		provider, err := e.ProviderFor(project, name)
+		if err != nil {
+			return err
+		}
+		if err := provider.Ping(ctx); err != nil {
+			return err
+		}
 		return provider.Exec(ctx, project, name, service, command, runtime.ExecOptions{
 			Interactive: interactive,
 			TTY:         tty,
@@ -230,12 +282,14 @@ var composeCmd = &cobra.Command{
 			composeArgs = []string{"ps"}
 		}
 
-		if _, err := state.GetEnvironment(project, name); err != nil {
+		provider, err := newEngine().ProviderFor(project, name)
+		if err != nil {
 			return err
 		}
-
-		provider := docker.NewProvider()
 		ctx := context.Background()
+		if err := provider.Ping(ctx); err != nil {
+			return err
+		}
 		return provider.Compose(ctx, project, name, runtime.ComposeOptions{
 			Args: composeArgs,
 		})
@@ -243,6 +297,7 @@ var composeCmd = &cobra.Command{
 }
 
 func init() {
+	listCmd.Annotations = structured
 	listCmd.Flags().String("format", "table", "Output format: table, json, quiet")
 	listCmd.Flags().Bool("all", false, "Show all environments across all projects")
 	listCmd.Flags().String("project", "", "Filter to specific project name")
@@ -253,6 +308,7 @@ func init() {
 	execCmd.Flags().BoolP("interactive", "i", true, "Keep STDIN open")
 	execCmd.Flags().BoolP("tty", "t", true, "Allocate a pseudo-TTY")
 
+	statusCmd.Annotations = structured
 	statusCmd.Flags().String("project", "", "Project name (defaults to configured project)")
 	logsCmd.Flags().String("project", "", "Project name (defaults to configured project)")
 	execCmd.Flags().String("project", "", "Project name (defaults to configured project)")
@@ -263,11 +319,11 @@ func init() {
 func listTable(envs []*models.Environment, all bool) error {
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 	if all {
-		fmt.Fprintf(w, "PROJECT\tNAME\tSTATUS\tSERVICES\tCREATED\t\n")
-		fmt.Fprintf(w, "-------\t----\t------\t--------\t-------\t\n")
+		fmt.Fprintf(w, "PROJECT\tNAME\tSTATUS\tBRANCH\tSERVICES\tCREATED\tLAST ACTIVE\t\n")
+		fmt.Fprintf(w, "-------\t----\t------\t------\t--------\t-------\t-----------\t\n")
 	} else {
-		fmt.Fprintf(w, "NAME\tSTATUS\tSERVICES\tCREATED\t\n")
-		fmt.Fprintf(w, "----\t------\t--------\t-------\t\n")
+		fmt.Fprintf(w, "NAME\tSTATUS\tBRANCH\tSERVICES\tCREATED\tLAST ACTIVE\t\n")
+		fmt.Fprintf(w, "----\t------\t------\t--------\t-------\t-----------\t\n")
 	}
 
 	for _, env := range envs {
@@ -281,10 +337,12 @@ func listTable(envs []*models.Environment, all bool) error {
 		}
 
 		created := env.CreatedAt.Format("Jan 02 15:04")
+		branch := sourceBranch(env)
+		lastActive := describeLastActivity(env.LastActivity)
 		if all {
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", env.Project, env.Name, env.Status, serviceList, created)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", env.Project, env.Name, env.Status, branch, serviceList, created, lastActive)
 		} else {
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", env.Name, env.Status, serviceList, created)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", env.Name, env.Status, branch, serviceList, created, lastActive)
 		}
 	}
 
@@ -309,11 +367,13 @@ func listJSON(envs []*models.Environment, all bool) error {
 		}
 
 		output = append(output, map[string]interface{}{
-			"name":       env.Name,
-			"status":     env.Status,
-			"created_at": env.CreatedAt,
-			"subnet":     env.Subnet,
-			"services":   services,
+			"name":          env.Name,
+			"status":        env.Status,
+			"created_at":    env.CreatedAt,
+			"last_activity": env.LastActivity,
+			"subnet":        env.Subnet,
+			"services":      services,
+			"source_repos":  env.SourceRepos,
 		})
 	}
 
@@ -332,6 +392,73 @@ func listQuiet(envs []*models.Environment, all bool) error {
 	return nil
 }
 
+// describeLastActivity formats a last-activity time relative to now
+func describeLastActivity(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	ago := time.Since(t)
+	switch {
+	case ago < time.Minute:
+		return "just now"
+	case ago < time.Hour:
+		return fmt.Sprintf("%dm ago", int(ago.Minutes()))
+	case ago < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(ago.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(ago.Hours()/24))
+	}
+}
+
+// sourceBranch returns the branch an env was created from, preferring the
+// repo at the source root
+func sourceBranch(env *models.Environment) string {
+	if len(env.SourceRepos) == 0 {
+		return "-"
+	}
+	repo := env.SourceRepos[0]
+	for _, r := range env.SourceRepos {
+		if r.Path == "." {
+			repo = r
+			break
+		}
+	}
+	switch {
+	case repo.Branch != "":
+		return repo.Branch
+	case repo.Commit != "":
+		return shortCommit(repo.Commit)
+	default:
+		return "-"
+	}
+}
+
+// describeRepoSnapshot formats a repo snapshot as "path (branch @ commit, dirty)"
+func describeRepoSnapshot(repo models.RepoSnapshot) string {
+	var parts []string
+	ref := "detached"
+	if repo.Branch != "" {
+		ref = repo.Branch
+	}
+	if repo.Commit != "" {
+		ref += " @ " + shortCommit(repo.Commit)
+	} else {
+		ref += " (no commits)"
+	}
+	parts = append(parts, ref)
+	if repo.Dirty {
+		parts = append(parts, "dirty")
+	}
+	return fmt.Sprintf("%s (%s)", repo.Path, strings.Join(parts, ", "))
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
 // contains checks if a string slice contains a value
 func contains(slice []string, value string) bool {
 	for _, item := range slice {
@@ -3,9 +3,17 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/sharedco/cilo/pkg/engine"
 	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
+	"github.com/sharedco/cilo/pkg/state"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 var configCmd = &cobra.Command{
@@ -13,38 +21,134 @@ var configCmd = &cobra.Command{
 	Short: "Show or manage project configuration",
 	Long: `Show or manage the cilo configuration for the current project.
 
-This command reads and displays the .cilo/config.yml file.
+The configuration is layered, each layer overriding the ones before:
+
+  ~/.cilo/config.yml        your defaults for every project
+  .cilo/config.yml          the project's config, committed with it
+  .cilo/config.local.yml    this checkout only; keep it out of git
+  environment overrides     one environment's own settings, set with
+                            'cilo config override <env>'
+
+Mappings merge key by key. Any other value, a list included, replaces the
+value of the layers before it; an empty value leaves it as it was.
 
 Examples:
   # Show current configuration
   cilo config
 
+  # Show where each value comes from
+  cilo config --explain
+  cilo config --explain --env feature-x
+
   # Show configuration in different formats
   cilo config --format yaml
-  cilo config --format json`,
+  cilo config --format json
+
+  # Change a value, keeping the file's comments
+  cilo config set idle.timeout 2h
+  cilo config add shared_services postgres
+
+  # Check the configuration and the files it refers to
+  cilo config validate`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		format, _ := cmd.Flags().GetString("format")
+		explain, _ := cmd.Flags().GetBool("explain")
+		envName, _ := cmd.Flags().GetString("env")
 
-		// Check if we're in a configured project
-		config, err := models.LoadProjectConfig()
+		layered, err := loadLayeredConfig(cmd, envName)
 		if err != nil {
-			return fmt.Errorf("failed to load project config: %w", err)
+			return err
 		}
-		if config == nil {
-			return fmt.Errorf("no project configured in current directory\n\nRun 'cilo setup' to configure this project")
+		config := layered.Config
+
+		if structuredOutput() {
+			doc, err := output.NewProjectConfig(config)
+			if err != nil {
+				return err
+			}
+			if explain {
+				doc.Sources = layered.Sources
+			}
+			return writeDocument(doc)
 		}
 
+		if explain {
+			return explainConfig(layered)
+		}
 		switch format {
 		case "json":
 			return showConfigJSON(config)
 		case "yaml", "yml":
-			return showConfigYAML(config)
+			return showConfigYAML(layered)
 		default:
 			return showConfigTable(config)
 		}
 	},
 }
 
+// loadLayeredConfig loads the current project's config, or with envName
+// the config that environment runs with
+func loadLayeredConfig(cmd *cobra.Command, envName string) (*models.LayeredConfig, error) {
+	dir, overrides := ".", ""
+	if envName != "" {
+		project, name, err := getProjectAndEnv(cmd, []string{envName})
+		if err != nil {
+			return nil, err
+		}
+		env, err := state.GetEnvironment(project, name)
+		if err != nil {
+			return nil, err
+		}
+		dir, overrides = state.GetEnvStoragePath(project, name), env.Config
+	}
+
+	layered, err := models.LoadLayeredConfig(dir, overrides)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+	if layered == nil {
+		if envName != "" {
+			return nil, fmt.Errorf("environment %s has no .cilo/config.yml in its workspace", envName)
+		}
+		return nil, fmt.Errorf("no project configured in current directory\n\nRun 'cilo setup' to configure this project")
+	}
+	return layered, nil
+}
+
+// explainConfig prints each value set and the layer it came from
+func explainConfig(layered *models.LayeredConfig) error {
+	paths := make([]string, 0, len(layered.Sources))
+	for path := range layered.Sources {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "KEY\tVALUE\tSOURCE\t\n")
+	fmt.Fprintf(w, "---\t-----\t------\t\n")
+	for _, path := range paths {
+		fmt.Fprintf(w, "%s\t%s\t%s\t\n", path, configValue(models.GetConfigPath(layered.Node, path)), layered.Sources[path])
+	}
+	return w.Flush()
+}
+
+// configValue formats a config value on one line
+func configValue(node *yaml.Node) string {
+	if node == nil {
+		return ""
+	}
+	if node.Kind == yaml.ScalarNode {
+		return node.Value
+	}
+	flow := *node
+	flow.Style = yaml.FlowStyle
+	data, err := yaml.Marshal(&flow)
+	if err != nil {
+		return "?"
+	}
+	return strings.TrimSpace(string(data))
+}
+
 func showConfigTable(config *models.ProjectConfig) error {
 	fmt.Printf("Project Configuration\n")
 	fmt.Printf("=====================\n\n")
@@ -54,9 +158,15 @@ func showConfigTable(config *models.ProjectConfig) error {
 	if config.DNSSuffix != "" {
 		fmt.Printf("DNS Suffix:        %s\n", config.DNSSuffix)
 	}
+	if config.DefaultIngressService != "" {
+		fmt.Printf("Ingress:           %s\n", config.DefaultIngressService)
+	}
 	if len(config.Hostnames) > 0 {
 		fmt.Printf("Hostnames:         %v\n", config.Hostnames)
 	}
+	if len(config.SharedServices) > 0 {
+		fmt.Printf("Shared Services:   %v\n", config.SharedServices)
+	}
 	fmt.Printf("\nCompose Files:\n")
 	for i, f := range config.ComposeFiles {
 		fmt.Printf("  %d. %s\n", i+1, f)
@@ -76,20 +186,33 @@ func showConfigTable(config *models.ProjectConfig) error {
 	return nil
 }
 
+// showConfigJSON prints the same document as --output json
 func showConfigJSON(config *models.ProjectConfig) error {
-	// Read raw file for accurate YAML output
-	data, err := os.ReadFile(".cilo/config.yml")
+	doc, err := output.NewProjectConfig(config)
 	if err != nil {
 		return err
 	}
-	// For now, just print the raw YAML since converting to JSON is extra work
-	// and the yaml package doesn't have a simple MarshalToJSON
-	fmt.Print(string(data))
-	return nil
+	return output.Write(os.Stdout, output.FormatJSON, doc)
 }
 
-func showConfigYAML(config *models.ProjectConfig) error {
-	data, err := os.ReadFile(".cilo/config.yml")
+// showConfigYAML prints .cilo/config.yml as written, or the merged config
+// when other layers change it
+func showConfigYAML(layered *models.LayeredConfig) error {
+	if len(layered.Layers) == 1 && layered.Layers[0].Layer == models.LayerProject {
+		data, err := os.ReadFile(".cilo/config.yml")
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+		return nil
+	}
+
+	layers := make([]string, len(layered.Layers))
+	for i, layer := range layered.Layers {
+		layers[i] = layer.File
+	}
+	fmt.Printf("# Merged from %s\n", strings.Join(layers, ", "))
+	data, err := yaml.Marshal(layered.Node)
 	if err != nil {
 		return err
 	}
@@ -97,6 +220,170 @@ func showConfigYAML(config *models.ProjectConfig) error {
 	return nil
 }
 
+var configOverrideCmd = &cobra.Command{
+	Use:   "override <env> [path=value...]",
+	Short: "Override config for one environment",
+	Long: `Set config values for one environment only. They are stored with the
+environment and layered over its workspace's config, so they outlive changes
+to the project's files.
+
+Paths are dotted, as in idle.timeout or resources.memory. Values are read as
+YAML: "[a, b]" is a list and "2" a number. With no values or flags, prints the
+environment's overrides. Restart the environment for them to apply.
+
+Examples:
+  cilo config override feature-x idle.timeout=8h resources.memory=4g
+  cilo config override feature-x 'shared_services=[postgres]'
+  cilo config override feature-x --unset idle.timeout
+  cilo config override feature-x --clear`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		unset, _ := cmd.Flags().GetStringArray("unset")
+		clear, _ := cmd.Flags().GetBool("clear")
+
+		project, name, err := getProjectAndEnv(cmd, args)
+		if err != nil {
+			return err
+		}
+		env, err := state.GetEnvironment(project, name)
+		if err != nil {
+			return err
+		}
+		if len(args) == 1 && len(unset) == 0 && !clear {
+			if env.Config == "" {
+				fmt.Printf("%s has no config overrides\n", name)
+				return nil
+			}
+			fmt.Print(env.Config)
+			return nil
+		}
+
+		var doc yaml.Node
+		if !clear {
+			if err := yaml.Unmarshal([]byte(env.Config), &doc); err != nil {
+				return fmt.Errorf("failed to parse overrides of %s: %w", name, err)
+			}
+		}
+		if doc.Kind == 0 {
+			doc.Kind = yaml.DocumentNode
+		}
+		for _, path := range unset {
+			if !models.UnsetConfigPath(&doc, path) {
+				fmt.Printf("%s is not overridden\n", path)
+			}
+		}
+		for _, arg := range args[1:] {
+			path, raw, ok := strings.Cut(arg, "=")
+			if !ok || path == "" {
+				return fmt.Errorf("invalid override %q (use path=value)", arg)
+			}
+			value, err := models.ParseConfigValue(raw)
+			if err != nil {
+				return err
+			}
+			if err := models.SetConfigPath(&doc, path, value); err != nil {
+				return err
+			}
+		}
+
+		overrides := ""
+		if root := doc.Content; len(root) > 0 && len(root[0].Content) > 0 {
+			data, err := yaml.Marshal(&doc)
+			if err != nil {
+				return err
+			}
+			overrides = string(data)
+		}
+		// Check the overrides against the schema before storing them
+		if _, err := models.LoadLayeredConfig(state.GetEnvStoragePath(project, name), overrides); err != nil {
+			return fmt.Errorf("overrides are invalid: %w", err)
+		}
+		if err := state.SetEnvironmentConfig(project, name, overrides); err != nil {
+			return err
+		}
+
+		fmt.Printf("✓ Config overrides of %s updated\n", name)
+		if env.Status == "running" {
+			fmt.Printf("Run 'cilo up %s' to apply them\n", name)
+		}
+		return nil
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [dir]",
+	Short: "Check the project configuration",
+	Long: `Check .cilo/config.yml in the current directory, or in dir.
+
+The config is checked against its JSON Schema (see 'cilo config schema'), so
+unknown fields and invalid values are reported with their line. Then the
+compose and env files it refers to must exist, the services it names must be
+defined, hostnames must be valid DNS names, and the compose files may only
+use the cilo.ingress, cilo.share and cilo.hostnames labels.
+
+Exits with code 8 (invalid_config) if anything is wrong.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := "."
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".cilo", "config.yml")); os.IsNotExist(err) {
+			return fmt.Errorf("no project configured in %s\n\nRun 'cilo setup' to configure this project", dir)
+		}
+
+		problems, err := engine.ValidateConfig(dir)
+		if err != nil {
+			return err
+		}
+		if structuredOutput() {
+			if err := writeDocument(output.NewConfigValidation(problems)); err != nil {
+				return err
+			}
+		} else if len(problems) == 0 {
+			fmt.Printf("✓ .cilo/config.yml is valid\n")
+		} else {
+			for _, problem := range problems {
+				fmt.Printf("✗ %s\n", problem)
+			}
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%d problem(s) found: %w", len(problems), models.ErrInvalidConfig)
+		}
+		return nil
+	},
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of .cilo/config.yml",
+	Long: `Print the JSON Schema of .cilo/config.yml, for editors that complete and
+check YAML against a schema.
+
+Examples:
+  cilo config schema > .cilo/config.schema.json
+  # then, as the first line of .cilo/config.yml:
+  # yaml-language-server: $schema=config.schema.json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, err := os.Stdout.Write(models.ConfigSchema)
+		return err
+	},
+}
+
 func init() {
+	configCmd.Annotations = structured
 	configCmd.Flags().String("format", "table", "Output format: table, yaml, json")
+	configCmd.Flags().Bool("explain", false, "Show the layer each value comes from")
+	configCmd.Flags().String("env", "", "Show the config this environment runs with, overrides included")
+	configCmd.Flags().String("project", "", "Project of --env (defaults to configured project)")
+
+	configValidateCmd.Annotations = structured
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configSchemaCmd)
+
+	configOverrideCmd.Flags().StringArray("unset", nil, "Remove an override (repeatable)")
+	configOverrideCmd.Flags().Bool("clear", false, "Remove every override first")
+	configOverrideCmd.Flags().String("project", "", "Project name (defaults to configured project)")
+	configCmd.AddCommand(configOverrideCmd)
 }
@@ -0,0 +1,367 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/engine"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
+	"github.com/sharedco/cilo/pkg/state"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <path>",
+	Short: "Print a config value",
+	Long: `Print the effective value at a dotted path, such as idle.timeout or
+env.render.0.file, with every config layer applied. Scalars print bare;
+mappings and lists print as YAML.
+
+Exits with code 2 (not_found) if nothing sets the value.
+
+Examples:
+  cilo config get dns_suffix
+  cilo config get shared_services
+  cilo config get idle.timeout --env feature-x`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		envName, _ := cmd.Flags().GetString("env")
+		layered, err := loadLayeredConfig(cmd, envName)
+		if err != nil {
+			return err
+		}
+		node := models.GetConfigPath(layered.Node, args[0])
+		if node == nil {
+			return output.WithCode(output.CodeNotFound, fmt.Errorf("%s is not set", args[0]))
+		}
+		if node.Kind == yaml.ScalarNode {
+			fmt.Println(node.Value)
+			return nil
+		}
+		data, err := yaml.Marshal(node)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <path> <value>",
+	Short: "Set a config value",
+	Long: `Set the value at a dotted path in .cilo/config.yml, or with --local or
+--user in .cilo/config.local.yml or ~/.cilo/config.yml. The value is read as
+YAML, so "2" is a number and "[a, b]" a list. The file keeps its comments and
+key order, and isn't written if the change would make it invalid.
+
+Examples:
+  cilo config set dns_suffix .localhost
+  cilo config set idle.timeout 2h
+  cilo config set resources.memory 4g --user`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		value, err := models.ParseConfigValue(args[1])
+		if err != nil {
+			return err
+		}
+		return changeConfigFile(cmd, func(doc *yaml.Node) (string, error) {
+			if err := models.SetConfigPath(doc, args[0], value); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Set %s", args[0]), nil
+		})
+	},
+}
+
+var configUnsetCmd = &cobra.Command{
+	Use:   "unset <path>",
+	Short: "Remove a config value",
+	Long: `Remove the value at a dotted path from .cilo/config.yml, or with --local
+or --user from .cilo/config.local.yml or ~/.cilo/config.yml, so the layers
+before it apply again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return changeConfigFile(cmd, func(doc *yaml.Node) (string, error) {
+			if !models.UnsetConfigPath(doc, args[0]) {
+				return "", nil
+			}
+			return fmt.Sprintf("Removed %s", args[0]), nil
+		})
+	},
+}
+
+var configAddCmd = &cobra.Command{
+	Use:   "add <path> <value>...",
+	Short: "Add items to a config list",
+	Long: `Add items to the list at a dotted path, such as shared_services or
+hostnames, in .cilo/config.yml, or with --local or --user in
+.cilo/config.local.yml or ~/.cilo/config.yml. Items already in the list are
+skipped.
+
+Examples:
+  cilo config add shared_services postgres redis
+  cilo config add hostnames admin`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return changeConfigFile(cmd, func(doc *yaml.Node) (string, error) {
+			var added []string
+			for _, raw := range args[1:] {
+				value, err := models.ParseConfigValue(raw)
+				if err != nil {
+					return "", err
+				}
+				ok, err := models.AddConfigListItem(doc, args[0], value)
+				if err != nil {
+					return "", err
+				}
+				if ok {
+					added = append(added, raw)
+				} else {
+					fmt.Printf("%s already has %s\n", args[0], raw)
+				}
+			}
+			if len(added) == 0 {
+				return "", nil
+			}
+			return fmt.Sprintf("Added %s to %s", strings.Join(added, ", "), args[0]), nil
+		})
+	},
+}
+
+var configRemoveCmd = &cobra.Command{
+	Use:   "remove <path> <value>...",
+	Short: "Remove items from a config list",
+	Long: `Remove items from the list at a dotted path in .cilo/config.yml, or with
+--local or --user in .cilo/config.local.yml or ~/.cilo/config.yml.
+
+Examples:
+  cilo config remove shared_services redis`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return changeConfigFile(cmd, func(doc *yaml.Node) (string, error) {
+			var removed []string
+			for _, value := range args[1:] {
+				ok, err := models.RemoveConfigListItem(doc, args[0], value)
+				if err != nil {
+					return "", err
+				}
+				if ok {
+					removed = append(removed, value)
+				} else {
+					fmt.Printf("%s doesn't have %s\n", args[0], value)
+				}
+			}
+			if len(removed) == 0 {
+				return "", nil
+			}
+			return fmt.Sprintf("Removed %s from %s", strings.Join(removed, ", "), args[0]), nil
+		})
+	},
+}
+
+var configEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Edit the config in $EDITOR",
+	Long: `Open .cilo/config.yml, or with --local or --user .cilo/config.local.yml
+or ~/.cilo/config.yml, in $VISUAL or $EDITOR (vi if neither is set). The
+edited file is checked against the schema before it replaces the config;
+if it's invalid, the problems are shown and you can edit it again.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, layer, err := openConfigLayer(cmd)
+		if err != nil {
+			return err
+		}
+		original, err := os.ReadFile(file.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		tmp, err := os.CreateTemp("", "cilo-config-*.yml")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(original); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+
+		for {
+			if err := runEditor(tmp.Name()); err != nil {
+				return err
+			}
+			edited, err := os.ReadFile(tmp.Name())
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(edited, original) {
+				fmt.Printf("No changes to %s\n", file.Name)
+				return nil
+			}
+
+			err = file.SaveBytes(edited)
+			var configErr *models.ConfigError
+			if !errors.As(err, &configErr) {
+				if err != nil {
+					return err
+				}
+				break
+			}
+			for _, problem := range configErr.Problems {
+				fmt.Printf("✗ %s\n", problem)
+			}
+			fmt.Print("Edit again? [Y/n] ")
+			var response string
+			fmt.Scanln(&response)
+			if response = strings.ToLower(response); response == "n" || response == "no" {
+				return fmt.Errorf("%s not changed: %w", file.Name, err)
+			}
+		}
+
+		fmt.Printf("✓ Updated %s\n", file.Name)
+		if layer != models.LayerUser {
+			// The schema passed; point out what else is wrong, as validate would
+			if problems, err := engine.ValidateConfig("."); err == nil {
+				for _, problem := range problems {
+					fmt.Printf("Warning: %s\n", problem)
+				}
+			}
+		}
+		warnOutOfDate(file, layer)
+		return nil
+	},
+}
+
+// openConfigLayer opens the config file the --user and --local flags
+// choose, .cilo/config.yml otherwise
+func openConfigLayer(cmd *cobra.Command) (*models.ConfigFile, string, error) {
+	user, _ := cmd.Flags().GetBool("user")
+	local, _ := cmd.Flags().GetBool("local")
+	if user && local {
+		return nil, "", fmt.Errorf("--user and --local can't be used together")
+	}
+
+	if user {
+		file, err := models.OpenConfigFile(models.UserConfigPath(), "~/.cilo/config.yml")
+		return file, models.LayerUser, err
+	}
+	if !models.ProjectConfigured() {
+		return nil, "", fmt.Errorf("no project configured in current directory\n\nRun 'cilo setup' to configure this project")
+	}
+	if local {
+		name := filepath.Join(".cilo", "config.local.yml")
+		file, err := models.OpenConfigFile(name, name)
+		return file, models.LayerLocal, err
+	}
+	name := filepath.Join(".cilo", "config.yml")
+	file, err := models.OpenConfigFile(name, name)
+	return file, models.LayerProject, err
+}
+
+// changeConfigFile applies change to the chosen config file and saves it.
+// change returns what it did, or "" if it left the file as it was.
+func changeConfigFile(cmd *cobra.Command, change func(doc *yaml.Node) (string, error)) error {
+	file, layer, err := openConfigLayer(cmd)
+	if err != nil {
+		return err
+	}
+	done, err := change(&file.Doc)
+	if err != nil {
+		return err
+	}
+	if done == "" {
+		fmt.Printf("%s not changed\n", file.Name)
+		return nil
+	}
+	if err := file.Save(); err != nil {
+		return fmt.Errorf("%s not changed: %w", file.Name, err)
+	}
+	fmt.Printf("✓ %s in %s\n", done, file.Name)
+	warnOutOfDate(file, layer)
+	return nil
+}
+
+// runEditor opens path in the user's editor and waits for it to exit
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// The editor may come with arguments, as in "code --wait"
+	args := append(strings.Fields(editor), path)
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("editor %s failed: %w", editor, err)
+	}
+	return nil
+}
+
+// warnOutOfDate points out running environments that don't have a config
+// change yet. Workspaces keep their own copy of the project's config files
+// until refreshed, while the user's config is read on each up.
+func warnOutOfDate(file *models.ConfigFile, layer string) {
+	envs, err := state.ListEnvironments()
+	if err != nil {
+		return
+	}
+
+	var stale []string
+	if layer == models.LayerUser {
+		for _, env := range envs {
+			if env.Status == "running" {
+				stale = append(stale, env.Project+"/"+env.Name)
+			}
+		}
+		if len(stale) > 0 {
+			fmt.Printf("Running environments apply this on their next 'cilo up': %s\n", strings.Join(stale, ", "))
+		}
+		return
+	}
+
+	source, err := filepath.Abs(".")
+	if err != nil {
+		return
+	}
+	current, _ := os.ReadFile(file.Path)
+	for _, env := range envs {
+		if env.Status != "running" || env.Source != source {
+			continue
+		}
+		copied, _ := os.ReadFile(filepath.Join(state.GetEnvStoragePath(env.Project, env.Name), file.Name))
+		if !bytes.Equal(copied, current) {
+			stale = append(stale, env.Name)
+		}
+	}
+	if len(stale) > 0 {
+		fmt.Printf("Warning: running environments still use their workspace's copy of %s: %s\n", file.Name, strings.Join(stale, ", "))
+		fmt.Printf("Run 'cilo refresh <env>' to bring the change in\n")
+	}
+}
+
+func init() {
+	configGetCmd.Flags().String("env", "", "Read the config this environment runs with, overrides included")
+	configGetCmd.Flags().String("project", "", "Project of --env (defaults to configured project)")
+	configCmd.AddCommand(configGetCmd)
+
+	for _, c := range []*cobra.Command{configSetCmd, configUnsetCmd, configAddCmd, configRemoveCmd, configEditCmd} {
+		c.Flags().Bool("user", false, "Change ~/.cilo/config.yml, your defaults for every project")
+		c.Flags().Bool("local", false, "Change .cilo/config.local.yml, this checkout's settings")
+		configCmd.AddCommand(c)
+	}
+}
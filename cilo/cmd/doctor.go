@@ -2,11 +2,16 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"sort"
 	"time"
 
 	"github.com/sharedco/cilo/pkg/dns"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
 	"github.com/sharedco/cilo/pkg/reconcile"
 	"github.com/sharedco/cilo/pkg/runtime/docker"
 	"github.com/sharedco/cilo/pkg/share"
@@ -23,37 +28,46 @@ Checks performed:
 - Docker daemon availability
 - dnsmasq process status
 - State/runtime synchronization
-- Orphaned Docker resources
+- Orphaned resources: containers, networks and volumes of environments no
+  longer in state, shared service containers missing from state, workspace
+  directories without an environment and stale DNS entries
 
-Use --fix to automatically repair issues.`,
+Use --fix to automatically repair issues. Orphaned workspaces are moved to
+~/.cilo/orphaned rather than deleted, since they may hold unmerged work.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		fix, _ := cmd.Flags().GetBool("fix")
+		report := output.NewDoctorReport()
 
-		fmt.Println("🔍 Checking cilo configuration...")
-		fmt.Println()
+		fmt.Fprintln(messageOut, "🔍 Checking cilo configuration...")
+		fmt.Fprintln(messageOut)
 
 		// Check Docker
-		fmt.Print("Checking Docker... ")
-		if err := checkDocker(); err != nil {
-			fmt.Printf("❌ %v\n", err)
+		fmt.Fprint(messageOut, "Checking Docker... ")
+		err := checkDocker()
+		report.AddCheck("docker", err)
+		if err != nil {
+			fmt.Fprintf(messageOut, "❌ %v\n", err)
 		} else {
-			fmt.Println("✅")
+			fmt.Fprintln(messageOut, "✅")
 		}
 
 		// Check dnsmasq
-		fmt.Print("Checking dnsmasq... ")
-		if err := checkDNSMasq(); err != nil {
-			fmt.Printf("❌ %v\n", err)
+		fmt.Fprint(messageOut, "Checking dnsmasq... ")
+		err = checkDNSMasq()
+		report.AddCheck("dnsmasq", err)
+		if err != nil {
+			fmt.Fprintf(messageOut, "❌ %v\n", err)
 		} else {
-			fmt.Println("✅")
+			fmt.Fprintln(messageOut, "✅")
 		}
 
 		// Load state
-		fmt.Print("\nLoading state... ")
+		fmt.Fprint(messageOut, "\nLoading state... ")
 		st, err := state.LoadState()
+		report.AddCheck("state", err)
 		if err != nil {
-			fmt.Printf("❌ %v\n", err)
-			return nil
+			fmt.Fprintf(messageOut, "❌ %v\n", err)
+			return writeDoctorReport(report)
 		}
 
 		// Count environments from all hosts
@@ -61,48 +75,70 @@ Use --fix to automatically repair issues.`,
 		for _, host := range st.Hosts {
 			envCount += len(host.Environments)
 		}
-		fmt.Printf("✅ (%d environments)\n", envCount)
+		fmt.Fprintf(messageOut, "✅ (%d environments)\n", envCount)
+		report.Environments = envCount
 
 		// Reconcile environments
-		fmt.Println("\n📊 Reconciling environments...")
+		fmt.Fprintln(messageOut, "\n📊 Reconciling environments...")
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
 		result := reconcile.All(ctx, st)
 
-		fmt.Printf("  Reconciled: %d environments\n", result.EnvsReconciled)
+		fmt.Fprintf(messageOut, "  Reconciled: %d environments\n", result.EnvsReconciled)
+		report.NotRunning = append(report.NotRunning, result.EnvsNotRunning...)
+		for _, err := range result.Errors {
+			report.Issues = append(report.Issues, output.Issue{Category: "reconcile", Type: "error", Detail: err.Error()})
+		}
 		if len(result.EnvsNotRunning) > 0 {
-			fmt.Printf("  Not running: %v\n", result.EnvsNotRunning)
+			fmt.Fprintf(messageOut, "  Not running: %v\n", result.EnvsNotRunning)
 		}
 		if len(result.Errors) > 0 {
-			fmt.Printf("  Errors: %d\n", len(result.Errors))
+			fmt.Fprintf(messageOut, "  Errors: %d\n", len(result.Errors))
 			for _, err := range result.Errors {
-				fmt.Printf("    - %v\n", err)
+				fmt.Fprintf(messageOut, "    - %v\n", err)
 			}
 		}
 
 		// Check for orphans
-		fmt.Println("\n🔎 Checking for orphaned resources...")
+		fmt.Fprintln(messageOut, "\n🔎 Checking for orphaned resources...")
 		orphans, err := reconcile.FindOrphans(ctx, st)
+		report.AddCheck("orphans", err)
+		for _, o := range orphans {
+			report.Issues = append(report.Issues, output.Issue{Category: "orphan", Type: o.Type, Name: o.Name})
+		}
 		if err != nil {
-			fmt.Printf("  ⚠️  Could not check orphans: %v\n", err)
+			fmt.Fprintf(messageOut, "  ⚠️  Could not check orphans: %v\n", err)
 		} else if len(orphans) > 0 {
-			fmt.Printf("  Found %d orphaned resources:\n", len(orphans))
+			fmt.Fprintf(messageOut, "  Found %d orphaned resources:\n", len(orphans))
 			for _, o := range orphans {
-				fmt.Printf("    - %s: %s\n", o.Type, o.Name)
+				fmt.Fprintf(messageOut, "    - %s: %s\n", o.Type, o.Name)
 			}
 		} else {
-			fmt.Println("  ✅ No orphaned resources")
+			fmt.Fprintln(messageOut, "  ✅ No orphaned resources")
 		}
 
 		// Check shared services
-		fmt.Println("\n🔎 Checking shared services...")
+		fmt.Fprintln(messageOut, "\n🔎 Checking shared services...")
 		provider := docker.NewProvider()
+		provider.SetOutput(messageOut, os.Stderr)
 		sharedIssues, err := share.CheckSharedServices(st, provider, ctx)
+		report.AddCheck("shared_services", err)
+		for _, issue := range sharedIssues {
+			report.Issues = append(report.Issues, output.Issue{Category: "shared_service", Type: issue.Type, Name: issue.Service, Detail: issue.Detail})
+		}
+		sharedKeys := make([]string, 0, len(st.SharedServices))
+		for key := range st.SharedServices {
+			sharedKeys = append(sharedKeys, key)
+		}
+		sort.Strings(sharedKeys)
+		for _, key := range sharedKeys {
+			report.SharedServices = append(report.SharedServices, output.NewSharedService(st.SharedServices[key]))
+		}
 		if err != nil {
-			fmt.Printf("  ⚠️  Could not check shared services: %v\n", err)
+			fmt.Fprintf(messageOut, "  ⚠️  Could not check shared services: %v\n", err)
 		} else if len(sharedIssues) > 0 {
-			fmt.Printf("  Found %d issues:\n", len(sharedIssues))
+			fmt.Fprintf(messageOut, "  Found %d issues:\n", len(sharedIssues))
 			for _, issue := range sharedIssues {
 				var emoji string
 				switch issue.Type {
@@ -117,68 +153,71 @@ Use --fix to automatically repair issues.`,
 				default:
 					emoji = "⚠️ "
 				}
-				fmt.Printf("    %s %s: %s\n", emoji, issue.Type, issue.Detail)
+				fmt.Fprintf(messageOut, "    %s %s: %s\n", emoji, issue.Type, issue.Detail)
 			}
 		} else {
-			fmt.Println("  ✅ No shared service issues")
+			fmt.Fprintln(messageOut, "  ✅ No shared service issues")
 		}
 
 		// Fix if requested
 		if fix {
-			fmt.Println("\n🔧 Applying fixes...")
-
-			// Fix shared services
-			if len(sharedIssues) > 0 {
-				fmt.Print("  Fixing orphaned shared services... ")
-				fixed, err := share.FixOrphanedServices(st, provider, ctx)
-				if err != nil {
-					fmt.Printf("❌ %v\n", err)
-				} else {
-					fmt.Printf("✅ (fixed %d)\n", fixed)
-				}
+			fmt.Fprintln(messageOut, "\n🔧 Applying fixes...")
 
-				fmt.Print("  Fixing stale grace periods... ")
-				fixed, err = share.FixStaleGracePeriods(st, provider, ctx)
-				if err != nil {
-					fmt.Printf("❌ %v\n", err)
-				} else {
-					fmt.Printf("✅ (fixed %d)\n", fixed)
+			// Reconcile and fix the state as it is now, under the state
+			// lock, rather than saving the copy checked above over whatever
+			// changed since
+			err := state.WithLock(func(current *models.State) error {
+				reconcile.All(ctx, current)
+				if len(sharedIssues) == 0 {
+					return nil
 				}
+				fmt.Fprint(messageOut, "  Fixing orphaned shared services... ")
+				fixed, err := share.FixOrphanedServices(current, provider, ctx, messageOut)
+				reportFix(report, "orphaned_shared_services", fixed, err)
 
-				fmt.Print("  Cleaning up missing service entries... ")
-				fixed, err = share.FixMissingServices(st, provider, ctx)
-				if err != nil {
-					fmt.Printf("❌ %v\n", err)
-				} else {
-					fmt.Printf("✅ (fixed %d)\n", fixed)
-				}
-			}
+				fmt.Fprint(messageOut, "  Fixing stale grace periods... ")
+				fixed, err = share.FixStaleGracePeriods(current, provider, ctx, messageOut)
+				reportFix(report, "stale_grace_periods", fixed, err)
 
-			// Save reconciled state
-			fmt.Print("  Saving state... ")
-			if err := state.SaveState(st); err != nil {
-				fmt.Printf("❌ %v\n", err)
+				fmt.Fprint(messageOut, "  Cleaning up missing service entries... ")
+				fixed, err = share.FixMissingServices(current, provider, ctx)
+				reportFix(report, "missing_shared_services", fixed, err)
+				return nil
+			})
+			fmt.Fprint(messageOut, "  Saving state... ")
+			report.AddFix("save_state", "", err)
+			if err != nil {
+				fmt.Fprintf(messageOut, "❌ %v\n", err)
 			} else {
-				fmt.Println("✅")
+				fmt.Fprintln(messageOut, "✅")
+			}
+
+			if len(orphans) > 0 {
+				fmt.Fprint(messageOut, "  Removing orphaned resources... ")
+				removed, errs := reconcile.RemoveOrphans(ctx, orphans)
+				reportFix(report, "orphans", len(removed), errors.Join(errs...))
 			}
 
 			// Regenerate DNS
-			fmt.Print("  Regenerating DNS... ")
-			if err := dns.UpdateDNSFromState(st); err != nil {
-				fmt.Printf("❌ %v\n", err)
+			fmt.Fprint(messageOut, "  Regenerating DNS... ")
+			err = state.WithReadLock(dns.UpdateDNSFromState)
+			report.AddFix("regenerate_dns", "", err)
+			if err != nil {
+				fmt.Fprintf(messageOut, "❌ %v\n", err)
 			} else {
-				fmt.Println("✅")
+				fmt.Fprintln(messageOut, "✅")
 			}
 		} else if len(result.Errors) > 0 || len(orphans) > 0 || len(sharedIssues) > 0 {
-			fmt.Println("\n💡 Run 'cilo doctor --fix' to repair issues")
+			fmt.Fprintln(messageOut, "\n💡 Run 'cilo doctor --fix' to repair issues")
 		}
 
-		fmt.Println("\n✨ Doctor check complete")
-		return nil
+		fmt.Fprintln(messageOut, "\n✨ Doctor check complete")
+		return writeDoctorReport(report)
 	},
 }
 
 func init() {
+	doctorCmd.Annotations = structured
 	doctorCmd.Flags().Bool("fix", false, "Automatically fix issues")
 	rootCmd.AddCommand(doctorCmd)
 }
@@ -198,3 +237,21 @@ func checkDNSMasq() error {
 	}
 	return nil
 }
+
+// reportFix prints a --fix step's outcome and records it in the report
+func reportFix(report *output.DoctorReport, name string, fixed int, err error) {
+	report.AddFix(name, fmt.Sprintf("fixed %d", fixed), err)
+	if err != nil {
+		fmt.Fprintf(messageOut, "❌ %v\n", err)
+	} else {
+		fmt.Fprintf(messageOut, "✅ (fixed %d)\n", fixed)
+	}
+}
+
+func writeDoctorReport(report *output.DoctorReport) error {
+	if !structuredOutput() {
+		return nil
+	}
+	report.Finish()
+	return writeDocument(report)
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sharedco/cilo/pkg/engine"
+)
+
+// newEngine returns an engine that prints progress the way the CLI always
+// has, to stderr when a structured document goes to stdout
+func newEngine() *engine.Engine {
+	return engine.New(engine.Options{OnEvent: printEvent, Stdout: messageOut})
+}
+
+func printEvent(event engine.Event) {
+	indent := ""
+	if event.Service != "" {
+		indent = "  "
+	}
+	switch event.Type {
+	case engine.EventDone:
+		fmt.Fprintf(messageOut, "%s✓ %s\n", indent, event.Message)
+	case engine.EventWarning:
+		fmt.Fprintf(messageOut, "Warning: %s\n", event.Message)
+	default:
+		if event.Service != "" {
+			fmt.Fprintf(messageOut, "  … %s\n", event.Message)
+			return
+		}
+		fmt.Fprintln(messageOut, event.Message)
+	}
+}
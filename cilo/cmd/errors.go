@@ -0,0 +1,11 @@
+package cmd
+
+import "github.com/sharedco/cilo/pkg/output"
+
+// ExitCode maps an error returned by Execute to the process exit code
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	return output.ExitCode(output.ErrorCode(err))
+}
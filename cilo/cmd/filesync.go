@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/engine"
+	"github.com/sharedco/cilo/pkg/filesync"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
+	"github.com/sharedco/cilo/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync <env>",
+	Short: "Sync files between an environment's source and workspace",
+	Long: `Sync copies file changes between the source directory an environment was
+created from and its workspace.
+
+A file changed on one side since the last sync is copied to the other
+(deletions included). A file changed on both sides is a conflict: it is
+left untouched on both sides and recorded in the workspace's
+.cilo/sync-journal.json until resolved with --prefer or by making the two
+sides identical.
+
+.gitignore rules are honoured, dot directories follow copy_dot_dirs and
+ignore_dot_dirs from the project config, and .git/.cilo are never synced.
+
+Examples:
+  # One-off sync in both directions
+  cilo sync my-env
+
+  # Only bring host edits into the workspace
+  cilo sync my-env --direction pull
+
+  # Keep syncing until interrupted
+  cilo sync my-env --watch
+
+  # Resolve conflicts in favour of the workspace
+  cilo sync my-env --prefer workspace`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		project, envName, err := getProjectAndEnv(cmd, args)
+		if err != nil {
+			return err
+		}
+
+		watch, _ := cmd.Flags().GetBool("watch")
+		direction, _ := cmd.Flags().GetString("direction")
+		prefer, _ := cmd.Flags().GetString("prefer")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		if err := filesync.ValidateDirection(direction); err != nil {
+			return err
+		}
+		if prefer != "" && prefer != filesync.PreferSource && prefer != filesync.PreferWorkspace {
+			return fmt.Errorf("invalid --prefer %q (must be source or workspace)", prefer)
+		}
+		if watch && dryRun {
+			return fmt.Errorf("--dry-run cannot be combined with --watch")
+		}
+
+		env, err := state.GetEnvironment(project, envName)
+		if err != nil {
+			return err
+		}
+		if env.Source == "" {
+			return fmt.Errorf("environment %q has no source directory to sync with", envName)
+		}
+		if _, err := os.Stat(env.Source); err != nil {
+			return fmt.Errorf("source directory %s is not accessible: %w", env.Source, err)
+		}
+
+		workspace := state.GetEnvStoragePath(project, envName)
+		sourceConfig, err := models.LoadProjectConfigFromPath(env.Source)
+		if err != nil {
+			return fmt.Errorf("failed to load project config: %w", err)
+		}
+
+		opts := filesync.Options{
+			Direction: direction,
+			Prefer:    prefer,
+			DryRun:    dryRun,
+			Rules:     engine.SyncRules(sourceConfig),
+		}
+
+		if !watch {
+			result, err := filesync.Sync(env.Source, workspace, opts)
+			if err != nil {
+				return err
+			}
+			printSyncResult(result, dryRun)
+			if len(result.Conflicts) > 0 && !dryRun {
+				return output.WithCode(output.CodeConflict, fmt.Errorf("%d conflict(s) left unsynced", len(result.Conflicts)))
+			}
+			return nil
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		fmt.Printf("Watching %s <-> %s (direction: %s, Ctrl+C to stop)\n", env.Source, workspace, direction)
+		return filesync.Watch(ctx, env.Source, workspace, opts, func(result *filesync.Result, err error) {
+			if err != nil {
+				fmt.Printf("Warning: %v\n", err)
+				return
+			}
+			if result.Changed() || len(result.Conflicts) > 0 {
+				fmt.Printf("[%s]\n", time.Now().Format("15:04:05"))
+				printSyncResult(result, false)
+			}
+		})
+	},
+}
+
+func printSyncResult(result *filesync.Result, dryRun bool) {
+	prefix := ""
+	if dryRun {
+		prefix = "(dry run) "
+	}
+
+	for _, path := range result.Pulled {
+		fmt.Printf("  %s← %s\n", prefix, path)
+	}
+	for _, path := range result.Pushed {
+		fmt.Printf("  %s→ %s\n", prefix, path)
+	}
+	for _, c := range result.Conflicts {
+		detail := "changed on both sides"
+		switch {
+		case c.SourceHash == "":
+			detail = "deleted in source, changed in workspace"
+		case c.WorkspaceHash == "":
+			detail = "changed in source, deleted in workspace"
+		}
+		fmt.Printf("  ⚠ conflict: %s (%s)\n", c.Path, detail)
+	}
+
+	if !result.Changed() && len(result.Conflicts) == 0 {
+		fmt.Println("✓ Already in sync")
+	} else {
+		fmt.Printf("%s%d pulled, %d pushed, %d conflict(s)\n", prefix, len(result.Pulled), len(result.Pushed), len(result.Conflicts))
+	}
+	if len(result.Pending) > 0 {
+		fmt.Printf("  %d change(s) held back by --direction\n", len(result.Pending))
+	}
+	if len(result.Conflicts) > 0 {
+		fmt.Println("  Resolve with --prefer source|workspace, or edit one side to match the other")
+	}
+}
+
+func init() {
+	syncCmd.Flags().Bool("watch", false, "Keep syncing as files change")
+	syncCmd.Flags().String("direction", filesync.DirectionBoth, "Sync direction: pull (source to workspace), push (workspace to source) or both")
+	syncCmd.Flags().String("prefer", "", "Resolve conflicts in favour of source or workspace")
+	syncCmd.Flags().Bool("dry-run", false, "Show what would be synced without changing anything")
+	syncCmd.Flags().String("project", "", "Project name (defaults to configured project)")
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+var hostCmd = &cobra.Command{
+	Use:   "host",
+	Short: "Manage the hosts environments run on",
+}
+
+var hostAddCmd = &cobra.Command{
+	Use:   "add <id>",
+	Short: "Register a remote Docker host",
+	Long: `Register a remote Docker host so 'cilo create --host <id>' can run
+environments on it.
+
+The host's daemon is reached through a DOCKER_HOST (--docker-host) or a docker
+context (--context). Workspaces stay on this machine and are copied to the
+same path on the host with rsync over SSH before every 'cilo up', so the host
+needs rsync and key-based SSH access. The SSH target is taken from an ssh://
+endpoint unless --ssh is given.
+
+Examples:
+  cilo host add build1 --docker-host ssh://me@build1.internal
+  cilo host add gpu --context gpu-box
+  cilo host add lab --docker-host tcp://10.0.0.5:2376 --ssh me@10.0.0.5:2222`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dockerHost, _ := cmd.Flags().GetString("docker-host")
+		dockerContext, _ := cmd.Flags().GetString("context")
+		ssh, _ := cmd.Flags().GetString("ssh")
+
+		host := &models.Host{
+			ID:            args[0],
+			DockerHost:    dockerHost,
+			DockerContext: dockerContext,
+			SSH:           ssh,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := newEngine().AddHost(ctx, host); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Host %s registered (%s, ssh %s)\n", host.ID, hostEndpoint(host), host.SSH)
+		return nil
+	},
+}
+
+var hostListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List hosts",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hosts, err := state.ListHosts()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "ID\tENDPOINT\tSSH\tENVS\t\n")
+		fmt.Fprintf(w, "--\t--------\t---\t----\t\n")
+		for _, host := range hosts {
+			ssh := host.SSH
+			if ssh == "" {
+				ssh = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t\n", host.ID, hostEndpoint(host), ssh, len(host.Environments))
+		}
+		return w.Flush()
+	},
+}
+
+var hostRemoveCmd = &cobra.Command{
+	Use:   "remove <id>",
+	Short: "Unregister a host with no environments",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := state.RemoveHost(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Host %s removed\n", args[0])
+		return nil
+	},
+}
+
+// hostEndpoint describes how a host's Docker daemon is reached
+func hostEndpoint(host *models.Host) string {
+	switch {
+	case host.DockerHost != "":
+		return host.DockerHost
+	case host.DockerContext != "":
+		return "context " + host.DockerContext
+	default:
+		return "default"
+	}
+}
+
+func init() {
+	hostAddCmd.Flags().String("docker-host", "", "DOCKER_HOST of the host's daemon, e.g. ssh://me@build1")
+	hostAddCmd.Flags().String("context", "", "Docker context that reaches the host's daemon")
+	hostAddCmd.Flags().String("ssh", "", "SSH target for workspace sync, [user@]host[:port] (defaults to the ssh:// endpoint)")
+
+	hostCmd.AddCommand(hostAddCmd)
+	hostCmd.AddCommand(hostListCmd)
+	hostCmd.AddCommand(hostRemoveCmd)
+	rootCmd.AddCommand(hostCmd)
+}
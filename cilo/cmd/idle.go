@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/engine"
+	"github.com/spf13/cobra"
+)
+
+// defaultIdleInterval is how often idle checks run when watching
+const defaultIdleInterval = 30 * time.Second
+
+var idleCmd = &cobra.Command{
+	Use:   "idle",
+	Short: "Suspend idle environments",
+	Long: `Suspend environments nobody has used for longer than their project's idle timeout:
+
+  # .cilo/config.yml
+  idle:
+    timeout: 2h
+    action: pause   # or stop
+
+Use is 'cilo run' or 'cilo exec' in the environment, a DNS lookup of one of its
+names, or its containers using CPU or network. Suspended environments resume on
+the next run, exec or DNS lookup.
+
+Without --watch, makes a single check (suitable for cron). A watcher ('cilo
+idle --watch', or 'cilo serve', which runs one) resumes environments as soon
+as they are looked up; otherwise the next 'cilo up' or 'cilo status' does.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		watch, _ := cmd.Flags().GetBool("watch")
+		interval, _ := cmd.Flags().GetDuration("interval")
+
+		monitor := newEngine().IdleMonitor()
+		if !watch {
+			return monitor.Check(context.Background(), time.Now())
+		}
+		if interval <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		fmt.Printf("Watching for idle environments every %s (Ctrl+C to stop)\n", interval)
+		monitor.Run(ctx, interval)
+		return nil
+	},
+}
+
+var suspendCmd = &cobra.Command{
+	Use:   "suspend <name>",
+	Short: "Pause or stop an environment until it is next used",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		project, name, err := getProjectAndEnv(cmd, args)
+		if err != nil {
+			return err
+		}
+		action := engine.IdlePause
+		if stop, _ := cmd.Flags().GetBool("stop"); stop {
+			action = engine.IdleStop
+		}
+		_, err = newEngine().Suspend(context.Background(), project, name, action)
+		return err
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume <name>",
+	Short: "Resume a suspended environment",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		project, name, err := getProjectAndEnv(cmd, args)
+		if err != nil {
+			return err
+		}
+		result, err := newEngine().Resume(context.Background(), project, name)
+		if err != nil {
+			return err
+		}
+		if status := result.Environment.Status; status != "running" {
+			fmt.Printf("Environment %s is %s, not suspended\n", name, status)
+		}
+		return nil
+	},
+}
+
+// checkLookups resumes suspended environments whose names were looked up
+// since cilo last read the DNS query log, so lookups bring environments
+// back without a watcher too. Failures are only warnings.
+func checkLookups() {
+	if err := newEngine().IdleMonitor().CheckLookups(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+func init() {
+	idleCmd.Flags().Bool("watch", false, "Keep checking, and resume suspended environments on DNS lookups")
+	idleCmd.Flags().Duration("interval", defaultIdleInterval, "How often to check when watching")
+
+	suspendCmd.Flags().Bool("stop", false, "Stop containers instead of pausing them, freeing their memory")
+	suspendCmd.Flags().String("project", "", "Project name (defaults to configured project)")
+	resumeCmd.Flags().String("project", "", "Project name (defaults to configured project)")
+
+	rootCmd.AddCommand(idleCmd)
+	rootCmd.AddCommand(suspendCmd)
+	rootCmd.AddCommand(resumeCmd)
+}
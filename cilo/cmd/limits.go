@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/resources"
+	"github.com/sharedco/cilo/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+var limitsCmd = &cobra.Command{
+	Use:   "limits",
+	Short: "Show or set the host's resource budget",
+	Long: `Show or set how much the environments running on this host may use together.
+
+With no flags, shows the budget and current usage. 'cilo up' refuses to start
+an environment that would exceed the budget (exit code 6), or waits for room
+with --queue.
+
+Memory is counted from each service's memory limit, so services without one
+(see the project's 'resources:' policy or 'cilo up --memory') aren't counted.
+
+Examples:
+  cilo limits --max-envs 4 --max-memory 16g
+  cilo limits --max-envs 0      # Remove the environment cap`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cmd.Flags().Changed("max-envs") || cmd.Flags().Changed("max-memory") {
+			if err := setBudget(cmd); err != nil {
+				return err
+			}
+		}
+
+		budget, err := state.GetBudget()
+		if err != nil {
+			return err
+		}
+		usage, err := state.GetUsage()
+		if err != nil {
+			return err
+		}
+
+		maxEnvs, maxMemory := "unlimited", "unlimited"
+		if budget != nil && budget.MaxRunningEnvs > 0 {
+			maxEnvs = fmt.Sprintf("%d", budget.MaxRunningEnvs)
+		}
+		if budget != nil && budget.MaxMemory != "" {
+			maxMemory = budget.MaxMemory
+		}
+		fmt.Printf("Running environments: %d of %s\n", usage.RunningEnvs, maxEnvs)
+		fmt.Printf("Memory limits:        %s of %s\n", resources.FormatSize(usage.Memory), maxMemory)
+		return nil
+	},
+}
+
+// setBudget applies the changed flags to the stored budget. Zero or empty
+// values remove that cap.
+func setBudget(cmd *cobra.Command) error {
+	budget, err := state.GetBudget()
+	if err != nil {
+		return err
+	}
+	if budget == nil {
+		budget = &models.HostBudget{}
+	}
+
+	if cmd.Flags().Changed("max-envs") {
+		maxEnvs, _ := cmd.Flags().GetInt("max-envs")
+		if maxEnvs < 0 {
+			return fmt.Errorf("--max-envs must not be negative")
+		}
+		budget.MaxRunningEnvs = maxEnvs
+	}
+	if cmd.Flags().Changed("max-memory") {
+		maxMemory, _ := cmd.Flags().GetString("max-memory")
+		if maxMemory == "0" {
+			maxMemory = ""
+		}
+		if maxMemory != "" {
+			if _, err := resources.ParseSize(maxMemory); err != nil {
+				return err
+			}
+		}
+		budget.MaxMemory = maxMemory
+	}
+
+	if *budget == (models.HostBudget{}) {
+		budget = nil
+	}
+	return state.SetBudget(budget)
+}
+
+func init() {
+	limitsCmd.Flags().Int("max-envs", 0, "Most environments running at once (0 for no limit)")
+	limitsCmd.Flags().String("max-memory", "", "Most memory the running environments' limits may add up to, e.g. 16g (0 for no limit)")
+	rootCmd.AddCommand(limitsCmd)
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/sharedco/cilo/pkg/engine"
+	"github.com/sharedco/cilo/pkg/mcp"
+	"github.com/sharedco/cilo/pkg/runtime"
+	"github.com/sharedco/cilo/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+var mcpCmd = &cobra.Command{
+	Use:   "mcp",
+	Short: "Serve MCP tools for the current environment over stdio",
+	Long: `Speak the Model Context Protocol over stdin/stdout so an agent running
+inside an environment can inspect and manage it.
+
+The server is scoped to the environment named by CILO_ENV (and CILO_PROJECT),
+which 'cilo run' sets. Tools take no environment argument, so an agent can
+only act on its own environment.
+
+Tools: status, list_urls, logs, exec, restart_service, snapshot, reset
+
+Example client config:
+  {"mcpServers": {"cilo": {"command": "cilo", "args": ["mcp"]}}}`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		envName := os.Getenv("CILO_ENV")
+		if envName == "" {
+			return fmt.Errorf("CILO_ENV is not set; run the agent with 'cilo run <agent> <env>' so cilo mcp knows which environment it serves")
+		}
+		project := os.Getenv("CILO_PROJECT")
+		if project == "" {
+			var err error
+			if project, envName, err = getProjectAndEnv(cmd, []string{envName}); err != nil {
+				return err
+			}
+		}
+		if _, err := state.GetEnvironment(project, envName); err != nil {
+			return err
+		}
+
+		// stdout carries the protocol; anything else written there, such
+		// as docker compose output, would corrupt it
+		protocolOut := os.Stdout
+		os.Stdout = os.Stderr
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		backend := &mcpBackend{
+			project: project,
+			env:     envName,
+			engine: engine.New(engine.Options{
+				OnEvent: func(event engine.Event) { fmt.Fprintln(os.Stderr, event.Message) },
+				Stdout:  os.Stderr,
+				Stderr:  os.Stderr,
+			}),
+		}
+		server := mcp.NewServer("cilo", version, mcp.Tools(backend))
+		return server.Serve(ctx, os.Stdin, protocolOut)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mcpCmd)
+}
+
+// mcpBackend runs MCP tool calls against a single environment
+type mcpBackend struct {
+	project string
+	env     string
+	engine  *engine.Engine
+}
+
+func (b *mcpBackend) Status(ctx context.Context) (*engine.Result, error) {
+	return b.engine.Status(ctx, b.project, b.env)
+}
+
+func (b *mcpBackend) Logs(ctx context.Context, service string, tail int, w io.Writer) error {
+	provider, err := b.engine.ProviderFor(b.project, b.env)
+	if err != nil {
+		return err
+	}
+	if err := provider.Ping(ctx); err != nil {
+		return err
+	}
+	return provider.Logs(ctx, b.project, b.env, service, runtime.LogOptions{
+		Tail:   tail,
+		Stdout: w,
+		Stderr: w,
+	})
+}
+
+func (b *mcpBackend) Exec(ctx context.Context, service string, command []string, stdout, stderr io.Writer) (int, error) {
+	if _, err := b.engine.Resume(ctx, b.project, b.env); err != nil {
+		return 0, err
+	}
+	provider, err := b.engine.ProviderFor(b.project, b.env)
+	if err != nil {
+		return 0, err
+	}
+	if err := provider.Ping(ctx); err != nil {
+		return 0, err
+	}
+	err = provider.Exec(ctx, b.project, b.env, service, command, runtime.ExecOptions{
+		Stdin:  strings.NewReader(""),
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
+
+func (b *mcpBackend) Restart(ctx context.Context, service string) error {
+	_, err := b.engine.Restart(ctx, b.project, b.env, service)
+	return err
+}
+
+func (b *mcpBackend) Snapshot(ctx context.Context, name string) (*engine.Snapshot, error) {
+	return b.engine.Snapshot(ctx, b.project, b.env, name)
+}
+
+func (b *mcpBackend) Reset(ctx context.Context, name string) (*engine.Snapshot, error) {
+	return b.engine.Reset(ctx, b.project, b.env, name)
+}
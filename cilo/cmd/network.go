@@ -7,6 +7,7 @@ import (
 
 	"github.com/sharedco/cilo/pkg/dns"
 	"github.com/sharedco/cilo/pkg/network"
+	"github.com/sharedco/cilo/pkg/output"
 	"github.com/sharedco/cilo/pkg/state"
 	"github.com/spf13/cobra"
 )
@@ -25,9 +26,13 @@ var networkStatusCmd = &cobra.Command{
 			return err
 		}
 
+		if structuredOutput() {
+			return writeDocument(output.NewNetworkStatus(st))
+		}
+
 		fmt.Printf("Base Subnet: %s\n", st.BaseSubnet)
 		fmt.Printf("DNS Port:    %d\n", st.DNSPort)
-		fmt.Printf("Next Subnet: %s%d.0/24\n", st.BaseSubnet, st.SubnetCounter+1)
+		fmt.Printf("Next Subnet: %s\n", state.NextSubnet(st))
 
 		return nil
 	},
@@ -103,6 +108,7 @@ NOTE: This will destroy all current environments and recreate them (without losi
 }
 
 func init() {
+	networkStatusCmd.Annotations = structured
 	networkCmd.AddCommand(networkStatusCmd)
 	networkCmd.AddCommand(networkMigrateCmd)
 	rootCmd.AddCommand(networkCmd)
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
+	"github.com/sharedco/cilo/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+// structuredAnnotation marks commands that can emit --output json/yaml
+const structuredAnnotation = "cilo.structured-output"
+
+var structured = map[string]string{structuredAnnotation: "true"}
+
+// outputFormat is the global --output value
+var outputFormat = output.FormatText
+
+// documentOut receives structured documents
+var documentOut io.Writer = os.Stdout
+
+// messageOut receives progress and other messages for people, along with
+// the output of docker and hooks. With --output json/yaml it is stderr, so
+// nothing can interleave with the document on stdout.
+var messageOut io.Writer = os.Stdout
+
+func structuredOutput() bool {
+	return outputFormat != output.FormatText
+}
+
+func setupOutput(cmd *cobra.Command, args []string) error {
+	if err := output.ValidateFormat(outputFormat); err != nil {
+		return err
+	}
+	if !structuredOutput() {
+		return nil
+	}
+
+	// Errors are reported as an error document by Execute instead
+	rootCmd.SilenceErrors = true
+	rootCmd.SilenceUsage = true
+
+	if cmd.Annotations[structuredAnnotation] == "" {
+		return fmt.Errorf("%s does not support --output %s", cmd.CommandPath(), outputFormat)
+	}
+
+	messageOut = os.Stderr
+	return nil
+}
+
+// reportError writes a failed command's error document to stderr
+func reportError(err error) {
+	if !structuredOutput() || output.ValidateFormat(outputFormat) != nil {
+		return
+	}
+	output.Write(os.Stderr, outputFormat, output.NewError(err))
+}
+
+// writeDocument emits a document in the selected format
+func writeDocument(doc interface{}) error {
+	return output.Write(documentOut, outputFormat, doc)
+}
+
+// writeEnvironment emits env as a document when structured output is on
+func writeEnvironment(env *models.Environment) error {
+	if !structuredOutput() {
+		return nil
+	}
+	return writeDocument(output.NewEnvironment(env, state.GetEnvStoragePath(env.Project, env.Name)))
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/engine"
+	"github.com/spf13/cobra"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Destroy expired environments and clean up orphaned resources",
+	Long: `Destroy environments past their expiry (set with 'cilo create --ttl' or the
+project's 'ttl:' config), then clean up what untracked environments and shared
+services left behind, as 'cilo doctor --fix' does: containers, networks,
+volumes and DNS entries are removed, and workspaces are moved to
+~/.cilo/orphaned.
+
+--older-than and --stopped select more environments; --stopped and --project
+narrow the selection. Destroying an environment removes its DNS entries, frees
+its subnet and releases its shared services, as 'cilo destroy' does.
+
+Examples:
+  cilo prune --dry-run                  # Show what would be removed
+  cilo prune                            # Expired environments and orphans
+  cilo prune --older-than 7d --stopped  # Plus stopped ones created over a week ago
+  cilo prune --stopped --project myapp  # Every stopped myapp environment`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		olderThanFlag, _ := cmd.Flags().GetString("older-than")
+		stopped, _ := cmd.Flags().GetBool("stopped")
+		project, _ := cmd.Flags().GetString("project")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		force, _ := cmd.Flags().GetBool("force")
+
+		opts := engine.PruneOptions{Stopped: stopped, Project: project, DryRun: true}
+		if olderThanFlag != "" {
+			olderThan, err := engine.ParseDuration(olderThanFlag)
+			if err != nil {
+				return fmt.Errorf("invalid --older-than: %w", err)
+			}
+			opts.OlderThan = olderThan
+		}
+
+		// Always look first, so the confirmation shows what will go
+		e := newEngine()
+		ctx := context.Background()
+		plan, err := e.Prune(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if len(plan.Environments) == 0 && len(plan.Orphans) == 0 {
+			fmt.Println("Nothing to prune")
+			return pruneErrors(plan.Errors)
+		}
+		printPrune("Would remove", plan)
+		if dryRun {
+			return pruneErrors(plan.Errors)
+		}
+
+		if !force {
+			fmt.Print("\nContinue? [y/N] ")
+			var response string
+			fmt.Scanln(&response)
+			if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
+				fmt.Println("Cancelled")
+				return nil
+			}
+		}
+
+		opts.DryRun = false
+		result, err := e.Prune(ctx, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Println()
+		printPrune("Removed", result)
+		return pruneErrors(result.Errors)
+	},
+}
+
+func printPrune(verb string, result *engine.PruneResult) {
+	if len(result.Environments) > 0 {
+		fmt.Printf("%s %d environments:\n", verb, len(result.Environments))
+		for _, env := range result.Environments {
+			reason := "created " + env.CreatedAt.Local().Format("2006-01-02 15:04")
+			if !env.ExpiresAt.IsZero() {
+				reason = "expires " + env.ExpiresAt.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Printf("  - %s/%s (%s, %s)\n", env.Project, env.Name, env.Status, reason)
+		}
+	}
+	if len(result.Orphans) > 0 {
+		fmt.Printf("%s %d orphaned resources:\n", verb, len(result.Orphans))
+		for _, o := range result.Orphans {
+			fmt.Printf("  - %s: %s\n", o.Type, o.Name)
+		}
+	}
+}
+
+func pruneErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	for _, err := range errs {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	return fmt.Errorf("prune finished with %d errors", len(errs))
+}
+
+func init() {
+	pruneCmd.Flags().String("older-than", "", "Also remove environments created at least this long ago, e.g. 7d or 12h")
+	pruneCmd.Flags().Bool("stopped", false, "Only remove environments that aren't running")
+	pruneCmd.Flags().String("project", "", "Only remove this project's environments (skips orphan cleanup)")
+	pruneCmd.Flags().Bool("dry-run", false, "Show what would be removed without removing it")
+	pruneCmd.Flags().Bool("force", false, "Skip confirmation prompt")
+	rootCmd.AddCommand(pruneCmd)
+}
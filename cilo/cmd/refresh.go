@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/engine"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+var refreshCmd = &cobra.Command{
+	Use:   "refresh <env> | --all",
+	Short: "Bring config, compose and env file changes into workspaces",
+	Long: `Refresh copies the files that say how an environment runs from the
+directory it was created from into its workspace: .cilo/config.yml and
+.cilo/config.local.yml, the compose files (or devcontainer.json or Procfile),
+and the env files (.env, env_files and env.render files, rendered for the
+environment). A workspace otherwise keeps the copies made at create.
+
+The changes are shown first, as diffs. A file edited in the workspace since
+it was copied (or rendered by 'cilo up') is only overwritten with --force.
+Restart a running environment with 'cilo up' to apply the changes. Other files
+are left alone; use 'cilo sync' for those.
+
+Examples:
+  # Preview, then confirm
+  cilo refresh my-env
+
+  # Every environment, without asking
+  cilo refresh --all --yes
+
+  # Only show what would change
+  cilo refresh --all --dry-run
+
+  # Overwrite files edited in the workspace too
+  cilo refresh my-env --force`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+		yes, _ := cmd.Flags().GetBool("yes")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		force, _ := cmd.Flags().GetBool("force")
+		projectFlag, _ := cmd.Flags().GetString("project")
+		if all == (len(args) == 1) {
+			return fmt.Errorf("name an environment or use --all")
+		}
+
+		var envs []*models.Environment
+		if all {
+			list, err := state.ListEnvironments()
+			if err != nil {
+				return err
+			}
+			for _, env := range list {
+				if env.Source != "" && (projectFlag == "" || env.Project == projectFlag) {
+					envs = append(envs, env)
+				}
+			}
+		} else {
+			project, name, err := getProjectAndEnv(cmd, args)
+			if err != nil {
+				return err
+			}
+			env, err := state.GetEnvironment(project, name)
+			if err != nil {
+				return err
+			}
+			envs = append(envs, env)
+		}
+
+		ctx := context.Background()
+		e := newEngine()
+		for _, env := range envs {
+			preview, err := e.Refresh(ctx, env.Project, env.Name, engine.RefreshOptions{DryRun: true})
+			if err != nil {
+				if !all {
+					return err
+				}
+				fmt.Printf("Warning: %s/%s: %v\n", env.Project, env.Name, err)
+				continue
+			}
+			if len(preview) == 0 {
+				fmt.Printf("✓ %s/%s is up to date\n", env.Project, env.Name)
+				continue
+			}
+
+			fmt.Printf("%s/%s:\n", env.Project, env.Name)
+			printRefreshPreview(preview)
+			if dryRun {
+				continue
+			}
+			// Without --force, Refresh refuses edited files; don't ask first
+			if !yes && (force || !refreshModified(preview)) {
+				fmt.Printf("Refresh %s/%s? [y/N] ", env.Project, env.Name)
+				var response string
+				fmt.Scanln(&response)
+				if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
+					fmt.Println("Skipped")
+					continue
+				}
+			}
+
+			if _, err := e.Refresh(ctx, env.Project, env.Name, engine.RefreshOptions{Force: force}); err != nil {
+				if !all {
+					return err
+				}
+				fmt.Printf("Warning: %s/%s: %v\n", env.Project, env.Name, err)
+				continue
+			}
+			if env.Status == "running" {
+				fmt.Printf("Run 'cilo up %s' to apply the changes\n", env.Name)
+			}
+		}
+		return nil
+	},
+}
+
+func printRefreshPreview(files []engine.RefreshedFile) {
+	for _, file := range files {
+		if file.Modified {
+			fmt.Printf("  ! %s was edited in the workspace; --force overwrites it\n", file.Path)
+		}
+		if file.Diff != "" {
+			fmt.Print(indentLines(file.Diff, "  "))
+		} else {
+			fmt.Printf("  ~ %s (%s)\n", file.Path, file.Change)
+		}
+	}
+}
+
+// refreshModified reports whether a refresh would overwrite an edited file
+func refreshModified(files []engine.RefreshedFile) bool {
+	for _, file := range files {
+		if file.Modified {
+			return true
+		}
+	}
+	return false
+}
+
+// indentLines prefixes each line of s
+func indentLines(s, prefix string) string {
+	lines := strings.SplitAfter(s, "\n")
+	var b strings.Builder
+	for _, line := range lines {
+		if line != "" {
+			b.WriteString(prefix + line)
+		}
+	}
+	return b.String()
+}
+
+func init() {
+	refreshCmd.Flags().Bool("all", false, "Refresh every environment with a source directory")
+	refreshCmd.Flags().BoolP("yes", "y", false, "Don't ask before refreshing")
+	refreshCmd.Flags().Bool("dry-run", false, "Only show what would change")
+	refreshCmd.Flags().Bool("force", false, "Overwrite files edited in the workspace")
+	refreshCmd.Flags().String("project", "", "Project name (defaults to configured project; with --all, limits it to this project)")
+	rootCmd.AddCommand(refreshCmd)
+}
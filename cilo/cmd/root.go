@@ -9,6 +9,7 @@ import (
 
 	"github.com/sharedco/cilo/pkg/config"
 	"github.com/sharedco/cilo/pkg/dns"
+	"github.com/sharedco/cilo/pkg/output"
 	"github.com/sharedco/cilo/pkg/state"
 	"github.com/spf13/cobra"
 )
@@ -29,10 +30,17 @@ and runs on an isolated Docker network accessible via DNS names rather than port
 }
 
 func Execute() error {
-	return rootCmd.Execute()
+	err := rootCmd.Execute()
+	if err != nil {
+		reportError(err)
+	}
+	return err
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", output.FormatText, "Output format: text, json, yaml")
+	rootCmd.PersistentPreRunE = setupOutput
+
 	initCmd.Flags().String("base-subnet", "", "Base subnet for environments (e.g. 10.224.)")
 	initCmd.Flags().Int("dns-port", 0, "Port for the local DNS daemon (default: 5354)")
 
@@ -53,6 +61,7 @@ func init() {
 	rootCmd.AddCommand(hostnamesCmd)
 	rootCmd.AddCommand(diffCmd)
 	rootCmd.AddCommand(mergeCmd)
+	rootCmd.AddCommand(syncCmd)
 	rootCmd.AddCommand(networkCmd)
 }
 
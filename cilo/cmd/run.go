@@ -1,15 +1,15 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"syscall"
 
-	"github.com/sharedco/cilo/pkg/compose"
 	"github.com/sharedco/cilo/pkg/config"
-	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/engine"
+	"github.com/sharedco/cilo/pkg/output"
 	"github.com/sharedco/cilo/pkg/state"
 	"github.com/spf13/cobra"
 )
@@ -26,6 +26,7 @@ func init() {
 	runCmd.Flags().String("project", "", "Project name (default: directory basename)")
 	runCmd.Flags().Bool("no-up", false, "Don't start the environment")
 	runCmd.Flags().Bool("no-create", false, "Don't create if missing")
+	runCmd.Flags().Bool("no-wait", false, "Don't wait for services to be ready before launching")
 	rootCmd.AddCommand(runCmd)
 }
 
@@ -38,110 +39,16 @@ func runRun(cmd *cobra.Command, args []string) error {
 	projectFlag, _ := cmd.Flags().GetString("project")
 	noUp, _ := cmd.Flags().GetBool("no-up")
 	noCreate, _ := cmd.Flags().GetBool("no-create")
+	noWait, _ := cmd.Flags().GetBool("no-wait")
 
 	if !isInitialized() {
-		return fmt.Errorf(`cilo is not initialized. DNS resolution for *.test domains won't work.
+		return output.WithCode(output.CodeNotInitialized, fmt.Errorf(`cilo is not initialized. DNS resolution for *.test domains won't work.
 
 Run:
   sudo cilo init
 
 Then retry:
-  cilo run %s %s`, command, envName)
-	}
-
-	if fromPath == "" {
-		cwd, err := os.Getwd()
-		if err != nil {
-			return fmt.Errorf("failed to get current directory: %w", err)
-		}
-		fromPath = cwd
-	}
-	fromPath, _ = filepath.Abs(fromPath)
-
-	project := projectFlag
-	if project == "" {
-		sourceConfig, err := models.LoadProjectConfigFromPath(fromPath)
-		if err != nil {
-			return fmt.Errorf("failed to load project config: %w", err)
-		}
-		if sourceConfig != nil && sourceConfig.Project != "" {
-			project = sourceConfig.Project
-		} else {
-			project = filepath.Base(fromPath)
-		}
-	}
-	project = state.NormalizeName(project)
-
-	env, err := state.GetEnvironment(project, envName)
-	if err != nil {
-		if noCreate {
-			return fmt.Errorf("environment %s/%s does not exist (use 'cilo create' first, or remove --no-create)", project, envName)
-		}
-
-		fmt.Printf("Creating environment: %s/%s\n", project, envName)
-
-		env, err = state.CreateEnvironment(envName, fromPath, project)
-		if err != nil {
-			return fmt.Errorf("failed to create environment: %w", err)
-		}
-
-		workspace := state.GetEnvStoragePath(project, envName)
-		if err := os.MkdirAll(workspace, 0755); err != nil {
-			return fmt.Errorf("failed to create workspace: %w", err)
-		}
-
-		sourceConfig, err := models.LoadProjectConfigFromPath(fromPath)
-		if err != nil {
-			return fmt.Errorf("failed to load project config: %w", err)
-		}
-
-		copyOpts := CopyOptions{}
-		if sourceConfig != nil {
-			copyOpts.CopyDotDirs = sourceConfig.CopyDotDirs
-			copyOpts.IgnoreDotDirs = sourceConfig.IgnoreDotDirs
-		}
-
-		if err := copyProject(fromPath, workspace, copyOpts); err != nil {
-			return fmt.Errorf("failed to copy project: %w", err)
-		}
-
-		composeFiles, _, err := compose.ResolveComposeFiles(workspace, nil)
-		if err == nil && sourceConfig != nil {
-			composeFiles, _, err = compose.ResolveComposeFiles(workspace, sourceConfig.ComposeFiles)
-		}
-		if err != nil {
-			return err
-		}
-		if len(composeFiles) == 0 {
-			return fmt.Errorf("no compose files found in source directory")
-		}
-
-		ciloDir := filepath.Join(workspace, ".cilo")
-		os.MkdirAll(ciloDir, 0755)
-
-		fmt.Printf("✓ Created environment: %s/%s\n", project, envName)
-	}
-
-	if !noUp && env.Status != "running" {
-		fmt.Printf("Starting environment: %s/%s\n", project, envName)
-
-		upArgs := []string{envName}
-		upCommand := upCmd
-		upCommand.Flags().Set("project", project)
-		if err := upCommand.RunE(upCommand, upArgs); err != nil {
-			return fmt.Errorf("failed to start environment: %w", err)
-		}
-	}
-
-	workspace := state.GetEnvStoragePath(project, envName)
-	projectConfig, err := models.LoadProjectConfigFromPath(workspace)
-	if err != nil {
-		return fmt.Errorf("failed to load project config: %w", err)
-	}
-
-	dnsSuffix := ".test"
-	if projectConfig != nil && projectConfig.DNSSuffix != "" {
-		dnsSuffix = projectConfig.DNSSuffix
+  cilo run %s %s`, command, envName))
 	}
 
 	cmdPath, err := exec.LookPath(command)
@@ -149,20 +56,23 @@ Then retry:
 		return fmt.Errorf("command not found: %s", command)
 	}
 
-	environ := os.Environ()
-	environ = append(environ,
-		fmt.Sprintf("CILO_ENV=%s", envName),
-		fmt.Sprintf("CILO_PROJECT=%s", project),
-		fmt.Sprintf("CILO_WORKSPACE=%s", workspace),
-		fmt.Sprintf("CILO_BASE_URL=http://%s.%s%s", project, envName, dnsSuffix),
-		fmt.Sprintf("CILO_DNS_SUFFIX=%s", dnsSuffix),
-	)
+	result, environ, err := newEngine().Prepare(context.Background(), engine.RunOptions{
+		Env:      envName,
+		Project:  projectFlag,
+		From:     fromPath,
+		NoCreate: noCreate,
+		NoUp:     noUp,
+		NoWait:   noWait,
+	})
+	if err != nil {
+		return err
+	}
 
-	if err := os.Chdir(workspace); err != nil {
+	if err := os.Chdir(result.Workspace); err != nil {
 		return fmt.Errorf("failed to change to workspace: %w", err)
 	}
 
-	fmt.Printf("\nLaunching %s in %s\n\n", command, workspace)
+	fmt.Printf("\nLaunching %s in %s\n\n", command, result.Workspace)
 
 	execArgs := append([]string{command}, cmdArgs...)
 
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/api"
+	"github.com/sharedco/cilo/pkg/config"
+	"github.com/sharedco/cilo/pkg/engine"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/ready"
+	"github.com/sharedco/cilo/pkg/runtime"
+	"github.com/sharedco/cilo/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the control API on a unix socket",
+	Long: `Serve a JSON API over a unix socket (default ~/.cilo/cilo.sock) so tools
+can drive cilo without running the CLI and parsing its output.
+
+Endpoints:
+  GET    /v1/environments                         List environments
+  POST   /v1/environments                         Create ({"name", "from", "project"?, ...})
+  GET    /v1/environments/{project}/{name}        Status
+  POST   /v1/environments/{project}/{name}/up     Start ({"build"?, "wait"?, ...})
+  POST   /v1/environments/{project}/{name}/down   Stop
+  DELETE /v1/environments/{project}/{name}        Destroy (?keep_workspace=true)
+  POST   /v1/environments/{project}/{name}/exec   Run a command ({"service", "command"})
+  GET    /v1/environments/{project}/{name}/logs   Logs (?service=&tail=&follow=true)
+  GET    /v1/events                               Server-sent lifecycle events
+
+Unless --idle-interval is 0, the server also suspends idle environments and
+resumes them on DNS lookups (see 'cilo idle').
+
+Example:
+  curl --unix-socket ~/.cilo/cilo.sock http://cilo/v1/environments`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		socketPath, _ := cmd.Flags().GetString("socket")
+		if socketPath == "" {
+			socketPath = config.GetSocketPath()
+		}
+
+		if _, err := state.LoadState(); err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		hub := api.NewHub()
+		go api.WatchState(ctx, hub, state.ListEnvironments, time.Second)
+
+		backend := newServerBackend()
+		if idleInterval, _ := cmd.Flags().GetDuration("idle-interval"); idleInterval > 0 {
+			go backend.engine.IdleMonitor().Run(ctx, idleInterval)
+		}
+
+		fmt.Printf("Serving cilo API on %s (Ctrl+C to stop)\n", socketPath)
+		return api.NewServer(backend, hub).Serve(ctx, socketPath)
+	},
+}
+
+func init() {
+	serveCmd.Flags().String("socket", "", "Socket path (default: ~/.cilo/cilo.sock)")
+	serveCmd.Flags().Duration("idle-interval", defaultIdleInterval, "How often to check for idle environments and DNS lookups that resume them (0 to disable)")
+	rootCmd.AddCommand(serveCmd)
+}
+
+// serverBackend runs API requests through the same engine as the CLI
+type serverBackend struct {
+	engine *engine.Engine
+}
+
+func newServerBackend() serverBackend {
+	return serverBackend{engine: engine.New(engine.Options{OnEvent: logEvent})}
+}
+
+// logEvent prints engine progress prefixed with the environment it is for,
+// since the server runs operations on several at once
+func logEvent(event engine.Event) {
+	prefix := ""
+	if event.Env != "" {
+		prefix = fmt.Sprintf("[%s/%s] ", event.Project, event.Env)
+	}
+	switch event.Type {
+	case engine.EventDone:
+		fmt.Printf("%s✓ %s\n", prefix, event.Message)
+	case engine.EventWarning:
+		fmt.Printf("%sWarning: %s\n", prefix, event.Message)
+	default:
+		fmt.Printf("%s%s\n", prefix, event.Message)
+	}
+}
+
+func (b serverBackend) List(ctx context.Context) ([]*models.Environment, error) {
+	return state.ListEnvironments()
+}
+
+func (b serverBackend) Status(ctx context.Context, project, name string) (*models.Environment, error) {
+	return environmentOf(b.engine.Status(ctx, project, name))
+}
+
+func (b serverBackend) Create(ctx context.Context, req api.CreateRequest) (*models.Environment, error) {
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		if ttl, err = engine.ParseDuration(req.TTL); err != nil {
+			return nil, err
+		}
+	}
+	return environmentOf(b.engine.Create(ctx, engine.CreateOptions{
+		Name:    req.Name,
+		Project: req.Project,
+		From:    req.From,
+		Empty:   req.Empty,
+		Include: req.Include,
+		TTL:     ttl,
+		Host:    req.Host,
+	}))
+}
+
+func (b serverBackend) Up(ctx context.Context, project, name string, req api.UpRequest) (*models.Environment, error) {
+	waitTimeout := ready.DefaultTimeout
+	if req.WaitTimeout != "" {
+		d, err := time.ParseDuration(req.WaitTimeout)
+		if err != nil {
+			return nil, err
+		}
+		waitTimeout = d
+	}
+	var queueTimeout time.Duration
+	if req.QueueTimeout != "" {
+		d, err := time.ParseDuration(req.QueueTimeout)
+		if err != nil {
+			return nil, err
+		}
+		queueTimeout = d
+	}
+	return environmentOf(b.engine.Up(ctx, project, name, engine.UpOptions{
+		Build:        req.Build,
+		Recreate:     req.Recreate,
+		Wait:         req.Wait,
+		WaitTimeout:  waitTimeout,
+		Shared:       req.Shared,
+		Isolate:      req.Isolate,
+		Resources:    req.Resources,
+		Queue:        req.Queue,
+		QueueTimeout: queueTimeout,
+		Profile:      req.Profile,
+		NoProfile:    req.NoProfile,
+		Images:       req.Images,
+	}))
+}
+
+func (b serverBackend) Down(ctx context.Context, project, name string) (*models.Environment, error) {
+	return environmentOf(b.engine.Down(ctx, project, name))
+}
+
+func (b serverBackend) Destroy(ctx context.Context, project, name string, keepWorkspace bool) (*models.Environment, error) {
+	return environmentOf(b.engine.Destroy(ctx, project, name, engine.DestroyOptions{KeepWorkspace: keepWorkspace}))
+}
+
+func environmentOf(result *engine.Result, err error) (*models.Environment, error) {
+	if err != nil {
+		return nil, err
+	}
+	return result.Environment, nil
+}
+
+func (b serverBackend) Exec(ctx context.Context, project, name string, req api.ExecRequest, stdout, stderr io.Writer) (int, error) {
+	if _, err := b.engine.Resume(ctx, project, name); err != nil {
+		return 0, err
+	}
+	provider, err := b.engine.ProviderFor(project, name)
+	if err != nil {
+		return 0, err
+	}
+	if err := provider.Ping(ctx); err != nil {
+		return 0, err
+	}
+
+	err = provider.Exec(ctx, project, name, req.Service, req.Command, runtime.ExecOptions{
+		Env:    req.Env,
+		Stdin:  strings.NewReader(""),
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
+
+func (b serverBackend) Logs(ctx context.Context, project, name string, req api.LogsRequest, w io.Writer) error {
+	provider, err := b.engine.ProviderFor(project, name)
+	if err != nil {
+		return err
+	}
+	if err := provider.Ping(ctx); err != nil {
+		return err
+	}
+	return provider.Logs(ctx, project, name, req.Service, runtime.LogOptions{
+		Follow: req.Follow,
+		Tail:   req.Tail,
+		Stdout: w,
+		Stderr: w,
+	})
+}
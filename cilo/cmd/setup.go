@@ -1,11 +1,15 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/sharedco/cilo/pkg/compose"
 	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/setup"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 )
@@ -17,18 +21,33 @@ var setupCmd = &cobra.Command{
 
 This configures the project for cilo with settings like:
 - Project name for DNS organization
-- Docker compose file location(s)
+- Docker compose file location(s), or a devcontainer.json or Procfile to
+  run instead
 - Build tool (docker/podman)
 - Default environment name
 - Hostname mappings
 
+Setup reads the compose services and proposes an ingress service, extra
+hostnames for it, services to share across environments, rewrites of
+localhost URLs in .env files to the environment's services, and the dot
+directories to copy into workspaces. It asks about each proposal, then
+writes them as a commented config. With --non-interactive, or without a
+terminal, the proposals are written as they are.
+
 Examples:
   # Interactive setup
   cilo setup
 
+  # Accept the detected settings, e.g. in CI
+  cilo setup --non-interactive
+
   # Quick setup with defaults (detects project name from directory)
   cilo setup --name myproject
 
+  # Compose files are detected as docker compose finds them: COMPOSE_FILE
+  # in .env, or compose.yaml/compose.yml/docker-compose.yml/...
+  # plus a matching override file
+
   # Setup with specific compose file
   cilo setup --compose ./docker/docker-compose.yml
 
@@ -69,6 +88,7 @@ Examples:
 		buildTool, _ := cmd.Flags().GetString("build-tool")
 		defaultEnv, _ := cmd.Flags().GetString("default-env")
 		dnsSuffix, _ := cmd.Flags().GetString("dns-suffix")
+		nonInteractive, _ := cmd.Flags().GetBool("non-interactive")
 
 		// Auto-detect project name from directory if not provided
 		if name == "" {
@@ -79,42 +99,43 @@ Examples:
 			name = filepath.Base(cwd)
 		}
 
-		// Auto-detect compose files if not provided
-		if len(composeFiles) == 0 {
-			possibleFiles := []string{
-				"docker-compose.yml",
-				"docker-compose.yaml",
-				"compose.yml",
-				"compose.yaml",
-			}
-			for _, f := range possibleFiles {
-				if _, err := os.Stat(f); err == nil {
-					composeFiles = append(composeFiles, f)
-					break
-				}
-			}
-			if len(composeFiles) == 0 {
-				return fmt.Errorf("no docker-compose.yml found in current directory (use --compose to specify path)")
-			}
+		if len(composeFiles) == 0 && compose.DetectFormat(".") == "" {
+			return fmt.Errorf("no docker-compose.yml, devcontainer.json or Procfile found in current directory (use --compose to specify path)")
 		}
 
-		// Validate compose files exist
-		for _, f := range composeFiles {
-			if _, err := os.Stat(f); err != nil {
-				return fmt.Errorf("compose file not found: %s", f)
-			}
+		// Propose the rest from the project: compose files found the way
+		// docker compose finds them, or a devcontainer.json or Procfile
+		// run through a compose file generated on up
+		proposal, err := setup.Detect(".", composeFiles)
+		if err != nil {
+			return err
+		}
+		for _, note := range proposal.Notes {
+			fmt.Printf("⚠ %s\n", note)
 		}
 
-		// Create config
 		config := models.ProjectConfig{
-			Project:            name,
-			BuildTool:          buildTool,
-			ComposeFiles:       composeFiles,
-			EnvFiles:           envFiles,
-			DNSSuffix:          dnsSuffix,
-			DefaultEnvironment: defaultEnv,
-			Hostnames:          []string{},
-			CopyDotDirs:        []string{".cilo"},
+			Project:               name,
+			BuildTool:             buildTool,
+			ComposeFiles:          proposal.ComposeFiles,
+			EnvFiles:              envFiles,
+			DNSSuffix:             dnsSuffix,
+			DefaultEnvironment:    defaultEnv,
+			DefaultIngressService: proposal.Ingress,
+			Hostnames:             proposal.Hostnames,
+			SharedServices:        proposal.Shared,
+			CopyDotDirs:           proposal.CopyDotDirs,
+		}
+		if len(proposal.EnvRender) > 0 {
+			config.Env = &models.EnvConfig{Render: proposal.EnvRender}
+		}
+
+		// Without a terminal there is nobody to ask, as in CI
+		if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice == 0 {
+			nonInteractive = true
+		}
+		if !nonInteractive {
+			reviewProposal(bufio.NewReader(os.Stdin), &config, proposal)
 		}
 
 		// Create .cilo directory
@@ -122,22 +143,40 @@ Examples:
 			return fmt.Errorf("failed to create .cilo directory: %w", err)
 		}
 
-		// Write config file
+		// Write config file, commented so it explains itself
 		configPath = filepath.Join(".cilo", "config.yml")
-		data, err := yaml.Marshal(config)
+		data, err := setup.Marshal(&config, proposal)
 		if err != nil {
-			return fmt.Errorf("failed to marshal config: %w", err)
+			return err
 		}
 
 		if err := os.WriteFile(configPath, data, 0644); err != nil {
 			return fmt.Errorf("failed to write config: %w", err)
 		}
+		// config.local.yml holds one checkout's settings; keep it out of git
+		gitignore := filepath.Join(".cilo", ".gitignore")
+		if _, err := os.Stat(gitignore); os.IsNotExist(err) {
+			if err := os.WriteFile(gitignore, []byte("config.local.yml\n"), 0644); err != nil {
+				fmt.Printf("Warning: failed to write %s: %v\n", gitignore, err)
+			}
+		}
 
+		name = config.Project
 		fmt.Printf("✓ Project configured: %s\n", name)
 		fmt.Printf("  Config: %s\n", configPath)
-		fmt.Printf("  Compose files:\n")
-		for _, f := range composeFiles {
-			fmt.Printf("    - %s\n", f)
+		if proposal.Format != compose.FormatCompose {
+			fmt.Printf("  Format: %s (compose file generated on up)\n", proposal.Format)
+		} else {
+			fmt.Printf("  Compose files:\n")
+			for _, f := range config.ComposeFiles {
+				fmt.Printf("    - %s\n", f)
+			}
+		}
+		if config.DefaultIngressService != "" {
+			fmt.Printf("  Ingress: %s\n", config.DefaultIngressService)
+		}
+		if len(config.SharedServices) > 0 {
+			fmt.Printf("  Shared: %s\n", strings.Join(config.SharedServices, ", "))
 		}
 		if len(envFiles) > 0 {
 			fmt.Printf("  Env files:\n")
@@ -161,4 +200,102 @@ func init() {
 	setupCmd.Flags().String("default-env", "dev", "Default environment name")
 	setupCmd.Flags().String("dns-suffix", "", "Override default DNS suffix (default: .test)")
 	setupCmd.Flags().Bool("force", false, "Overwrite existing configuration")
+	setupCmd.Flags().Bool("non-interactive", false, "Accept the detected settings without asking (implied without a terminal)")
+}
+
+// reviewProposal walks through the detected settings, letting the user keep
+// or change each one
+func reviewProposal(in *bufio.Reader, config *models.ProjectConfig, proposal *setup.Proposal) {
+	fmt.Printf("Services: %s\n", strings.Join(proposal.Services, ", "))
+	fmt.Printf("Press enter to keep the suggestion in brackets; \"-\" clears a list.\n\n")
+
+	config.Project = ask(in, "Project name", config.Project)
+	for {
+		ingress := ask(in, "Ingress service", config.DefaultIngressService)
+		if ingress == "" || contains(proposal.Services, ingress) {
+			config.DefaultIngressService = ingress
+			break
+		}
+		fmt.Printf("  no service named %q\n", ingress)
+	}
+	config.Hostnames = askList(in, "Extra hostnames of the ingress", config.Hostnames)
+	for {
+		shared := askList(in, "Services to share across environments", config.SharedServices)
+		unknown := ""
+		for _, svc := range shared {
+			if !contains(proposal.Services, svc) {
+				unknown = svc
+			}
+		}
+		if unknown == "" {
+			config.SharedServices = shared
+			break
+		}
+		fmt.Printf("  no service named %q\n", unknown)
+	}
+
+	if config.Env != nil {
+		var rules []models.EnvRenderRule
+		for _, rule := range config.Env.Render {
+			kept := models.EnvRenderRule{File: rule.File, Tokens: rule.Tokens}
+			for _, rep := range rule.Replace {
+				if askYes(in, fmt.Sprintf("In %s, rewrite %s to %s?", rule.File, rep.From, rep.To), true) {
+					kept.Replace = append(kept.Replace, rep)
+				}
+			}
+			if len(kept.Replace) > 0 {
+				rules = append(rules, kept)
+			}
+		}
+		config.Env = nil
+		if len(rules) > 0 {
+			config.Env = &models.EnvConfig{Render: rules}
+		}
+	}
+
+	config.CopyDotDirs = askList(in, "Dot directories to copy into workspaces", config.CopyDotDirs)
+	fmt.Println()
+}
+
+// ask prompts for a value, returning def when the answer is empty
+func ask(in *bufio.Reader, label, def string) string {
+	fmt.Printf("%s [%s]: ", label, def)
+	answer, _ := in.ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// askList prompts for a comma-separated list; "-" empties it
+func askList(in *bufio.Reader, label string, def []string) []string {
+	answer := ask(in, label, strings.Join(def, ", "))
+	if answer == "-" {
+		return nil
+	}
+	var list []string
+	for _, item := range strings.Split(answer, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// askYes prompts for a yes or no answer
+func askYes(in *bufio.Reader, question string, def bool) bool {
+	hint := "Y/n"
+	if !def {
+		hint = "y/N"
+	}
+	fmt.Printf("%s [%s]: ", question, hint)
+	answer, _ := in.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	default:
+		return def
+	}
 }
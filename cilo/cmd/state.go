@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/dns"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/reconcile"
+	"github.com/sharedco/cilo/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "State file maintenance commands",
+}
+
+var stateRepairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Rebuild a missing or corrupt state file",
+	Long: `Rebuild ~/.cilo/state.json when it is missing or can't be parsed.
+
+Repair starts from the newest readable backup (state.json.1 to state.json.5,
+kept on every change), or from empty state if there is none. It then brings
+that up to date from what lives outside state:
+
+- Workspaces' .cilo/meta.json files add environments missing from the backup
+- Environments whose workspace and containers are both gone are dropped
+- Containers' Docker labels give each environment's services and status
+- The DNS config gives service addresses and DNS suffixes
+- Shared service containers are re-registered with the environments they
+  are attached to
+
+A readable state file it replaces is kept as state.json.1; an unreadable one
+is moved to state.json.corrupt-<time>, leaving the backups alone. With no
+readable backup, the DNS port is taken from the DNS config. Use --rebuild to
+run the same rebuild on a state file that is readable.
+
+Examples:
+  cilo state repair
+  cilo state repair --rebuild --force`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rebuild, _ := cmd.Flags().GetBool("rebuild")
+		force, _ := cmd.Flags().GetBool("force")
+
+		base, err := state.LoadState()
+		switch {
+		case err == nil && !rebuild:
+			fmt.Println("✅ State is readable; nothing to repair (use --rebuild to rebuild it anyway)")
+			return nil
+		case err == nil:
+			fmt.Println("Rebuilding the current state")
+		case errors.Is(err, state.ErrCorrupt), errors.Is(err, state.ErrNotInitialized):
+			fmt.Printf("⚠️  %v\n", err)
+			backup, path, backupErr := state.LoadBackup()
+			if backupErr == nil {
+				base = backup
+				fmt.Printf("Starting from backup %s\n", path)
+			} else {
+				// The DNS config outlives state and records the port
+				port, portErr := dns.ConfigPort()
+				if portErr != nil || port == 0 {
+					port = dns.GetDNSPort(nil)
+				}
+				base = state.New("", port)
+				fmt.Printf("No readable backup; starting from empty state (DNS port %d)\n", port)
+			}
+		default:
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		notes, err := reconcile.Rebuild(ctx, base)
+		if err != nil {
+			return fmt.Errorf("failed to rebuild state: %w", err)
+		}
+		if base.BaseSubnet == "" {
+			base.BaseSubnet = "10.224."
+		}
+
+		fmt.Println()
+		for _, note := range notes {
+			fmt.Printf("  - %s\n", note)
+		}
+		printRepairSummary(base)
+
+		if !force {
+			fmt.Print("\nWrite this state? [y/N] ")
+			var response string
+			fmt.Scanln(&response)
+			if strings.ToLower(response) != "y" && strings.ToLower(response) != "yes" {
+				fmt.Println("Cancelled")
+				return nil
+			}
+		}
+
+		corrupt, err := state.Restore(base)
+		if err != nil {
+			return err
+		}
+		if corrupt != "" {
+			fmt.Printf("The unreadable state was moved to %s\n", corrupt)
+		}
+		if err := dns.UpdateDNSFromState(base); err != nil {
+			fmt.Printf("Warning: DNS update failed: %v\n", err)
+		}
+		fmt.Println("✅ State repaired")
+		return nil
+	},
+}
+
+// printRepairSummary lists the environments and shared services in a
+// rebuilt state
+func printRepairSummary(st *models.State) {
+	var envs []string
+	for key, env := range st.Hosts["local"].Environments {
+		envs = append(envs, fmt.Sprintf("%s (%s, %d services)", key, env.Status, len(env.Services)))
+	}
+	fmt.Printf("\n%d environments:\n", len(envs))
+	sort.Strings(envs)
+	for _, env := range envs {
+		fmt.Printf("  %s\n", env)
+	}
+	fmt.Printf("%d shared services\n", len(st.SharedServices))
+}
+
+func init() {
+	stateRepairCmd.Flags().Bool("rebuild", false, "Rebuild even if the state file is readable")
+	stateRepairCmd.Flags().Bool("force", false, "Skip confirmation prompt")
+	stateCmd.AddCommand(stateRepairCmd)
+	rootCmd.AddCommand(stateCmd)
+}
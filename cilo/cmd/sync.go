@@ -1,24 +1,52 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	"github.com/sharedco/cilo/pkg/config"
 	"github.com/sharedco/cilo/pkg/git"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
 	"github.com/sharedco/cilo/pkg/state"
 	"github.com/spf13/cobra"
 )
 
 var diffCmd = &cobra.Command{
-	Use:   "diff <env>",
+	Use:   "diff <env> [path...]",
 	Short: "Show logical diff between host and environment",
-	Args:  cobra.ExactArgs(1),
+	Long: `Diff shows what changed in an environment compared to the host.
+
+The env's working tree is compared, so uncommitted edits and untracked
+files are included. Files outside any git repo are compared against the
+environment's source directory.
+
+Examples:
+  # Full diff against the host's current HEAD
+  cilo diff my-env
+
+  # Summary of changed files
+  cilo diff my-env --stat
+  cilo diff my-env --name-only
+
+  # Compare against the source commit recorded when the env was created
+  cilo diff my-env --since-create
+
+  # Limit to paths
+  cilo diff my-env src/api docs`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		project, envName, err := getProjectAndEnv(cmd, args)
 		if err != nil {
 			return err
 		}
 
+		stat, _ := cmd.Flags().GetBool("stat")
+		nameOnly, _ := cmd.Flags().GetBool("name-only")
+		sinceCreate, _ := cmd.Flags().GetBool("since-create")
+
 		env, err := state.GetEnvironment(project, envName)
 		if err != nil {
 			return err
@@ -32,39 +60,105 @@ var diffCmd = &cobra.Command{
 			return err
 		}
 
-		if len(repos) == 0 {
-			fmt.Println("No git repositories found in environment.")
-			return nil
+		opts := git.DiffOptions{
+			Stat:     stat,
+			NameOnly: nameOnly,
+			Paths:    args[1:],
 		}
 
+		changes := false
 		for _, repo := range repos {
-			fmt.Printf("--- Repo: %s ---\n", repo.Name)
-			diff, err := git.Diff(hostRoot, envRoot, repo)
+			repoOpts := opts
+			if sinceCreate {
+				base := sourceCommit(env, repo.Path)
+				if base == "" {
+					fmt.Printf("--- Repo: %s ---\n", repo.Name)
+					fmt.Printf("No creation commit recorded for %s (diffing against host HEAD)\n", repo.Name)
+				}
+				repoOpts.Base = base
+			}
+
+			diff, err := git.Diff(hostRoot, envRoot, repo, repoOpts)
 			if err != nil {
+				fmt.Printf("--- Repo: %s ---\n", repo.Name)
 				fmt.Printf("Error diffing %s: %v\n", repo.Name, err)
 				continue
 			}
 			if diff == "" {
-				fmt.Println("No changes.")
-			} else {
+				continue
+			}
+			changes = true
+			fmt.Printf("--- Repo: %s ---\n", repo.Name)
+			fmt.Println(diff)
+		}
+
+		if hostRoot != "" {
+			diff, err := git.DiffTree(hostRoot, envRoot, opts)
+			if err != nil {
+				fmt.Printf("Error diffing files outside git: %v\n", err)
+			} else if diff != "" {
+				changes = true
+				fmt.Println("--- Files outside git ---")
 				fmt.Println(diff)
 			}
 		}
 
+		if !changes {
+			fmt.Println("No changes.")
+		}
+
 		return nil
 	},
 }
 
+// sourceCommit returns the commit a repo was at when the env was created
+func sourceCommit(env *models.Environment, repoPath string) string {
+	for _, repo := range env.SourceRepos {
+		if repo.Path == repoPath {
+			return repo.Commit
+		}
+	}
+	return ""
+}
+
 var mergeCmd = &cobra.Command{
 	Use:   "merge <env>",
 	Short: "Merge changes from environment back to host",
-	Args:  cobra.ExactArgs(1),
+	Long: `Merge brings commits made in an environment back to the host repos.
+
+By default env HEAD is merged into the host's current checkout. The host
+working tree must be clean; a conflicting merge is aborted and reported per
+repo so the host is never left half-merged.
+
+Examples:
+  # Merge into the current checkout
+  cilo merge my-env
+
+  # Fetch env HEAD into a branch without touching the checkout
+  cilo merge my-env --branch agent/my-env
+
+  # Squash all env commits into one
+  cilo merge my-env --squash
+
+  # Write a format-patch series instead of merging
+  cilo merge my-env --patch --patch-dir ./patches
+
+  # Commit the env's uncommitted work first
+  cilo merge my-env --include-uncommitted`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		project, envName, err := getProjectAndEnv(cmd, args)
 		if err != nil {
 			return err
 		}
 
+		branch, _ := cmd.Flags().GetString("branch")
+		squash, _ := cmd.Flags().GetBool("squash")
+		patch, _ := cmd.Flags().GetBool("patch")
+		patchDir, _ := cmd.Flags().GetString("patch-dir")
+		includeUncommitted, _ := cmd.Flags().GetBool("include-uncommitted")
+		message, _ := cmd.Flags().GetString("message")
+
 		env, err := state.GetEnvironment(project, envName)
 		if err != nil {
 			return err
@@ -83,13 +177,81 @@ var mergeCmd = &cobra.Command{
 			return nil
 		}
 
+		opts := git.MergeOptions{
+			Branch:             branch,
+			Squash:             squash,
+			IncludeUncommitted: includeUncommitted,
+			Message:            message,
+		}
+		if patch {
+			if patchDir == "" {
+				patchDir = filepath.Join("cilo-patches", envName)
+			}
+			opts.PatchDir, _ = filepath.Abs(patchDir)
+		}
+		if opts.Message == "" {
+			opts.Message = fmt.Sprintf("cilo: changes from environment %s", envName)
+		}
+
+		// Refuse up front so a dirty repo can't leave the others half-merged
+		if opts.TouchesCheckout() {
+			if err := git.CheckHostClean(hostRoot, repos); err != nil {
+				return err
+			}
+		}
+
+		var failed []string
+		conflicted := false
 		for _, repo := range repos {
 			fmt.Printf("Merging %s...\n", repo.Name)
-			if err := git.Merge(hostRoot, envRoot, repo); err != nil {
-				fmt.Printf("Error merging %s: %v\n", repo.Name, err)
+			repoOpts := opts
+			repoOpts.Base = sourceCommit(env, repo.Path)
+			result, err := git.Merge(hostRoot, envRoot, repo, repoOpts)
+			if err != nil {
+				var conflict *git.ConflictError
+				if errors.As(err, &conflict) {
+					conflicted = true
+					fmt.Printf("✗ %s: merge conflicts, host left unchanged\n", repo.Name)
+					for _, f := range conflict.Files {
+						fmt.Printf("    %s\n", f)
+					}
+				} else {
+					fmt.Printf("Error merging %s: %v\n", repo.Name, err)
+				}
+				failed = append(failed, repo.Name)
 				continue
 			}
-			fmt.Printf("✓ %s merged successfully\n", repo.Name)
+
+			if result.AutoCommitted {
+				fmt.Printf("  Committed uncommitted changes in environment\n")
+			}
+			if result.Uncommitted {
+				fmt.Printf("  ⚠ Environment has uncommitted changes that were not merged (use --include-uncommitted)\n")
+			}
+			if result.HostAhead > 0 {
+				fmt.Printf("  Host has %d new commit(s) since the environment was created\n", result.HostAhead)
+			}
+
+			switch {
+			case result.UpToDate():
+				fmt.Printf("✓ %s already up to date\n", repo.Name)
+			case result.Mode == git.ModeBranch:
+				fmt.Printf("✓ %s: %d commit(s) fetched into branch %s\n", repo.Name, result.Commits, branch)
+			case result.Mode == git.ModePatch:
+				fmt.Printf("✓ %s: wrote %d patch(es) to %s\n", repo.Name, len(result.Patches), filepath.Join(opts.PatchDir, repo.Name))
+			case result.Mode == git.ModeSquash:
+				fmt.Printf("✓ %s: %d commit(s) squashed and merged\n", repo.Name, result.Commits)
+			default:
+				fmt.Printf("✓ %s merged successfully (%d commit(s))\n", repo.Name, result.Commits)
+			}
+		}
+
+		if len(failed) > 0 {
+			err := fmt.Errorf("merge failed for %d repo(s): %s", len(failed), strings.Join(failed, ", "))
+			if conflicted {
+				return output.WithCode(output.CodeConflict, err)
+			}
+			return err
 		}
 
 		return nil
@@ -98,5 +260,16 @@ var mergeCmd = &cobra.Command{
 
 func init() {
 	diffCmd.Flags().String("project", "", "Project name (defaults to configured project)")
+	diffCmd.Flags().Bool("stat", false, "Show a diffstat instead of the full diff")
+	diffCmd.Flags().Bool("name-only", false, "Show only names of changed files")
+	diffCmd.Flags().Bool("since-create", false, "Compare against the source commit recorded at creation")
+	diffCmd.MarkFlagsMutuallyExclusive("stat", "name-only")
 	mergeCmd.Flags().String("project", "", "Project name (defaults to configured project)")
+	mergeCmd.Flags().String("branch", "", "Fetch env HEAD into this host branch instead of merging")
+	mergeCmd.Flags().Bool("squash", false, "Squash env commits into a single commit")
+	mergeCmd.Flags().Bool("patch", false, "Write a format-patch series instead of merging")
+	mergeCmd.Flags().String("patch-dir", "", "Directory for --patch output (default: ./cilo-patches/<env>)")
+	mergeCmd.Flags().Bool("include-uncommitted", false, "Commit the env's uncommitted changes before merging")
+	mergeCmd.Flags().StringP("message", "m", "", "Commit message for --squash and --include-uncommitted")
+	mergeCmd.MarkFlagsMutuallyExclusive("branch", "squash", "patch")
 }
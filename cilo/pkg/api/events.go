@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+// Event types sent on the /v1/events stream
+const (
+	// Operations requested through the API
+	EventOperationStarted   = "operation.started"
+	EventOperationSucceeded = "operation.succeeded"
+	EventOperationFailed    = "operation.failed"
+
+	// Changes seen in state, whether made through the API or the CLI
+	EventEnvironmentCreated   = "environment.created"
+	EventEnvironmentStatus    = "environment.status"
+	EventEnvironmentDestroyed = "environment.destroyed"
+)
+
+// Event is a lifecycle change
+type Event struct {
+	Type      string    `json:"type"`
+	Project   string    `json:"project"`
+	Env       string    `json:"env"`
+	Operation string    `json:"operation,omitempty"` // create, up, down or destroy
+	Status    string    `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Hub fans events out to subscribers
+type Hub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// NewHub returns a hub with no subscribers
+func NewHub() *Hub {
+	return &Hub{subs: make(map[chan Event]struct{})}
+}
+
+// Publish sends an event to every subscriber. A subscriber that has fallen
+// too far behind misses the event rather than blocking the publisher.
+func (h *Hub) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of events and a function that ends the
+// subscription
+func (h *Hub) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+// WatchState polls state and publishes an event whenever an environment
+// appears, disappears or changes status, until ctx is done
+func WatchState(ctx context.Context, hub *Hub, load func() ([]*models.Environment, error), interval time.Duration) {
+	previous := snapshotStatuses(load)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := snapshotStatuses(load)
+		if current == nil {
+			continue
+		}
+		for _, event := range diffStatuses(previous, current) {
+			hub.Publish(event)
+		}
+		previous = current
+	}
+}
+
+type envKey struct {
+	project string
+	name    string
+}
+
+// snapshotStatuses returns each environment's status, or nil if state
+// couldn't be read
+func snapshotStatuses(load func() ([]*models.Environment, error)) map[envKey]string {
+	envs, err := load()
+	if err != nil {
+		return nil
+	}
+	statuses := make(map[envKey]string, len(envs))
+	for _, env := range envs {
+		statuses[envKey{env.Project, env.Name}] = env.Status
+	}
+	return statuses
+}
+
+func diffStatuses(previous, current map[envKey]string) []Event {
+	var events []Event
+	for key, status := range current {
+		old, existed := previous[key]
+		switch {
+		case !existed && previous != nil:
+			events = append(events, Event{Type: EventEnvironmentCreated, Project: key.project, Env: key.name, Status: status})
+		case existed && old != status:
+			events = append(events, Event{Type: EventEnvironmentStatus, Project: key.project, Env: key.name, Status: status})
+		}
+	}
+	for key := range previous {
+		if _, exists := current[key]; !exists {
+			events = append(events, Event{Type: EventEnvironmentDestroyed, Project: key.project, Env: key.name})
+		}
+	}
+	return events
+}
@@ -0,0 +1,446 @@
+// Package api serves cilo's control API: JSON over HTTP on a unix socket.
+//
+// Responses use the documents from pkg/output, and failures are output
+// error documents with an HTTP status derived from the error code.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// Backend carries out the operations the API exposes
+type Backend interface {
+	List(ctx context.Context) ([]*models.Environment, error)
+	Status(ctx context.Context, project, name string) (*models.Environment, error)
+	Create(ctx context.Context, req CreateRequest) (*models.Environment, error)
+	Up(ctx context.Context, project, name string, req UpRequest) (*models.Environment, error)
+	Down(ctx context.Context, project, name string) (*models.Environment, error)
+	Destroy(ctx context.Context, project, name string, keepWorkspace bool) (*models.Environment, error)
+	// Exec returns the command's exit code; err is only for failures to run it
+	Exec(ctx context.Context, project, name string, req ExecRequest, stdout, stderr io.Writer) (int, error)
+	Logs(ctx context.Context, project, name string, req LogsRequest, w io.Writer) error
+}
+
+// CreateRequest is the body of POST /v1/environments
+type CreateRequest struct {
+	Name    string `json:"name"`
+	Project string `json:"project,omitempty"`
+	From    string `json:"from"` // Absolute source path
+	Empty   bool   `json:"empty,omitempty"`
+	Include string `json:"include,omitempty"`
+	TTL     string `json:"ttl,omitempty"`  // e.g. "4h" or "3d"
+	Host    string `json:"host,omitempty"` // ID of a host from 'cilo host add'
+}
+
+// UpRequest is the body of POST /v1/environments/{project}/{name}/up
+type UpRequest struct {
+	Build       bool     `json:"build,omitempty"`
+	Recreate    bool     `json:"recreate,omitempty"`
+	Wait        bool     `json:"wait,omitempty"`
+	WaitTimeout string   `json:"wait_timeout,omitempty"` // Go duration, e.g. "90s"
+	Shared      []string `json:"shared,omitempty"`
+	Isolate     []string `json:"isolate,omitempty"`
+	// Resources sets per-environment limits, kept for later ups
+	Resources *models.ResourceLimits `json:"resources,omitempty"`
+	// Queue waits for room in the host budget instead of failing with 429
+	Queue        bool   `json:"queue,omitempty"`
+	QueueTimeout string `json:"queue_timeout,omitempty"` // Go duration
+	// Profile runs a profile's services, kept for later ups; NoProfile
+	// drops it
+	Profile   string `json:"profile,omitempty"`
+	NoProfile bool   `json:"no_profile,omitempty"`
+	// Images swaps in prebuilt images by service, kept for later ups; an
+	// empty ref drops a swap
+	Images map[string]string `json:"images,omitempty"`
+}
+
+// ExecRequest is the body of POST /v1/environments/{project}/{name}/exec
+type ExecRequest struct {
+	Service string            `json:"service"`
+	Command []string          `json:"command"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+// LogsRequest holds the query parameters of GET .../logs
+type LogsRequest struct {
+	Service string
+	Tail    int
+	Follow  bool
+}
+
+// Server handles API requests
+type Server struct {
+	backend Backend
+	hub     *Hub
+}
+
+// NewServer returns a server for backend. Lifecycle events are published
+// to hub.
+func NewServer(backend Backend, hub *Hub) *Server {
+	return &Server{backend: backend, hub: hub}
+}
+
+// Handler returns the API's routes
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/health", s.handleHealth)
+	mux.HandleFunc("GET /v1/events", s.handleEvents)
+	mux.HandleFunc("GET /v1/environments", s.handleList)
+	mux.HandleFunc("POST /v1/environments", s.handleCreate)
+	mux.HandleFunc("GET /v1/environments/{project}/{name}", s.handleStatus)
+	mux.HandleFunc("DELETE /v1/environments/{project}/{name}", s.handleDestroy)
+	mux.HandleFunc("POST /v1/environments/{project}/{name}/up", s.handleUp)
+	mux.HandleFunc("POST /v1/environments/{project}/{name}/down", s.handleDown)
+	mux.HandleFunc("POST /v1/environments/{project}/{name}/exec", s.handleExec)
+	mux.HandleFunc("GET /v1/environments/{project}/{name}/logs", s.handleLogs)
+	return mux
+}
+
+// Serve listens on a unix socket until ctx is done. A stale socket left by
+// a previous server is replaced; a live one is an error.
+func (s *Server) Serve(ctx context.Context, socketPath string) error {
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return fmt.Errorf("another server is already listening on %s", socketPath)
+	}
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socketPath)
+	// The API can run anything in the user's environments
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	httpServer := &http.Server{Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// mutate runs op, publishing operation events around it. The backend holds
+// the environment's operation lock (state.LockEnvironment) while op runs,
+// which also serializes it with CLI invocations.
+func (s *Server) mutate(w http.ResponseWriter, project, name, operation string, op func() (*models.Environment, error)) {
+	s.hub.Publish(Event{Type: EventOperationStarted, Project: project, Env: name, Operation: operation})
+	env, err := op()
+	if err != nil {
+		s.hub.Publish(Event{Type: EventOperationFailed, Project: project, Env: name, Operation: operation, Error: err.Error()})
+		writeError(w, err)
+		return
+	}
+	s.hub.Publish(Event{Type: EventOperationSucceeded, Project: project, Env: name, Operation: operation, Status: env.Status})
+
+	status := http.StatusOK
+	if operation == "create" {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, environmentDocument(env))
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	envs, err := s.backend.List(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	items := make([]output.Environment, 0, len(envs))
+	for _, env := range envs {
+		items = append(items, environmentDocument(env))
+	}
+	writeJSON(w, http.StatusOK, output.NewEnvironmentList(items))
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	env, err := s.backend.Status(r.Context(), r.PathValue("project"), r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, environmentDocument(env))
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req CreateRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Name == "" || req.From == "" {
+		writeBadRequest(w, errors.New("name and from are required"))
+		return
+	}
+	// The server's working directory means nothing to the client
+	if !filepath.IsAbs(req.From) {
+		writeBadRequest(w, fmt.Errorf("from must be an absolute path, got %q", req.From))
+		return
+	}
+	req.From = filepath.Clean(req.From)
+	name := state.NormalizeName(req.Name)
+	// Resolve the project the way create would, so events carry the env's
+	// real key
+	if req.Project == "" {
+		config, err := models.LoadProjectConfigFromPath(req.From)
+		if err != nil {
+			writeBadRequest(w, err)
+			return
+		}
+		req.Project = models.ProjectName(req.From, config)
+	}
+	s.mutate(w, req.Project, name, "create", func() (*models.Environment, error) {
+		return s.backend.Create(r.Context(), req)
+	})
+}
+
+func (s *Server) handleUp(w http.ResponseWriter, r *http.Request) {
+	var req UpRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.WaitTimeout != "" {
+		if _, err := time.ParseDuration(req.WaitTimeout); err != nil {
+			writeBadRequest(w, fmt.Errorf("invalid wait_timeout: %w", err))
+			return
+		}
+	}
+	if req.QueueTimeout != "" {
+		if _, err := time.ParseDuration(req.QueueTimeout); err != nil {
+			writeBadRequest(w, fmt.Errorf("invalid queue_timeout: %w", err))
+			return
+		}
+	}
+	if req.Profile != "" && req.NoProfile {
+		writeBadRequest(w, fmt.Errorf("profile and no_profile can't be used together"))
+		return
+	}
+	project, name := r.PathValue("project"), r.PathValue("name")
+	s.mutate(w, project, name, "up", func() (*models.Environment, error) {
+		return s.backend.Up(r.Context(), project, name, req)
+	})
+}
+
+func (s *Server) handleDown(w http.ResponseWriter, r *http.Request) {
+	project, name := r.PathValue("project"), r.PathValue("name")
+	s.mutate(w, project, name, "down", func() (*models.Environment, error) {
+		return s.backend.Down(r.Context(), project, name)
+	})
+}
+
+func (s *Server) handleDestroy(w http.ResponseWriter, r *http.Request) {
+	project, name := r.PathValue("project"), r.PathValue("name")
+	keepWorkspace := r.URL.Query().Get("keep_workspace") == "true"
+	s.mutate(w, project, name, "destroy", func() (*models.Environment, error) {
+		return s.backend.Destroy(r.Context(), project, name, keepWorkspace)
+	})
+}
+
+func (s *Server) handleExec(w http.ResponseWriter, r *http.Request) {
+	var req ExecRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Service == "" || len(req.Command) == 0 {
+		writeBadRequest(w, errors.New("service and command are required"))
+		return
+	}
+
+	var stdout, stderr limitedBuffer
+	exitCode, err := s.backend.Exec(r.Context(), r.PathValue("project"), r.PathValue("name"), req, &stdout, &stderr)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, output.NewExecResult(exitCode, stdout.String(), stderr.String()))
+}
+
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := LogsRequest{
+		Service: query.Get("service"),
+		Follow:  query.Get("follow") == "true",
+		Tail:    100,
+	}
+	if tail := query.Get("tail"); tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil {
+			writeBadRequest(w, fmt.Errorf("invalid tail: %w", err))
+			return
+		}
+		req.Tail = n
+	}
+
+	project, name := r.PathValue("project"), r.PathValue("name")
+	if _, err := s.backend.Status(r.Context(), project, name); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	// Once streaming has started a failure can only be noted in the body
+	if err := s.backend.Logs(r.Context(), project, name, req, &flushWriter{w: w}); err != nil && r.Context().Err() == nil {
+		fmt.Fprintf(w, "\nerror: %v\n", err)
+	}
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, errors.New("streaming not supported"))
+		return
+	}
+
+	events, unsubscribe := s.hub.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+func environmentDocument(env *models.Environment) output.Environment {
+	return output.NewEnvironment(env, state.GetEnvStoragePath(env.Project, env.Name))
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.ContentLength == 0 {
+		return true
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeBadRequest(w, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+// httpStatus maps an output error code to an HTTP status
+func httpStatus(code string) int {
+	switch code {
+	case output.CodeNotFound:
+		return http.StatusNotFound
+	case output.CodeConflict:
+		return http.StatusConflict
+	case output.CodeRuntimeUnavailable, output.CodeNotInitialized, output.CodeStateCorrupt:
+		return http.StatusServiceUnavailable
+	case output.CodeOverBudget:
+		return http.StatusTooManyRequests
+	case output.CodeInvalidConfig:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	doc := output.NewError(err)
+	writeJSON(w, httpStatus(doc.Code), doc)
+}
+
+func writeBadRequest(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusBadRequest, output.NewError(err))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	output.Write(w, output.FormatJSON, v)
+}
+
+// flushWriter flushes after every write so log lines stream as they arrive
+type flushWriter struct {
+	w  http.ResponseWriter
+	mu sync.Mutex
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+// maxExecOutput caps how much of each exec stream is returned
+const maxExecOutput = 4 << 20
+
+// limitedBuffer keeps the first maxExecOutput bytes written to it
+type limitedBuffer struct {
+	mu        sync.Mutex
+	data      []byte
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	room := maxExecOutput - len(b.data)
+	if len(p) > room {
+		b.data = append(b.data, p[:room]...)
+		b.truncated = true
+	} else {
+		b.data = append(b.data, p...)
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.truncated {
+		return string(b.data) + "\n[output truncated]\n"
+	}
+	return string(b.data)
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// fakeBackend keeps environments in memory
+type fakeBackend struct {
+	envs map[string]*models.Environment
+}
+
+func (f *fakeBackend) get(project, name string) (*models.Environment, error) {
+	env, ok := f.envs[project+"/"+name]
+	if !ok {
+		return nil, fmt.Errorf("environment %q: %w", name, state.ErrNotFound)
+	}
+	return env, nil
+}
+
+func (f *fakeBackend) List(ctx context.Context) ([]*models.Environment, error) {
+	var envs []*models.Environment
+	for _, env := range f.envs {
+		envs = append(envs, env)
+	}
+	return envs, nil
+}
+
+func (f *fakeBackend) Status(ctx context.Context, project, name string) (*models.Environment, error) {
+	return f.get(project, name)
+}
+
+func (f *fakeBackend) Create(ctx context.Context, req CreateRequest) (*models.Environment, error) {
+	if _, err := f.get(req.Project, req.Name); err == nil {
+		return nil, fmt.Errorf("environment %q: %w", req.Name, state.ErrAlreadyExists)
+	}
+	env := &models.Environment{Name: req.Name, Project: req.Project, Status: "created", Source: req.From}
+	f.envs[req.Project+"/"+req.Name] = env
+	return env, nil
+}
+
+func (f *fakeBackend) Up(ctx context.Context, project, name string, req UpRequest) (*models.Environment, error) {
+	env, err := f.get(project, name)
+	if err != nil {
+		return nil, err
+	}
+	env.Status = "running"
+	return env, nil
+}
+
+func (f *fakeBackend) Down(ctx context.Context, project, name string) (*models.Environment, error) {
+	env, err := f.get(project, name)
+	if err != nil {
+		return nil, err
+	}
+	env.Status = "stopped"
+	return env, nil
+}
+
+func (f *fakeBackend) Destroy(ctx context.Context, project, name string, keepWorkspace bool) (*models.Environment, error) {
+	env, err := f.get(project, name)
+	if err != nil {
+		return nil, err
+	}
+	delete(f.envs, project+"/"+name)
+	return env, nil
+}
+
+func (f *fakeBackend) Exec(ctx context.Context, project, name string, req ExecRequest, stdout, stderr io.Writer) (int, error) {
+	fmt.Fprint(stdout, strings.Join(req.Command, " "))
+	return 3, nil
+}
+
+func (f *fakeBackend) Logs(ctx context.Context, project, name string, req LogsRequest, w io.Writer) error {
+	fmt.Fprintf(w, "%s tail=%d\n", req.Service, req.Tail)
+	return nil
+}
+
+func do(t *testing.T, server *httptest.Server, method, path, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	var decoded map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		t.Fatalf("decode %s %s: %v", method, path, err)
+	}
+	return resp.StatusCode, decoded
+}
+
+func TestServer_Lifecycle(t *testing.T) {
+	hub := NewHub()
+	events, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+	server := httptest.NewServer(NewServer(&fakeBackend{envs: map[string]*models.Environment{}}, hub).Handler())
+	defer server.Close()
+
+	status, doc := do(t, server, "POST", "/v1/environments", `{"name":"feat","project":"shop","from":"/src"}`)
+	if status != http.StatusCreated || doc["kind"] != "environment" || doc["status"] != "created" {
+		t.Fatalf("create: %d %v", status, doc)
+	}
+
+	status, doc = do(t, server, "POST", "/v1/environments", `{"name":"feat","project":"shop","from":"/src"}`)
+	if status != http.StatusConflict || doc["code"] != "conflict" {
+		t.Fatalf("duplicate create: %d %v", status, doc)
+	}
+
+	status, doc = do(t, server, "POST", "/v1/environments/shop/feat/up", `{"wait":true}`)
+	if status != http.StatusOK || doc["status"] != "running" {
+		t.Fatalf("up: %d %v", status, doc)
+	}
+
+	status, doc = do(t, server, "POST", "/v1/environments/shop/feat/exec", `{"service":"api","command":["echo","hi"]}`)
+	if status != http.StatusOK || doc["exit_code"] != float64(3) || doc["stdout"] != "echo hi" {
+		t.Fatalf("exec: %d %v", status, doc)
+	}
+
+	status, doc = do(t, server, "GET", "/v1/environments/shop/missing", "")
+	if status != http.StatusNotFound || doc["code"] != "not_found" {
+		t.Fatalf("status of missing env: %d %v", status, doc)
+	}
+
+	status, doc = do(t, server, "POST", "/v1/environments/shop/feat/up", `{"bogus":true}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("expected unknown field to be rejected, got %d %v", status, doc)
+	}
+
+	status, doc = do(t, server, "POST", "/v1/environments", `{"name":"other","project":"shop","from":"src"}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("expected relative from to be rejected, got %d %v", status, doc)
+	}
+
+	var types []string
+	for len(types) < 6 {
+		select {
+		case event := <-events:
+			types = append(types, event.Operation+":"+event.Type)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for events, got %v", types)
+		}
+	}
+	want := []string{
+		"create:" + EventOperationStarted, "create:" + EventOperationSucceeded,
+		"create:" + EventOperationStarted, "create:" + EventOperationFailed,
+		"up:" + EventOperationStarted, "up:" + EventOperationSucceeded,
+	}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+}
+
+func TestServer_EventStream(t *testing.T) {
+	hub := NewHub()
+	server := httptest.NewServer(NewServer(&fakeBackend{envs: map[string]*models.Environment{}}, hub).Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/events")
+	if err != nil {
+		t.Fatalf("get events: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The handler has subscribed once the response headers arrive
+	hub.Publish(Event{Type: EventEnvironmentStatus, Project: "shop", Env: "feat", Status: "stopped"})
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read event: %v", err)
+	}
+	if line != "event: "+EventEnvironmentStatus+"\n" {
+		t.Fatalf("unexpected event line %q", line)
+	}
+	data, _ := reader.ReadString('\n')
+	if !strings.Contains(data, `"status":"stopped"`) {
+		t.Fatalf("unexpected data line %q", data)
+	}
+}
+
+func TestDiffStatuses(t *testing.T) {
+	previous := map[envKey]string{{"shop", "a"}: "running", {"shop", "b"}: "running"}
+	current := map[envKey]string{{"shop", "a"}: "stopped", {"shop", "c"}: "created"}
+
+	got := map[string]string{}
+	for _, event := range diffStatuses(previous, current) {
+		got[event.Env] = event.Type
+	}
+	want := map[string]string{"a": EventEnvironmentStatus, "b": EventEnvironmentDestroyed, "c": EventEnvironmentCreated}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("diffStatuses = %v, want %v", got, want)
+	}
+}
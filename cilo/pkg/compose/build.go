@@ -0,0 +1,371 @@
+package compose
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ServiceBuild is a service's build section, with its paths made absolute
+type ServiceBuild struct {
+	Context    string
+	Dockerfile string // Relative to Context unless absolute; empty is "Dockerfile"
+	Target     string
+	Args       map[string]string
+	// Unsupported lists build options cilo doesn't pass to docker build,
+	// such as secrets or a remote context; such services are left for
+	// compose to build
+	Unsupported []string
+}
+
+// parseBuild reads a build section, a context path or a mapping, resolving
+// relative paths against dir
+func parseBuild(value interface{}, dir string) *ServiceBuild {
+	build := &ServiceBuild{Args: map[string]string{}}
+	switch val := value.(type) {
+	case string:
+		build.Context = val
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			switch key {
+			case "context":
+				build.Context = fmt.Sprintf("%v", val[key])
+			case "dockerfile":
+				build.Dockerfile = fmt.Sprintf("%v", val[key])
+			case "target":
+				build.Target = fmt.Sprintf("%v", val[key])
+			case "args":
+				parseBuildArgs(build, val[key])
+			default:
+				build.Unsupported = append(build.Unsupported, key)
+			}
+		}
+	default:
+		return nil
+	}
+
+	if build.Context == "" {
+		build.Context = "."
+	}
+	if strings.Contains(build.Context, "://") || strings.HasPrefix(build.Context, "git@") {
+		build.Unsupported = append(build.Unsupported, "remote context")
+		return build
+	}
+	if !filepath.IsAbs(build.Context) {
+		build.Context = filepath.Join(dir, build.Context)
+	}
+	return build
+}
+
+// parseBuildArgs reads build args, a mapping or a list of KEY=VALUE. An arg
+// without a value comes from the environment compose runs in, which cilo
+// doesn't see, so it leaves the build to compose.
+func parseBuildArgs(build *ServiceBuild, value interface{}) {
+	switch val := value.(type) {
+	case map[string]interface{}:
+		for k, v := range val {
+			if v == nil {
+				build.Unsupported = append(build.Unsupported, "args."+k)
+				continue
+			}
+			build.Args[k] = fmt.Sprintf("%v", v)
+		}
+	case []interface{}:
+		for _, item := range val {
+			k, v, ok := strings.Cut(fmt.Sprintf("%v", item), "=")
+			if !ok {
+				build.Unsupported = append(build.Unsupported, "args."+k)
+				continue
+			}
+			build.Args[k] = v
+		}
+	}
+}
+
+// DockerfilePath returns the absolute path of the build's Dockerfile
+func (b *ServiceBuild) DockerfilePath() string {
+	dockerfile := b.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	if filepath.IsAbs(dockerfile) {
+		return dockerfile
+	}
+	return filepath.Join(b.Context, dockerfile)
+}
+
+// HashOptions configures ServiceBuild.Hash
+type HashOptions struct {
+	// Cache, when set, supplies the content hashes of files unchanged
+	// since it last saw them, and keeps those it reads
+	Cache *HashCache
+}
+
+// Hash returns a content hash of what the build sees: the files in its
+// context that .dockerignore doesn't exclude, its Dockerfile, target and
+// args. Where the context lives doesn't count, so workspaces copied from the
+// same source hash the same. Git metadata and cilo's .cilo directories are
+// left out, as they differ between workspaces.
+func (b *ServiceBuild) Hash(opts HashOptions) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "target %q\n", b.Target)
+	args := make([]string, 0, len(b.Args))
+	for k := range b.Args {
+		args = append(args, k)
+	}
+	sort.Strings(args)
+	for _, k := range args {
+		fmt.Fprintf(h, "arg %q=%q\n", k, b.Args[k])
+	}
+	if err := hashFile(h, "dockerfile", b.DockerfilePath(), opts.Cache); err != nil {
+		return "", err
+	}
+
+	ignore, err := readDockerignore(b.Context)
+	if err != nil {
+		return "", err
+	}
+	err = filepath.WalkDir(b.Context, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(b.Context, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if name := d.Name(); name == ".git" || name == ".cilo" {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignore.excludes(rel) {
+			// A later exception may bring back something inside
+			if d.IsDir() && !ignore.exceptions {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "link %q %q\n", rel, target)
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return hashFile(h, fmt.Sprintf("file %q %t", rel, info.Mode()&0111 != 0), path, opts.Cache)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash build context %s: %w", b.Context, err)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12], nil
+}
+
+// hashFile writes a header and the hash of the file's content to h
+func hashFile(h io.Writer, header, path string, cache *HashCache) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	sum, err := cache.hash(path, info)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "%s %s\n", header, sum)
+	return nil
+}
+
+// HashCache keeps the content hashes of build context files by path, size
+// and modification time, so hashing a context again only reads the files
+// changed since
+type HashCache struct {
+	Files map[string]CachedHash `json:"files"`
+	used  map[string]bool
+}
+
+// CachedHash is a file's content hash as of its size and modification time
+type CachedHash struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Hash    string    `json:"hash"`
+}
+
+// LoadHashCache reads a hash cache, returning an empty one if the file is
+// missing or unreadable
+func LoadHashCache(path string) *HashCache {
+	cache := &HashCache{}
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, cache)
+	}
+	return cache
+}
+
+// Save writes the cache, keeping only the files hashed since it was loaded
+func (c *HashCache) Save(path string) error {
+	for file := range c.Files {
+		if !c.used[file] {
+			delete(c.Files, file)
+		}
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// hash returns the content hash of a file, reading it unless the cache
+// has it at the same size and modification time. A nil cache reads every
+// file.
+func (c *HashCache) hash(path string, info os.FileInfo) (string, error) {
+	stamp := CachedHash{Size: info.Size(), ModTime: info.ModTime().UTC()}
+	if c != nil {
+		if c.used == nil {
+			c.used = map[string]bool{}
+		}
+		c.used[path] = true
+		if cached, ok := c.Files[path]; ok && cached.Size == stamp.Size && cached.ModTime.Equal(stamp.ModTime) {
+			return cached.Hash, nil
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	stamp.Hash = hex.EncodeToString(h.Sum(nil))
+	if c != nil {
+		if c.Files == nil {
+			c.Files = map[string]CachedHash{}
+		}
+		c.Files[path] = stamp
+	}
+	return stamp.Hash, nil
+}
+
+// ImageTag is the tag cilo builds a service's image as, for a build context
+// hash
+func ImageTag(project, service, hash string) string {
+	return fmt.Sprintf("cilo/%s/%s:%s", strings.ToLower(project), strings.ToLower(service), hash)
+}
+
+// dockerignore holds a build context's .dockerignore patterns
+type dockerignore struct {
+	patterns   []ignorePattern
+	exceptions bool // Some pattern starts with "!"
+}
+
+type ignorePattern struct {
+	re        *regexp.Regexp
+	exception bool
+}
+
+func readDockerignore(context string) (*dockerignore, error) {
+	ignore := &dockerignore{}
+	f, err := os.Open(filepath.Join(context, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return ignore, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			pattern.exception = true
+			ignore.exceptions = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(line)), "/")
+		pattern.re = regexp.MustCompile("^" + globToRegexp(line) + "$")
+		ignore.patterns = append(ignore.patterns, pattern)
+	}
+	return ignore, scanner.Err()
+}
+
+// excludes reports whether a context path, slash-separated, is left out of
+// the build. As with docker, the last matching pattern wins, and a pattern
+// matching a directory matches everything in it.
+func (d *dockerignore) excludes(rel string) bool {
+	excluded := false
+	for _, pattern := range d.patterns {
+		if pattern.matches(rel) {
+			excluded = !pattern.exception
+		}
+	}
+	return excluded
+}
+
+func (p ignorePattern) matches(rel string) bool {
+	for {
+		if p.re.MatchString(rel) {
+			return true
+		}
+		i := strings.LastIndex(rel, "/")
+		if i < 0 {
+			return false
+		}
+		rel = rel[:i]
+	}
+}
+
+// globToRegexp translates a .dockerignore pattern: "*" and "?" stay within
+// a path segment, "**" crosses them
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
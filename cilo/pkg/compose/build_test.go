@@ -0,0 +1,125 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServiceBuildHash(t *testing.T) {
+	// Two workspaces copied from the same source
+	var builds []*ServiceBuild
+	for range 2 {
+		root := t.TempDir()
+		files := map[string]string{
+			"compose.yaml":                 "services:\n  api:\n    build:\n      context: ./api\n      args:\n        - NODE_ENV=production\n  worker:\n    build:\n      context: ./api\n      secrets: [npm]\n",
+			"api/Dockerfile":               "FROM node:20\nCOPY . .\n",
+			"api/server.js":                "listen(8080)\n",
+			"api/.dockerignore":            "**/*.log\nnode_modules\n!node_modules/keep.js\n",
+			"api/debug.log":                "ignored\n",
+			"api/node_modules/left/out.js": "ignored\n",
+		}
+		for name, content := range files {
+			path := filepath.Join(root, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("MkdirAll: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+		}
+		services, err := LoadServices([]string{filepath.Join(root, "compose.yaml")})
+		if err != nil {
+			t.Fatalf("LoadServices: %v", err)
+		}
+		build := services["api"].Build
+		if build == nil || build.Context != filepath.Join(root, "api") || build.Args["NODE_ENV"] != "production" {
+			t.Fatalf("api build = %+v", build)
+		}
+		if worker := services["worker"].Build; len(worker.Unsupported) != 1 || worker.Unsupported[0] != "secrets" {
+			t.Fatalf("worker build = %+v, want secrets unsupported", worker)
+		}
+		builds = append(builds, build)
+	}
+
+	hash := func(b *ServiceBuild) string {
+		h, err := b.Hash(HashOptions{})
+		if err != nil {
+			t.Fatalf("Hash: %v", err)
+		}
+		return h
+	}
+	first := hash(builds[0])
+	if second := hash(builds[1]); second != first {
+		t.Fatalf("hashes = %s, %s; want the same for identical contexts", first, second)
+	}
+
+	// Ignored files don't count, except those brought back
+	context := builds[1].Context
+	changes := []struct {
+		path    string
+		changes bool
+	}{
+		{"debug.log", false},
+		{"node_modules/left/out.js", false},
+		{"node_modules/keep.js", true},
+		{"server.js", true},
+	}
+	for _, change := range changes {
+		if err := os.WriteFile(filepath.Join(context, change.path), []byte("changed\n"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		got := hash(builds[1])
+		if (got != first) != change.changes {
+			t.Errorf("writing %s: hash changed = %t, want %t", change.path, got != first, change.changes)
+		}
+		first = got
+	}
+
+	// Nor do git metadata or cilo's files
+	for _, path := range []string{".git/HEAD", ".cilo/meta.json"} {
+		path = filepath.Join(context, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("per workspace\n"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	if got := hash(builds[1]); got != first {
+		t.Errorf("Hash with workspace files = %s, want %s", got, first)
+	}
+
+	// A cache reads a file again only once its size or mtime changes
+	cache := HashOptions{Cache: &HashCache{}}
+	cached, err := builds[1].Hash(cache)
+	if err != nil || cached != first {
+		t.Fatalf("Hash with a cache = %s, %v; want %s", cached, err, first)
+	}
+	server := filepath.Join(context, "server.js")
+	info, err := os.Stat(server)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if err := os.WriteFile(server, []byte("CHANGED\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.Chtimes(server, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	if got, _ := builds[1].Hash(cache); got != cached {
+		t.Errorf("Hash with a cache = %s, want the cached %s", got, cached)
+	}
+	if got := hash(builds[1]); got == cached {
+		t.Errorf("Hash without a cache missed the change")
+	}
+	first = hash(builds[1])
+
+	builds[1].Args["NODE_ENV"] = "development"
+	if hash(builds[1]) == first {
+		t.Errorf("hash ignores build args")
+	}
+	if tag := ImageTag("Shop", "api", "abc123"); tag != "cilo/shop/api:abc123" {
+		t.Errorf("ImageTag = %s", tag)
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/sharedco/cilo/pkg/config"
 	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/resources"
 	"gopkg.in/yaml.v3"
 )
 
@@ -19,11 +20,53 @@ func Transform(env *models.Environment, baseFiles []string, overridePath, dnsSuf
 
 // TransformWithShared creates a cilo override compose file, skipping shared services
 func TransformWithShared(env *models.Environment, baseFiles []string, overridePath, dnsSuffix string, sharedServices []string) error {
+	return TransformWithOptions(env, baseFiles, overridePath, TransformOptions{
+		DNSSuffix: dnsSuffix,
+		Shared:    sharedServices,
+	})
+}
+
+// TransformOptions configures TransformWithOptions
+type TransformOptions struct {
+	DNSSuffix string
+	Shared    []string               // Services provided by shared containers instead of the env
+	Resources *models.ResourceLimits // Applied to every service, except limits a service sets itself
+	Profiles  []string               // Enabled compose profiles; services outside them get no address
+	Ingress   string                 // Ingress service when no cilo.ingress label names one
+	Hostnames []string               // Extra hostnames of the ingress service
+	// Services, when set, are the only services the environment runs; the
+	// rest are scaled to zero, as a profile chooses
+	Services []string
+	// Images run in place of the image a service names or builds, by
+	// service
+	Images map[string]string
+}
+
+// TransformWithOptions creates a cilo override compose file
+func TransformWithOptions(env *models.Environment, baseFiles []string, overridePath string, opts TransformOptions) error {
+	dnsSuffix := opts.DNSSuffix
+	sharedServices := opts.Shared
+	scaledDown := func(name string) bool {
+		return opts.Services != nil && !contains(opts.Services, name) && !contains(sharedServices, name)
+	}
+
 	services, err := LoadServices(baseFiles)
 	if err != nil {
 		return err
 	}
-	if len(services) == 0 {
+	// Compose won't start services outside the enabled profiles. They are
+	// kept until addresses are handed out, so enabling a profile doesn't
+	// move the services after them.
+	disabled := func(name string) bool {
+		return !services[name].Enabled(opts.Profiles)
+	}
+	enabled := 0
+	for name := range services {
+		if !disabled(name) {
+			enabled++
+		}
+	}
+	if enabled == 0 {
 		return fmt.Errorf("no services found in compose files")
 	}
 	if dnsSuffix == "" {
@@ -48,13 +91,14 @@ func TransformWithShared(env *models.Environment, baseFiles []string, overridePa
 	networkName := "default"
 
 	// First pass: find the default ingress service
-	// Priority: 1) cilo.ingress label, 2) default service names, 3) first service with hostnames
+	// Priority: 1) cilo.ingress label, 2) configured ingress, 3) default
+	// service names, 4) first service with hostnames
 	ingressName := ""
 	servicesWithHostnames := []string{}
 
 	for name, service := range services {
 		// Skip shared services in ingress detection
-		if contains(sharedServices, name) {
+		if contains(sharedServices, name) || scaledDown(name) || disabled(name) {
 			continue
 		}
 
@@ -70,6 +114,11 @@ func TransformWithShared(env *models.Environment, baseFiles []string, overridePa
 			ingressName = name
 		}
 	}
+	if _, ok := services[opts.Ingress]; ok && !contains(sharedServices, opts.Ingress) && !scaledDown(opts.Ingress) && !disabled(opts.Ingress) {
+		if ingressName == "" || services[ingressName].Labels["cilo.ingress"] != "true" {
+			ingressName = opts.Ingress
+		}
+	}
 	if ingressName == "" && len(servicesWithHostnames) > 0 {
 		ingressName = servicesWithHostnames[0]
 	}
@@ -91,6 +140,13 @@ func TransformWithShared(env *models.Environment, baseFiles []string, overridePa
 
 	serviceOverrides := override["services"].(map[string]interface{})
 	for _, name := range SortedServiceNames(services) {
+		if disabled(name) {
+			delete(env.Services, name)
+			if !contains(sharedServices, name) {
+				ip = incrementIP(ip)
+			}
+			continue
+		}
 		// For shared services, mark them as disabled so compose doesn't start them
 		if contains(sharedServices, name) {
 			serviceOverrides[name] = map[string]interface{}{
@@ -100,10 +156,22 @@ func TransformWithShared(env *models.Environment, baseFiles []string, overridePa
 			}
 			continue
 		}
+		// Services outside the profile keep their address, so switching
+		// profiles doesn't move the others
+		if scaledDown(name) {
+			serviceOverrides[name] = map[string]interface{}{
+				"deploy": map[string]interface{}{
+					"replicas": 0,
+				},
+			}
+			delete(env.Services, name)
+			ip = incrementIP(ip)
+			continue
+		}
 
 		service := services[name]
 		containerName := fmt.Sprintf("cilo_%s_%s", env.Name, name)
-		serviceOverrides[name] = map[string]interface{}{
+		serviceOverride := map[string]interface{}{
 			"ports":          []interface{}{},
 			"container_name": containerName,
 			"networks": map[string]interface{}{
@@ -112,6 +180,15 @@ func TransformWithShared(env *models.Environment, baseFiles []string, overridePa
 				},
 			},
 		}
+		if image, ok := opts.Images[name]; ok {
+			serviceOverride["image"] = image
+			if service.Build != nil {
+				// Otherwise compose builds over the image with --build
+				serviceOverride["build"] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!reset", Value: "null"}
+			}
+		}
+		memory := applyResources(serviceOverride, service.Limits, opts.Resources)
+		serviceOverrides[name] = serviceOverride
 
 		hostnames := []string{}
 		if service.Labels != nil {
@@ -123,6 +200,14 @@ func TransformWithShared(env *models.Environment, baseFiles []string, overridePa
 			}
 		}
 
+		if name == ingressName {
+			for _, h := range opts.Hostnames {
+				if !contains(hostnames, h) {
+					hostnames = append(hostnames, h)
+				}
+			}
+		}
+
 		isIngress := (name == ingressName) || len(hostnames) > 0
 		env.Services[name] = &models.Service{
 			Name:      name,
@@ -131,6 +216,7 @@ func TransformWithShared(env *models.Environment, baseFiles []string, overridePa
 			URL:       fmt.Sprintf("http://%s.%s%s", name, env.Name, dnsSuffix),
 			IsIngress: isIngress,
 			Hostnames: hostnames,
+			Memory:    memory,
 		}
 
 		ip = incrementIP(ip)
@@ -155,6 +241,43 @@ func TransformWithShared(env *models.Environment, baseFiles []string, overridePa
 	return nil
 }
 
+// applyResources adds the policy's limits to a service override, skipping
+// any the service sets itself, and returns the service's effective memory
+// limit in bytes (0 when unlimited)
+func applyResources(override map[string]interface{}, own ServiceLimits, policy *models.ResourceLimits) int64 {
+	memory := own.Memory
+	if policy != nil {
+		limits := map[string]interface{}{}
+		if own.CPUs == "" && policy.CPUs != "" {
+			limits["cpus"] = policy.CPUs
+		}
+		if own.Memory == "" && policy.Memory != "" {
+			limits["memory"] = policy.Memory
+			memory = policy.Memory
+		}
+		if own.Pids == 0 && policy.Pids != 0 {
+			limits["pids"] = policy.Pids
+		}
+		if len(limits) > 0 {
+			override["deploy"] = map[string]interface{}{
+				"resources": map[string]interface{}{"limits": limits},
+			}
+		}
+		if own.Storage == "" && policy.Storage != "" {
+			override["storage_opt"] = map[string]interface{}{"size": policy.Storage}
+		}
+	}
+
+	if memory == "" {
+		return 0
+	}
+	bytes, err := resources.ParseSize(memory)
+	if err != nil {
+		return 0
+	}
+	return bytes
+}
+
 // contains checks if a slice contains a value
 func contains(slice []string, value string) bool {
 	for _, item := range slice {
@@ -225,11 +348,12 @@ func Validate(paths []string) error {
 // SyncServices updates environment services from running containers
 func SyncServices(env *models.Environment) error {
 	workspace := config.GetEnvPath(env.Project, env.Name)
-	projectConfig, _ := models.LoadProjectConfigFromPath(workspace)
-	composeFiles, _, err := ResolveComposeFiles(workspace, nil)
-	if err == nil && projectConfig != nil {
-		composeFiles, _, err = ResolveComposeFiles(workspace, projectConfig.ComposeFiles)
+	projectConfig, _ := models.LoadEnvironmentConfig(env)
+	var configured []string
+	if projectConfig != nil {
+		configured = projectConfig.ComposeFiles
 	}
+	composeFiles, _, err := ResolveComposeFiles(workspace, configured)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,268 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTransformWithOptions_Resources(t *testing.T) {
+	root := t.TempDir()
+	composeFile := filepath.Join(root, "docker-compose.yml")
+	content := `services:
+  web:
+    image: nginx:alpine
+  db:
+    image: postgres:16
+    mem_limit: 2g
+    deploy:
+      resources:
+        limits:
+          cpus: "2"
+  cache:
+    image: redis:7
+`
+	if err := os.WriteFile(composeFile, []byte(content), 0644); err != nil {
+		t.Fatalf("write compose file: %v", err)
+	}
+
+	env := &models.Environment{Name: "dev", Subnet: "10.224.1.0/24"}
+	overridePath := filepath.Join(root, ".cilo", "override.yml")
+	err := TransformWithOptions(env, []string{composeFile}, overridePath, TransformOptions{
+		Shared:    []string{"cache"},
+		Resources: &models.ResourceLimits{CPUs: "0.5", Memory: "512m", Pids: 200, Storage: "10g"},
+	})
+	if err != nil {
+		t.Fatalf("TransformWithOptions: %v", err)
+	}
+
+	data, err := os.ReadFile(overridePath)
+	if err != nil {
+		t.Fatalf("read override: %v", err)
+	}
+	var override struct {
+		Services map[string]struct {
+			Deploy struct {
+				Replicas  *int `yaml:"replicas"`
+				Resources struct {
+					Limits map[string]interface{} `yaml:"limits"`
+				} `yaml:"resources"`
+			} `yaml:"deploy"`
+			StorageOpt map[string]string `yaml:"storage_opt"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &override); err != nil {
+		t.Fatalf("parse override: %v", err)
+	}
+
+	web := override.Services["web"]
+	if got := web.Deploy.Resources.Limits; got["cpus"] != "0.5" || got["memory"] != "512m" || got["pids"] != 200 {
+		t.Fatalf("web limits = %v, want the policy", got)
+	}
+	if web.StorageOpt["size"] != "10g" {
+		t.Fatalf("web storage_opt = %v", web.StorageOpt)
+	}
+
+	// db's own limits win; only the ones it leaves unset are added
+	db := override.Services["db"]
+	if got := db.Deploy.Resources.Limits; got["cpus"] != nil || got["memory"] != nil || got["pids"] != 200 {
+		t.Fatalf("db limits = %v, want only pids", got)
+	}
+
+	if cache := override.Services["cache"]; cache.Deploy.Replicas == nil || len(cache.Deploy.Resources.Limits) != 0 {
+		t.Fatalf("shared cache override = %+v, want replicas 0 and no limits", cache)
+	}
+
+	if got := env.Services["web"].Memory; got != 512<<20 {
+		t.Fatalf("web memory = %d", got)
+	}
+	if got := env.Services["db"].Memory; got != 2<<30 {
+		t.Fatalf("db memory = %d, want its own mem_limit", got)
+	}
+}
+
+func TestTransformWithOptions_Profiles(t *testing.T) {
+	root := t.TempDir()
+	composeFile := filepath.Join(root, "compose.yaml")
+	content := `services:
+  web:
+    image: nginx:alpine
+  debug:
+    image: busybox
+    profiles: [debug]
+  seed:
+    image: busybox
+    profiles: [tools]
+`
+	if err := os.WriteFile(composeFile, []byte(content), 0644); err != nil {
+		t.Fatalf("write compose file: %v", err)
+	}
+
+	env := &models.Environment{Name: "dev", Subnet: "10.224.1.0/24"}
+	overridePath := filepath.Join(root, ".cilo", "override.yml")
+	if err := TransformWithOptions(env, []string{composeFile}, overridePath, TransformOptions{Profiles: []string{"debug"}}); err != nil {
+		t.Fatalf("TransformWithOptions: %v", err)
+	}
+	if env.Services["web"] == nil || env.Services["debug"] == nil || env.Services["seed"] != nil {
+		t.Fatalf("services = %v, want web and debug only", env.Services)
+	}
+
+	env = &models.Environment{Name: "all", Subnet: "10.224.2.0/24"}
+	if err := TransformWithOptions(env, []string{composeFile}, overridePath, TransformOptions{Profiles: []string{"*"}}); err != nil {
+		t.Fatalf("TransformWithOptions: %v", err)
+	}
+	if len(env.Services) != 3 {
+		t.Fatalf("services = %v, want every profile enabled", env.Services)
+	}
+
+	// Turning the profiles off drops their services and leaves the
+	// others' addresses alone
+	webIP := env.Services["web"].IP
+	if err := TransformWithOptions(env, []string{composeFile}, overridePath, TransformOptions{}); err != nil {
+		t.Fatalf("TransformWithOptions: %v", err)
+	}
+	if len(env.Services) != 1 || env.Services["web"] == nil {
+		t.Fatalf("services = %v, want web only", env.Services)
+	}
+	if env.Services["web"].IP != webIP {
+		t.Fatalf("web IP = %s without profiles, want %s as with them", env.Services["web"].IP, webIP)
+	}
+}
+
+func TestTransformWithOptions_Services(t *testing.T) {
+	root := t.TempDir()
+	composeFile := filepath.Join(root, "compose.yaml")
+	content := `services:
+  web:
+    image: nginx:alpine
+  api:
+    image: node:20
+  worker:
+    image: node:20
+  search:
+    image: elasticsearch:8
+`
+	if err := os.WriteFile(composeFile, []byte(content), 0644); err != nil {
+		t.Fatalf("write compose file: %v", err)
+	}
+
+	env := &models.Environment{Name: "dev", Subnet: "10.224.1.0/24"}
+	overridePath := filepath.Join(root, ".cilo", "override.yml")
+	if err := TransformWithOptions(env, []string{composeFile}, overridePath, TransformOptions{}); err != nil {
+		t.Fatalf("TransformWithOptions: %v", err)
+	}
+	webIP := env.Services["web"].IP
+
+	opts := TransformOptions{Services: []string{"web", "api"}}
+	if err := TransformWithOptions(env, []string{composeFile}, overridePath, opts); err != nil {
+		t.Fatalf("TransformWithOptions: %v", err)
+	}
+	if len(env.Services) != 2 || env.Services["web"] == nil || env.Services["api"] == nil {
+		t.Fatalf("services = %v, want web and api only", env.Services)
+	}
+	if env.Services["web"].IP != webIP {
+		t.Fatalf("web IP = %s, want it kept at %s", env.Services["web"].IP, webIP)
+	}
+
+	data, err := os.ReadFile(overridePath)
+	if err != nil {
+		t.Fatalf("read override: %v", err)
+	}
+	var override struct {
+		Services map[string]map[string]interface{} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &override); err != nil {
+		t.Fatalf("parse override: %v", err)
+	}
+	for _, name := range []string{"worker", "search"} {
+		deploy, _ := override.Services[name]["deploy"].(map[string]interface{})
+		if deploy["replicas"] != 0 {
+			t.Errorf("%s deploy = %v, want it scaled to zero", name, deploy)
+		}
+	}
+}
+
+func TestTransformWithOptions_Images(t *testing.T) {
+	root := t.TempDir()
+	composeFile := filepath.Join(root, "compose.yaml")
+	content := `services:
+  api:
+    build: ./api
+  db:
+    image: postgres:16
+`
+	if err := os.WriteFile(composeFile, []byte(content), 0644); err != nil {
+		t.Fatalf("write compose file: %v", err)
+	}
+
+	env := &models.Environment{Name: "dev", Subnet: "10.224.1.0/24"}
+	overridePath := filepath.Join(root, ".cilo", "override.yml")
+	opts := TransformOptions{Images: map[string]string{"api": "cilo/shop/api:abc123", "db": "postgres:17"}}
+	if err := TransformWithOptions(env, []string{composeFile}, overridePath, opts); err != nil {
+		t.Fatalf("TransformWithOptions: %v", err)
+	}
+	data, err := os.ReadFile(overridePath)
+	if err != nil {
+		t.Fatalf("read override: %v", err)
+	}
+	var override struct {
+		Services map[string]map[string]yaml.Node `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &override); err != nil {
+		t.Fatalf("parse override: %v", err)
+	}
+	api, db := override.Services["api"], override.Services["db"]
+	if api["image"].Value != "cilo/shop/api:abc123" || db["image"].Value != "postgres:17" {
+		t.Fatalf("images = %s, %s", api["image"].Value, db["image"].Value)
+	}
+	// Compose mustn't build over the image; db has nothing to build
+	if build := api["build"]; build.Tag != "!reset" {
+		t.Fatalf("api build = %s %s, want !reset", build.Tag, build.Value)
+	}
+	if _, ok := db["build"]; ok {
+		t.Fatalf("db build is set")
+	}
+}
+
+func TestTransformWithOptions_Ingress(t *testing.T) {
+	root := t.TempDir()
+	composeFile := filepath.Join(root, "compose.yaml")
+	content := `services:
+  web:
+    image: nginx:alpine
+  api:
+    image: node:20
+`
+	if err := os.WriteFile(composeFile, []byte(content), 0644); err != nil {
+		t.Fatalf("write compose file: %v", err)
+	}
+
+	env := &models.Environment{Name: "dev", Subnet: "10.224.1.0/24"}
+	overridePath := filepath.Join(root, ".cilo", "override.yml")
+	opts := TransformOptions{Ingress: "api", Hostnames: []string{"docs"}}
+	if err := TransformWithOptions(env, []string{composeFile}, overridePath, opts); err != nil {
+		t.Fatalf("TransformWithOptions: %v", err)
+	}
+	if !env.Services["api"].IsIngress || env.Services["web"].IsIngress {
+		t.Fatalf("services = %v, want the configured api as ingress over web", env.Services)
+	}
+	if hostnames := env.Services["api"].Hostnames; len(hostnames) != 1 || hostnames[0] != "docs" {
+		t.Fatalf("api hostnames = %v, want docs", hostnames)
+	}
+
+	// A cilo.ingress label still wins
+	content += "  proxy:\n    image: caddy\n    labels:\n      cilo.ingress: \"true\"\n"
+	if err := os.WriteFile(composeFile, []byte(content), 0644); err != nil {
+		t.Fatalf("write compose file: %v", err)
+	}
+	env = &models.Environment{Name: "dev", Subnet: "10.224.1.0/24"}
+	if err := TransformWithOptions(env, []string{composeFile}, overridePath, opts); err != nil {
+		t.Fatalf("TransformWithOptions: %v", err)
+	}
+	if !env.Services["proxy"].IsIngress || env.Services["api"].IsIngress {
+		t.Fatalf("services = %v, want the labelled service as ingress", env.Services)
+	}
+}
@@ -0,0 +1,362 @@
+package compose
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+// devcontainerService names the service an image or Dockerfile based
+// devcontainer runs as, so it resolves as app.<env>.test
+const devcontainerService = "app"
+
+// devcontainerPaths are where the devcontainer spec looks for its config,
+// relative to the project root
+var devcontainerPaths = []string{".devcontainer/devcontainer.json", ".devcontainer.json"}
+
+// devcontainerConfig holds the parts of devcontainer.json cilo runs
+type devcontainerConfig struct {
+	Image             string                     `json:"image"`
+	Build             *devcontainerBuild         `json:"build"`
+	DockerFile        string                     `json:"dockerFile"` // Older spelling of build.dockerfile
+	DockerComposeFile stringList                 `json:"dockerComposeFile"`
+	Service           string                     `json:"service"`
+	WorkspaceFolder   string                     `json:"workspaceFolder"`
+	ContainerEnv      map[string]string          `json:"containerEnv"`
+	ContainerUser     string                     `json:"containerUser"`
+	OverrideCommand   *bool                      `json:"overrideCommand"`
+	Features          map[string]json.RawMessage `json:"features"`
+	ForwardPorts      []json.RawMessage          `json:"forwardPorts"`
+	PostCreateCommand json.RawMessage            `json:"postCreateCommand"`
+}
+
+type devcontainerBuild struct {
+	Dockerfile string            `json:"dockerfile"`
+	Context    string            `json:"context"`
+	Args       map[string]string `json:"args"`
+	Target     string            `json:"target"`
+}
+
+// stringList is a JSON string or array of strings
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*l = stringList{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("expected a string or a list of strings")
+	}
+	*l = many
+	return nil
+}
+
+// devcontainerAdapter runs a Dev Container. An image or Dockerfile based
+// one becomes a single service with the workspace mounted at its
+// workspaceFolder; a compose based one runs its compose files with the
+// main service marked as the ingress.
+type devcontainerAdapter struct{}
+
+func (devcontainerAdapter) Format() string { return FormatDevcontainer }
+
+func (devcontainerAdapter) Detect(dir string) bool {
+	return devcontainerPath(dir) != ""
+}
+
+func (devcontainerAdapter) Load(dir, generated string) (*Project, error) {
+	path := devcontainerPath(dir)
+	rel, _ := filepath.Rel(dir, path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", rel, err)
+	}
+	var cfg devcontainerConfig
+	if err := json.Unmarshal(stripJSONC(data), &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", rel, err)
+	}
+	configDir := filepath.Dir(path)
+
+	project := &Project{Format: FormatDevcontainer, Dir: dir}
+	service := devcontainerService
+	if len(cfg.DockerComposeFile) > 0 {
+		if cfg.Service == "" {
+			return nil, fmt.Errorf("%s sets dockerComposeFile but not service", rel)
+		}
+		service = cfg.Service
+		files, err := absComposeFiles(configDir, cfg.DockerComposeFile)
+		if err != nil {
+			return nil, err
+		}
+		project.ComposeFiles = files
+		project.Dir = filepath.Dir(files[0])
+	}
+
+	main := map[string]interface{}{
+		"labels": map[string]interface{}{"cilo.ingress": "true"},
+	}
+	if len(cfg.DockerComposeFile) == 0 {
+		if err := devcontainerContainer(main, &cfg, dir, configDir); err != nil {
+			return nil, fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+	services := map[string]interface{}{service: main}
+
+	ports, err := forwardedPorts(cfg.ForwardPorts, service)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid forwardPorts: %w", rel, err)
+	}
+	for name, exposed := range ports {
+		svc, ok := services[name].(map[string]interface{})
+		if !ok {
+			svc = map[string]interface{}{}
+			services[name] = svc
+		}
+		svc["expose"] = exposed
+	}
+
+	commands, err := commandList(cfg.PostCreateCommand)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid postCreateCommand: %w", rel, err)
+	}
+	for i, command := range commands {
+		// postCreateCommand runs once per container, so a marker left in
+		// the container skips it on later ups
+		marker := "/tmp/.cilo-post-create"
+		if len(commands) > 1 {
+			marker += "-" + strconv.Itoa(i+1)
+		}
+		project.PostUp = append(project.PostUp, models.Hook{
+			Run:       fmt.Sprintf("[ -e %s ] || { %s\n} && touch %s", marker, command, marker),
+			Service:   service,
+			OnFailure: "warn",
+		})
+	}
+
+	if len(cfg.Features) > 0 {
+		features := make([]string, 0, len(cfg.Features))
+		for feature := range cfg.Features {
+			features = append(features, feature)
+		}
+		sort.Strings(features)
+		project.Warnings = append(project.Warnings, fmt.Sprintf(
+			"devcontainer features aren't installed (%s); build an image with them using 'devcontainer build' and set it as the image",
+			strings.Join(features, ", ")))
+	}
+
+	file, err := writeGenerated(generated, FormatDevcontainer, rel, services)
+	if err != nil {
+		return nil, err
+	}
+	project.ComposeFiles = append(project.ComposeFiles, file)
+	return project, nil
+}
+
+// devcontainerContainer fills in the service of an image or Dockerfile
+// based devcontainer
+func devcontainerContainer(svc map[string]interface{}, cfg *devcontainerConfig, dir, configDir string) error {
+	build := cfg.Build
+	if build == nil && cfg.DockerFile != "" {
+		build = &devcontainerBuild{Dockerfile: cfg.DockerFile}
+	}
+	switch {
+	case build != nil && build.Dockerfile != "":
+		context := filepath.Join(configDir, build.Context)
+		if build.Context == "" {
+			context = configDir
+		}
+		b := map[string]interface{}{
+			"context":    context,
+			"dockerfile": filepath.Join(configDir, build.Dockerfile),
+		}
+		if len(build.Args) > 0 {
+			b["args"] = build.Args
+		}
+		if build.Target != "" {
+			b["target"] = build.Target
+		}
+		svc["build"] = b
+	case cfg.Image != "":
+		svc["image"] = cfg.Image
+	default:
+		return fmt.Errorf("sets none of image, build.dockerfile or dockerComposeFile")
+	}
+
+	folder := cfg.WorkspaceFolder
+	if folder == "" {
+		folder = "/workspaces/" + filepath.Base(dir)
+	}
+	svc["volumes"] = []string{dir + ":" + folder}
+	svc["working_dir"] = folder
+	if len(cfg.ContainerEnv) > 0 {
+		svc["environment"] = cfg.ContainerEnv
+	}
+	if cfg.ContainerUser != "" {
+		svc["user"] = cfg.ContainerUser
+	}
+	// Like the devcontainer CLI, keep the container up rather than running
+	// the image's own command
+	if cfg.OverrideCommand == nil || *cfg.OverrideCommand {
+		svc["command"] = []string{"sh", "-c", "trap 'exit 0' TERM; while sleep 1000 & wait $!; do :; done"}
+	}
+	return nil
+}
+
+// forwardedPorts groups forwardPorts entries, a port of the main service or
+// "service:port", by service
+func forwardedPorts(raw []json.RawMessage, main string) (map[string][]string, error) {
+	ports := map[string][]string{}
+	for _, entry := range raw {
+		var port int
+		if err := json.Unmarshal(entry, &port); err == nil {
+			ports[main] = append(ports[main], strconv.Itoa(port))
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(entry, &s); err != nil {
+			return nil, fmt.Errorf("%s is not a port", entry)
+		}
+		service, p, found := strings.Cut(s, ":")
+		if !found {
+			service, p = main, s
+		}
+		if _, err := strconv.Atoi(p); err != nil {
+			return nil, fmt.Errorf("%q is not a port", s)
+		}
+		ports[service] = append(ports[service], p)
+	}
+	return ports, nil
+}
+
+// commandList reads a devcontainer lifecycle command: a shell command, an
+// argument list, or an object of named commands, which are run in name
+// order
+func commandList(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &named); err == nil {
+		names := make([]string, 0, len(named))
+		for name := range named {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var commands []string
+		for _, name := range names {
+			command, err := shellCommand(named[name])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			commands = append(commands, command)
+		}
+		return commands, nil
+	}
+	command, err := shellCommand(raw)
+	if err != nil {
+		return nil, err
+	}
+	return []string{command}, nil
+}
+
+// shellCommand reads a shell command string, or an argument list that is
+// quoted into one
+func shellCommand(raw json.RawMessage) (string, error) {
+	var command string
+	if err := json.Unmarshal(raw, &command); err == nil {
+		return command, nil
+	}
+	var args []string
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", fmt.Errorf("expected a command string or argument list")
+	}
+	for i, arg := range args {
+		args[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(args, " "), nil
+}
+
+// devcontainerPath returns the devcontainer.json in dir, or "" if there is
+// none
+func devcontainerPath(dir string) string {
+	for _, rel := range devcontainerPaths {
+		if path := filepath.Join(dir, rel); exists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// stripJSONC removes the comments and trailing commas devcontainer.json
+// allows, leaving plain JSON
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i+1 < len(data) && data[i+1] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return dropTrailingCommas(out)
+			}
+			i += end + 3
+		default:
+			out = append(out, c)
+		}
+	}
+	return dropTrailingCommas(out)
+}
+
+// dropTrailingCommas removes commas that only whitespace separates from a
+// closing bracket
+func dropTrailingCommas(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			if c == '\\' && i+1 < len(data) {
+				out = append(out, c)
+				i++
+				c = data[i]
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == ',':
+			rest := bytes.TrimLeft(data[i+1:], " \t\r\n")
+			if len(rest) > 0 && (rest[0] == '}' || rest[0] == ']') {
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
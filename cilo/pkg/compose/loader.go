@@ -5,14 +5,52 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type ServiceMeta struct {
-	Name   string
-	Labels map[string]string
+	Name      string
+	Image     string
+	Labels    map[string]string
+	Limits    ServiceLimits // Resource limits the compose files set themselves
+	Profiles  []string      // Compose profiles the service belongs to; none means it always runs
+	Ports     []ServicePort // Ports the service publishes or exposes
+	DependsOn []string      // Services named in depends_on
+	Build     *ServiceBuild // nil if the service doesn't build its image
+}
+
+// ServicePort is a container port a service listens on, and the host port
+// it is published on (0 when it is only exposed)
+type ServicePort struct {
+	Published int
+	Target    int
+}
+
+// Enabled reports whether compose starts the service with the given
+// profiles enabled. "*" enables every profile.
+func (m *ServiceMeta) Enabled(profiles []string) bool {
+	if len(m.Profiles) == 0 || contains(profiles, "*") {
+		return true
+	}
+	for _, profile := range m.Profiles {
+		if contains(profiles, profile) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceLimits are the resource limits a service's compose definition sets,
+// from deploy.resources.limits or the older top-level keys. Empty fields
+// aren't set.
+type ServiceLimits struct {
+	CPUs    string
+	Memory  string
+	Pids    int
+	Storage string
 }
 
 // LoadServices reads compose files and returns merged service metadata.
@@ -55,32 +93,125 @@ func LoadServices(files []string) (map[string]*ServiceMeta, error) {
 					meta.Labels[k] = v
 				}
 			}
+			if image, ok := svcMap["image"].(string); ok {
+				meta.Image = image
+			}
+			// Compose resolves relative paths against the project
+			// directory, the first file's
+			if build, ok := svcMap["build"]; ok {
+				meta.Build = parseBuild(build, filepath.Dir(files[0]))
+			}
+			mergeLimits(&meta.Limits, svcMap)
+			meta.Ports = append(meta.Ports, parsePorts(svcMap)...)
+			for _, dependency := range parseDependsOn(svcMap["depends_on"]) {
+				if !contains(meta.DependsOn, dependency) {
+					meta.DependsOn = append(meta.DependsOn, dependency)
+				}
+			}
+			if profiles, ok := svcMap["profiles"].([]interface{}); ok {
+				meta.Profiles = meta.Profiles[:0]
+				for _, profile := range profiles {
+					meta.Profiles = append(meta.Profiles, fmt.Sprintf("%v", profile))
+				}
+			}
 		}
 	}
 
 	return services, nil
 }
 
-// ResolveComposeFiles returns absolute compose file paths and the project directory.
+// ResolveComposeFiles returns absolute compose file paths and the project
+// directory. Without compose files, the workspace's format is detected (see
+// LoadProject).
 func ResolveComposeFiles(workspace string, composeFiles []string) ([]string, string, error) {
-	if len(composeFiles) == 0 {
-		composeFiles = []string{"docker-compose.yml"}
+	project, err := LoadProject(workspace, composeFiles)
+	if err != nil {
+		return nil, "", err
 	}
+	return project.ComposeFiles, project.Dir, nil
+}
 
-	files := make([]string, 0, len(composeFiles))
-	for _, f := range composeFiles {
-		path := f
-		if !filepath.IsAbs(path) {
-			path = filepath.Join(workspace, f)
+// mergeLimits records the resource limits set in one compose file's
+// definition of a service over those from earlier files
+func mergeLimits(limits *ServiceLimits, svc map[string]interface{}) {
+	set := func(field *string, value interface{}) {
+		if value != nil {
+			*field = fmt.Sprintf("%v", value)
 		}
-		if _, err := os.Stat(path); err != nil {
-			return nil, "", fmt.Errorf("compose file not found: %s", path)
+	}
+
+	set(&limits.CPUs, svc["cpus"])
+	set(&limits.Memory, svc["mem_limit"])
+	if pids, ok := svc["pids_limit"].(int); ok {
+		limits.Pids = pids
+	}
+	if storage, ok := svc["storage_opt"].(map[string]interface{}); ok {
+		set(&limits.Storage, storage["size"])
+	}
+
+	deploy, _ := svc["deploy"].(map[string]interface{})
+	res, _ := deploy["resources"].(map[string]interface{})
+	deployLimits, _ := res["limits"].(map[string]interface{})
+	set(&limits.CPUs, deployLimits["cpus"])
+	set(&limits.Memory, deployLimits["memory"])
+	if pids, ok := deployLimits["pids"].(int); ok {
+		limits.Pids = pids
+	}
+}
+
+// parsePorts reads a service's ports, in short ("[ip:]published:target",
+// "target/proto") or long form, and its expose list. Port ranges are
+// skipped.
+func parsePorts(svc map[string]interface{}) []ServicePort {
+	var ports []ServicePort
+	entries, _ := svc["ports"].([]interface{})
+	for _, entry := range entries {
+		if long, ok := entry.(map[string]interface{}); ok {
+			target, _ := strconv.Atoi(fmt.Sprintf("%v", long["target"]))
+			published, _ := strconv.Atoi(fmt.Sprintf("%v", long["published"]))
+			if target > 0 {
+				ports = append(ports, ServicePort{Published: published, Target: target})
+			}
+			continue
+		}
+		spec, _, _ := strings.Cut(fmt.Sprintf("%v", entry), "/")
+		parts := strings.Split(spec, ":")
+		target, err := strconv.Atoi(parts[len(parts)-1])
+		if err != nil {
+			continue
+		}
+		port := ServicePort{Target: target}
+		if len(parts) > 1 {
+			port.Published, _ = strconv.Atoi(parts[len(parts)-2])
 		}
-		files = append(files, path)
+		ports = append(ports, port)
 	}
+	exposed, _ := svc["expose"].([]interface{})
+	for _, entry := range exposed {
+		spec, _, _ := strings.Cut(fmt.Sprintf("%v", entry), "/")
+		if target, err := strconv.Atoi(spec); err == nil {
+			ports = append(ports, ServicePort{Target: target})
+		}
+	}
+	return ports
+}
 
-	projectDir := filepath.Dir(files[0])
-	return files, projectDir, nil
+// parseDependsOn reads depends_on, a list of service names or a mapping
+// from them to conditions
+func parseDependsOn(value interface{}) []string {
+	var names []string
+	switch val := value.(type) {
+	case []interface{}:
+		for _, item := range val {
+			names = append(names, fmt.Sprintf("%v", item))
+		}
+	case map[string]interface{}:
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	return names
 }
 
 func normalizeLabels(labels interface{}) map[string]string {
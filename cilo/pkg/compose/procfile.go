@@ -0,0 +1,134 @@
+package compose
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+// procfileWorkdir is where a Procfile process sees the workspace
+const procfileWorkdir = "/app"
+
+// procfileImages guesses the image a Procfile's processes run in from a
+// file in the project root, in order
+var procfileImages = []struct{ marker, image string }{
+	{"package.json", "node:20"},
+	{"Gemfile", "ruby:3.3"},
+	{"requirements.txt", "python:3.12"},
+	{"pyproject.toml", "python:3.12"},
+	{"Pipfile", "python:3.12"},
+	{"go.mod", "golang:1.23"},
+	{"composer.json", "php:8.3-cli"},
+	{"mix.exs", "elixir:1.17"},
+}
+
+var procfileLine = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+// procfileAdapter runs each process of a Procfile in its own container,
+// with the workspace mounted and PORT set as a Procfile runner would. The
+// web process is the ingress, and listens on port 80 so its URL needs no
+// port.
+type procfileAdapter struct{}
+
+func (procfileAdapter) Format() string { return FormatProcfile }
+
+func (procfileAdapter) Detect(dir string) bool {
+	return exists(filepath.Join(dir, "Procfile"))
+}
+
+func (procfileAdapter) Load(dir, generated string) (*Project, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "Procfile"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Procfile: %w", err)
+	}
+	processes, err := parseProcfile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var image string
+	if cfg, err := models.LoadProjectConfigFromPath(dir); err == nil && cfg != nil && cfg.Procfile != nil {
+		image = cfg.Procfile.Image
+	}
+	if image == "" {
+		for _, guess := range procfileImages {
+			if exists(filepath.Join(dir, guess.marker)) {
+				image = guess.image
+				break
+			}
+		}
+	}
+	if image == "" {
+		return nil, fmt.Errorf("can't tell which image runs the Procfile; set procfile.image in .cilo/config.yml")
+	}
+
+	var envFiles []string
+	if exists(filepath.Join(dir, ".env")) {
+		envFiles = []string{filepath.Join(dir, ".env")}
+	}
+
+	services := map[string]interface{}{}
+	for _, p := range processes {
+		svc := map[string]interface{}{
+			"image":       image,
+			"working_dir": procfileWorkdir,
+			"volumes":     []string{dir + ":" + procfileWorkdir},
+			"command":     []string{"sh", "-c", p.command},
+			"environment": map[string]interface{}{"PORT": "80"},
+		}
+		if envFiles != nil {
+			svc["env_file"] = envFiles
+		}
+		if p.name == "web" {
+			svc["labels"] = map[string]interface{}{"cilo.ingress": "true"}
+		}
+		services[p.name] = svc
+	}
+
+	file, err := writeGenerated(generated, FormatProcfile, "Procfile", services)
+	if err != nil {
+		return nil, err
+	}
+	return &Project{Format: FormatProcfile, ComposeFiles: []string{file}, Dir: dir}, nil
+}
+
+type procfileProcess struct {
+	name    string
+	command string
+}
+
+// parseProcfile reads the "name: command" lines of a Procfile, skipping
+// blank lines and comments
+func parseProcfile(data []byte) ([]procfileProcess, error) {
+	var processes []procfileProcess
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := procfileLine.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("Procfile line %d: expected \"name: command\"", n)
+		}
+		if seen[m[1]] {
+			return nil, fmt.Errorf("Procfile line %d: process %q is defined twice", n, m[1])
+		}
+		seen[m[1]] = true
+		processes = append(processes, procfileProcess{name: m[1], command: m[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read Procfile: %w", err)
+	}
+	if len(processes) == 0 {
+		return nil, fmt.Errorf("Procfile defines no processes")
+	}
+	return processes, nil
+}
@@ -0,0 +1,126 @@
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+// ErrUnknownProfile is wrapped by SelectProfile's error for a name no
+// profile has
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// ProfileSelection is what a profile chosen with 'cilo up --profile' runs
+type ProfileSelection struct {
+	Name            string
+	Services        []string // Services to run; nil runs every enabled service
+	Shared          []string // Services to share on top of the usual ones
+	Isolated        []string // Services to keep isolated
+	ComposeProfiles []string // Compose profiles to enable
+}
+
+// SelectProfile resolves a profile name, first against the profiles in the
+// project config and then against the compose files' own profiles.
+//
+// A config profile runs its services and everything they depend on. Compose
+// profiles those services belong to are enabled, so a config profile can
+// pick services compose would otherwise leave out. A compose profile is
+// enabled as if listed in COMPOSE_PROFILES.
+func SelectProfile(cfg *models.ProjectConfig, services map[string]*ServiceMeta, name string) (*ProfileSelection, error) {
+	var profiles map[string]*models.Profile
+	if cfg != nil {
+		profiles = cfg.Profiles
+	}
+
+	if profile, ok := profiles[name]; ok {
+		selection := &ProfileSelection{Name: name}
+		if profile == nil {
+			return selection, nil
+		}
+		for _, list := range [][]string{profile.Services, profile.Shared, profile.Isolated} {
+			for _, svc := range list {
+				if _, ok := services[svc]; !ok {
+					return nil, fmt.Errorf("profile %q names service %q, which the compose files don't define", name, svc)
+				}
+			}
+		}
+		selection.Shared = profile.Shared
+		selection.Isolated = profile.Isolated
+		if len(profile.Services) == 0 {
+			return selection, nil
+		}
+
+		selection.Services = withDependencies(services, profile.Services)
+		for _, svc := range selection.Services {
+			meta := services[svc]
+			if !meta.Enabled(selection.ComposeProfiles) {
+				selection.ComposeProfiles = append(selection.ComposeProfiles, meta.Profiles[0])
+			}
+		}
+		return selection, nil
+	}
+
+	for _, meta := range services {
+		if contains(meta.Profiles, name) {
+			return &ProfileSelection{Name: name, ComposeProfiles: []string{name}}, nil
+		}
+	}
+
+	known := ProfileNames(cfg, services)
+	if len(known) == 0 {
+		return nil, fmt.Errorf("%w %q: neither the project config nor the compose files define profiles", ErrUnknownProfile, name)
+	}
+	return nil, fmt.Errorf("%w %q (known profiles: %s)", ErrUnknownProfile, name, strings.Join(known, ", "))
+}
+
+// ProfileNames lists the profiles SelectProfile knows: the project config's
+// and the compose files'
+func ProfileNames(cfg *models.ProjectConfig, services map[string]*ServiceMeta) []string {
+	seen := map[string]bool{}
+	if cfg != nil {
+		for name := range cfg.Profiles {
+			seen[name] = true
+		}
+	}
+	for _, meta := range services {
+		for _, profile := range meta.Profiles {
+			seen[profile] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withDependencies returns the named services and those they depend on,
+// directly or not, sorted
+func withDependencies(services map[string]*ServiceMeta, names []string) []string {
+	seen := map[string]bool{}
+	var visit func(name string)
+	visit = func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		if meta, ok := services[name]; ok {
+			for _, dependency := range meta.DependsOn {
+				visit(dependency)
+			}
+		}
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	result := make([]string, 0, len(seen))
+	for name := range seen {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
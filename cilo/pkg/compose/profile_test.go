@@ -0,0 +1,83 @@
+package compose
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+func TestSelectProfile(t *testing.T) {
+	composeFile := filepath.Join(t.TempDir(), "compose.yaml")
+	content := `services:
+  web:
+    image: nginx:alpine
+    depends_on: [api]
+  api:
+    image: node:20
+    depends_on:
+      postgres:
+        condition: service_healthy
+  postgres:
+    image: postgres:16
+  elasticsearch:
+    image: elasticsearch:8
+  mailhog:
+    image: mailhog/mailhog
+    profiles: [mail]
+  admin:
+    image: node:20
+    profiles: [ops]
+`
+	if err := os.WriteFile(composeFile, []byte(content), 0644); err != nil {
+		t.Fatalf("write compose file: %v", err)
+	}
+	services, err := LoadServices([]string{composeFile})
+	if err != nil {
+		t.Fatalf("LoadServices: %v", err)
+	}
+	cfg := &models.ProjectConfig{Profiles: map[string]*models.Profile{
+		"frontend": {Services: []string{"web"}, Shared: []string{"elasticsearch"}},
+		"ops":      {Services: []string{"admin"}},
+	}}
+
+	selection, err := SelectProfile(cfg, services, "frontend")
+	if err != nil {
+		t.Fatalf("SelectProfile: %v", err)
+	}
+	if want := []string{"api", "postgres", "web"}; !reflect.DeepEqual(selection.Services, want) {
+		t.Fatalf("services = %v, want %v with their dependencies", selection.Services, want)
+	}
+	if !reflect.DeepEqual(selection.Shared, []string{"elasticsearch"}) || len(selection.ComposeProfiles) != 0 {
+		t.Fatalf("selection = %+v", selection)
+	}
+
+	// A config profile enables the compose profiles its services need
+	selection, err = SelectProfile(cfg, services, "ops")
+	if err != nil {
+		t.Fatalf("SelectProfile: %v", err)
+	}
+	if !reflect.DeepEqual(selection.ComposeProfiles, []string{"ops"}) {
+		t.Fatalf("compose profiles = %v, want ops", selection.ComposeProfiles)
+	}
+
+	// Compose's own profiles work without config
+	selection, err = SelectProfile(nil, services, "mail")
+	if err != nil {
+		t.Fatalf("SelectProfile: %v", err)
+	}
+	if selection.Services != nil || !reflect.DeepEqual(selection.ComposeProfiles, []string{"mail"}) {
+		t.Fatalf("selection = %+v, want the mail compose profile", selection)
+	}
+
+	if _, err := SelectProfile(cfg, services, "backend"); !errors.Is(err, ErrUnknownProfile) {
+		t.Fatalf("SelectProfile(backend) = %v, want ErrUnknownProfile", err)
+	}
+	cfg.Profiles["broken"] = &models.Profile{Services: []string{"worker"}}
+	if _, err := SelectProfile(cfg, services, "broken"); err == nil || errors.Is(err, ErrUnknownProfile) {
+		t.Fatalf("SelectProfile(broken) = %v, want an error naming the missing service", err)
+	}
+}
@@ -0,0 +1,302 @@
+package compose
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Project formats cilo can run. Formats other than compose are turned into
+// a compose file in the workspace's .cilo directory, so their services get
+// the same override, subnet and DNS names as a compose project's.
+const (
+	FormatCompose      = "compose"
+	FormatDevcontainer = "devcontainer"
+	FormatProcfile     = "procfile"
+)
+
+// Project describes how the services of a project directory are run
+type Project struct {
+	Format       string
+	ComposeFiles []string      // Absolute paths, in the order compose reads them
+	Dir          string        // Compose project directory
+	Profiles     []string      // Compose profiles to enable, from COMPOSE_PROFILES
+	PostUp       []models.Hook // Commands the format itself runs once containers start
+	Warnings     []string      // Parts of the format cilo doesn't support
+}
+
+// Adapter turns one project format into compose files
+type Adapter interface {
+	Format() string
+	// Detect reports whether dir holds a project in this format
+	Detect(dir string) bool
+	// Load reads the project in dir, writing any compose file it
+	// generates to the generated directory
+	Load(dir, generated string) (*Project, error)
+}
+
+// adapters are tried in order, so compose files win over the others
+var adapters = []Adapter{composeAdapter{}, devcontainerAdapter{}, procfileAdapter{}}
+
+// DetectFormat returns the format of the project in dir, or "" if there is
+// nothing cilo knows how to run
+func DetectFormat(dir string) string {
+	for _, adapter := range adapters {
+		if adapter.Detect(dir) {
+			return adapter.Format()
+		}
+	}
+	return ""
+}
+
+// LoadProject returns how the project in a workspace runs. Compose files
+// listed in its config are used as they are; without any, the workspace's
+// format is detected. Profiles come from COMPOSE_PROFILES in the
+// workspace's .env either way.
+func LoadProject(workspace string, composeFiles []string) (*Project, error) {
+	return ReadProject(workspace, composeFiles, filepath.Join(workspace, ".cilo"))
+}
+
+// ReadProject is LoadProject writing any compose file the project's format
+// generates to the generated directory rather than dir's .cilo directory,
+// for callers that only look at a source checkout and mustn't change it
+func ReadProject(dir string, composeFiles []string, generated string) (*Project, error) {
+	dotEnv, err := readDotEnv(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var project *Project
+	if len(composeFiles) > 0 {
+		project, err = composeProject(dir, composeFiles)
+	} else {
+		err = fmt.Errorf("no compose file, devcontainer.json or Procfile found in %s", dir)
+		for _, adapter := range adapters {
+			if adapter.Detect(dir) {
+				project, err = adapter.Load(dir, generated)
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	project.Profiles = splitList(dotEnv["COMPOSE_PROFILES"], ",")
+	return project, nil
+}
+
+// DefinitionFiles returns the files in dir that define how its project
+// runs, as absolute paths: the compose files listed in its config, or else
+// those of its detected format, which are the compose files docker compose
+// would pick, a devcontainer.json and the compose files it names, or a
+// Procfile. Unlike LoadProject it generates nothing.
+func DefinitionFiles(dir string, composeFiles []string) ([]string, error) {
+	if len(composeFiles) > 0 {
+		return absComposeFiles(dir, composeFiles)
+	}
+	switch DetectFormat(dir) {
+	case FormatCompose:
+		files, _, err := DiscoverComposeFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		return absComposeFiles(dir, files)
+	case FormatDevcontainer:
+		path := devcontainerPath(dir)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var cfg devcontainerConfig
+		if err := json.Unmarshal(stripJSONC(data), &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		files, err := absComposeFiles(filepath.Dir(path), cfg.DockerComposeFile)
+		if err != nil {
+			return nil, err
+		}
+		return append([]string{path}, files...), nil
+	case FormatProcfile:
+		return []string{filepath.Join(dir, "Procfile")}, nil
+	}
+	return nil, nil
+}
+
+// composeProject returns a project that runs the given compose files
+func composeProject(workspace string, composeFiles []string) (*Project, error) {
+	files, err := absComposeFiles(workspace, composeFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &Project{Format: FormatCompose, ComposeFiles: files, Dir: filepath.Dir(files[0])}, nil
+}
+
+// absComposeFiles resolves compose files relative to the workspace and
+// checks they exist
+func absComposeFiles(workspace string, composeFiles []string) ([]string, error) {
+	files := make([]string, 0, len(composeFiles))
+	for _, f := range composeFiles {
+		path := f
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(workspace, f)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("compose file not found: %s", path)
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// composeFileNames are the files compose looks for in a project directory,
+// most preferred first, and composeOverrideNames the override files it
+// reads after the one it picks
+var (
+	composeFileNames     = []string{"compose.yaml", "compose.yml", "docker-compose.yml", "docker-compose.yaml"}
+	composeOverrideNames = []string{"compose.override.yml", "compose.override.yaml", "docker-compose.override.yml", "docker-compose.override.yaml"}
+)
+
+// DiscoverComposeFiles returns the compose files docker compose would use in
+// dir, relative to it: those COMPOSE_FILE in dir's .env lists, or else the
+// preferred compose file and override file present. The warnings note
+// files passed over because a preferred one exists.
+func DiscoverComposeFiles(dir string) ([]string, []string, error) {
+	dotEnv, err := readDotEnv(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if list := dotEnv["COMPOSE_FILE"]; list != "" {
+		separator := dotEnv["COMPOSE_PATH_SEPARATOR"]
+		if separator == "" {
+			separator = string(os.PathListSeparator)
+		}
+		return splitList(list, separator), nil, nil
+	}
+
+	file, warnings := preferredFile(dir, composeFileNames)
+	if file == "" {
+		return nil, nil, nil
+	}
+	files := []string{file}
+	override, overrideWarnings := preferredFile(dir, composeOverrideNames)
+	if override != "" {
+		files = append(files, override)
+	}
+	return files, append(warnings, overrideWarnings...), nil
+}
+
+// preferredFile returns the first of names present in dir, with a warning
+// if others are too
+func preferredFile(dir string, names []string) (string, []string) {
+	var found []string
+	for _, name := range names {
+		if exists(filepath.Join(dir, name)) {
+			found = append(found, name)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return found[0], nil
+	default:
+		return found[0], []string{fmt.Sprintf("found %s; using %s", strings.Join(found, ", "), found[0])}
+	}
+}
+
+// composeAdapter runs the compose files docker compose itself would pick
+type composeAdapter struct{}
+
+func (composeAdapter) Format() string { return FormatCompose }
+
+func (composeAdapter) Detect(dir string) bool {
+	files, _, err := DiscoverComposeFiles(dir)
+	return err != nil || len(files) > 0
+}
+
+func (composeAdapter) Load(dir, generated string) (*Project, error) {
+	files, warnings, err := DiscoverComposeFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	project, err := composeProject(dir, files)
+	if err != nil {
+		return nil, err
+	}
+	project.Warnings = warnings
+	return project, nil
+}
+
+// readDotEnv reads the variables a directory's .env file sets. A missing
+// file sets none.
+func readDotEnv(dir string) (map[string]string, error) {
+	vars := map[string]string{}
+	data, err := os.ReadFile(filepath.Join(dir, ".env"))
+	if os.IsNotExist(err) {
+		return vars, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .env: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "export "))
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if n := len(value); n >= 2 && (value[0] == '"' || value[0] == '\'') && value[n-1] == value[0] {
+			value = value[1 : n-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		vars[strings.TrimSpace(key)] = value
+	}
+	return vars, nil
+}
+
+// splitList splits a separated list, dropping empty entries
+func splitList(list, separator string) []string {
+	var items []string
+	for _, item := range strings.Split(list, separator) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// writeGenerated writes the compose file an adapter generated from source
+// to the generated directory and returns its path. An unchanged file isn't
+// rewritten.
+func writeGenerated(generated, format, source string, services map[string]interface{}) (string, error) {
+	body, err := yaml.Marshal(map[string]interface{}{"services": services})
+	if err != nil {
+		return "", fmt.Errorf("failed to generate compose file: %w", err)
+	}
+	header := fmt.Sprintf("# Generated by cilo from %s. Do not edit; it is regenerated on every up.\n\n", source)
+	data := append([]byte(header), body...)
+
+	path := filepath.Join(generated, format+".compose.yml")
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		return path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return path, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
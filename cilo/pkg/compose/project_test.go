@@ -0,0 +1,265 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+}
+
+// generatedServices reads the services of a compose file an adapter wrote
+func generatedServices(t *testing.T, path string) map[string]map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var doc struct {
+		Services map[string]map[string]interface{} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	return doc.Services
+}
+
+func TestLoadProjectDevcontainer(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".devcontainer/devcontainer.json": `{
+	// Comments and trailing commas are allowed
+	"image": "mcr.microsoft.com/devcontainers/go:1", /* inline */
+	"forwardPorts": [8080, "db:5432",],
+	"postCreateCommand": "go mod download",
+	"features": {"ghcr.io/devcontainers/features/node:1": {}},
+	"containerEnv": {"URL": "http://x//y"},
+}`,
+	})
+
+	if got := DetectFormat(dir); got != FormatDevcontainer {
+		t.Fatalf("DetectFormat = %q, want %q", got, FormatDevcontainer)
+	}
+	project, err := LoadProject(dir, nil)
+	if err != nil {
+		t.Fatalf("LoadProject: %v", err)
+	}
+	if len(project.ComposeFiles) != 1 || project.Dir != dir {
+		t.Fatalf("project = %+v, want one generated file run from %s", project, dir)
+	}
+
+	services := generatedServices(t, project.ComposeFiles[0])
+	app := services["app"]
+	if app["image"] != "mcr.microsoft.com/devcontainers/go:1" {
+		t.Fatalf("app image = %v", app["image"])
+	}
+	folder := "/workspaces/" + filepath.Base(dir)
+	if app["working_dir"] != folder {
+		t.Fatalf("app working_dir = %v, want %s", app["working_dir"], folder)
+	}
+	if vols, _ := app["volumes"].([]interface{}); len(vols) != 1 || vols[0] != dir+":"+folder {
+		t.Fatalf("app volumes = %v", app["volumes"])
+	}
+	if env, _ := app["environment"].(map[string]interface{}); env["URL"] != "http://x//y" {
+		t.Fatalf("app environment = %v, want the URL kept intact", app["environment"])
+	}
+	if labels, _ := app["labels"].(map[string]interface{}); labels["cilo.ingress"] != "true" {
+		t.Fatalf("app labels = %v, want it marked as ingress", app["labels"])
+	}
+	if expose, _ := services["db"]["expose"].([]interface{}); len(expose) != 1 || expose[0] != "5432" {
+		t.Fatalf("db expose = %v", services["db"]["expose"])
+	}
+
+	if len(project.PostUp) != 1 || project.PostUp[0].Service != "app" || !strings.Contains(project.PostUp[0].Run, "go mod download") {
+		t.Fatalf("PostUp = %+v, want go mod download in app", project.PostUp)
+	}
+	if len(project.Warnings) != 1 || !strings.Contains(project.Warnings[0], "features/node") {
+		t.Fatalf("Warnings = %v, want the uninstalled feature", project.Warnings)
+	}
+}
+
+func TestLoadProjectDevcontainerCompose(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".devcontainer/devcontainer.json": `{"dockerComposeFile": ["compose.yml"], "service": "dev", "postCreateCommand": {"a": ["echo", "it's"], "b": "make"}}`,
+		".devcontainer/compose.yml":       "services:\n  dev:\n    image: alpine\n  db:\n    image: postgres\n",
+	})
+
+	project, err := LoadProject(dir, nil)
+	if err != nil {
+		t.Fatalf("LoadProject: %v", err)
+	}
+	devcontainerDir := filepath.Join(dir, ".devcontainer")
+	if len(project.ComposeFiles) != 2 || project.ComposeFiles[0] != filepath.Join(devcontainerDir, "compose.yml") || project.Dir != devcontainerDir {
+		t.Fatalf("project = %+v, want compose.yml then the generated file, run from .devcontainer", project)
+	}
+	services, err := LoadServices(project.ComposeFiles)
+	if err != nil {
+		t.Fatalf("LoadServices: %v", err)
+	}
+	if services["dev"].Labels["cilo.ingress"] != "true" || services["db"] == nil {
+		t.Fatalf("services = %+v, want dev as ingress alongside db", services)
+	}
+	if len(project.PostUp) != 2 || project.PostUp[0].Service != "dev" || !strings.Contains(project.PostUp[0].Run, `'echo' 'it'\''s'`) {
+		t.Fatalf("PostUp = %+v, want the named commands in order", project.PostUp)
+	}
+}
+
+func TestLoadProjectProcfile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Procfile":     "# processes\nweb: bundle exec puma -p $PORT\nworker: bundle exec sidekiq\n",
+		"Gemfile":      "source 'https://rubygems.org'\n",
+		".env":         "RAILS_ENV=development\n",
+		"package.json": "{}\n",
+	})
+
+	project, err := LoadProject(dir, nil)
+	if err != nil {
+		t.Fatalf("LoadProject: %v", err)
+	}
+	if project.Format != FormatProcfile {
+		t.Fatalf("Format = %q, want %q", project.Format, FormatProcfile)
+	}
+	services := generatedServices(t, project.ComposeFiles[0])
+	if len(services) != 2 {
+		t.Fatalf("services = %v, want web and worker", services)
+	}
+	web := services["web"]
+	if web["image"] != "node:20" {
+		t.Fatalf("web image = %v, want the first marker's image", web["image"])
+	}
+	if cmd, _ := web["command"].([]interface{}); len(cmd) != 3 || cmd[2] != "bundle exec puma -p $PORT" {
+		t.Fatalf("web command = %v", web["command"])
+	}
+	if vols, _ := web["volumes"].([]interface{}); len(vols) != 1 || vols[0] != dir+":/app" {
+		t.Fatalf("web volumes = %v", web["volumes"])
+	}
+	if files, _ := services["worker"]["env_file"].([]interface{}); len(files) != 1 {
+		t.Fatalf("worker env_file = %v, want .env", services["worker"]["env_file"])
+	}
+	if _, ok := services["worker"]["labels"]; ok {
+		t.Fatalf("worker labels = %v, want only web as ingress", services["worker"]["labels"])
+	}
+
+	// The project config names the image when the files don't
+	writeFiles(t, dir, map[string]string{".cilo/config.yml": "project: shop\nprocfile:\n  image: ruby:3.2\n"})
+	project, err = LoadProject(dir, nil)
+	if err != nil {
+		t.Fatalf("LoadProject: %v", err)
+	}
+	if image := generatedServices(t, project.ComposeFiles[0])["web"]["image"]; image != "ruby:3.2" {
+		t.Fatalf("web image = %v, want the configured one", image)
+	}
+}
+
+func TestReadProjectLeavesSourceAlone(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Procfile":     "web: npm start\n",
+		"package.json": "{}\n",
+	})
+	generated := t.TempDir()
+
+	project, err := ReadProject(dir, nil, generated)
+	if err != nil {
+		t.Fatalf("ReadProject: %v", err)
+	}
+	if len(project.ComposeFiles) != 1 || filepath.Dir(project.ComposeFiles[0]) != generated {
+		t.Fatalf("ComposeFiles = %v, want one file in %s", project.ComposeFiles, generated)
+	}
+	if services := generatedServices(t, project.ComposeFiles[0]); services["web"] == nil {
+		t.Fatalf("services = %v, want web", services)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".cilo")); !os.IsNotExist(err) {
+		t.Fatalf("ReadProject wrote to the source's .cilo directory (%v)", err)
+	}
+}
+
+func TestLoadProjectNothing(t *testing.T) {
+	dir := t.TempDir()
+	if got := DetectFormat(dir); got != "" {
+		t.Fatalf("DetectFormat = %q, want none", got)
+	}
+	if _, err := LoadProject(dir, nil); err == nil {
+		t.Fatal("LoadProject succeeded on an empty directory")
+	}
+
+	writeFiles(t, dir, map[string]string{"Procfile": "web: ./server\n"})
+	if _, err := LoadProject(dir, nil); err == nil || !strings.Contains(err.Error(), "procfile.image") {
+		t.Fatalf("LoadProject: %v, want a hint to set procfile.image", err)
+	}
+
+	// A compose file wins over the other formats
+	writeFiles(t, dir, map[string]string{"docker-compose.yml": "services:\n  web:\n    image: nginx\n"})
+	if got := DetectFormat(dir); got != FormatCompose {
+		t.Fatalf("DetectFormat = %q, want %q", got, FormatCompose)
+	}
+}
+
+func TestDiscoverComposeFiles(t *testing.T) {
+	dir := t.TempDir()
+	service := "services:\n  web:\n    image: nginx\n"
+	writeFiles(t, dir, map[string]string{
+		"docker-compose.yml":          service,
+		"compose.yaml":                service,
+		"docker-compose.override.yml": "services:\n  web:\n    environment:\n      DEBUG: \"1\"\n",
+	})
+
+	files, warnings, err := DiscoverComposeFiles(dir)
+	if err != nil {
+		t.Fatalf("DiscoverComposeFiles: %v", err)
+	}
+	if strings.Join(files, ",") != "compose.yaml,docker-compose.override.yml" {
+		t.Fatalf("files = %v, want compose.yaml and its override", files)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "using compose.yaml") {
+		t.Fatalf("warnings = %v, want one about docker-compose.yml", warnings)
+	}
+
+	// COMPOSE_FILE in .env replaces discovery, override files included
+	writeFiles(t, dir, map[string]string{
+		"docker/base.yml": service,
+		".env": "# compose settings\nexport COMPOSE_FILE=\"docker/base.yml;docker-compose.yml\"\n" +
+			"COMPOSE_PATH_SEPARATOR=;\nCOMPOSE_PROFILES=debug, tools # both\n",
+	})
+	files, _, err = DiscoverComposeFiles(dir)
+	if err != nil {
+		t.Fatalf("DiscoverComposeFiles: %v", err)
+	}
+	if strings.Join(files, ",") != "docker/base.yml,docker-compose.yml" {
+		t.Fatalf("files = %v, want COMPOSE_FILE's list", files)
+	}
+
+	project, err := LoadProject(dir, nil)
+	if err != nil {
+		t.Fatalf("LoadProject: %v", err)
+	}
+	if project.Dir != filepath.Join(dir, "docker") || len(project.ComposeFiles) != 2 {
+		t.Fatalf("project = %+v, want both files run from docker/", project)
+	}
+	if strings.Join(project.Profiles, ",") != "debug,tools" {
+		t.Fatalf("Profiles = %v, want debug and tools", project.Profiles)
+	}
+
+	// An override file alone is nothing to run
+	alone := t.TempDir()
+	writeFiles(t, alone, map[string]string{"compose.override.yml": service})
+	if got := DetectFormat(alone); got != "" {
+		t.Fatalf("DetectFormat = %q, want none", got)
+	}
+}
@@ -30,3 +30,7 @@ func GetDNSDir() string {
 func GetEnvPath(project, name string) string {
 	return filepath.Join(GetEnvsDir(), project, name)
 }
+
+func GetSocketPath() string {
+	return filepath.Join(GetCiloHome(), "cilo.sock")
+}
@@ -0,0 +1,214 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+const (
+	queryLogFile = "queries.log"
+	// queryOffsetFile records how far cilo has read the query log
+	queryOffsetFile = "queries.offset"
+	// maxQueryLog is the size past which a fully read query log is emptied
+	maxQueryLog = 1 << 20
+	// queryLockTimeout bounds the wait for another process's read
+	queryLockTimeout = 10 * time.Second
+)
+
+// Lookup is a name looked up through dnsmasq
+type Lookup struct {
+	Name string
+	Time time.Time // To the second; when it was read if the line has no time
+}
+
+// QueryLogPath returns the file dnsmasq logs queries to
+func QueryLogPath() string {
+	return filepath.Join(getDNSDir(), queryLogFile)
+}
+
+// ReadQueries returns the lookups logged since the last read by any cilo
+// process, and records where it stopped. Once the log has been read past
+// maxQueryLog it is emptied; dnsmasq appends, so it carries on at the
+// start. Lookups are returned even when emptying the log fails. Reads hold
+// a lock on the offset, so concurrent readers neither skip nor repeat lines.
+func ReadQueries() ([]Lookup, error) {
+	offsetPath := filepath.Join(getDNSDir(), queryOffsetFile)
+	if _, err := os.Stat(getDNSDir()); os.IsNotExist(err) {
+		return nil, nil // Nothing has been logged
+	}
+	lockPath := offsetPath + ".lock"
+	fileLock := flock.New(lockPath)
+	ctx, cancel := context.WithTimeout(context.Background(), queryLockTimeout)
+	defer cancel()
+	locked, err := fileLock.TryLockContext(ctx, 100*time.Millisecond)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock DNS query log offset: %w", err)
+	}
+	if !locked {
+		return nil, fmt.Errorf("DNS query log offset lock timeout after %v", queryLockTimeout)
+	}
+	defer fileLock.Unlock()
+	chownToUser(lockPath)
+
+	var offset int64
+	if data, err := os.ReadFile(offsetPath); err == nil {
+		offset, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+
+	lookups, next, err := readQueries(offset, time.Now())
+	if next != offset {
+		if werr := os.WriteFile(offsetPath, []byte(strconv.FormatInt(next, 10)+"\n"), 0644); werr != nil {
+			return lookups, fmt.Errorf("failed to record DNS query log offset: %w", werr)
+		}
+		chownToUser(offsetPath)
+	}
+	return lookups, err
+}
+
+// readQueries reads the query log from offset, returning the offset to read
+// from next time
+func readQueries(offset int64, now time.Time) ([]Lookup, int64, error) {
+	path := QueryLogPath()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, offset, fmt.Errorf("failed to open DNS query log: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, offset, err
+	}
+	if info.Size() < offset {
+		offset = 0 // Emptied or replaced since the last read
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, err
+	}
+
+	var lookups []Lookup
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break // A partial last line is read again next time
+		}
+		offset += int64(len(line))
+		if lookup, ok := parseQuery(line, now); ok {
+			lookups = append(lookups, lookup)
+		}
+	}
+
+	if offset >= maxQueryLog {
+		if err := os.Truncate(path, 0); err != nil {
+			return lookups, offset, fmt.Errorf("failed to empty DNS query log (it should belong to you, not the user dnsmasq runs as): %w", err)
+		}
+		offset = 0
+	}
+	return lookups, offset, nil
+}
+
+// prepareQueryLog creates the query log before dnsmasq opens it, so that it
+// belongs to the cilo user even when dnsmasq is started through sudo
+func prepareQueryLog() error {
+	path := QueryLogPath()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create DNS query log: %w", err)
+	}
+	f.Close()
+	return chownToUser(path)
+}
+
+// chownToUser gives a file cilo creates to the user who ran sudo, if it
+// is running through sudo
+func chownToUser(path string) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	uid, err := strconv.Atoi(os.Getenv("SUDO_UID"))
+	if err != nil {
+		return nil
+	}
+	gid, err := strconv.Atoi(os.Getenv("SUDO_GID"))
+	if err != nil {
+		gid = -1
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// parseQuery extracts the lookup from a dnsmasq query line, such as
+// "Oct 18 10:00:00 dnsmasq[42]: query[A] web.dev.test from 127.0.0.1"
+func parseQuery(line string, now time.Time) (Lookup, bool) {
+	fields := strings.Fields(line)
+	for i, field := range fields {
+		if strings.HasPrefix(field, "query[") && i+1 < len(fields) {
+			return Lookup{
+				Name: strings.ToLower(strings.TrimSuffix(fields[i+1], ".")),
+				Time: queryTime(fields, now),
+			}, true
+		}
+	}
+	return Lookup{}, false
+}
+
+// queryTime reads the local time a dnsmasq line starts with. The line has
+// no year, so it's taken to be within the year before now.
+func queryTime(fields []string, now time.Time) time.Time {
+	if len(fields) < 3 {
+		return now
+	}
+	t, err := time.ParseInLocation("Jan 2 15:04:05", strings.Join(fields[:3], " "), now.Location())
+	if err != nil {
+		return now
+	}
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+// MatchesEnvironment reports whether a looked-up name resolves to one of an
+// environment's services, following the entries RenderConfig writes
+func MatchesEnvironment(env *models.Environment, name string) bool {
+	suffix := env.DNSSuffix
+	if suffix == "" {
+		suffix = ".test"
+	}
+	matches := func(domain string) bool {
+		// dnsmasq's address=/domain/ also answers for subdomains
+		return name == domain || strings.HasSuffix(name, "."+domain)
+	}
+
+	for _, svc := range env.Services {
+		if svc == nil || svc.IP == "" {
+			continue
+		}
+		if matches(fmt.Sprintf("%s.%s%s", svc.Name, env.Name, suffix)) {
+			return true
+		}
+		for _, hostname := range svc.Hostnames {
+			if matches(strings.ToLower(hostname)) {
+				return true
+			}
+		}
+		if svc.IsIngress && matches(fmt.Sprintf("%s.%s%s", env.Project, env.Name, suffix)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+func TestReadQueries(t *testing.T) {
+	t.Setenv("CILO_USER_HOME", t.TempDir())
+	if _, err := ReadQueries(); err != nil {
+		t.Fatalf("ReadQueries without a log: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(QueryLogPath()), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	log := strings.Join([]string{
+		"Oct 18 10:00:00 dnsmasq[42]: query[A] Web.Dev.Test from 127.0.0.1",
+		"Oct 18 10:00:00 dnsmasq[42]: config web.dev.test is 10.224.1.10",
+		"Oct  8 10:00:01 dnsmasq[42]: query[AAAA] api.shop.dev.test. from 127.0.0.1",
+		"Oct 18 10:00:02 dnsmasq[42]: query[A] partial",
+	}, "\n")
+	if err := os.WriteFile(QueryLogPath(), []byte(log), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	lookups, err := ReadQueries()
+	if err != nil {
+		t.Fatalf("ReadQueries: %v", err)
+	}
+	if len(lookups) != 2 || lookups[0].Name != "web.dev.test" || lookups[1].Name != "api.shop.dev.test" {
+		t.Fatalf("lookups = %+v", lookups)
+	}
+	if when := lookups[1].Time; when.Month() != time.October || when.Day() != 8 || when.Hour() != 10 || when.Second() != 1 {
+		t.Fatalf("lookup time = %v, want Oct 8 10:00:01", when)
+	}
+	// The unterminated last line is left for the next read, whichever
+	// process makes it
+	lookups, _ = ReadQueries()
+	if len(lookups) != 0 {
+		t.Fatalf("second read = %v, want nothing new", lookups)
+	}
+}
+
+func TestReadQueriesEmptiesLargeLog(t *testing.T) {
+	t.Setenv("CILO_USER_HOME", t.TempDir())
+	if err := os.MkdirAll(filepath.Dir(QueryLogPath()), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	line := "Oct 18 10:00:00 dnsmasq[42]: query[A] web.dev.test from 127.0.0.1\n"
+	log := strings.Repeat(line, maxQueryLog/len(line)+1)
+	if err := os.WriteFile(QueryLogPath(), []byte(log), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if _, err := ReadQueries(); err != nil {
+		t.Fatalf("ReadQueries: %v", err)
+	}
+	info, err := os.Stat(QueryLogPath())
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size() != 0 {
+		t.Fatalf("log size = %d after reading past the cap, want 0", info.Size())
+	}
+
+	// dnsmasq carries on at the start
+	if err := os.WriteFile(QueryLogPath(), []byte(line), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if lookups, err := ReadQueries(); err != nil || len(lookups) != 1 {
+		t.Fatalf("ReadQueries after emptying = %v, %v; want one lookup", lookups, err)
+	}
+}
+
+func TestReadQueriesWaitsForLock(t *testing.T) {
+	t.Setenv("CILO_USER_HOME", t.TempDir())
+	if err := os.MkdirAll(filepath.Dir(QueryLogPath()), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	line := "Oct 18 10:00:00 dnsmasq[42]: query[A] web.dev.test from 127.0.0.1\n"
+	if err := os.WriteFile(QueryLogPath(), []byte(line), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// Another reader holds the offset
+	held := flock.New(filepath.Join(getDNSDir(), queryOffsetFile) + ".lock")
+	if err := held.Lock(); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	done := make(chan []Lookup)
+	go func() {
+		lookups, err := ReadQueries()
+		if err != nil {
+			t.Errorf("ReadQueries: %v", err)
+		}
+		done <- lookups
+	}()
+	select {
+	case <-done:
+		t.Fatalf("ReadQueries read while another reader held the offset")
+	case <-time.After(300 * time.Millisecond):
+	}
+	held.Unlock()
+	if lookups := <-done; len(lookups) != 1 {
+		t.Fatalf("lookups = %v once the lock was released, want one", lookups)
+	}
+}
+
+func TestMatchesEnvironment(t *testing.T) {
+	env := &models.Environment{
+		Name:    "dev",
+		Project: "shop",
+		Services: map[string]*models.Service{
+			"web": {Name: "web", IP: "10.224.1.10", IsIngress: true, Hostnames: []string{"app.local"}},
+			"db":  {Name: "db", IP: "10.224.1.11"},
+		},
+	}
+	for name, want := range map[string]bool{
+		"db.dev.test":       true,
+		"api.shop.dev.test": true,
+		"shop.dev.test":     true,
+		"app.local":         true,
+		"db.other.test":     false,
+		"dev.test":          false,
+		"xdb.dev.test":      false,
+	} {
+		if got := MatchesEnvironment(env, name); got != want {
+			t.Fatalf("MatchesEnvironment(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -66,6 +68,81 @@ func UpdateDNSFromState(state *models.State) error {
 	return reloadDNSGraceful()
 }
 
+// StaleEntries returns the hostnames the dnsmasq config resolves that the
+// state wouldn't, or would resolve to a different address, sorted.
+// UpdateDNSFromState removes them.
+func StaleEntries(state *models.State) ([]string, error) {
+	current, err := ConfigAddresses()
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := RenderConfig(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render DNS config: %w", err)
+	}
+
+	want := parseAddresses(rendered)
+	var stale []string
+	for host, ip := range current {
+		if want[host] != ip {
+			stale = append(stale, host)
+		}
+	}
+	sort.Strings(stale)
+	return stale, nil
+}
+
+// ConfigAddresses maps the hostnames the dnsmasq config resolves to their
+// addresses. Wildcard entries keep their leading dot.
+func ConfigAddresses() (map[string]string, error) {
+	data, err := os.ReadFile(filepath.Join(getDNSDir(), dnsConfFile))
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DNS config: %w", err)
+	}
+	return parseAddresses(string(data)), nil
+}
+
+// ConfigPort returns the port the dnsmasq config listens on, or 0 if there
+// is no config or it doesn't say
+func ConfigPort() (int, error) {
+	data, err := os.ReadFile(filepath.Join(getDNSDir(), dnsConfFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read DNS config: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "port="); ok {
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return 0, fmt.Errorf("invalid port in DNS config: %q", value)
+			}
+			return port, nil
+		}
+	}
+	return 0, nil
+}
+
+// parseAddresses maps the hostnames of a dnsmasq config's address=/host/ip
+// lines to their addresses
+func parseAddresses(config string) map[string]string {
+	addresses := make(map[string]string)
+	for _, line := range strings.Split(config, "\n") {
+		entry, ok := strings.CutPrefix(strings.TrimSpace(line), "address=/")
+		if !ok {
+			continue
+		}
+		if host, ip, ok := strings.Cut(entry, "/"); ok {
+			addresses[host] = ip
+		}
+	}
+	return addresses
+}
+
 // UpdateDNS updates DNS entries for an environment (deprecated - use UpdateDNSFromState)
 func UpdateDNS(env *models.Environment) error {
 	// For backward compat, we need to load full state and render
@@ -157,8 +234,17 @@ func startDNS() error {
 		}
 	}
 
-	// Start dnsmasq
-	cmd := exec.Command("dnsmasq", "--conf-file="+configPath, fmt.Sprintf("--pid-file=%s", pidPath))
+	if err := prepareQueryLog(); err != nil {
+		return err
+	}
+
+	// Start dnsmasq. Run through sudo, it would switch to nobody and give
+	// the query log to nobody, so it runs as the user instead.
+	args := []string{"--conf-file=" + configPath, fmt.Sprintf("--pid-file=%s", pidPath)}
+	if sudoUser := os.Getenv("SUDO_USER"); os.Geteuid() == 0 && sudoUser != "" {
+		args = append(args, "--user="+sudoUser)
+	}
+	cmd := exec.Command("dnsmasq", args...)
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start dnsmasq: %w", err)
 	}
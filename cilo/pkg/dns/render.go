@@ -23,10 +23,14 @@ func RenderConfig(state *models.State) (string, error) {
 	sb.WriteString(fmt.Sprintf("# Generated: %s\n\n", time.Now().UTC().Format(time.RFC3339)))
 
 	// Core dnsmasq settings
-	sb.WriteString("port=5354\n")
+	sb.WriteString(fmt.Sprintf("port=%d\n", GetDNSPort(state)))
 	sb.WriteString("bind-interfaces\n")
 	sb.WriteString("listen-address=127.0.0.1\n\n")
 
+	// Queries are logged so idle environments can be resumed on lookup
+	sb.WriteString("log-queries\n")
+	sb.WriteString(fmt.Sprintf("log-facility=%s\n\n", QueryLogPath()))
+
 	// Upstream DNS servers (detected from system)
 	upstreams := getSystemUpstreams()
 	if len(upstreams) == 0 {
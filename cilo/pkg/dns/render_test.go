@@ -1,6 +1,8 @@
 package dns
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -79,3 +81,74 @@ func TestRenderConfig_DefaultSuffix(t *testing.T) {
 		t.Errorf("Expected web.dev.test entry, got:\n%s", config)
 	}
 }
+
+func TestStaleEntries(t *testing.T) {
+	t.Setenv("CILO_USER_HOME", t.TempDir())
+	state := &models.State{
+		Hosts: map[string]*models.Host{
+			"local": {
+				Environments: map[string]*models.Environment{
+					"shop/dev": {
+						Name:    "dev",
+						Project: "shop",
+						Services: map[string]*models.Service{
+							"web": {Name: "web", IP: "10.224.1.2"},
+						},
+					},
+				},
+			},
+		},
+	}
+	if stale, err := StaleEntries(state); err != nil || len(stale) != 0 {
+		t.Fatalf("StaleEntries without a config = %v, %v", stale, err)
+	}
+
+	config := strings.Join([]string{
+		"address=/web.dev.test/10.224.1.2",
+		"address=/api.dev.test/10.224.1.3",
+		"address=/web.old.test/10.224.2.2",
+		"address=/web.moved.test/10.224.3.2",
+	}, "\n")
+	state.Hosts["local"].Environments["shop/moved"] = &models.Environment{
+		Name:     "moved",
+		Project:  "shop",
+		Services: map[string]*models.Service{"web": {Name: "web", IP: "10.224.4.2"}},
+	}
+	path := filepath.Join(getDNSDir(), dnsConfFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	stale, err := StaleEntries(state)
+	if err != nil {
+		t.Fatalf("StaleEntries: %v", err)
+	}
+	if got := strings.Join(stale, ","); got != "api.dev.test,web.moved.test,web.old.test" {
+		t.Fatalf("stale = %s", got)
+	}
+}
+
+func TestConfigPort(t *testing.T) {
+	t.Setenv("CILO_USER_HOME", t.TempDir())
+	if port, err := ConfigPort(); err != nil || port != 0 {
+		t.Fatalf("ConfigPort without a config = %d, %v", port, err)
+	}
+
+	config, err := RenderConfig(&models.State{DNSPort: 5400})
+	if err != nil {
+		t.Fatalf("RenderConfig: %v", err)
+	}
+	path := filepath.Join(getDNSDir(), dnsConfFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if port, err := ConfigPort(); err != nil || port != 5400 {
+		t.Fatalf("ConfigPort = %d, %v; want 5400", port, err)
+	}
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/sharedco/cilo/pkg/compose"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
+	"github.com/sharedco/cilo/pkg/runtime"
+)
+
+// imageBuild is an image cilo builds for a service, tagged with its build
+// context's hash so environments with the same context share it
+type imageBuild struct {
+	service string
+	tag     string
+	build   *compose.ServiceBuild
+}
+
+// setImages records the images swapped in for an environment's services.
+// An empty ref drops a service's swap.
+func setImages(env *models.Environment, services map[string]*compose.ServiceMeta, images map[string]string) error {
+	for svc, ref := range images {
+		if ref == "" {
+			delete(env.Images, svc)
+			continue
+		}
+		if services[svc] == nil {
+			return output.WithCode(output.CodeNotFound, fmt.Errorf("can't swap the image of %q, which the compose files don't define", svc))
+		}
+		if env.Images == nil {
+			env.Images = map[string]string{}
+		}
+		env.Images[svc] = ref
+	}
+	if len(env.Images) == 0 {
+		env.Images = nil
+	}
+	return nil
+}
+
+// buildHashCache is the workspace file keeping build context file hashes
+// between ups
+var buildHashCache = filepath.Join(".cilo", "build-hashes.json")
+
+// planImages decides the image each running service uses in place of the
+// one it names or builds: the one swapped in for the environment, or one
+// cilo builds from the service's build context. Builds with options cilo
+// doesn't pass on are left to compose. Env files rendered for the
+// environment count towards the hash unless .dockerignore leaves them out,
+// so an image holding one isn't shared with other environments.
+func planImages(env *models.Environment, workspace string, services map[string]*compose.ServiceMeta, running func(string) bool) (map[string]string, []imageBuild, error) {
+	opts := compose.HashOptions{Cache: compose.LoadHashCache(filepath.Join(workspace, buildHashCache))}
+
+	images := map[string]string{}
+	var builds []imageBuild
+	for _, name := range compose.SortedServiceNames(services) {
+		if !running(name) {
+			continue
+		}
+		if ref := env.Images[name]; ref != "" {
+			images[name] = ref
+			continue
+		}
+		build := services[name].Build
+		if build == nil || len(build.Unsupported) > 0 {
+			continue
+		}
+		hash, err := build.Hash(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		tag := compose.ImageTag(env.Project, name, hash)
+		images[name] = tag
+		builds = append(builds, imageBuild{service: name, tag: tag, build: build})
+	}
+	if len(builds) > 0 {
+		// The cache only saves time; without it the next up reads every file
+		opts.Cache.Save(filepath.Join(workspace, buildHashCache))
+	}
+	return images, builds, nil
+}
+
+// buildImages builds the images planImages tagged. An image the daemon
+// already has, from this environment or another, is reused unless force is
+// set.
+func (e *Engine) buildImages(ctx context.Context, env *models.Environment, provider runtime.Provider, builds []imageBuild, force bool) error {
+	for _, b := range builds {
+		if !force {
+			exists, err := provider.ImageExists(ctx, b.tag)
+			if err != nil {
+				return fmt.Errorf("failed to look up image %s: %w", b.tag, err)
+			}
+			if exists {
+				e.emit(env, EventDone, b.service, "Using image %s", b.tag)
+				continue
+			}
+		}
+		e.emit(env, EventProgress, b.service, "Building %s as %s...", b.service, b.tag)
+		if err := provider.BuildImage(ctx, runtime.BuildOptions{
+			Tag:        b.tag,
+			Context:    b.build.Context,
+			Dockerfile: b.build.DockerfilePath(),
+			Target:     b.build.Target,
+			Args:       b.build.Args,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
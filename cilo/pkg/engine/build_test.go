@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sharedco/cilo/pkg/compose"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/runtime"
+)
+
+// buildProvider has some images and records the builds asked of it; other
+// runtime calls are not expected
+type buildProvider struct {
+	runtime.Provider
+	images map[string]bool
+	built  []string
+}
+
+func (p *buildProvider) ImageExists(ctx context.Context, ref string) (bool, error) {
+	return p.images[ref], nil
+}
+
+func (p *buildProvider) BuildImage(ctx context.Context, opts runtime.BuildOptions) error {
+	p.built = append(p.built, opts.Tag)
+	p.images[opts.Tag] = true
+	return nil
+}
+
+func TestPlanImages(t *testing.T) {
+	setupState(t)
+	source := writeSource(t)
+	files := map[string]string{
+		"docker-compose.yml": "services:\n  web:\n    image: nginx\n  api:\n    build: ./api\n  app:\n    build: .\n",
+		"api/Dockerfile":     "FROM node:20\n",
+		"Dockerfile":         "FROM node:20\nCOPY . .\n",
+		".cilo/config.yml":   "project: myapp\nenv:\n  render:\n    - file: .env.local\n      tokens: true\n",
+		".env.local":         "ENV=${CILO_ENV}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(source, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	provider := &buildProvider{images: map[string]bool{}}
+	e := New(Options{Provider: provider})
+	ctx := context.Background()
+	running := func(string) bool { return true }
+	var tags, appTags, workspaces []string
+	var envs []*models.Environment
+	var plans [][]imageBuild
+	for _, name := range []string{"dev", "qa"} {
+		result, err := e.Create(ctx, CreateOptions{Name: name, From: source})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		// up renders .env.local for each environment, inside app's context
+		if err := os.WriteFile(filepath.Join(result.Workspace, ".env.local"), []byte("ENV="+name+"\n"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		services, err := compose.LoadServices([]string{filepath.Join(result.Workspace, "docker-compose.yml")})
+		if err != nil {
+			t.Fatalf("LoadServices: %v", err)
+		}
+		images, builds, err := planImages(result.Environment, result.Workspace, services, running)
+		if err != nil {
+			t.Fatalf("planImages: %v", err)
+		}
+		if _, ok := images["web"]; ok || len(builds) != 2 {
+			t.Fatalf("images = %v, builds = %v; want api and app built", images, builds)
+		}
+		if _, err := os.Stat(filepath.Join(result.Workspace, buildHashCache)); err != nil {
+			t.Fatalf("hash cache not saved: %v", err)
+		}
+		tags = append(tags, images["api"])
+		appTags = append(appTags, images["app"])
+		envs = append(envs, result.Environment)
+		workspaces = append(workspaces, result.Workspace)
+		plans = append(plans, builds)
+	}
+	if !strings.HasPrefix(tags[0], "cilo/myapp/api:") || tags[0] != tags[1] {
+		t.Fatalf("tags = %v, want one cilo/myapp/api tag for both environments", tags)
+	}
+	// app's context holds the rendered env file, which COPY bakes in, so
+	// each environment gets its own image
+	if !strings.HasPrefix(appTags[0], "cilo/myapp/app:") || appTags[0] == appTags[1] {
+		t.Fatalf("app tags = %v, want one per environment", appTags)
+	}
+
+	// The second environment reuses the first's api image
+	for i, builds := range plans {
+		if err := e.buildImages(ctx, envs[i], provider, builds, false); err != nil {
+			t.Fatalf("buildImages: %v", err)
+		}
+	}
+	if len(provider.built) != 3 {
+		t.Fatalf("built = %v, want api once and app for each environment", provider.built)
+	}
+
+	// Left out of the context by .dockerignore, the workspaces differ only
+	// in .cilo, and app's image is shared too
+	for i, workspace := range workspaces {
+		if err := os.WriteFile(filepath.Join(workspace, ".dockerignore"), []byte(".env.local\n"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		services, err := compose.LoadServices([]string{filepath.Join(workspace, "docker-compose.yml")})
+		if err != nil {
+			t.Fatalf("LoadServices: %v", err)
+		}
+		images, _, err := planImages(envs[i], workspace, services, running)
+		if err != nil {
+			t.Fatalf("planImages: %v", err)
+		}
+		appTags[i] = images["app"]
+	}
+	if appTags[0] != appTags[1] {
+		t.Fatalf("app tags = %v with .env.local ignored, want one for both environments", appTags)
+	}
+
+	// A swapped-in image replaces the build for one environment
+	services, _ := compose.LoadServices([]string{filepath.Join(source, "docker-compose.yml")})
+	if err := setImages(envs[1], services, map[string]string{"api": "registry.example.com/api:pr-7"}); err != nil {
+		t.Fatalf("setImages: %v", err)
+	}
+	images, builds, err := planImages(envs[1], workspaces[1], services, running)
+	if err != nil {
+		t.Fatalf("planImages: %v", err)
+	}
+	if images["api"] != "registry.example.com/api:pr-7" || len(builds) != 1 || builds[0].service != "app" {
+		t.Fatalf("images = %v, builds = %v; want the swapped image", images, builds)
+	}
+	if err := setImages(envs[1], services, map[string]string{"api": ""}); err != nil || envs[1].Images != nil {
+		t.Fatalf("setImages = %v, images = %v; want the swap dropped", err, envs[1].Images)
+	}
+	if err := setImages(envs[1], services, map[string]string{"worker": "busybox"}); err == nil {
+		t.Fatalf("expected swapping an unknown service's image to fail")
+	}
+}
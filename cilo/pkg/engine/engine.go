@@ -0,0 +1,202 @@
+// Package engine runs cilo's environment lifecycle: create, up, down,
+// destroy, status and run. The cilo CLI and API server are thin wrappers
+// around it, and it can be embedded in other Go programs.
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/runtime"
+	"github.com/sharedco/cilo/pkg/runtime/docker"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// Event types
+const (
+	EventProgress = "progress" // A step has started, or a service is still waiting
+	EventDone     = "done"     // A step has finished
+	EventWarning  = "warning"  // Something failed without failing the operation
+)
+
+// Event reports progress of an operation
+type Event struct {
+	Type    string
+	Project string
+	Env     string
+	Service string // Set for events about a single service
+	Message string
+}
+
+// Options configures an Engine
+type Options struct {
+	Provider runtime.Provider // Defaults to Docker
+	OnEvent  func(Event)      // Receives progress; may be nil
+	Stdout   io.Writer        // Output of docker, lifecycle hooks and run commands; defaults to os.Stdout
+	Stderr   io.Writer        // Defaults to os.Stderr
+
+	// HostProvider returns the runtime for a registered remote host.
+	// Defaults to Docker aimed at the host's DOCKER_HOST or context.
+	HostProvider func(*models.Host) runtime.Provider
+}
+
+// Engine runs lifecycle operations. It is safe for concurrent use: each
+// operation holds its environment's operation lock throughout, and state
+// changes are serialized by the state file lock.
+type Engine struct {
+	provider     runtime.Provider
+	hostProvider func(*models.Host) runtime.Provider
+	onEvent      func(Event)
+	stdout       io.Writer
+	stderr       io.Writer
+}
+
+// New returns an engine
+func New(opts Options) *Engine {
+	e := &Engine{
+		provider:     opts.Provider,
+		hostProvider: opts.HostProvider,
+		onEvent:      opts.OnEvent,
+		stdout:       opts.Stdout,
+		stderr:       opts.Stderr,
+	}
+	if e.stdout == nil {
+		e.stdout = os.Stdout
+	}
+	if e.stderr == nil {
+		e.stderr = os.Stderr
+	}
+	if e.provider == nil {
+		provider := docker.NewProvider()
+		provider.SetOutput(e.stdout, e.stderr)
+		provider.SetEnvironmentResolver(composeConfig)
+		e.provider = provider
+	}
+	if e.hostProvider == nil {
+		e.hostProvider = func(host *models.Host) runtime.Provider {
+			provider := docker.NewProviderForHost(host)
+			provider.SetOutput(e.stdout, e.stderr)
+			provider.SetEnvironmentResolver(composeConfig)
+			return provider
+		}
+	}
+	return e
+}
+
+// composeConfig returns the config and profile an environment in state runs
+// compose with, for the docker commands that are only given its name. One
+// not in state runs with its workspace's config.
+func composeConfig(project, name string) (*models.ProjectConfig, string, error) {
+	env, err := state.GetEnvironment(project, name)
+	if err != nil {
+		return nil, "", nil
+	}
+	projectConfig, err := models.LoadEnvironmentConfig(env)
+	return projectConfig, env.Profile, err
+}
+
+// Provider returns the runtime the engine drives on the local host
+func (e *Engine) Provider() runtime.Provider {
+	return e.provider
+}
+
+// Result describes an environment after an operation
+type Result struct {
+	Environment *models.Environment
+	Workspace   string
+	URLs        []URL // Set once the environment has been started
+}
+
+// URL is an address an environment serves on
+type URL struct {
+	URL     string
+	Service string
+	Apex    bool // The project-level URL, without a hostname prefix
+}
+
+func (e *Engine) emit(env *models.Environment, eventType, service, format string, args ...interface{}) {
+	if e.onEvent == nil {
+		return
+	}
+	e.onEvent(Event{
+		Type:    eventType,
+		Project: env.Project,
+		Env:     env.Name,
+		Service: service,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (e *Engine) progress(env *models.Environment, format string, args ...interface{}) {
+	e.emit(env, EventProgress, "", format, args...)
+}
+
+func (e *Engine) done(env *models.Environment, format string, args ...interface{}) {
+	e.emit(env, EventDone, "", format, args...)
+}
+
+func (e *Engine) warn(env *models.Environment, format string, args ...interface{}) {
+	e.emit(env, EventWarning, "", format, args...)
+}
+
+func (e *Engine) result(env *models.Environment) *Result {
+	return &Result{
+		Environment: env,
+		Workspace:   state.GetEnvStoragePath(env.Project, env.Name),
+		URLs:        urls(env),
+	}
+}
+
+// urls lists the ingress service's addresses
+func urls(env *models.Environment) []URL {
+	suffix := env.DNSSuffix
+	if suffix == "" {
+		suffix = ".test"
+	}
+
+	var ingress *models.Service
+	for _, name := range sortedServiceNames(env.Services) {
+		if env.Services[name].IsIngress {
+			ingress = env.Services[name]
+			break
+		}
+	}
+	if ingress == nil {
+		return nil
+	}
+
+	if len(ingress.Hostnames) == 0 {
+		return []URL{{URL: fmt.Sprintf("http://%s.%s%s", ingress.Name, env.Name, suffix), Service: ingress.Name}}
+	}
+	var list []URL
+	for _, hostname := range ingress.Hostnames {
+		list = append(list, URL{
+			URL:     fmt.Sprintf("http://%s.%s.%s%s", hostname, env.Project, env.Name, suffix),
+			Service: ingress.Name,
+		})
+	}
+	return append(list, URL{
+		URL:     fmt.Sprintf("http://%s.%s%s", env.Project, env.Name, suffix),
+		Service: ingress.Name,
+		Apex:    true,
+	})
+}
+
+func sortedServiceNames(services map[string]*models.Service) []string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func dnsSuffix(cfg *models.ProjectConfig) string {
+	if cfg != nil && cfg.DNSSuffix != "" {
+		return cfg.DNSSuffix
+	}
+	return ".test"
+}
@@ -0,0 +1,239 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
+	"github.com/sharedco/cilo/pkg/runtime"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+func setupState(t *testing.T) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("CILO_USER_HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".cilo"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := state.InitializeState("10.224.", 5354); err != nil {
+		t.Fatalf("InitializeState: %v", err)
+	}
+}
+
+func writeSource(t *testing.T) string {
+	t.Helper()
+	source := filepath.Join(t.TempDir(), "myapp")
+	if err := os.MkdirAll(filepath.Join(source, ".cache"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	files := map[string]string{
+		"docker-compose.yml": "services:\n  web:\n    image: nginx\n",
+		"README.md":          "hello\n",
+		".cache/junk":        "x\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(source, name), []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	return source
+}
+
+func TestCreate(t *testing.T) {
+	setupState(t)
+	source := writeSource(t)
+
+	var events []Event
+	e := New(Options{OnEvent: func(ev Event) { events = append(events, ev) }})
+
+	result, err := e.Create(context.Background(), CreateOptions{Name: "Feature_1", From: source})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if result.Environment.Name != "feature-1" || result.Environment.Project != "myapp" {
+		t.Fatalf("env = %s/%s, want myapp/feature-1", result.Environment.Project, result.Environment.Name)
+	}
+
+	for _, name := range []string{"docker-compose.yml", "README.md", ".cilo/meta.json"} {
+		if _, err := os.Stat(filepath.Join(result.Workspace, name)); err != nil {
+			t.Fatalf("workspace missing %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(result.Workspace, ".cache")); !os.IsNotExist(err) {
+		t.Fatalf(".cache copied into workspace (err = %v)", err)
+	}
+
+	if len(events) == 0 || events[len(events)-1].Type != EventDone {
+		t.Fatalf("events = %+v, want a final done event", events)
+	}
+	if events[len(events)-1].Project != "myapp" || events[len(events)-1].Env != "feature-1" {
+		t.Fatalf("event = %+v, want it tagged with the env", events[len(events)-1])
+	}
+
+	_, err = e.Create(context.Background(), CreateOptions{Name: "feature-1", From: source})
+	if output.ErrorCode(err) != output.CodeConflict {
+		t.Fatalf("second Create: %v, want a conflict", err)
+	}
+}
+
+func TestPrepareExisting(t *testing.T) {
+	setupState(t)
+	source := writeSource(t)
+
+	e := New(Options{})
+	if _, err := e.Create(context.Background(), CreateOptions{Name: "dev", From: source}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	result, environ, err := e.Prepare(context.Background(), RunOptions{Env: "dev", From: source, NoUp: true})
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if result.Environment.Name != "dev" {
+		t.Fatalf("env = %q, want dev", result.Environment.Name)
+	}
+	found := false
+	for _, kv := range environ {
+		if kv == "CILO_ENV=dev" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("environ lacks CILO_ENV=dev")
+	}
+
+	_, _, err = e.Prepare(context.Background(), RunOptions{Env: "missing", From: source, NoCreate: true})
+	if output.ErrorCode(err) != output.CodeNotFound {
+		t.Fatalf("Prepare missing: %v, want not found", err)
+	}
+}
+
+func TestURLs(t *testing.T) {
+	env := &models.Environment{
+		Name:      "dev",
+		Project:   "shop",
+		DNSSuffix: ".localhost",
+		Services: map[string]*models.Service{
+			"db":  {Name: "db"},
+			"web": {Name: "web", IsIngress: true, Hostnames: []string{"api"}},
+		},
+	}
+	got := urls(env)
+	want := []URL{
+		{URL: "http://api.shop.dev.localhost", Service: "web"},
+		{URL: "http://shop.dev.localhost", Service: "web", Apex: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("urls = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("urls[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	env.Services["web"].Hostnames = nil
+	env.DNSSuffix = ""
+	if got := urls(env); len(got) != 1 || got[0].URL != "http://web.dev.test" {
+		t.Fatalf("urls without hostnames = %+v", got)
+	}
+}
+
+func TestFilterOut(t *testing.T) {
+	got := filterOut([]string{"db", "redis", "queue"}, []string{" redis"})
+	if strings.Join(got, ",") != "db,queue" {
+		t.Fatalf("filterOut = %v", got)
+	}
+}
+
+func TestSnapshotName(t *testing.T) {
+	cases := map[string]string{
+		"":          DefaultSnapshot,
+		"Clean_DB":  "clean-db",
+		"seed.v2":   "seed.v2",
+		"..":        "",
+		"../escape": "",
+	}
+	for in, want := range cases {
+		got, err := snapshotName(in)
+		if want == "" {
+			if err == nil {
+				t.Fatalf("snapshotName(%q) = %q, want error", in, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Fatalf("snapshotName(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
+
+func TestProviderFor(t *testing.T) {
+	setupState(t)
+	source := writeSource(t)
+	if err := state.AddHost(&models.Host{ID: "build1", DockerHost: "ssh://me@build1"}); err != nil {
+		t.Fatalf("AddHost: %v", err)
+	}
+
+	local, remote := &idleProvider{}, &idleProvider{}
+	var gotHost *models.Host
+	e := New(Options{Provider: local, HostProvider: func(host *models.Host) runtime.Provider {
+		gotHost = host
+		return remote
+	}})
+
+	ctx := context.Background()
+	if _, err := e.Create(ctx, CreateOptions{Name: "here", From: source}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := e.Create(ctx, CreateOptions{Name: "there", From: source, Host: "build1"}); err != nil {
+		t.Fatalf("Create on host: %v", err)
+	}
+	if _, err := e.Create(ctx, CreateOptions{Name: "nowhere", From: source, Host: "missing"}); output.ErrorCode(err) != output.CodeNotFound {
+		t.Fatalf("Create on unknown host: %v, want not found", err)
+	}
+
+	if p, err := e.ProviderFor("myapp", "here"); err != nil || p != local {
+		t.Fatalf("ProviderFor(here) = %v, %v, want the local provider", p, err)
+	}
+	if p, err := e.ProviderFor("myapp", "there"); err != nil || p != remote {
+		t.Fatalf("ProviderFor(there) = %v, %v, want the host's provider", p, err)
+	}
+	if gotHost == nil || gotHost.DockerHost != "ssh://me@build1" {
+		t.Fatalf("HostProvider got %+v, want build1", gotHost)
+	}
+}
+
+func TestSplitSSHTarget(t *testing.T) {
+	tests := []struct{ target, host, port string }{
+		{"build1", "build1", ""},
+		{"me@build1", "me@build1", ""},
+		{"me@build1:2222", "me@build1", "2222"},
+		{"10.0.0.5:22", "10.0.0.5", "22"},
+	}
+	for _, tt := range tests {
+		host, port := splitSSHTarget(tt.target)
+		if host != tt.host || port != tt.port {
+			t.Errorf("splitSSHTarget(%q) = %q, %q, want %q, %q", tt.target, host, port, tt.host, tt.port)
+		}
+	}
+	if got := shellQuote("it's"); got != `'it'\''s'` {
+		t.Errorf("shellQuote = %s", got)
+	}
+}
+
+func TestIsLocalMachine(t *testing.T) {
+	for _, target := range []string{"localhost", "me@127.0.0.1", "me@127.0.0.1:2222", "[::1]:22"} {
+		if !isLocalMachine(target) {
+			t.Errorf("isLocalMachine(%q) = false, want true", target)
+		}
+	}
+	if isLocalMachine("me@192.0.2.7") {
+		t.Errorf("isLocalMachine(192.0.2.7) = true, want false")
+	}
+}
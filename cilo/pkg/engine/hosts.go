@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/config"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/runtime"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// remote reports whether an environment runs on a registered host rather
+// than this one
+func remote(env *models.Environment) bool {
+	return env.Host != "" && env.Host != state.LocalHostID
+}
+
+// providerFor returns the runtime for the host an environment is on
+func (e *Engine) providerFor(env *models.Environment) (runtime.Provider, error) {
+	if !remote(env) {
+		return e.provider, nil
+	}
+	host, err := state.GetHost(env.Host)
+	if err != nil {
+		return nil, err
+	}
+	return e.hostProvider(host), nil
+}
+
+// ProviderFor returns the runtime for the host an environment is on
+func (e *Engine) ProviderFor(project, name string) (runtime.Provider, error) {
+	env, err := state.GetEnvironment(project, name)
+	if err != nil {
+		return nil, err
+	}
+	return e.providerFor(env)
+}
+
+// AddHost checks that a host's Docker daemon and, for workspace sync, its
+// SSH server can be reached, then registers it. An SSH target is taken
+// from an ssh:// DOCKER_HOST or docker context when SSH isn't set.
+//
+// Workspaces are synced to the same absolute path on the host, so the SSH
+// user must be able to create and write this machine's envs directory there
+// (e.g. the same username and home directory); AddHost checks that it can.
+func (e *Engine) AddHost(ctx context.Context, host *models.Host) error {
+	if (host.DockerHost == "") == (host.DockerContext == "") {
+		return fmt.Errorf("a host needs exactly one of a DOCKER_HOST or a docker context")
+	}
+	if host.SSH == "" {
+		endpoint := host.DockerHost
+		if host.DockerContext != "" {
+			out, err := exec.CommandContext(ctx, "docker", "context", "inspect", host.DockerContext,
+				"--format", "{{.Endpoints.docker.Host}}").Output()
+			if err != nil {
+				return fmt.Errorf("unknown docker context %q: %w", host.DockerContext, err)
+			}
+			endpoint = strings.TrimSpace(string(out))
+		}
+		host.SSH = strings.TrimPrefix(endpoint, "ssh://")
+		if host.SSH == endpoint {
+			return fmt.Errorf("%s isn't reached over SSH, so set an SSH target for workspace sync", endpoint)
+		}
+	}
+
+	if err := e.hostProvider(host).Ping(ctx); err != nil {
+		return err
+	}
+	if err := runSSH(ctx, host.SSH, "true"); err != nil {
+		return err
+	}
+	envsDir := shellQuote(config.GetEnvsDir())
+	if err := runSSH(ctx, host.SSH, "mkdir -p "+envsDir+" && test -w "+envsDir); err != nil {
+		return fmt.Errorf("workspaces are synced to %s on the host, which the SSH user can't write: %w", config.GetEnvsDir(), err)
+	}
+	return state.AddHost(host)
+}
+
+// syncToHost copies a remote environment's workspace to the same path on
+// its host, unless the host is this machine. Compose resolves bind mounts to
+// workspace paths, which the host's daemon then mounts from its own
+// filesystem.
+func (e *Engine) syncToHost(ctx context.Context, env *models.Environment) error {
+	if !remote(env) {
+		return nil
+	}
+	host, err := state.GetHost(env.Host)
+	if err != nil {
+		return err
+	}
+	if isLocalMachine(host.SSH) {
+		return nil
+	}
+	workspace := state.GetEnvStoragePath(env.Project, env.Name)
+
+	e.progress(env, "Syncing workspace to %s...", host.ID)
+	if err := runSSH(ctx, host.SSH, "mkdir -p "+shellQuote(workspace)); err != nil {
+		return err
+	}
+	target, port := splitSSHTarget(host.SSH)
+	rsh := "ssh -o BatchMode=yes"
+	if port != "" {
+		rsh += " -p " + port
+	}
+	cmd := exec.CommandContext(ctx, "rsync", "-az", "--delete", "-e", rsh,
+		workspace+"/", target+":"+workspace+"/")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to sync workspace to %s: %s", host.ID, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// removeFromHost deletes a remote environment's copy of its workspace. A
+// host reached over SSH on this machine shares the local workspace, which
+// is left to the caller.
+func (e *Engine) removeFromHost(ctx context.Context, env *models.Environment) {
+	if !remote(env) {
+		return
+	}
+	host, err := state.GetHost(env.Host)
+	if err == nil && isLocalMachine(host.SSH) {
+		return
+	}
+	if err == nil {
+		err = runSSH(ctx, host.SSH, "rm -rf "+shellQuote(state.GetEnvStoragePath(env.Project, env.Name)))
+	}
+	if err != nil {
+		e.warn(env, "failed to remove workspace from host %s: %v", env.Host, err)
+	}
+}
+
+// isLocalMachine reports whether an SSH target resolves to this machine: a
+// loopback address, this machine's hostname or one of its interface
+// addresses
+func isLocalMachine(target string) bool {
+	host, _ := splitSSHTarget(target)
+	if _, h, found := strings.Cut(host, "@"); found {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if name, err := os.Hostname(); err == nil && strings.EqualFold(host, name) {
+		return true
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, ip := range ips {
+		if ip.IsLoopback() {
+			return true
+		}
+		for _, addr := range addrs {
+			if n, ok := addr.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runSSH runs a shell command on an SSH target without prompting
+func runSSH(ctx context.Context, target, command string) error {
+	host, port := splitSSHTarget(target)
+	args := []string{"-o", "BatchMode=yes"}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, host, command)
+	if out, err := exec.CommandContext(ctx, "ssh", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("ssh %s: %s", target, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// splitSSHTarget splits [user@]host[:port] into what ssh takes as the
+// destination and the port
+func splitSSHTarget(target string) (string, string) {
+	user, hostPort, found := strings.Cut(target, "@")
+	if !found {
+		user, hostPort = "", target
+	}
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return target, ""
+	}
+	if user != "" {
+		host = user + "@" + host
+	}
+	return host, port
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
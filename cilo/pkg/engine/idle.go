@@ -0,0 +1,328 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/dns"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/runtime"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// Ways to suspend an idle environment
+const (
+	IdlePause = "pause" // docker compose pause; resumes instantly, keeps memory
+	IdleStop  = "stop"  // docker compose stop; frees memory, resumes in seconds
+)
+
+// Container counters between two samples that count as activity
+const (
+	activeCPUPercent = 2.0
+	activeNetBytes   = 64 * 1024
+)
+
+// Touch records that an environment was just used
+func (e *Engine) Touch(project, name string) error {
+	return state.RecordActivity(project, name, time.Now())
+}
+
+// Suspend pauses or stops a running environment, keeping its network, DNS
+// entries and shared services so Resume can bring it straight back
+func (e *Engine) Suspend(ctx context.Context, project, name, action string) (*Result, error) {
+	unlock, err := e.lock(ctx, project, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	env, err := state.GetEnvironment(project, name)
+	if err != nil {
+		return nil, err
+	}
+	if env.Status != "running" {
+		return nil, fmt.Errorf("environment %s/%s is %s, not running", project, name, env.Status)
+	}
+	return e.suspend(ctx, env, action)
+}
+
+// suspendIdle suspends env if, once its operation lock is held, it is still
+// running and was last used no later than cutoff, so an environment used
+// since the monitor looked at it is left alone. It reports whether env was
+// suspended.
+func (e *Engine) suspendIdle(ctx context.Context, env *models.Environment, action string, cutoff time.Time) (bool, error) {
+	unlock, err := e.lock(ctx, env.Project, env.Name)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	current, err := state.GetEnvironment(env.Project, env.Name)
+	if errors.Is(err, state.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if current.Status != "running" || lastUsed(current).After(cutoff) {
+		return false, nil
+	}
+	if _, err := e.suspend(ctx, current, action); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lastUsed returns when an environment was last used, or created if it
+// hasn't been
+func lastUsed(env *models.Environment) time.Time {
+	if env.LastActivity.IsZero() {
+		return env.CreatedAt
+	}
+	return env.LastActivity
+}
+
+// suspend is Suspend for a caller holding env's operation lock
+func (e *Engine) suspend(ctx context.Context, env *models.Environment, action string) (*Result, error) {
+	switch action {
+	case "", IdlePause:
+		e.progress(env, "Pausing environment...")
+		if err := e.compose(ctx, env, "pause"); err != nil {
+			return nil, fmt.Errorf("failed to pause environment: %w", err)
+		}
+		env.Status = "paused"
+	case IdleStop:
+		e.progress(env, "Stopping environment...")
+		if err := e.compose(ctx, env, "stop"); err != nil {
+			return nil, fmt.Errorf("failed to stop environment: %w", err)
+		}
+		env.Status = "suspended"
+	default:
+		return nil, fmt.Errorf("invalid idle action %q (use %q or %q)", action, IdlePause, IdleStop)
+	}
+	env.SuspendedAt = time.Now().UTC()
+
+	if err := state.UpdateEnvironment(env); err != nil {
+		return nil, err
+	}
+	e.done(env, "Environment suspended (%s)", env.Status)
+	return e.result(env), nil
+}
+
+// Resume records use of an environment and, if Suspend paused or stopped
+// it, brings it back. Environments in any other state are left alone.
+func (e *Engine) Resume(ctx context.Context, project, name string) (*Result, error) {
+	unlock, err := e.lock(ctx, project, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := e.Touch(project, name); err != nil {
+		return nil, err
+	}
+	env, err := state.GetEnvironment(project, name)
+	if err != nil {
+		return nil, err
+	}
+	if env.Status != "paused" && env.Status != "suspended" {
+		return e.result(env), nil
+	}
+
+	provider, err := e.providerFor(env)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.Ping(ctx); err != nil {
+		return nil, err
+	}
+	if env.Status == "paused" {
+		e.progress(env, "Resuming paused environment...")
+		if err := e.compose(ctx, env, "unpause"); err != nil {
+			return nil, fmt.Errorf("failed to unpause environment: %w", err)
+		}
+	} else {
+		// Stopped environments gave their room in the host budget back
+		if err := e.admit(ctx, env, false, 0); err != nil {
+			return nil, err
+		}
+		e.progress(env, "Resuming suspended environment...")
+		if err := e.compose(ctx, env, "start"); err != nil {
+			env.Status = "suspended"
+			state.UpdateEnvironment(env)
+			return nil, fmt.Errorf("failed to start environment: %w", err)
+		}
+	}
+
+	env.Status = "running"
+	env.SuspendedAt = time.Time{}
+	if err := state.UpdateEnvironment(env); err != nil {
+		return nil, err
+	}
+	e.done(env, "Environment resumed")
+	return e.result(env), nil
+}
+
+// IdleMonitor records environment activity from DNS lookups and container
+// counters, suspends environments idle past their project's idle timeout
+// and resumes suspended ones when their names are looked up
+type IdleMonitor struct {
+	engine  *Engine
+	samples map[string]runtime.ContainerStats // Previous sample by container
+}
+
+// IdleMonitor returns a monitor driven by the engine. DNS lookups are read
+// from where the last cilo process to read them stopped.
+func (e *Engine) IdleMonitor() *IdleMonitor {
+	return &IdleMonitor{engine: e, samples: make(map[string]runtime.ContainerStats)}
+}
+
+// Run checks every interval until ctx is done, reporting failures as
+// warning events
+func (m *IdleMonitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := m.Check(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			m.engine.warn(&models.Environment{}, "Idle check: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check makes one pass over the local environments
+func (m *IdleMonitor) Check(ctx context.Context, now time.Time) error {
+	return m.pass(ctx, func(env *models.Environment, lookups []dns.Lookup) error {
+		return m.check(ctx, env, lookups, now)
+	})
+}
+
+// CheckLookups only applies the DNS lookups made since the last read of the
+// query log: suspended environments that were looked up resume, and running
+// ones record the activity. The CLI runs it on up and status, so lookups
+// resume environments even without a watcher.
+func (m *IdleMonitor) CheckLookups(ctx context.Context) error {
+	return m.pass(ctx, func(env *models.Environment, lookups []dns.Lookup) error {
+		_, err := m.applyLookups(ctx, env, lookups)
+		return err
+	})
+}
+
+func (m *IdleMonitor) pass(ctx context.Context, fn func(*models.Environment, []dns.Lookup) error) error {
+	var errs []error
+	lookups, err := dns.ReadQueries()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	envs, err := state.ListEnvironments()
+	if err != nil {
+		return err
+	}
+	for _, env := range envs {
+		if err := fn(env, lookups); err != nil {
+			errs = append(errs, fmt.Errorf("%s/%s: %w", env.Project, env.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *IdleMonitor) check(ctx context.Context, env *models.Environment, lookups []dns.Lookup, now time.Time) error {
+	if resumed, err := m.applyLookups(ctx, env, lookups); resumed || err != nil {
+		return err
+	}
+	if env.Status != "running" {
+		return nil
+	}
+
+	active, err := m.active(ctx, env)
+	if err != nil {
+		return err
+	}
+	if active {
+		return state.RecordActivity(env.Project, env.Name, now)
+	}
+
+	timeout, action, err := idlePolicy(env)
+	if err != nil || timeout <= 0 {
+		return err
+	}
+	if idle := now.Sub(lastUsed(env)); idle >= timeout {
+		m.engine.progress(env, "Idle for %s", idle.Round(time.Minute))
+		_, err := m.engine.suspendIdle(ctx, env, action, now.Add(-timeout))
+		return err
+	}
+	return nil
+}
+
+// applyLookups resumes env if one of its names was looked up since Suspend
+// suspended it, and otherwise records its latest lookup as activity. It
+// reports whether env was resumed.
+func (m *IdleMonitor) applyLookups(ctx context.Context, env *models.Environment, lookups []dns.Lookup) (bool, error) {
+	suspended := env.Status == "paused" || env.Status == "suspended"
+	// Lookups are logged to the second
+	since := env.SuspendedAt.Truncate(time.Second)
+	var latest time.Time
+	for _, lookup := range lookups {
+		if !dns.MatchesEnvironment(env, lookup.Name) {
+			continue
+		}
+		if suspended && !lookup.Time.Before(since) {
+			_, err := m.engine.Resume(ctx, env.Project, env.Name)
+			return true, err
+		}
+		if lookup.Time.After(latest) {
+			latest = lookup.Time
+		}
+	}
+	if !latest.After(env.LastActivity) {
+		return false, nil
+	}
+	env.LastActivity = latest
+	return false, state.RecordActivity(env.Project, env.Name, latest)
+}
+
+// active samples an environment's containers and reports whether any used
+// CPU, or moved data since the previous sample
+func (m *IdleMonitor) active(ctx context.Context, env *models.Environment) (bool, error) {
+	provider, err := m.engine.providerFor(env)
+	if err != nil {
+		return false, err
+	}
+	stats, err := provider.ContainerStats(ctx, env.Name)
+	if err != nil {
+		return false, err
+	}
+	active := false
+	for container, sample := range stats {
+		previous, seen := m.samples[container]
+		if sample.CPUPercent >= activeCPUPercent {
+			active = true
+		}
+		// Counters restart with the container, so only growth counts
+		if seen && sample.NetBytes-previous.NetBytes >= activeNetBytes {
+			active = true
+		}
+		m.samples[container] = sample
+	}
+	return active, nil
+}
+
+// idlePolicy reads an environment's idle settings from its config. A zero timeout means it is never suspended.
+func idlePolicy(env *models.Environment) (time.Duration, string, error) {
+	projectConfig, err := models.LoadEnvironmentConfig(env)
+	if err != nil || projectConfig == nil || projectConfig.Idle == nil || projectConfig.Idle.Timeout == "" {
+		return 0, "", err
+	}
+	timeout, err := time.ParseDuration(projectConfig.Idle.Timeout)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid idle timeout %q: %w", projectConfig.Idle.Timeout, err)
+	}
+	return timeout, projectConfig.Idle.Action, nil
+}
@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/config"
+	"github.com/sharedco/cilo/pkg/dns"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/runtime"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// idleProvider records compose commands and reports canned container
+// stats; other runtime calls are not expected
+type idleProvider struct {
+	runtime.Provider
+	compose []string
+	stats   runtime.ContainerStats
+}
+
+func (p *idleProvider) Ping(ctx context.Context) error { return nil }
+
+func (p *idleProvider) Compose(ctx context.Context, project, envName string, opts runtime.ComposeOptions) error {
+	p.compose = append(p.compose, strings.Join(opts.Args, " "))
+	return nil
+}
+
+func (p *idleProvider) ContainerStats(ctx context.Context, envName string) (map[string]runtime.ContainerStats, error) {
+	return map[string]runtime.ContainerStats{"cilo_" + envName + "_web": p.stats}, nil
+}
+
+func TestIdleMonitor(t *testing.T) {
+	setupState(t)
+	source := writeSource(t)
+	if err := os.MkdirAll(config.GetDNSDir(), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	provider := &idleProvider{}
+	e := New(Options{Provider: provider})
+	result, err := e.Create(context.Background(), CreateOptions{Name: "dev", From: source})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	idleConfig := "project: myapp\nidle:\n  timeout: 1h\n"
+	if err := os.WriteFile(filepath.Join(result.Workspace, ".cilo", "config.yml"), []byte(idleConfig), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	now := time.Now()
+	env := result.Environment
+	env.Status = "running"
+	env.LastActivity = now.Add(-30 * time.Minute)
+	env.Services = map[string]*models.Service{"web": {Name: "web", IP: "10.224.1.10"}}
+	if err := state.UpdateEnvironment(env); err != nil {
+		t.Fatalf("UpdateEnvironment: %v", err)
+	}
+	status := func() string {
+		t.Helper()
+		env, err := state.GetEnvironment("myapp", "dev")
+		if err != nil {
+			t.Fatalf("GetEnvironment: %v", err)
+		}
+		return env.Status
+	}
+
+	monitor := e.IdleMonitor()
+	if err := monitor.Check(context.Background(), now); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if status() != "running" || len(provider.compose) != 0 {
+		t.Fatalf("env used 30m ago was suspended (compose %v)", provider.compose)
+	}
+
+	// Busy containers keep it awake past the timeout
+	provider.stats = runtime.ContainerStats{CPUPercent: 40}
+	if err := monitor.Check(context.Background(), now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if status() != "running" {
+		t.Fatalf("busy env was suspended")
+	}
+
+	provider.stats = runtime.ContainerStats{}
+	if err := monitor.Check(context.Background(), now.Add(4*time.Hour)); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if status() != "paused" || strings.Join(provider.compose, ",") != "pause" {
+		t.Fatalf("idle env status = %s (compose %v), want paused", status(), provider.compose)
+	}
+
+	// A lookup from before it was suspended doesn't bring it back
+	env, err = state.GetEnvironment("myapp", "dev")
+	if err != nil {
+		t.Fatalf("GetEnvironment: %v", err)
+	}
+	queryAt := func(at time.Time) string {
+		return at.Format("Jan _2 15:04:05") + " dnsmasq[42]: query[A] web.dev.test from 127.0.0.1\n"
+	}
+	if err := os.WriteFile(dns.QueryLogPath(), []byte(queryAt(env.SuspendedAt.Add(-time.Minute))), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := monitor.CheckLookups(context.Background()); err != nil {
+		t.Fatalf("CheckLookups: %v", err)
+	}
+	if status() != "paused" {
+		t.Fatalf("env resumed by a lookup from before it was suspended")
+	}
+
+	// A later lookup of one of its names brings it back, even without a
+	// watcher
+	log, err := os.OpenFile(dns.QueryLogPath(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	if _, err := log.WriteString(queryAt(env.SuspendedAt.Add(time.Minute))); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+	log.Close()
+	if err := e.IdleMonitor().CheckLookups(context.Background()); err != nil {
+		t.Fatalf("CheckLookups: %v", err)
+	}
+	if status() != "running" || strings.Join(provider.compose, ",") != "pause,unpause" {
+		t.Fatalf("looked-up env status = %s (compose %v), want running", status(), provider.compose)
+	}
+}
+
+func TestIdleSuspendRechecksActivity(t *testing.T) {
+	setupState(t)
+	source := writeSource(t)
+	provider := &idleProvider{}
+	e := New(Options{Provider: provider})
+	result, err := e.Create(context.Background(), CreateOptions{Name: "dev", From: source})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	idleConfig := "project: myapp\nidle:\n  timeout: 1h\n"
+	if err := os.WriteFile(filepath.Join(result.Workspace, ".cilo", "config.yml"), []byte(idleConfig), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	now := time.Now()
+	env := result.Environment
+	env.Status = "running"
+	env.LastActivity = now.Add(-2 * time.Hour)
+	if err := state.UpdateEnvironment(env); err != nil {
+		t.Fatalf("UpdateEnvironment: %v", err)
+	}
+
+	// The monitor's snapshot says idle, but the environment is used before
+	// it takes the lock
+	snapshot := *env
+	if err := e.Touch("myapp", "dev"); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+	if err := e.IdleMonitor().check(context.Background(), &snapshot, nil, now); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	current, err := state.GetEnvironment("myapp", "dev")
+	if err != nil {
+		t.Fatalf("GetEnvironment: %v", err)
+	}
+	if current.Status != "running" || len(provider.compose) != 0 {
+		t.Fatalf("env used after the snapshot was suspended (status %s, compose %v)", current.Status, provider.compose)
+	}
+}
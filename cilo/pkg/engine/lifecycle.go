@@ -0,0 +1,839 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/compose"
+	"github.com/sharedco/cilo/pkg/dns"
+	envpkg "github.com/sharedco/cilo/pkg/env"
+	"github.com/sharedco/cilo/pkg/hooks"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
+	"github.com/sharedco/cilo/pkg/ready"
+	"github.com/sharedco/cilo/pkg/reconcile"
+	"github.com/sharedco/cilo/pkg/resources"
+	"github.com/sharedco/cilo/pkg/runtime"
+	"github.com/sharedco/cilo/pkg/share"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// CreateOptions configures Create
+type CreateOptions struct {
+	Name    string
+	Project string // Defaults to the source's configured project, then its directory name
+	From    string // Source directory; defaults to the current directory
+	Empty   bool   // Start from a minimal compose file instead of copying the source
+	Include string // Only copy files matching this glob
+	Host    string // ID of a registered host to run on; empty for this one
+	// TTL is how long until the environment expires and 'cilo prune'
+	// removes it; defaults to the source config's ttl
+	TTL time.Duration
+}
+
+// UpOptions configures Up
+type UpOptions struct {
+	Build       bool
+	Recreate    bool
+	Wait        bool          // Wait for services to be ready before returning
+	WaitTimeout time.Duration // Per-service readiness timeout; defaults to ready.DefaultTimeout
+	Shared      []string      // Share these services on top of cilo.share labels
+	Isolate     []string      // Keep these services isolated despite their labels
+
+	// Profile runs a profile from the project config or compose files. It
+	// is saved on the environment and applies to later ups too, until
+	// NoProfile clears it.
+	Profile   string
+	NoProfile bool
+
+	// Images swaps in prebuilt images by service, in place of the image
+	// the service names or builds. They are saved on the environment and
+	// apply to later ups too; an empty ref drops a swap.
+	Images map[string]string
+
+	// Resources sets per-environment limits over the project's policy. They
+	// are saved on the environment and apply to later ups too.
+	Resources    *models.ResourceLimits
+	Queue        bool          // Wait for room in the host budget instead of failing
+	QueueTimeout time.Duration // Give up waiting after this long; 0 waits until ctx is done
+}
+
+// queuePollInterval is how often a queued up rechecks the host budget
+const queuePollInterval = 2 * time.Second
+
+// DestroyOptions configures Destroy
+type DestroyOptions struct {
+	KeepWorkspace bool
+}
+
+// Create allocates an environment, fills its workspace and runs post_create
+// hooks
+func (e *Engine) Create(ctx context.Context, opts CreateOptions) (*Result, error) {
+	name := state.NormalizeName(opts.Name)
+
+	from := opts.From
+	if from == "" {
+		from = "."
+	}
+	source, err := filepath.Abs(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source path: %w", err)
+	}
+
+	project, sourceConfig, err := sourceProject(source, opts.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := e.lock(ctx, project, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	exists, err := state.EnvironmentExists(project, name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, output.WithCode(output.CodeConflict, fmt.Errorf("environment %q already exists in project %q (use a different name or destroy first)", name, project))
+	}
+
+	ttl := opts.TTL
+	if ttl == 0 && sourceConfig != nil && sourceConfig.TTL != "" {
+		if ttl, err = ParseDuration(sourceConfig.TTL); err != nil {
+			return nil, fmt.Errorf("invalid ttl in project config: %w", err)
+		}
+	}
+
+	env, err := state.CreateEnvironment(name, source, project, opts.Host)
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		env.ExpiresAt = env.CreatedAt.Add(ttl)
+		if err := state.UpdateEnvironment(env); err != nil {
+			return nil, err
+		}
+	}
+
+	workspace := state.GetEnvStoragePath(project, name)
+	if err := os.MkdirAll(workspace, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	if opts.Empty {
+		composePath := filepath.Join(workspace, "docker-compose.yml")
+		if err := compose.CreateMinimal(env, composePath); err != nil {
+			return nil, err
+		}
+
+		envPath := filepath.Join(workspace, ".env")
+		os.WriteFile(envPath, []byte("# Environment variables\n"), 0644)
+	} else {
+		if err := e.populateWorkspace(env, source, workspace, opts.Include, sourceConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := recordSourceRepos(env); err != nil {
+		e.warn(env, "failed to record source commits: %v", err)
+	}
+
+	ciloDir := filepath.Join(workspace, ".cilo")
+	if err := os.MkdirAll(ciloDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create .cilo directory: %w", err)
+	}
+
+	if err := writeEnvMeta(env, ciloDir); err != nil {
+		e.warn(env, "failed to write meta.json: %v", err)
+	}
+	if env.Source != "" {
+		if err := recordCopies(env, workspace); err != nil {
+			e.warn(env, "failed to record copied files: %v", err)
+		}
+	}
+
+	e.done(env, "Environment %q created in project %q", name, project)
+
+	if err := e.runHooks(ctx, hooks.PostCreate, env, workspace, "", sourceConfig); err != nil {
+		return nil, err
+	}
+
+	return &Result{Environment: env, Workspace: workspace}, nil
+}
+
+// sourceProject loads a source directory's project config and returns the
+// project its environments go in: project if set, else the configured one or
+// the directory's name
+func sourceProject(source, project string) (string, *models.ProjectConfig, error) {
+	sourceConfig, err := models.LoadProjectConfigFromPath(source)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+	if project == "" {
+		project = models.ProjectName(source, sourceConfig)
+	}
+	return project, sourceConfig, nil
+}
+
+// lock takes the operation lock of project/name, reporting when it has to
+// wait for another operation (see state.LockEnvironment)
+func (e *Engine) lock(ctx context.Context, project, name string) (func(), error) {
+	return state.LockEnvironment(ctx, project, name, func() {
+		e.progress(&models.Environment{Project: project, Name: name}, "Waiting for another operation on %s/%s...", project, name)
+	})
+}
+
+// populateWorkspace copies the source into a new workspace and records the
+// copy as the sync baseline
+func (e *Engine) populateWorkspace(env *models.Environment, source, workspace, include string, cfg *models.ProjectConfig) error {
+	copyOpts := CopyOptions{Include: include}
+	if cfg != nil {
+		copyOpts.CopyDotDirs = cfg.CopyDotDirs
+		copyOpts.IgnoreDotDirs = cfg.IgnoreDotDirs
+	}
+
+	if err := CopyProject(source, workspace, copyOpts); err != nil {
+		return fmt.Errorf("failed to copy project: %w", err)
+	}
+
+	composeFiles, err := resolveComposeFiles(workspace, cfg)
+	if err != nil {
+		return err
+	}
+	if len(composeFiles) == 0 {
+		return fmt.Errorf("no compose files found in source directory")
+	}
+
+	if err := initFileSync(source, workspace, cfg); err != nil {
+		e.warn(env, "failed to initialize sync journal: %v", err)
+	}
+	return nil
+}
+
+// Up starts an environment's containers, connects its shared services,
+// updates DNS and runs the pre_up/post_up hooks. It fails with an error
+// wrapping state.ErrOverBudget if the host budget has no room for the
+// environment, unless Queue is set.
+func (e *Engine) Up(ctx context.Context, project, name string, opts UpOptions) (result *Result, err error) {
+	unlock, err := e.lock(ctx, project, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	env, err := state.GetEnvironment(project, name)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace := state.GetEnvStoragePath(project, name)
+	projectConfig, err := models.LoadEnvironmentConfig(env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+	if sourceConfigNewer(env, workspace) {
+		e.warn(env, "%s has .cilo/config.yml changes this workspace doesn't; run 'cilo refresh %s' to bring them in", env.Source, name)
+	}
+
+	suffix := dnsSuffix(projectConfig)
+	env.DNSSuffix = suffix
+
+	if opts.Resources != nil {
+		env.Resources = resources.Merge(env.Resources, opts.Resources)
+	}
+	var policy *models.ResourceLimits
+	if projectConfig != nil {
+		policy = projectConfig.Resources
+	}
+	policy = resources.Merge(policy, env.Resources)
+	if err := resources.Validate(policy); err != nil {
+		return nil, err
+	}
+
+	if err := envpkg.ApplyConfig(workspace, projectConfig, envpkg.RenderContext{
+		Project:   project,
+		Env:       name,
+		DNSSuffix: suffix,
+	}, e.stdout, e.stderr); err != nil {
+		return nil, fmt.Errorf("failed to apply env config: %w", err)
+	}
+
+	if err := e.runHooks(ctx, hooks.PreUp, env, workspace, "", projectConfig); err != nil {
+		return nil, err
+	}
+
+	composeProject, err := loadProject(workspace, projectConfig)
+	if err != nil {
+		return nil, err
+	}
+	composeFiles := composeProject.ComposeFiles
+	for _, warning := range composeProject.Warnings {
+		e.warn(env, "%s", warning)
+	}
+	projectConfig = withHooks(projectConfig, composeProject.PostUp)
+
+	if err := compose.Validate(composeFiles); err != nil {
+		return nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+
+	if opts.NoProfile {
+		env.Profile = ""
+	}
+	if opts.Profile != "" {
+		env.Profile = opts.Profile
+	}
+	services, err := compose.LoadServices(composeFiles)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := selectProfile(env, projectConfig, services)
+	if err != nil {
+		return nil, err
+	}
+	if err := setImages(env, services, opts.Images); err != nil {
+		return nil, err
+	}
+
+	// Determine which services should be shared
+	// 1. Start with services labeled cilo.share: "true"
+	sharedServices, err := compose.GetServicesWithLabel(composeFiles, "cilo.share", "true")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shared services: %w", err)
+	}
+
+	// 2. Add from shared_services in the project config and --shared flag
+	var requested []string
+	if projectConfig != nil {
+		requested = append(requested, projectConfig.SharedServices...)
+	}
+	if profile != nil {
+		requested = append(requested, profile.Shared...)
+	}
+	for _, svc := range append(requested, opts.Shared...) {
+		svc = strings.TrimSpace(svc)
+		if svc != "" && !contains(sharedServices, svc) {
+			sharedServices = append(sharedServices, svc)
+		}
+	}
+
+	// 3. Remove from the profile's isolated services and --isolate flag
+	if profile != nil {
+		sharedServices = filterOut(sharedServices, profile.Isolated)
+	}
+	sharedServices = filterOut(sharedServices, opts.Isolate)
+
+	// Shared services live on the local daemon, out of a remote host's reach
+	if remote(env) && len(sharedServices) > 0 {
+		e.warn(env, "shared services aren't supported on remote hosts; running %s isolated", strings.Join(sharedServices, ", "))
+		sharedServices = nil
+	}
+
+	var ingress string
+	var hostnames []string
+	if projectConfig != nil {
+		ingress, hostnames = projectConfig.DefaultIngressService, projectConfig.Hostnames
+	}
+
+	profiles := composeProject.Profiles
+	var profileServices []string
+	if profile != nil {
+		profiles = append(append([]string{}, profiles...), profile.ComposeProfiles...)
+		profileServices = profile.Services
+	}
+	images, builds, err := planImages(env, workspace, services, func(svc string) bool {
+		return services[svc].Enabled(profiles) && !contains(sharedServices, svc) &&
+			(profileServices == nil || contains(profileServices, svc))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e.progress(env, "Generating cilo override...")
+	overridePath := filepath.Join(workspace, ".cilo", "override.yml")
+	if err := compose.TransformWithOptions(env, composeFiles, overridePath, compose.TransformOptions{
+		DNSSuffix: suffix,
+		Shared:    sharedServices,
+		Resources: policy,
+		Profiles:  profiles,
+		Ingress:   ingress,
+		Hostnames: hostnames,
+		Services:  profileServices,
+		Images:    images,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to generate override file: %w", err)
+	}
+
+	// Reserve room in the host budget before touching docker, so a queued
+	// up leaves nothing half-started while it waits
+	previousStatus := env.Status
+	if err := e.admit(ctx, env, opts.Queue, opts.QueueTimeout); err != nil {
+		return nil, err
+	}
+	// Release the reservation if the environment doesn't come up
+	defer func() {
+		if err != nil && env.Status == "starting" {
+			env.Status = previousStatus
+			state.UpdateEnvironment(env)
+		}
+	}()
+
+	// Create network first
+	provider, err := e.providerFor(env)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.Ping(ctx); err != nil {
+		return nil, err
+	}
+	// Docker only rejects an unsupported storage_opt when it creates the
+	// container, by which time other services may be up
+	if policy != nil && policy.Storage != "" {
+		if err := provider.CheckStorageLimits(ctx); err != nil {
+			return nil, output.WithCode(output.CodeInvalidConfig, fmt.Errorf("can't apply the storage limit %s: %w (drop it from resources or --storage)", policy.Storage, err))
+		}
+	}
+	if err := e.syncToHost(ctx, env); err != nil {
+		return nil, err
+	}
+	if err := provider.CreateNetwork(ctx, env); err != nil {
+		return nil, fmt.Errorf("failed to create network: %w", err)
+	}
+	if err := e.buildImages(ctx, env, provider, builds, opts.Build); err != nil {
+		return nil, err
+	}
+
+	// Handle shared services
+	if len(sharedServices) > 0 {
+		e.progress(env, "Managing shared services: %s", strings.Join(sharedServices, ", "))
+		shareMgr := share.NewManager(e.provider, ctx)
+		shareMgr.SetOutput(e.stdout, e.stderr)
+
+		for _, svc := range sharedServices {
+			// Ensure shared service is running
+			containerName, ip, err := shareMgr.EnsureSharedService(svc, project, composeFiles)
+			if err != nil {
+				return nil, fmt.Errorf("failed to ensure shared service %s: %w", svc, err)
+			}
+
+			// Register in state
+			if err := shareMgr.RegisterSharedService(svc, project, containerName, ip, composeFiles); err != nil {
+				return nil, fmt.Errorf("failed to register shared service %s: %w", svc, err)
+			}
+
+			// Connect to environment network
+			if err := shareMgr.ConnectSharedServiceToEnvironment(svc, project, name); err != nil {
+				return nil, fmt.Errorf("failed to connect shared service %s: %w", svc, err)
+			}
+
+			// Get IP for this specific network
+			ip, err = shareMgr.GetSharedServiceIP(svc, project, name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get shared service IP: %w", err)
+			}
+
+			// Add reference
+			if err := shareMgr.AddEnvironmentReference(svc, project, project, name); err != nil {
+				return nil, fmt.Errorf("failed to add environment reference: %w", err)
+			}
+
+			// Add to environment's service list
+			if env.Services == nil {
+				env.Services = make(map[string]*models.Service)
+			}
+			env.Services[svc] = &models.Service{
+				Name:      svc,
+				IP:        ip,
+				Container: containerName,
+			}
+
+			e.emit(env, EventDone, svc, "Shared service %s connected (IP: %s)", svc, ip)
+		}
+
+		// Store shared services list in environment
+		env.UsesSharedServices = sharedServices
+	}
+
+	// compose up leaves paused containers paused
+	if previousStatus == "paused" {
+		if err := e.compose(ctx, env, "unpause"); err != nil {
+			return nil, fmt.Errorf("failed to unpause environment: %w", err)
+		}
+	}
+
+	e.progress(env, "Starting containers...")
+	if err := provider.Up(ctx, env, runtime.UpOptions{
+		Build:    opts.Build,
+		Recreate: opts.Recreate,
+	}); err != nil {
+		return nil, err
+	}
+	env.LastActivity = time.Now().UTC()
+
+	if err := dns.UpdateDNS(env); err != nil {
+		e.warn(env, "failed to update DNS: %v", err)
+	}
+
+	if err := state.UpdateEnvironment(env); err != nil {
+		return nil, err
+	}
+
+	// post_up hooks expect services to be ready, not just started
+	if opts.Wait || len(hooks.ForEvent(projectConfig, hooks.PostUp)) > 0 {
+		if err := e.waitForServices(ctx, env, composeFiles, opts.WaitTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := e.runHooks(ctx, hooks.PostUp, env, workspace, "", projectConfig); err != nil {
+		return nil, err
+	}
+
+	e.done(env, "Environment %s is running", name)
+	return e.result(env), nil
+}
+
+// admit reserves room for env in the host budget, waiting for it to free
+// up when queue is set
+func (e *Engine) admit(ctx context.Context, env *models.Environment, queue bool, timeout time.Duration) error {
+	if budget, err := state.GetBudget(); err == nil && budget != nil && budget.MaxMemory != "" {
+		if unlimited := resources.Unlimited(env); len(unlimited) > 0 {
+			sort.Strings(unlimited)
+			e.warn(env, "%s have no memory limit and aren't counted against the host memory budget", strings.Join(unlimited, ", "))
+		}
+	}
+
+	if queue && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	queued := false
+	for {
+		err := state.AdmitEnvironment(env)
+		if err == nil || !queue || !errors.Is(err, state.ErrOverBudget) {
+			return err
+		}
+		if !queued {
+			e.progress(env, "Queued until the host budget has room (%v)", err)
+			queued = true
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for the host budget: %w", err)
+		case <-time.After(queuePollInterval):
+		}
+	}
+}
+
+// Down stops an environment, releasing its shared services
+func (e *Engine) Down(ctx context.Context, project, name string) (*Result, error) {
+	unlock, err := e.lock(ctx, project, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	env, err := state.GetEnvironment(project, name)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace := state.GetEnvStoragePath(project, name)
+	projectConfig, err := models.LoadEnvironmentConfig(env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+	if err := e.runHooks(ctx, hooks.PreDown, env, workspace, "", projectConfig); err != nil {
+		return nil, err
+	}
+
+	provider, err := e.providerFor(env)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.Ping(ctx); err != nil {
+		return nil, err
+	}
+
+	// Disconnect shared services before stopping environment
+	e.releaseSharedServices(ctx, env)
+	e.unpause(ctx, env)
+
+	if err := provider.Down(ctx, env); err != nil {
+		return nil, err
+	}
+
+	if err := state.UpdateEnvironment(env); err != nil {
+		return nil, err
+	}
+
+	e.done(env, "Environment %s stopped", name)
+	return &Result{Environment: env, Workspace: workspace}, nil
+}
+
+// releaseSharedServices disconnects an environment from the shared services
+// it uses and drops its references to them, stopping any left unused once
+// their grace period ends
+func (e *Engine) releaseSharedServices(ctx context.Context, env *models.Environment) {
+	if len(env.UsesSharedServices) == 0 {
+		return
+	}
+	e.progress(env, "Disconnecting shared services...")
+	shareMgr := share.NewManager(e.provider, ctx)
+	shareMgr.SetOutput(e.stdout, e.stderr)
+
+	for _, svc := range env.UsesSharedServices {
+		if err := shareMgr.DisconnectSharedServiceFromEnvironment(svc, env.Project, env.Name); err != nil {
+			e.warn(env, "failed to disconnect shared service %s: %v", svc, err)
+		}
+
+		if err := shareMgr.RemoveEnvironmentReference(svc, env.Project, env.Project, env.Name); err != nil {
+			e.warn(env, "failed to remove environment reference: %v", err)
+		}
+
+		// Check if service should be stopped (grace period handled internally)
+		if err := shareMgr.StopSharedServiceIfUnused(svc, env.Project); err != nil {
+			e.warn(env, "failed to stop shared service: %v", err)
+		}
+	}
+}
+
+// unpause unpauses a paused environment's containers so they can be stopped
+func (e *Engine) unpause(ctx context.Context, env *models.Environment) {
+	if env.Status != "paused" {
+		return
+	}
+	if err := e.compose(ctx, env, "unpause"); err != nil {
+		e.warn(env, "failed to unpause environment: %v", err)
+	}
+}
+
+// Destroy removes an environment's containers, DNS entries, shared service
+// references, state and (unless KeepWorkspace is set) workspace. The
+// returned environment has status "destroyed".
+func (e *Engine) Destroy(ctx context.Context, project, name string, opts DestroyOptions) (*Result, error) {
+	unlock, err := e.lock(ctx, project, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	env, err := state.GetEnvironment(project, name)
+	if err != nil {
+		return nil, err
+	}
+	return e.destroy(ctx, env, opts)
+}
+
+// destroy is Destroy for a caller holding env's operation lock
+func (e *Engine) destroy(ctx context.Context, env *models.Environment, opts DestroyOptions) (*Result, error) {
+	project, name := env.Project, env.Name
+	workspace := state.GetEnvStoragePath(project, name)
+	projectConfig, err := models.LoadEnvironmentConfig(env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+	if err := e.runHooks(ctx, hooks.PreDestroy, env, workspace, "", projectConfig); err != nil {
+		return nil, err
+	}
+
+	provider, err := e.providerFor(env)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.Ping(ctx); err != nil {
+		return nil, err
+	}
+	e.releaseSharedServices(ctx, env)
+	e.unpause(ctx, env)
+	if err := provider.Destroy(ctx, env); err != nil {
+		return nil, err
+	}
+
+	// post_destroy runs from the source once the workspace is gone
+	hookDir := workspace
+	if !opts.KeepWorkspace {
+		e.removeFromHost(ctx, env)
+		if err := os.RemoveAll(workspace); err != nil {
+			return nil, fmt.Errorf("failed to remove workspace: %w", err)
+		}
+		hookDir = env.Source
+		if _, err := os.Stat(hookDir); hookDir == "" || err != nil {
+			hookDir = os.TempDir()
+		}
+	} else if err := os.WriteFile(filepath.Join(workspace, reconcile.KeptMarker), nil, 0644); err != nil && !os.IsNotExist(err) {
+		// Without the marker prune would move the workspace away as an orphan
+		return nil, fmt.Errorf("failed to mark workspace as kept: %w", err)
+	}
+
+	if err := state.DeleteEnvironment(project, name); err != nil {
+		return nil, err
+	}
+	if st, err := state.LoadState(); err == nil {
+		if err := dns.UpdateDNSFromState(st); err != nil {
+			e.warn(env, "failed to remove DNS entries: %v", err)
+		}
+	}
+
+	e.done(env, "Environment %s destroyed from project %s", name, project)
+	if err := e.runHooks(ctx, hooks.PostDestroy, env, workspace, hookDir, projectConfig); err != nil {
+		return nil, err
+	}
+
+	env.Status = "destroyed"
+	return &Result{Environment: env, Workspace: workspace}, nil
+}
+
+// Status returns an environment as recorded in state
+func (e *Engine) Status(ctx context.Context, project, name string) (*Result, error) {
+	env, err := state.GetEnvironment(project, name)
+	if err != nil {
+		return nil, err
+	}
+	return e.result(env), nil
+}
+
+// waitForServices blocks until the env's services pass their readiness
+// checks, reporting each service's progress as its status changes
+func (e *Engine) waitForServices(ctx context.Context, env *models.Environment, composeFiles []string, timeout time.Duration) error {
+	services, err := compose.LoadServices(composeFiles)
+	if err != nil {
+		return err
+	}
+
+	var targets []ready.Target
+	for _, name := range sortedServiceNames(env.Services) {
+		svc := env.Services[name]
+		target := ready.Target{Service: name, Container: svc.Container, IP: svc.IP}
+		if meta, ok := services[name]; ok {
+			target.Labels = meta.Labels
+		}
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	if timeout == 0 {
+		timeout = ready.DefaultTimeout
+	}
+
+	provider, err := e.providerFor(env)
+	if err != nil {
+		return err
+	}
+	e.progress(env, "Waiting for services to be ready...")
+	return ready.Wait(ctx, provider, targets, ready.Options{
+		Timeout: timeout,
+		Progress: func(u ready.Update) {
+			if u.Ready {
+				e.emit(env, EventDone, u.Service, "%s %s (%s)", u.Service, u.Status, u.Elapsed.Round(100*time.Millisecond))
+				return
+			}
+			e.emit(env, EventProgress, u.Service, "%s: %s", u.Service, u.Status)
+		},
+	})
+}
+
+// runHooks runs the project's hooks for an event. Host hooks run in dir, or
+// in the workspace when dir is empty.
+func (e *Engine) runHooks(ctx context.Context, event string, env *models.Environment, workspace, dir string, cfg *models.ProjectConfig) error {
+	provider, err := e.providerFor(env)
+	if err != nil {
+		return err
+	}
+	return hooks.Run(ctx, cfg, event, hooks.Context{
+		Project:   env.Project,
+		Env:       env.Name,
+		DNSSuffix: dnsSuffix(cfg),
+		Workspace: workspace,
+		Dir:       dir,
+		Provider:  provider,
+		Stdout:    e.stdout,
+		Stderr:    e.stderr,
+	})
+}
+
+func resolveComposeFiles(workspace string, cfg *models.ProjectConfig) ([]string, error) {
+	project, err := loadProject(workspace, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return project.ComposeFiles, nil
+}
+
+// loadProject returns how a workspace's services run: the compose files its
+// config lists, or else those of the project format it holds
+func loadProject(workspace string, cfg *models.ProjectConfig) (*compose.Project, error) {
+	var composeFiles []string
+	if cfg != nil {
+		composeFiles = cfg.ComposeFiles
+	}
+	return compose.LoadProject(workspace, composeFiles)
+}
+
+// withHooks returns cfg with post_up hooks a project format brings run
+// ahead of the configured ones
+func withHooks(cfg *models.ProjectConfig, postUp []models.Hook) *models.ProjectConfig {
+	if len(postUp) == 0 {
+		return cfg
+	}
+	merged := models.ProjectConfig{}
+	if cfg != nil {
+		merged = *cfg
+	}
+	hooksConfig := models.HooksConfig{}
+	if merged.Hooks != nil {
+		hooksConfig = *merged.Hooks
+	}
+	hooksConfig.PostUp = append(append([]models.Hook{}, postUp...), hooksConfig.PostUp...)
+	merged.Hooks = &hooksConfig
+	return &merged
+}
+
+// selectProfile resolves the profile an environment runs, or returns nil
+// if it has none
+func selectProfile(env *models.Environment, projectConfig *models.ProjectConfig, services map[string]*compose.ServiceMeta) (*compose.ProfileSelection, error) {
+	if env.Profile == "" {
+		return nil, nil
+	}
+	profile, err := compose.SelectProfile(projectConfig, services, env.Profile)
+	if errors.Is(err, compose.ErrUnknownProfile) {
+		return nil, output.WithCode(output.CodeNotFound, err)
+	}
+	if err != nil {
+		return nil, output.WithCode(output.CodeInvalidConfig, err)
+	}
+	return profile, nil
+}
+
+// filterOut removes items from slice that are in the filter list
+func filterOut(slice []string, filter []string) []string {
+	result := []string{}
+	for _, item := range slice {
+		if !contains(filter, item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// contains reports whether slice holds value, ignoring surrounding space
+// in the slice's entries
+func contains(slice []string, value string) bool {
+	for _, item := range slice {
+		if strings.TrimSpace(item) == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/reconcile"
+	"github.com/sharedco/cilo/pkg/resources"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// ParseDuration parses a Go duration ("90m", "4h") or a whole number of
+// days ("7d")
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(strings.TrimSpace(s), "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q (use e.g. 4h or 7d)", s)
+	}
+	return d, nil
+}
+
+// PruneOptions selects the environments Prune removes. Expired
+// environments always match; OlderThan and Stopped add environments that
+// haven't expired, and Stopped and Project narrow what matches.
+type PruneOptions struct {
+	OlderThan time.Duration // Created at least this long ago
+	Stopped   bool          // Only environments that aren't running or paused
+	Project   string        // Only this project's environments; also skips orphan cleanup
+	DryRun    bool          // Report what would be removed without removing it
+}
+
+// PruneResult is what Prune removed, or would remove on a dry run
+type PruneResult struct {
+	Environments []*models.Environment
+	Orphans      []reconcile.OrphanedResource
+	Errors       []error
+}
+
+// Prune destroys matching environments, then removes what environments and
+// shared services no longer in state left behind (see
+// reconcile.FindOrphans). A failure to remove one thing is recorded in the
+// result and doesn't stop the rest.
+func (e *Engine) Prune(ctx context.Context, opts PruneOptions) (*PruneResult, error) {
+	envs, err := state.ListEnvironments()
+	if err != nil {
+		return nil, err
+	}
+	result := &PruneResult{Environments: pruneCandidates(envs, opts, time.Now())}
+
+	unreachable := map[string]error{}
+	if !opts.DryRun {
+		unreachable, err = e.pingHosts(ctx, result.Environments)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var destroyed []*models.Environment
+	for _, env := range result.Environments {
+		if opts.DryRun {
+			continue
+		}
+		if err := unreachable[env.Host]; err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("%s/%s: %w", env.Project, env.Name, err))
+			continue
+		}
+		ok, err := e.pruneEnvironment(ctx, env, opts)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("%s/%s: %w", env.Project, env.Name, err))
+			continue
+		}
+		if ok {
+			destroyed = append(destroyed, env)
+		}
+	}
+	if !opts.DryRun {
+		result.Environments = destroyed
+	}
+
+	if opts.Project != "" {
+		return result, nil
+	}
+
+	st, err := state.LoadState()
+	if err != nil {
+		return nil, err
+	}
+	orphans, err := reconcile.FindOrphans(ctx, st)
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Errorf("failed to find orphaned resources: %w", err))
+		return result, nil
+	}
+	if opts.DryRun {
+		result.Orphans = orphans
+		return result, nil
+	}
+	removed, errs := reconcile.RemoveOrphans(ctx, orphans)
+	result.Orphans = removed
+	result.Errors = append(result.Errors, errs...)
+	return result, nil
+}
+
+// pingHosts pings the daemon of each host envs are on, once per host. A
+// local daemon that doesn't answer is an error; remote hosts that don't
+// answer are returned by host ID, so only their environments are skipped.
+func (e *Engine) pingHosts(ctx context.Context, envs []*models.Environment) (map[string]error, error) {
+	pinged := map[string]bool{}
+	unreachable := map[string]error{}
+	for _, env := range envs {
+		if pinged[env.Host] {
+			continue
+		}
+		pinged[env.Host] = true
+		provider, err := e.providerFor(env)
+		if err == nil {
+			err = provider.Ping(ctx)
+		}
+		if err == nil {
+			continue
+		}
+		if !remote(env) {
+			return nil, err
+		}
+		unreachable[env.Host] = fmt.Errorf("host %s: %w", env.Host, err)
+	}
+	return unreachable, nil
+}
+
+// pruneEnvironment destroys env if it still matches opts once its operation
+// lock is held, so an environment started, extended or destroyed since it
+// was listed is left alone. It reports whether env was destroyed.
+func (e *Engine) pruneEnvironment(ctx context.Context, env *models.Environment, opts PruneOptions) (bool, error) {
+	unlock, err := e.lock(ctx, env.Project, env.Name)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	current, err := state.GetEnvironment(env.Project, env.Name)
+	if errors.Is(err, state.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(pruneCandidates([]*models.Environment{current}, opts, time.Now())) == 0 {
+		return false, nil
+	}
+	if _, err := e.destroy(ctx, current, DestroyOptions{}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// pruneCandidates returns the environments opts selects, oldest first
+func pruneCandidates(envs []*models.Environment, opts PruneOptions, now time.Time) []*models.Environment {
+	var matched []*models.Environment
+	for _, env := range envs {
+		if opts.Project != "" && env.Project != opts.Project {
+			continue
+		}
+		if opts.Stopped && resources.Running(env) {
+			continue
+		}
+		expired := !env.ExpiresAt.IsZero() && !now.Before(env.ExpiresAt)
+		old := opts.OlderThan > 0 && now.Sub(env.CreatedAt) >= opts.OlderThan
+		// --stopped on its own means every stopped environment
+		anyStopped := opts.Stopped && opts.OlderThan == 0
+		if expired || old || anyStopped {
+			matched = append(matched, env)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].CreatedAt.Before(matched[j].CreatedAt)
+	})
+	return matched
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/runtime"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+func TestParseDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"90m": 90 * time.Minute,
+		"4h":  4 * time.Hour,
+		"7d":  7 * 24 * time.Hour,
+	}
+	for in, want := range cases {
+		if got, err := ParseDuration(in); err != nil || got != want {
+			t.Fatalf("ParseDuration(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "d", "1.5d", "-1h", "soon"} {
+		if _, err := ParseDuration(bad); err == nil {
+			t.Fatalf("ParseDuration(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestPruneCandidates(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	envs := []*models.Environment{
+		{Project: "shop", Name: "expired", Status: "running", CreatedAt: now.Add(-2 * day), ExpiresAt: now.Add(-time.Hour)},
+		{Project: "shop", Name: "fresh", Status: "running", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{Project: "shop", Name: "old-stopped", Status: "stopped", CreatedAt: now.Add(-10 * day)},
+		{Project: "shop", Name: "old-running", Status: "running", CreatedAt: now.Add(-9 * day)},
+		{Project: "blog", Name: "new-stopped", Status: "suspended", CreatedAt: now.Add(-day)},
+	}
+	names := func(opts PruneOptions) string {
+		var out []string
+		for _, env := range pruneCandidates(envs, opts, now) {
+			out = append(out, env.Name)
+		}
+		return strings.Join(out, ",")
+	}
+
+	cases := []struct {
+		opts PruneOptions
+		want string
+	}{
+		{PruneOptions{}, "expired"},
+		{PruneOptions{OlderThan: 7 * day}, "old-stopped,old-running,expired"},
+		{PruneOptions{OlderThan: 7 * day, Stopped: true}, "old-stopped"},
+		{PruneOptions{Stopped: true}, "old-stopped,new-stopped"},
+		{PruneOptions{Stopped: true, Project: "blog"}, "new-stopped"},
+	}
+	for _, tc := range cases {
+		if got := names(tc.opts); got != tc.want {
+			t.Fatalf("pruneCandidates(%+v) = %s, want %s", tc.opts, got, tc.want)
+		}
+	}
+}
+
+func TestPruneRechecksCandidates(t *testing.T) {
+	setupState(t)
+	source := writeSource(t)
+	e := New(Options{Provider: &idleProvider{}})
+	ctx := context.Background()
+	result, err := e.Create(ctx, CreateOptions{Name: "dev", From: source})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// Listed as expired, but given more time before prune got to it
+	listed := *result.Environment
+	listed.ExpiresAt = time.Now().Add(-time.Hour)
+	destroyed, err := e.pruneEnvironment(ctx, &listed, PruneOptions{})
+	if err != nil || destroyed {
+		t.Fatalf("pruneEnvironment = %v, %v; want the extended environment kept", destroyed, err)
+	}
+	if _, err := state.GetEnvironment("myapp", "dev"); err != nil {
+		t.Fatalf("GetEnvironment: %v", err)
+	}
+
+	// Destroyed by someone else in the meantime
+	if err := state.DeleteEnvironment("myapp", "dev"); err != nil {
+		t.Fatalf("DeleteEnvironment: %v", err)
+	}
+	if destroyed, err := e.pruneEnvironment(ctx, &listed, PruneOptions{}); err != nil || destroyed {
+		t.Fatalf("pruneEnvironment of a removed environment = %v, %v", destroyed, err)
+	}
+}
+
+// downProvider is a daemon that doesn't answer
+type downProvider struct{ runtime.Provider }
+
+func (downProvider) Ping(ctx context.Context) error { return errors.New("daemon down") }
+
+func TestPrunePingsEachHost(t *testing.T) {
+	setupState(t)
+	if err := state.AddHost(&models.Host{ID: "build1", DockerHost: "ssh://me@build1"}); err != nil {
+		t.Fatalf("AddHost: %v", err)
+	}
+	e := New(Options{Provider: &idleProvider{}, HostProvider: func(host *models.Host) runtime.Provider {
+		return downProvider{}
+	}})
+	envs := []*models.Environment{{Project: "shop", Name: "here"}, {Project: "shop", Name: "there", Host: "build1"}}
+
+	// Only the environments on the host that doesn't answer are skipped
+	unreachable, err := e.pingHosts(context.Background(), envs)
+	if err != nil {
+		t.Fatalf("pingHosts: %v", err)
+	}
+	if len(unreachable) != 1 || unreachable["build1"] == nil {
+		t.Fatalf("unreachable = %v, want build1", unreachable)
+	}
+
+	e = New(Options{Provider: downProvider{}})
+	if _, err := e.pingHosts(context.Background(), envs[:1]); err == nil {
+		t.Fatalf("pingHosts succeeded with the local daemon down")
+	}
+}
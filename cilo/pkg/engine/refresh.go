@@ -0,0 +1,343 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/compose"
+	envpkg "github.com/sharedco/cilo/pkg/env"
+	"github.com/sharedco/cilo/pkg/git"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// Changes Refresh makes to a workspace file
+const (
+	RefreshAdded   = "added"
+	RefreshUpdated = "updated"
+)
+
+// RefreshOptions configures Refresh
+type RefreshOptions struct {
+	DryRun bool // Only report what would change
+	Force  bool // Overwrite files edited in the workspace since they were copied
+}
+
+// RefreshedFile is a workspace file Refresh replaces with its source's
+type RefreshedFile struct {
+	Path   string `json:"path" yaml:"path"` // Relative to the source and the workspace
+	Change string `json:"change" yaml:"change"`
+	// Diff is a unified diff from the workspace's file to the source's
+	Diff    string `json:"diff,omitempty" yaml:"diff,omitempty"`
+	EnvFile bool   `json:"env_file,omitempty" yaml:"env_file,omitempty"`
+	// Modified is set when the workspace's file was edited since it was
+	// copied from the source. Refresh only overwrites it with Force.
+	Modified bool `json:"modified,omitempty" yaml:"modified,omitempty"`
+}
+
+// Refresh copies an environment's config files, the files that define its
+// services and its env files from its source into its workspace, so edits
+// made to them since create apply on its next up. Env files with a render
+// rule are rendered as they are copied. Other files are left to
+// 'cilo sync'. If any file was edited in the workspace since it was copied,
+// nothing is written and Refresh fails with a conflict, unless Force is set.
+func (e *Engine) Refresh(ctx context.Context, project, name string, opts RefreshOptions) ([]RefreshedFile, error) {
+	unlock, err := e.lock(ctx, project, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	env, err := state.GetEnvironment(project, name)
+	if err != nil {
+		return nil, err
+	}
+	if env.Source == "" {
+		return nil, fmt.Errorf("environment %q has no source directory to refresh from", name)
+	}
+	if _, err := os.Stat(env.Source); err != nil {
+		return nil, fmt.Errorf("source directory %s is not accessible: %w", env.Source, err)
+	}
+
+	files, renderCtx, err := refreshPlan(env)
+	if err != nil {
+		return nil, err
+	}
+	workspace := state.GetEnvStoragePath(project, name)
+	record, err := loadRefreshRecord(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	var refreshed []RefreshedFile
+	var writes []refreshWrite
+	var modified []string
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(env.Source, file.path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if file.render != nil {
+			data = []byte(envpkg.Render(string(data), *file.render, renderCtx))
+		}
+
+		target := filepath.Join(workspace, file.path)
+		current, err := os.ReadFile(target)
+		change := RefreshUpdated
+		if os.IsNotExist(err) {
+			change = RefreshAdded
+		} else if err != nil {
+			return nil, err
+		} else if bytes.Equal(current, data) {
+			record[file.path] = copiedHashes(data, file, renderCtx)
+			continue
+		}
+
+		result := RefreshedFile{Path: file.path, Change: change, EnvFile: file.env}
+		if change == RefreshUpdated && !slices.Contains(record[file.path], contentHash(current)) {
+			result.Modified = true
+			modified = append(modified, file.path)
+		}
+		if result.Diff, err = diffAgainst(target, data, file.path); err != nil {
+			e.warn(env, "failed to diff %s: %v", file.path, err)
+		}
+		refreshed = append(refreshed, result)
+		writes = append(writes, refreshWrite{file: file, data: data})
+	}
+	if opts.DryRun {
+		return refreshed, nil
+	}
+	if len(modified) > 0 && !opts.Force {
+		return refreshed, output.WithCode(output.CodeConflict, fmt.Errorf(
+			"%s changed in the workspace since it was copied; refresh with --force to overwrite", strings.Join(modified, ", ")))
+	}
+
+	for _, w := range writes {
+		target := filepath.Join(workspace, w.file.path)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		mode := os.FileMode(0644)
+		if info, err := os.Stat(filepath.Join(env.Source, w.file.path)); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if err := os.WriteFile(target, w.data, mode); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", w.file.path, err)
+		}
+		record[w.file.path] = copiedHashes(w.data, w.file, renderCtx)
+	}
+	if err := saveRefreshRecord(workspace, record); err != nil {
+		e.warn(env, "failed to record refreshed files: %v", err)
+	}
+
+	if len(refreshed) > 0 {
+		e.done(env, "Refreshed %d file(s) in %s/%s", len(refreshed), project, name)
+	}
+	return refreshed, nil
+}
+
+// refreshFile is a source file Refresh copies
+type refreshFile struct {
+	path   string
+	env    bool
+	render *models.EnvRenderRule
+}
+
+// refreshWrite is a file Refresh replaces, with its new content
+type refreshWrite struct {
+	file refreshFile
+	data []byte
+}
+
+// refreshPlan returns the files Refresh copies from an environment's
+// source, and how env files are rendered, per the config the environment
+// runs with once refreshed
+func refreshPlan(env *models.Environment) ([]refreshFile, envpkg.RenderContext, error) {
+	layered, err := models.LoadLayeredConfig(env.Source, env.Config)
+	if err != nil {
+		return nil, envpkg.RenderContext{}, fmt.Errorf("failed to load project config: %w", err)
+	}
+	var cfg *models.ProjectConfig
+	if layered != nil {
+		cfg = layered.Config
+	}
+	files, err := refreshFiles(env.Source, cfg)
+	if err != nil {
+		return nil, envpkg.RenderContext{}, err
+	}
+	return files, envpkg.RenderContext{Project: env.Project, Env: env.Name, DNSSuffix: dnsSuffix(cfg)}, nil
+}
+
+// refreshFiles lists the files Refresh copies from a source, relative to
+// it: the config layers kept with the project, the files defining its
+// services, and its env files
+func refreshFiles(source string, cfg *models.ProjectConfig) ([]refreshFile, error) {
+	seen := map[string]int{}
+	var files []refreshFile
+	add := func(path string, file refreshFile) {
+		if filepath.IsAbs(path) {
+			rel, err := filepath.Rel(source, path)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				return // Outside the source; the workspace uses it where it is
+			}
+			path = rel
+		}
+		file.path = filepath.Clean(path)
+		if i, ok := seen[file.path]; ok {
+			// An env file may also be a render rule's; keep the rule
+			if file.render != nil {
+				files[i].render = file.render
+			}
+			return
+		}
+		seen[file.path] = len(files)
+		files = append(files, file)
+	}
+
+	add(filepath.Join(".cilo", "config.yml"), refreshFile{})
+	add(filepath.Join(".cilo", "config.local.yml"), refreshFile{})
+
+	var composeFiles []string
+	if cfg != nil {
+		composeFiles = cfg.ComposeFiles
+	}
+	definitions, err := compose.DefinitionFiles(source, composeFiles)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range definitions {
+		add(path, refreshFile{})
+	}
+
+	// .env is read by compose itself
+	add(".env", refreshFile{env: true})
+	if cfg != nil {
+		for _, path := range cfg.EnvFiles {
+			add(path, refreshFile{env: true})
+		}
+		if cfg.Env != nil {
+			for i := range cfg.Env.Render {
+				if rule := &cfg.Env.Render[i]; rule.File != "" {
+					add(rule.File, refreshFile{env: true, render: rule})
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// refreshRecordFile is where a workspace records, for each file copied from
+// its source, the hashes of the content the file has until edited there
+var refreshRecordFile = filepath.Join(".cilo", "refresh.json")
+
+// recordCopies records the files Refresh copies as a new workspace has
+// them, so a later Refresh can tell them from files edited since
+func recordCopies(env *models.Environment, workspace string) error {
+	files, renderCtx, err := refreshPlan(env)
+	if err != nil {
+		return err
+	}
+	record := map[string][]string{}
+	for _, file := range files {
+		if data, err := os.ReadFile(filepath.Join(workspace, file.path)); err == nil {
+			record[file.path] = copiedHashes(data, file, renderCtx)
+		}
+	}
+	return saveRefreshRecord(workspace, record)
+}
+
+// copiedHashes returns the hashes a file copied with data has until it is
+// edited: as copied, and as up renders it when it has a render rule
+func copiedHashes(data []byte, file refreshFile, renderCtx envpkg.RenderContext) []string {
+	hashes := []string{contentHash(data)}
+	if file.render != nil {
+		if rendered := contentHash([]byte(envpkg.Render(string(data), *file.render, renderCtx))); rendered != hashes[0] {
+			hashes = append(hashes, rendered)
+		}
+	}
+	return hashes
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// loadRefreshRecord reads a workspace's refresh record. A workspace
+// without one records nothing, so every file that differs from its source
+// counts as edited.
+func loadRefreshRecord(workspace string) (map[string][]string, error) {
+	record := map[string][]string{}
+	data, err := os.ReadFile(filepath.Join(workspace, refreshRecordFile))
+	if os.IsNotExist(err) {
+		return record, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", refreshRecordFile, err)
+	}
+	return record, nil
+}
+
+func saveRefreshRecord(workspace string, record map[string][]string) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(workspace, refreshRecordFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// diffAgainst diffs a workspace file against the content that replaces it
+func diffAgainst(target string, data []byte, path string) (string, error) {
+	tmp, err := os.CreateTemp("", "cilo-refresh-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return git.DiffFiles(target, tmp.Name(), path)
+}
+
+// sourceConfigNewer reports whether an environment's source has changed
+// its .cilo/config.yml since the workspace's copy was made
+func sourceConfigNewer(env *models.Environment, workspace string) bool {
+	if env.Source == "" {
+		return false
+	}
+	file := filepath.Join(".cilo", "config.yml")
+	sourceInfo, err := os.Stat(filepath.Join(env.Source, file))
+	if err != nil {
+		return false
+	}
+	workspaceInfo, err := os.Stat(filepath.Join(workspace, file))
+	if err != nil || !sourceInfo.ModTime().After(workspaceInfo.ModTime()) {
+		return false
+	}
+	source, _ := os.ReadFile(filepath.Join(env.Source, file))
+	copied, _ := os.ReadFile(filepath.Join(workspace, file))
+	return !bytes.Equal(source, copied)
+}
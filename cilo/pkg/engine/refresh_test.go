@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/output"
+)
+
+func TestRefresh(t *testing.T) {
+	setupState(t)
+	source := writeSource(t)
+	files := map[string]string{
+		".cilo/config.yml": "project: myapp\nenv:\n  render:\n    - file: .env.local\n      tokens: true\n",
+		".env.local":       "URL=${CILO_BASE_URL}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(source, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	e := New(Options{})
+	ctx := context.Background()
+	result, err := e.Create(ctx, CreateOptions{Name: "dev", From: source})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	workspace := result.Workspace
+
+	// The render file differs from its template until rendered
+	refreshed, err := e.Refresh(ctx, "myapp", "dev", RefreshOptions{})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if len(refreshed) != 1 || refreshed[0].Path != ".env.local" || !refreshed[0].EnvFile || refreshed[0].Modified {
+		t.Fatalf("refreshed = %+v, want only the rendered .env.local, unedited", refreshed)
+	}
+	if !strings.Contains(refreshed[0].Diff, "+URL=http://myapp.dev.test") {
+		t.Fatalf("diff = %q, want the rendered line", refreshed[0].Diff)
+	}
+	if data, _ := os.ReadFile(filepath.Join(workspace, ".env.local")); string(data) != "URL=http://myapp.dev.test\n" {
+		t.Fatalf(".env.local = %q, want it rendered", data)
+	}
+
+	// Edits to the config and compose file are previewed, then copied
+	later := time.Now().Add(time.Minute)
+	config := filepath.Join(source, ".cilo", "config.yml")
+	if err := os.WriteFile(config, []byte(files[".cilo/config.yml"]+"ttl: 3d\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.Chtimes(config, later, later); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "docker-compose.yml"), []byte("services:\n  web:\n    image: caddy\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	env := result.Environment
+	if !sourceConfigNewer(env, workspace) {
+		t.Fatalf("expected the source config to be newer")
+	}
+
+	preview, err := e.Refresh(ctx, "myapp", "dev", RefreshOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if len(preview) != 2 || !strings.Contains(preview[0].Diff, "+ttl: 3d") || !strings.Contains(preview[1].Diff, "+    image: caddy") {
+		t.Fatalf("preview = %+v, want diffs of the config and compose file", preview)
+	}
+	if !strings.Contains(preview[0].Diff, "--- a/.cilo/config.yml") {
+		t.Fatalf("diff names the wrong file:\n%s", preview[0].Diff)
+	}
+	if data, _ := os.ReadFile(filepath.Join(workspace, "docker-compose.yml")); strings.Contains(string(data), "caddy") {
+		t.Fatalf("dry run changed the workspace")
+	}
+
+	if _, err := e.Refresh(ctx, "myapp", "dev", RefreshOptions{}); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if sourceConfigNewer(env, workspace) {
+		t.Fatalf("source config still newer after refresh")
+	}
+	if refreshed, _ := e.Refresh(ctx, "myapp", "dev", RefreshOptions{DryRun: true}); len(refreshed) != 0 {
+		t.Fatalf("refreshed = %+v after refresh, want nothing", refreshed)
+	}
+
+	// A file edited in the workspace is only overwritten with Force
+	edited := "URL=http://localhost:3000\n"
+	if err := os.WriteFile(filepath.Join(workspace, ".env.local"), []byte(edited), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(source, ".env.local"), []byte("URL=${CILO_BASE_URL}/v2\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	refreshed, err = e.Refresh(ctx, "myapp", "dev", RefreshOptions{})
+	if output.ErrorCode(err) != output.CodeConflict {
+		t.Fatalf("Refresh over an edited file = %v, want a conflict", err)
+	}
+	if len(refreshed) != 1 || !refreshed[0].Modified {
+		t.Fatalf("refreshed = %+v, want .env.local marked as edited", refreshed)
+	}
+	if data, _ := os.ReadFile(filepath.Join(workspace, ".env.local")); string(data) != edited {
+		t.Fatalf(".env.local = %q, want the edit kept", data)
+	}
+	if _, err := e.Refresh(ctx, "myapp", "dev", RefreshOptions{Force: true}); err != nil {
+		t.Fatalf("Refresh with Force: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(workspace, ".env.local")); string(data) != "URL=http://myapp.dev.test/v2\n" {
+		t.Fatalf(".env.local = %q, want it overwritten", data)
+	}
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	envpkg "github.com/sharedco/cilo/pkg/env"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// RunOptions configures Prepare and Run
+type RunOptions struct {
+	Env      string
+	Project  string // Defaults to the source's configured project, then its directory name
+	From     string // Source directory used to resolve the project and create the env; defaults to the current directory
+	NoCreate bool   // Fail instead of creating a missing environment
+	NoUp     bool   // Don't start the environment
+	NoWait   bool   // Don't wait for services to be ready
+
+	Command []string // Program and arguments; used by Run only
+	Stdin   io.Reader
+	Stdout  io.Writer // Defaults to the engine's stdout
+	Stderr  io.Writer // Defaults to the engine's stderr
+}
+
+// RunResult is the outcome of Run
+type RunResult struct {
+	*Result
+	ExitCode int
+}
+
+// Prepare makes an environment ready to run a command in: it creates the
+// environment if it is missing, resumes it if it was suspended for being
+// idle and starts it if it isn't running. It returns the environment and
+// the process environment (os.Environ plus the CILO_* variables) the
+// command should get.
+func (e *Engine) Prepare(ctx context.Context, opts RunOptions) (*Result, []string, error) {
+	name := state.NormalizeName(opts.Env)
+
+	from := opts.From
+	if from == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get current directory: %w", err)
+		}
+		from = cwd
+	}
+	from, _ = filepath.Abs(from)
+
+	// The project create would put the environment in
+	project, _, err := sourceProject(from, opts.Project)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env, err := state.GetEnvironment(project, name)
+	if errors.Is(err, state.ErrNotFound) {
+		if opts.NoCreate {
+			return nil, nil, output.WithCode(output.CodeNotFound, fmt.Errorf("environment %s/%s does not exist (use 'cilo create' first, or remove --no-create)", project, name))
+		}
+		e.emit(&models.Environment{Project: project, Name: name}, EventProgress, "", "Creating environment: %s/%s", project, name)
+		created, err := e.Create(ctx, CreateOptions{Name: name, Project: project, From: from})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create environment: %w", err)
+		}
+		env = created.Environment
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	// Running a command counts as use, and brings back a suspended env
+	result, err := e.Resume(ctx, project, name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resume environment: %w", err)
+	}
+	env = result.Environment
+	if !opts.NoUp && env.Status != "running" {
+		e.progress(env, "Starting environment: %s/%s", project, name)
+		result, err = e.Up(ctx, project, name, UpOptions{Wait: !opts.NoWait})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to start environment: %w", err)
+		}
+	}
+
+	projectConfig, err := models.LoadEnvironmentConfig(env)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+
+	environ := os.Environ()
+	vars := envpkg.Variables(envpkg.RenderContext{Project: project, Env: name, DNSSuffix: dnsSuffix(projectConfig)}, result.Workspace)
+	for key, value := range vars {
+		environ = append(environ, fmt.Sprintf("%s=%s", key, value))
+	}
+	return result, environ, nil
+}
+
+// Run prepares an environment and runs a command in its workspace. A
+// command that runs and exits non-zero is not an error; its status is in
+// the result.
+func (e *Engine) Run(ctx context.Context, opts RunOptions) (*RunResult, error) {
+	if len(opts.Command) == 0 {
+		return nil, fmt.Errorf("no command given")
+	}
+	cmdPath, err := exec.LookPath(opts.Command[0])
+	if err != nil {
+		return nil, fmt.Errorf("command not found: %s", opts.Command[0])
+	}
+
+	result, environ, err := e.Prepare(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, cmdPath, opts.Command[1:]...)
+	cmd.Dir = result.Workspace
+	cmd.Env = environ
+	cmd.Stdin = opts.Stdin
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	if cmd.Stdout == nil {
+		cmd.Stdout = e.stdout
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = e.stderr
+	}
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &RunResult{Result: result, ExitCode: exitErr.ExitCode()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &RunResult{Result: result}, nil
+}
@@ -0,0 +1,267 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/output"
+	"github.com/sharedco/cilo/pkg/runtime"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// DefaultSnapshot is the snapshot Snapshot and Reset use when none is named
+const DefaultSnapshot = "default"
+
+// Snapshot is a saved copy of an environment's volumes
+type Snapshot struct {
+	Name    string
+	Path    string
+	Volumes []string
+}
+
+// Restart restarts one of an environment's own services. Shared services
+// belong to every environment using them and can't be restarted from one.
+func (e *Engine) Restart(ctx context.Context, project, name, service string) (*Result, error) {
+	unlock, err := e.lock(ctx, project, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	env, err := state.GetEnvironment(project, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := env.Services[service]; !ok {
+		return nil, output.WithCode(output.CodeNotFound, fmt.Errorf("service %q not found in environment %s", service, name))
+	}
+	if contains(env.UsesSharedServices, service) {
+		return nil, fmt.Errorf("service %q is shared with other environments and can't be restarted from %s", service, name)
+	}
+	provider, err := e.providerFor(env)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.Ping(ctx); err != nil {
+		return nil, err
+	}
+
+	e.emit(env, EventProgress, service, "Restarting %s...", service)
+	if err := e.compose(ctx, env, "restart", service); err != nil {
+		return nil, fmt.Errorf("failed to restart %s: %w", service, err)
+	}
+	e.emit(env, EventDone, service, "%s restarted", service)
+	return e.result(env), nil
+}
+
+// Snapshot saves the contents of an environment's volumes under a name,
+// replacing any earlier snapshot of that name. Running services are stopped
+// while their volumes are copied so databases are captured consistently.
+func (e *Engine) Snapshot(ctx context.Context, project, name, snapshot string) (*Snapshot, error) {
+	unlock, err := e.lock(ctx, project, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	env, err := state.GetEnvironment(project, name)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err = snapshotName(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := e.providerFor(env)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.Ping(ctx); err != nil {
+		return nil, err
+	}
+
+	volumes, err := provider.ListVolumes(ctx, env.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(volumes) == 0 {
+		return nil, fmt.Errorf("environment %s has no volumes to snapshot", name)
+	}
+
+	dir := snapshotDir(env, snapshot)
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	err = e.whileStopped(ctx, env, func() error {
+		for _, volume := range volumes {
+			e.progress(env, "Saving volume %s...", volume)
+			if err := writeVolume(ctx, provider, volume, filepath.Join(tmp, volume+".tar")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return nil, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	e.done(env, "Snapshot %q saved (%d volumes)", snapshot, len(volumes))
+	return &Snapshot{Name: snapshot, Path: dir, Volumes: volumes}, nil
+}
+
+// Reset restores an environment's volumes from a snapshot, stopping its
+// services while the volumes are replaced
+func (e *Engine) Reset(ctx context.Context, project, name, snapshot string) (*Snapshot, error) {
+	unlock, err := e.lock(ctx, project, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	env, err := state.GetEnvironment(project, name)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err = snapshotName(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := snapshotDir(env, snapshot)
+	volumes, err := snapshotVolumes(dir)
+	if os.IsNotExist(err) {
+		return nil, output.WithCode(output.CodeNotFound, fmt.Errorf("snapshot %q does not exist for environment %s", snapshot, name))
+	}
+	if err != nil {
+		return nil, err
+	}
+	provider, err := e.providerFor(env)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.Ping(ctx); err != nil {
+		return nil, err
+	}
+
+	err = e.whileStopped(ctx, env, func() error {
+		for _, volume := range volumes {
+			e.progress(env, "Restoring volume %s...", volume)
+			if err := readVolume(ctx, provider, volume, filepath.Join(dir, volume+".tar")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e.done(env, "Environment %s reset to snapshot %q", name, snapshot)
+	return &Snapshot{Name: snapshot, Path: dir, Volumes: volumes}, nil
+}
+
+// whileStopped runs fn with the environment's containers stopped, starting
+// them again afterwards if they were running
+func (e *Engine) whileStopped(ctx context.Context, env *models.Environment, fn func() error) error {
+	if env.Status != "running" {
+		return fn()
+	}
+
+	e.progress(env, "Stopping services...")
+	if err := e.compose(ctx, env, "stop"); err != nil {
+		return fmt.Errorf("failed to stop services: %w", err)
+	}
+	fnErr := fn()
+
+	e.progress(env, "Starting services...")
+	if err := e.compose(ctx, env, "start"); err != nil && fnErr == nil {
+		return fmt.Errorf("failed to start services: %w", err)
+	}
+	return fnErr
+}
+
+func (e *Engine) compose(ctx context.Context, env *models.Environment, args ...string) error {
+	provider, err := e.providerFor(env)
+	if err != nil {
+		return err
+	}
+	return provider.Compose(ctx, env.Project, env.Name, runtime.ComposeOptions{
+		Args:   args,
+		Stdin:  strings.NewReader(""),
+		Stdout: e.stdout,
+		Stderr: e.stderr,
+	})
+}
+
+var validSnapshotName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+func snapshotName(name string) (string, error) {
+	name = state.NormalizeName(name)
+	if name == "" {
+		return DefaultSnapshot, nil
+	}
+	if !validSnapshotName.MatchString(name) {
+		return "", fmt.Errorf("invalid snapshot name %q", name)
+	}
+	return name, nil
+}
+
+func snapshotDir(env *models.Environment, snapshot string) string {
+	return filepath.Join(state.GetEnvStoragePath(env.Project, env.Name), ".cilo", "snapshots", snapshot)
+}
+
+// snapshotVolumes lists the volumes saved in a snapshot directory
+func snapshotVolumes(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var volumes []string
+	for _, entry := range entries {
+		if volume, ok := strings.CutSuffix(entry.Name(), ".tar"); ok && !entry.IsDir() {
+			volumes = append(volumes, volume)
+		}
+	}
+	sort.Strings(volumes)
+	return volumes, nil
+}
+
+func writeVolume(ctx context.Context, provider runtime.Provider, volume, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := provider.ExportVolume(ctx, volume, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readVolume(ctx context.Context, provider runtime.Provider, volume, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return provider.ImportVolume(ctx, volume, f)
+}
@@ -0,0 +1,306 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/compose"
+	"github.com/sharedco/cilo/pkg/hooks"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/resources"
+	"gopkg.in/yaml.v3"
+)
+
+// hostnamePattern matches a DNS name of one or more labels
+var hostnamePattern = regexp.MustCompile(`(?i)^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// ciloLabels are the compose labels cilo reads
+var ciloLabels = []string{"cilo.ingress", "cilo.share", "cilo.hostnames"}
+
+// ValidateConfig checks the project config in dir, with the layers over
+// it: the schema, then that the compose and env files it refers to exist,
+// the services it names are defined, hostnames are valid DNS names,
+// durations and limits parse, and the compose files' cilo.* labels are ones
+// cilo reads. It returns every problem found, each in the file that set the
+// value; an error means the config couldn't be checked at all.
+func ValidateConfig(dir string) ([]models.ConfigProblem, error) {
+	layered, err := models.LoadLayeredConfig(dir, "")
+	var configErr *models.ConfigError
+	if errors.As(err, &configErr) {
+		return configErr.Problems, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if layered == nil {
+		return nil, fmt.Errorf("no %s in %s", filepath.Join(".cilo", "config.yml"), dir)
+	}
+
+	v := &configValidator{dir: dir, layered: layered}
+	v.checkNames(layered.Config)
+	v.checkFiles(layered.Config)
+	v.checkSettings(layered.Config)
+	v.checkServices(layered.Config)
+	// The config's own problems first, layer by layer in file order
+	order := map[string]int{}
+	for i, layer := range layered.Layers {
+		order[layer.File] = i + 1
+	}
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		if order[a.File] != order[b.File] {
+			return order[a.File] != 0 && (order[b.File] == 0 || order[a.File] < order[b.File])
+		}
+		return a.Line < b.Line
+	})
+	return v.problems, nil
+}
+
+type configValidator struct {
+	dir       string
+	generated string // Where compose files generated from dir's project format go
+	layered   *models.LayeredConfig
+	problems  []models.ConfigProblem
+}
+
+// fail records a problem at a field of the config, given as map keys and
+// list indexes, in the layer that set it
+func (v *configValidator) fail(path []interface{}, format string, args ...interface{}) {
+	file := filepath.Join(".cilo", "config.yml")
+	var keys []string
+	for _, step := range path {
+		key, ok := step.(string)
+		if !ok {
+			break
+		}
+		keys = append(keys, key)
+	}
+	if source, ok := v.layered.Source(strings.Join(keys, ".")); ok {
+		file = source.File
+	}
+	v.problems = append(v.problems, models.ConfigProblem{
+		File:    file,
+		Line:    lineOf(v.layered.Node, path...),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *configValidator) checkNames(cfg *models.ProjectConfig) {
+	if cfg.Project == "" {
+		v.fail(nil, "project is not set")
+	} else if !hostnamePattern.MatchString(cfg.Project) {
+		v.fail(at("project"), "project %q isn't a valid DNS name", cfg.Project)
+	}
+	if cfg.DNSSuffix != "" && !hostnamePattern.MatchString(strings.TrimPrefix(cfg.DNSSuffix, ".")) {
+		v.fail(at("dns_suffix"), "dns_suffix %q isn't a valid DNS name", cfg.DNSSuffix)
+	}
+	for i, hostname := range cfg.Hostnames {
+		if !hostnamePattern.MatchString(hostname) {
+			v.fail(at("hostnames", i), "hostname %q isn't a valid DNS name", hostname)
+		}
+	}
+}
+
+func (v *configValidator) checkFiles(cfg *models.ProjectConfig) {
+	for i, f := range cfg.ComposeFiles {
+		if !v.exists(f) {
+			v.fail(at("compose_files", i), "compose file %s not found", f)
+		}
+	}
+	for i, f := range cfg.EnvFiles {
+		if !v.exists(f) {
+			v.fail(at("env_files", i), "env file %s not found", f)
+		}
+	}
+	if cfg.Env != nil {
+		for i, rule := range cfg.Env.Render {
+			if !v.exists(rule.File) {
+				v.fail(at("env", "render", i, "file"), "env file %s not found; nothing will be rendered", rule.File)
+			}
+		}
+	}
+}
+
+func (v *configValidator) checkSettings(cfg *models.ProjectConfig) {
+	if cfg.TTL != "" {
+		if _, err := ParseDuration(cfg.TTL); err != nil {
+			v.fail(at("ttl"), "ttl: %v", err)
+		}
+	}
+	if cfg.Idle != nil && cfg.Idle.Timeout != "" {
+		if _, err := time.ParseDuration(cfg.Idle.Timeout); err != nil {
+			v.fail(at("idle", "timeout"), "idle.timeout: invalid duration %q (use e.g. 30m or 2h)", cfg.Idle.Timeout)
+		}
+	}
+	if err := resources.Validate(cfg.Resources); err != nil {
+		v.fail(at("resources"), "resources: %v", err)
+	}
+}
+
+// checkServices checks the services the config names against those the
+// compose files define, and the cilo labels on them
+func (v *configValidator) checkServices(cfg *models.ProjectConfig) {
+	// Validating reads the source checkout, so a devcontainer.json or
+	// Procfile is turned into a compose file elsewhere
+	generated, err := os.MkdirTemp("", "cilo-validate-")
+	if err != nil {
+		v.fail(at("compose_files"), "%v", err)
+		return
+	}
+	defer os.RemoveAll(generated)
+	v.generated = generated
+
+	project, err := compose.ReadProject(v.dir, cfg.ComposeFiles, generated)
+	if err != nil {
+		v.fail(at("compose_files"), "%v", err)
+		return
+	}
+	services, err := compose.LoadServices(project.ComposeFiles)
+	if err != nil {
+		v.fail(at("compose_files"), "%v", err)
+		return
+	}
+	if len(services) == 0 {
+		v.fail(at("compose_files"), "compose files have no services")
+		return
+	}
+
+	if name := cfg.DefaultIngressService; name != "" && services[name] == nil {
+		v.fail(at("default_ingress_service"), "default_ingress_service %q is not a service", name)
+	}
+	for i, name := range cfg.SharedServices {
+		if services[name] == nil {
+			v.fail(at("shared_services", i), "shared service %q is not a service", name)
+		}
+	}
+	profiles := make([]string, 0, len(cfg.Profiles))
+	for profile := range cfg.Profiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+	for _, profile := range profiles {
+		p := cfg.Profiles[profile]
+		if p == nil {
+			continue
+		}
+		lists := []struct {
+			key   string
+			names []string
+		}{
+			{"services", p.Services},
+			{"shared", p.Shared},
+			{"isolated", p.Isolated},
+		}
+		for _, list := range lists {
+			for i, name := range list.names {
+				if services[name] == nil {
+					v.fail(at("profiles", profile, list.key, i), "profile %s: %q is not a service", profile, name)
+				}
+			}
+		}
+	}
+	if cfg.Hooks != nil {
+		for _, event := range hooks.Events {
+			for i, hook := range hooks.ForEvent(cfg, event) {
+				if hook.Service != "" && services[hook.Service] == nil {
+					v.fail(at("hooks", event, i, "service"), "%s hook runs in %q, which is not a service", event, hook.Service)
+				}
+			}
+		}
+	}
+
+	for _, name := range compose.SortedServiceNames(services) {
+		labels := services[name].Labels
+		keys := make([]string, 0, len(labels))
+		for key := range labels {
+			if strings.HasPrefix(key, "cilo.") {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := labels[key]
+			switch key {
+			case "cilo.ingress", "cilo.share":
+				if value != "true" && value != "false" {
+					v.composeFail(project, "service %s: label %s is %q; use \"true\" or \"false\"", name, key, value)
+				}
+			case "cilo.hostnames":
+				for _, hostname := range strings.Split(value, ",") {
+					if hostname = strings.TrimSpace(hostname); !hostnamePattern.MatchString(hostname) {
+						v.composeFail(project, "service %s: hostname %q in cilo.hostnames isn't a valid DNS name", name, hostname)
+					}
+				}
+			default:
+				v.composeFail(project, "service %s: unknown label %s (cilo reads %s)", name, key, strings.Join(ciloLabels, ", "))
+			}
+		}
+	}
+}
+
+// composeFail records a problem with the project's compose files
+func (v *configValidator) composeFail(project *compose.Project, format string, args ...interface{}) {
+	files := make([]string, len(project.ComposeFiles))
+	for i, f := range project.ComposeFiles {
+		files[i] = f
+		if v.generated != "" && filepath.Dir(f) == v.generated {
+			files[i] = filepath.Join(".cilo", filepath.Base(f))
+		} else if rel, err := filepath.Rel(v.dir, f); err == nil {
+			files[i] = rel
+		}
+	}
+	v.problems = append(v.problems, models.ConfigProblem{
+		File:    strings.Join(files, ", "),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *configValidator) exists(path string) bool {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(v.dir, path)
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func at(path ...interface{}) []interface{} { return path }
+
+// lineOf returns the line of the value at a path of map keys and list
+// indexes in a YAML document, or of the deepest part of the path present
+func lineOf(doc *yaml.Node, path ...interface{}) int {
+	node := doc
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := node.Line
+	for _, step := range path {
+		var next *yaml.Node
+		switch step := step.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == step {
+						next = node.Content[i+1]
+						line = node.Content[i].Line
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && step < len(node.Content) {
+				next = node.Content[step]
+			}
+		}
+		if next == nil {
+			return line
+		}
+		node = next
+		line = node.Line
+	}
+	return line
+}
@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"compose.yaml": `services:
+  web:
+    image: nginx
+    labels:
+      cilo.ingres: "true"
+      cilo.hostnames: "docs, bad_name"
+  db:
+    image: postgres
+`,
+		".cilo/config.yml": `project: shop
+compose_files:
+  - compose.yaml
+env_files:
+  - .env.compose
+hostnames:
+  - admin
+  - -nope
+shared_services:
+  - db
+  - cache
+default_ingress_service: web
+ttl: 3x
+hooks:
+  post_up:
+    - run: migrate
+      service: api
+profiles:
+  web:
+    services: [web, worker]
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	problems, err := ValidateConfig(dir)
+	if err != nil {
+		t.Fatalf("ValidateConfig: %v", err)
+	}
+	want := []string{
+		`.cilo/config.yml:5: env file .env.compose not found`,
+		`.cilo/config.yml:8: hostname "-nope" isn't a valid DNS name`,
+		`.cilo/config.yml:11: shared service "cache" is not a service`,
+		`.cilo/config.yml:13: ttl: invalid duration "3x" (use e.g. 4h or 7d)`,
+		`.cilo/config.yml:17: post_up hook runs in "api", which is not a service`,
+		`.cilo/config.yml:20: profile web: "worker" is not a service`,
+		`compose.yaml: service web: hostname "bad_name" in cilo.hostnames isn't a valid DNS name`,
+		`compose.yaml: service web: unknown label cilo.ingres (cilo reads cilo.ingress, cilo.share, cilo.hostnames)`,
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("problems = %v, want %d", problems, len(want))
+	}
+	for i, problem := range problems {
+		if problem.String() != want[i] {
+			t.Errorf("problem %d = %s, want %s", i, problem, want[i])
+		}
+	}
+
+	// Schema problems are reported on their own
+	if err := os.WriteFile(filepath.Join(dir, ".cilo", "config.yml"), []byte("project: shop\ncompose_file: [compose.yaml]\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	problems, err = ValidateConfig(dir)
+	if err != nil {
+		t.Fatalf("ValidateConfig: %v", err)
+	}
+	if len(problems) != 1 || problems[0].Line != 2 {
+		t.Fatalf("problems = %v, want the unknown field on line 2", problems)
+	}
+}
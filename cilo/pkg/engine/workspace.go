@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/filesync"
+	"github.com/sharedco/cilo/pkg/filesystem"
+	"github.com/sharedco/cilo/pkg/git"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// CopyOptions controls which files CopyProject copies
+type CopyOptions struct {
+	Include       string
+	CopyDotDirs   []string
+	IgnoreDotDirs []string
+}
+
+// CopyProject copies a source tree into a workspace, skipping dot
+// directories per opts
+func CopyProject(src, dst string, opts CopyOptions) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && strings.HasPrefix(info.Name(), ".") {
+			if filesystem.SkipDotDir(info.Name(), opts.CopyDotDirs, opts.IgnoreDotDirs) {
+				return filepath.SkipDir
+			}
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		dstPath := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(dstPath, info.Mode())
+		}
+
+		if opts.Include != "" {
+			matched, _ := filepath.Match(opts.Include, info.Name())
+			if !matched {
+				return nil
+			}
+		}
+
+		return filesystem.CopyFile(path, dstPath)
+	})
+}
+
+// SyncRules derives sync ignore rules from the project config. Env files
+// cilo renders hold per-environment values, so they never sync.
+func SyncRules(cfg *models.ProjectConfig) filesync.Rules {
+	if cfg == nil {
+		return filesync.Rules{}
+	}
+	rules := filesync.Rules{
+		CopyDotDirs:   cfg.CopyDotDirs,
+		IgnoreDotDirs: cfg.IgnoreDotDirs,
+	}
+	if cfg.Env != nil {
+		for _, rule := range cfg.Env.Render {
+			if rule.File != "" && !filepath.IsAbs(rule.File) {
+				rules.Exclude = append(rules.Exclude, filepath.ToSlash(filepath.Clean(rule.File)))
+			}
+		}
+	}
+	return rules
+}
+
+// initFileSync records the freshly copied workspace as the sync baseline
+func initFileSync(source, workspace string, cfg *models.ProjectConfig) error {
+	return filesync.Init(source, workspace, SyncRules(cfg))
+}
+
+// recordSourceRepos stores the source repos' HEAD commits on the environment
+// so diffs can later be taken against the point the env was created from
+func recordSourceRepos(env *models.Environment) error {
+	if env.Source == "" {
+		return nil
+	}
+	snapshots, err := git.Snapshot(env.Source)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return nil
+	}
+	env.SourceRepos = snapshots
+	return state.UpdateEnvironment(env)
+}
+
+// writeEnvMeta writes .cilo/meta.json so the workspace describes itself
+func writeEnvMeta(env *models.Environment, ciloDir string) error {
+	data, err := json.MarshalIndent(models.WorkspaceMeta{
+		Name:        env.Name,
+		Project:     env.Project,
+		CreatedAt:   env.CreatedAt,
+		Source:      env.Source,
+		Subnet:      env.Subnet,
+		Host:        env.Host,
+		SourceRepos: env.SourceRepos,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(ciloDir, "meta.json"), append(data, '\n'), 0644)
+}
@@ -2,6 +2,7 @@ package env
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"os/exec"
@@ -18,13 +19,14 @@ type RenderContext struct {
 }
 
 // ApplyConfig executes env handling for a workspace: optional pruning + render rules.
-// It never logs secret values; only paths and counts.
-func ApplyConfig(workspace string, config *models.ProjectConfig, ctx RenderContext) error {
+// It never logs secret values; only paths and counts. The init hook's output
+// goes to stdout and stderr.
+func ApplyConfig(workspace string, config *models.ProjectConfig, ctx RenderContext, stdout, stderr io.Writer) error {
 	if config == nil || config.Env == nil {
 		return nil
 	}
 
-	if err := RunInitHook(workspace, config.Env.InitHook); err != nil {
+	if err := RunInitHook(workspace, config.Env.InitHook, stdout, stderr); err != nil {
 		return err
 	}
 
@@ -54,7 +56,7 @@ func ApplyConfig(workspace string, config *models.ProjectConfig, ctx RenderConte
 
 // RunInitHook executes a shell command in the workspace if configured.
 // This can be used to pull secrets or generate env files before rendering.
-func RunInitHook(workspace string, hook string) error {
+func RunInitHook(workspace string, hook string, stdout, stderr io.Writer) error {
 	hook = strings.TrimSpace(hook)
 	if hook == "" {
 		return nil
@@ -62,8 +64,8 @@ func RunInitHook(workspace string, hook string) error {
 
 	cmd := exec.Command("bash", "-lc", hook)
 	cmd.Dir = workspace
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 	return cmd.Run()
 }
 
@@ -160,7 +162,11 @@ func renderFile(path string, rule models.EnvRenderRule, ctx RenderContext) error
 		return fmt.Errorf("failed to read env file %s: %w", path, err)
 	}
 
-	content := string(data)
+	return os.WriteFile(path, []byte(Render(string(data), rule, ctx)), 0644)
+}
+
+// Render applies a render rule to the content of an env file
+func Render(content string, rule models.EnvRenderRule, ctx RenderContext) string {
 	for _, rep := range rule.Replace {
 		from := expandTokens(rep.From, ctx)
 		to := expandTokens(rep.To, ctx)
@@ -173,8 +179,23 @@ func renderFile(path string, rule models.EnvRenderRule, ctx RenderContext) error
 	if rule.Tokens {
 		content = expandTokens(content, ctx)
 	}
+	return content
+}
 
-	return os.WriteFile(path, []byte(content), 0644)
+// Variables returns the CILO_* variables describing an environment, as
+// exported to `cilo run` commands and lifecycle hooks
+func Variables(ctx RenderContext, workspace string) map[string]string {
+	dnsSuffix := ctx.DNSSuffix
+	if dnsSuffix == "" {
+		dnsSuffix = ".test"
+	}
+	return map[string]string{
+		"CILO_ENV":        ctx.Env,
+		"CILO_PROJECT":    ctx.Project,
+		"CILO_WORKSPACE":  workspace,
+		"CILO_BASE_URL":   fmt.Sprintf("http://%s.%s%s", ctx.Project, ctx.Env, dnsSuffix),
+		"CILO_DNS_SUFFIX": dnsSuffix,
+	}
 }
 
 func expandTokens(value string, ctx RenderContext) string {
@@ -1,6 +1,7 @@
 package env
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -36,7 +37,7 @@ func TestApplyConfig_RenderTokensAndReplace(t *testing.T) {
 	}
 
 	ctx := RenderContext{Project: "proj", Env: "dev", DNSSuffix: ".test"}
-	if err := ApplyConfig(workspace, config, ctx); err != nil {
+	if err := ApplyConfig(workspace, config, ctx, io.Discard, io.Discard); err != nil {
 		t.Fatalf("ApplyConfig: %v", err)
 	}
 
@@ -71,7 +72,7 @@ func TestApplyConfig_CopyModeAllowlist(t *testing.T) {
 		},
 	}
 
-	if err := ApplyConfig(workspace, config, RenderContext{}); err != nil {
+	if err := ApplyConfig(workspace, config, RenderContext{}, io.Discard, io.Discard); err != nil {
 		t.Fatalf("ApplyConfig: %v", err)
 	}
 	if _, err := os.Stat(keep); err != nil {
@@ -95,7 +96,7 @@ func TestApplyConfig_CopyModeNone(t *testing.T) {
 		},
 	}
 
-	if err := ApplyConfig(workspace, config, RenderContext{}); err != nil {
+	if err := ApplyConfig(workspace, config, RenderContext{}, io.Discard, io.Discard); err != nil {
 		t.Fatalf("ApplyConfig: %v", err)
 	}
 	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
@@ -0,0 +1,191 @@
+package filesync
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/filesystem"
+)
+
+// Rules decides which paths take part in a sync.
+// .gitignore files are honoured at every level, dot directories follow the
+// project's copy_dot_dirs/ignore_dot_dirs, and .git/.cilo are never synced.
+type Rules struct {
+	CopyDotDirs   []string
+	IgnoreDotDirs []string
+	// Exclude lists paths, slash-separated and relative to the roots, that
+	// differ per environment by design, such as rendered env files
+	Exclude []string
+}
+
+type ignorePattern struct {
+	base    string // Directory of the .gitignore, slash-separated and relative to root
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// matcher evaluates ignore rules for one tree
+type matcher struct {
+	rules    Rules
+	root     string
+	patterns []ignorePattern
+	loaded   map[string]bool
+}
+
+func newMatcher(root string, rules Rules) *matcher {
+	return &matcher{rules: rules, root: root, loaded: map[string]bool{}}
+}
+
+// ignored reports whether rel (slash-separated, relative to root) is excluded
+func (m *matcher) ignored(rel string, isDir bool) bool {
+	name := path.Base(rel)
+	if isDir && (name == ".git" || name == ".cilo") {
+		return true
+	}
+	if isDir && strings.HasPrefix(name, ".") && filesystem.SkipDotDir(name, m.rules.CopyDotDirs, m.rules.IgnoreDotDirs) {
+		return true
+	}
+	for _, excluded := range m.rules.Exclude {
+		if rel == excluded {
+			return true
+		}
+	}
+
+	m.loadFor(path.Dir(rel))
+
+	ignored := false
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if p.base != "." {
+			if !strings.HasPrefix(rel, p.base+"/") {
+				continue
+			}
+			sub = strings.TrimPrefix(rel, p.base+"/")
+		}
+		if p.re.MatchString(sub) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// loadFor reads .gitignore files from root down to dir, once each
+func (m *matcher) loadFor(dir string) {
+	parts := []string{"."}
+	if dir != "." {
+		segments := strings.Split(dir, "/")
+		for i := range segments {
+			parts = append(parts, strings.Join(segments[:i+1], "/"))
+		}
+	}
+	for _, d := range parts {
+		if m.loaded[d] {
+			continue
+		}
+		m.loaded[d] = true
+		m.patterns = append(m.patterns, readGitignore(filepath.Join(m.root, filepath.FromSlash(d), ".gitignore"), d)...)
+	}
+}
+
+func readGitignore(file, base string) []ignorePattern {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if p, ok := parseIgnoreLine(scanner.Text(), base); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// parseIgnoreLine converts one .gitignore line into a pattern
+func parseIgnoreLine(line, base string) (ignorePattern, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+
+	p := ignorePattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, "\\")
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return ignorePattern{}, false
+	}
+
+	// A slash anywhere but the end anchors the pattern to its .gitignore
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := globToRegexp(line)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "(^|/)" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return ignorePattern{}, false
+	}
+	p.re = re
+	return p, true
+}
+
+// globToRegexp translates gitignore glob syntax, including **, to a regexp
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					sb.WriteString("(.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,103 @@
+package filesync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// JournalFile is the workspace-relative path of the sync journal
+const JournalFile = ".cilo/sync-journal.json"
+
+const journalVersion = 1
+
+// FileStamp identifies a file version cheaply, without hashing it
+type FileStamp struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+func stampOf(info os.FileInfo) FileStamp {
+	return FileStamp{Size: info.Size(), ModTime: info.ModTime().UTC()}
+}
+
+// Entry records a file as of the last sync, when both sides were identical
+type Entry struct {
+	Hash      string    `json:"hash"`
+	Source    FileStamp `json:"source"`
+	Workspace FileStamp `json:"workspace"`
+	SyncedAt  time.Time `json:"synced_at"`
+}
+
+// Conflict is a file changed on both sides since the last sync.
+// An empty hash means the file was deleted on that side.
+type Conflict struct {
+	Path          string    `json:"path"`
+	SourceHash    string    `json:"source_hash,omitempty"`
+	WorkspaceHash string    `json:"workspace_hash,omitempty"`
+	DetectedAt    time.Time `json:"detected_at"`
+}
+
+// Journal is the per-workspace record of what was last synced
+type Journal struct {
+	Version   int                  `json:"version"`
+	LastSync  time.Time            `json:"last_sync,omitempty"`
+	Files     map[string]*Entry    `json:"files"`
+	Conflicts map[string]*Conflict `json:"conflicts,omitempty"`
+}
+
+func newJournal() *Journal {
+	return &Journal{
+		Version:   journalVersion,
+		Files:     make(map[string]*Entry),
+		Conflicts: make(map[string]*Conflict),
+	}
+}
+
+// LoadJournal reads a workspace's journal, returning an empty one if none exists
+func LoadJournal(workspace string) (*Journal, error) {
+	data, err := os.ReadFile(filepath.Join(workspace, JournalFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return newJournal(), nil
+		}
+		return nil, fmt.Errorf("failed to read sync journal: %w", err)
+	}
+
+	journal := newJournal()
+	if err := json.Unmarshal(data, journal); err != nil {
+		return nil, fmt.Errorf("failed to parse sync journal: %w", err)
+	}
+	if journal.Files == nil {
+		journal.Files = make(map[string]*Entry)
+	}
+	if journal.Conflicts == nil {
+		journal.Conflicts = make(map[string]*Conflict)
+	}
+	return journal, nil
+}
+
+// save writes the journal atomically using temp file + rename
+func (j *Journal) save(workspace string) error {
+	path := filepath.Join(workspace, JournalFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create .cilo directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write sync journal: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename sync journal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+package filesync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/sharedco/cilo/pkg/filesystem"
+)
+
+// Sync directions
+const (
+	DirectionPull = "pull" // Source -> workspace
+	DirectionPush = "push" // Workspace -> source
+	DirectionBoth = "both"
+)
+
+// Conflict resolution preferences
+const (
+	PreferSource    = "source"
+	PreferWorkspace = "workspace"
+)
+
+// Options controls a sync pass
+type Options struct {
+	Direction string // pull, push or both (default both)
+	Prefer    string // Resolve conflicts in favour of this side; empty leaves them alone
+	DryRun    bool   // Report what would change without touching files or the journal
+	Rules     Rules
+}
+
+func (o Options) allows(op string) bool {
+	switch o.Direction {
+	case "", DirectionBoth:
+		return true
+	default:
+		return o.Direction == op
+	}
+}
+
+// ValidateDirection checks a user-supplied direction
+func ValidateDirection(direction string) error {
+	switch direction {
+	case DirectionPull, DirectionPush, DirectionBoth:
+		return nil
+	default:
+		return fmt.Errorf("invalid direction %q (must be pull, push or both)", direction)
+	}
+}
+
+// Result describes what a sync pass did. Paths are slash-separated and
+// relative to the roots; deletions are listed under the direction they flowed.
+type Result struct {
+	Pulled    []string
+	Pushed    []string
+	Conflicts []Conflict
+	Pending   []string // Changes held back by the direction filter
+}
+
+// Changed reports whether the pass copied or deleted anything
+func (r *Result) Changed() bool {
+	return len(r.Pulled) > 0 || len(r.Pushed) > 0
+}
+
+// Init records the current state of both trees as the sync baseline.
+// Files that are identical on both sides are journalled; anything else is
+// left for the first sync to sort out.
+func Init(source, workspace string, rules Rules) error {
+	unlock, err := lockJournal(workspace)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	sourceFiles, err := listFiles(source, rules)
+	if err != nil {
+		return err
+	}
+	workspaceFiles, err := listFiles(workspace, rules)
+	if err != nil {
+		return err
+	}
+
+	journal := newJournal()
+	now := time.Now().UTC()
+	for rel, sInfo := range sourceFiles {
+		wInfo, ok := workspaceFiles[rel]
+		if !ok || sInfo.Size() != wInfo.Size() {
+			continue
+		}
+		sHash, err := hashFile(filepath.Join(source, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		wHash, err := hashFile(filepath.Join(workspace, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		if sHash == wHash {
+			journal.Files[rel] = &Entry{Hash: sHash, Source: stampOf(sInfo), Workspace: stampOf(wInfo), SyncedAt: now}
+		}
+	}
+	journal.LastSync = now
+	return journal.save(workspace)
+}
+
+// Sync runs one pass between source and workspace. A file changed on one
+// side since the last sync is copied (or deleted) on the other; a file
+// changed on both sides is recorded as a conflict and left untouched.
+func Sync(source, workspace string, opts Options) (*Result, error) {
+	unlock, err := lockJournal(workspace)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	journal, err := LoadJournal(workspace)
+	if err != nil {
+		return nil, err
+	}
+	sourceFiles, err := listFiles(source, opts.Rules)
+	if err != nil {
+		return nil, err
+	}
+	workspaceFiles, err := listFiles(workspace, opts.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[string]bool{}
+	for rel := range sourceFiles {
+		paths[rel] = true
+	}
+	for rel := range workspaceFiles {
+		paths[rel] = true
+	}
+	// Journalled files deleted on both sides still need their entry dropped
+	for rel := range journal.Files {
+		paths[rel] = true
+	}
+	var sorted []string
+	for rel := range paths {
+		sorted = append(sorted, rel)
+	}
+	sort.Strings(sorted)
+
+	result := &Result{}
+	now := time.Now().UTC()
+	for _, rel := range sorted {
+		entry := journal.Files[rel]
+		sPath := filepath.Join(source, filepath.FromSlash(rel))
+		wPath := filepath.Join(workspace, filepath.FromSlash(rel))
+
+		sHash, err := currentHash(sPath, sourceFiles[rel], entry, true)
+		if err != nil {
+			return nil, err
+		}
+		wHash, err := currentHash(wPath, workspaceFiles[rel], entry, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if sHash == wHash {
+			if opts.DryRun {
+				continue
+			}
+			delete(journal.Conflicts, rel)
+			if sHash == "" {
+				delete(journal.Files, rel)
+			} else if entry == nil || entry.Hash != sHash || entry.Source != stampOf(sourceFiles[rel]) || entry.Workspace != stampOf(workspaceFiles[rel]) {
+				journal.Files[rel] = &Entry{Hash: sHash, Source: stampOf(sourceFiles[rel]), Workspace: stampOf(workspaceFiles[rel]), SyncedAt: now}
+			}
+			continue
+		}
+
+		op := decide(entry, sHash, wHash)
+		if op == "conflict" {
+			switch opts.Prefer {
+			case PreferSource:
+				op = DirectionPull
+			case PreferWorkspace:
+				op = DirectionPush
+			}
+		}
+
+		if op == "conflict" {
+			conflict := Conflict{Path: rel, SourceHash: sHash, WorkspaceHash: wHash, DetectedAt: now}
+			if existing, ok := journal.Conflicts[rel]; ok && existing.SourceHash == sHash && existing.WorkspaceHash == wHash {
+				conflict.DetectedAt = existing.DetectedAt
+			}
+			result.Conflicts = append(result.Conflicts, conflict)
+			if !opts.DryRun {
+				journal.Conflicts[rel] = &conflict
+			}
+			continue
+		}
+
+		if !opts.allows(op) {
+			result.Pending = append(result.Pending, rel)
+			continue
+		}
+
+		from, to, hash := sPath, wPath, sHash
+		if op == DirectionPush {
+			from, to, hash = wPath, sPath, wHash
+		}
+		if op == DirectionPull {
+			result.Pulled = append(result.Pulled, rel)
+		} else {
+			result.Pushed = append(result.Pushed, rel)
+		}
+		if opts.DryRun {
+			continue
+		}
+
+		if err := apply(from, to, hash == ""); err != nil {
+			return nil, fmt.Errorf("failed to sync %s: %w", rel, err)
+		}
+		delete(journal.Conflicts, rel)
+		if hash == "" {
+			delete(journal.Files, rel)
+			continue
+		}
+		sInfo, err := os.Stat(sPath)
+		if err != nil {
+			return nil, err
+		}
+		wInfo, err := os.Stat(wPath)
+		if err != nil {
+			return nil, err
+		}
+		journal.Files[rel] = &Entry{Hash: hash, Source: stampOf(sInfo), Workspace: stampOf(wInfo), SyncedAt: now}
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+	journal.LastSync = now
+	if err := journal.save(workspace); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// decide picks the operation for a file whose sides differ
+func decide(entry *Entry, sHash, wHash string) string {
+	if entry == nil {
+		// Never synced: a file that exists on one side only is new there
+		switch {
+		case sHash == "":
+			return DirectionPush
+		case wHash == "":
+			return DirectionPull
+		default:
+			return "conflict"
+		}
+	}
+
+	sourceChanged := sHash != entry.Hash
+	workspaceChanged := wHash != entry.Hash
+	switch {
+	case sourceChanged && !workspaceChanged:
+		return DirectionPull
+	case workspaceChanged && !sourceChanged:
+		return DirectionPush
+	default:
+		return "conflict"
+	}
+}
+
+// currentHash returns the content hash of a file, reusing the journalled
+// hash when size and mtime are unchanged. Missing files hash to "".
+func currentHash(path string, info os.FileInfo, entry *Entry, source bool) (string, error) {
+	if info == nil {
+		return "", nil
+	}
+	if entry != nil {
+		stamp := entry.Workspace
+		if source {
+			stamp = entry.Source
+		}
+		if stamp == stampOf(info) {
+			return entry.Hash, nil
+		}
+	}
+	return hashFile(path)
+}
+
+// apply copies from over to (preserving permissions), or deletes to
+func apply(from, to string, remove bool) error {
+	if remove {
+		if err := os.Remove(to); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	info, err := os.Stat(from)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := filesystem.CopyFile(from, to); err != nil {
+		return err
+	}
+	return os.Chmod(to, info.Mode().Perm())
+}
+
+// listFiles returns the regular files under root that pass the ignore rules,
+// keyed by slash-separated relative path
+func listFiles(root string, rules Rules) (map[string]os.FileInfo, error) {
+	m := newMatcher(root, rules)
+	files := map[string]os.FileInfo{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if m.ignored(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[rel] = info
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", root, err)
+	}
+	return files, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lockJournal serializes sync passes on a workspace, e.g. a watcher and a
+// one-off `cilo sync`
+func lockJournal(workspace string) (func(), error) {
+	dir := filepath.Join(workspace, ".cilo")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create .cilo directory: %w", err)
+	}
+	lock := flock.New(filepath.Join(dir, "sync.lock"))
+	if err := lock.Lock(); err != nil {
+		return nil, fmt.Errorf("failed to acquire sync lock: %w", err)
+	}
+	return func() { lock.Unlock() }, nil
+}
@@ -0,0 +1,196 @@
+package filesync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+// setupTrees creates identical source and workspace trees with a baseline
+func setupTrees(t *testing.T, files map[string]string) (source, workspace string) {
+	t.Helper()
+	source = t.TempDir()
+	workspace = t.TempDir()
+	for rel, content := range files {
+		writeFile(t, filepath.Join(source, rel), content)
+		writeFile(t, filepath.Join(workspace, rel), content)
+	}
+	if err := Init(source, workspace, Rules{}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return source, workspace
+}
+
+// touch bumps a file's mtime so the journal stamp no longer matches
+func touch(t *testing.T, path string) {
+	t.Helper()
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+}
+
+func TestSync_BothDirections(t *testing.T) {
+	source, workspace := setupTrees(t, map[string]string{
+		"a.txt":     "a\n",
+		"b.txt":     "b\n",
+		"gone.txt":  "gone\n",
+		"src/c.txt": "c\n",
+	})
+
+	writeFile(t, filepath.Join(source, "a.txt"), "a from host\n")
+	touch(t, filepath.Join(source, "a.txt"))
+	writeFile(t, filepath.Join(workspace, "src/c.txt"), "c from agent\n")
+	touch(t, filepath.Join(workspace, "src/c.txt"))
+	writeFile(t, filepath.Join(workspace, "new.txt"), "new\n")
+	if err := os.Remove(filepath.Join(source, "gone.txt")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	result, err := Sync(source, workspace, Options{Direction: DirectionBoth})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(result.Pulled) != 2 || len(result.Pushed) != 2 || len(result.Conflicts) != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	if got := readFile(t, filepath.Join(workspace, "a.txt")); got != "a from host\n" {
+		t.Fatalf("expected host edit pulled, got %q", got)
+	}
+	if got := readFile(t, filepath.Join(source, "src/c.txt")); got != "c from agent\n" {
+		t.Fatalf("expected workspace edit pushed, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(source, "new.txt")); err != nil {
+		t.Fatalf("expected new workspace file pushed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "gone.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected deletion pulled, got %v", err)
+	}
+
+	again, err := Sync(source, workspace, Options{})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if again.Changed() {
+		t.Fatalf("expected second pass to be a no-op, got %+v", again)
+	}
+}
+
+func TestSync_ConflictLeftUntouched(t *testing.T) {
+	source, workspace := setupTrees(t, map[string]string{"shared.txt": "base\n"})
+
+	writeFile(t, filepath.Join(source, "shared.txt"), "host edit\n")
+	writeFile(t, filepath.Join(workspace, "shared.txt"), "agent edit\n")
+
+	result, err := Sync(source, workspace, Options{})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(result.Conflicts) != 1 || result.Conflicts[0].Path != "shared.txt" {
+		t.Fatalf("expected conflict on shared.txt, got %+v", result)
+	}
+	if got := readFile(t, filepath.Join(source, "shared.txt")); got != "host edit\n" {
+		t.Fatalf("source modified despite conflict: %q", got)
+	}
+
+	journal, err := LoadJournal(workspace)
+	if err != nil {
+		t.Fatalf("LoadJournal: %v", err)
+	}
+	if _, ok := journal.Conflicts["shared.txt"]; !ok {
+		t.Fatalf("expected conflict recorded in journal")
+	}
+
+	resolved, err := Sync(source, workspace, Options{Prefer: PreferWorkspace})
+	if err != nil {
+		t.Fatalf("Sync --prefer: %v", err)
+	}
+	if len(resolved.Pushed) != 1 || len(resolved.Conflicts) != 0 {
+		t.Fatalf("expected conflict resolved by push, got %+v", resolved)
+	}
+	if got := readFile(t, filepath.Join(source, "shared.txt")); got != "agent edit\n" {
+		t.Fatalf("expected workspace version in source, got %q", got)
+	}
+
+	journal, err = LoadJournal(workspace)
+	if err != nil {
+		t.Fatalf("LoadJournal: %v", err)
+	}
+	if len(journal.Conflicts) != 0 {
+		t.Fatalf("expected conflict cleared, got %v", journal.Conflicts)
+	}
+}
+
+func TestSync_DirectionAndIgnores(t *testing.T) {
+	source, workspace := setupTrees(t, map[string]string{".gitignore": "*.log\nbuild/\n!keep.log\n"})
+
+	writeFile(t, filepath.Join(workspace, "debug.log"), "x\n")
+	writeFile(t, filepath.Join(workspace, "keep.log"), "x\n")
+	writeFile(t, filepath.Join(workspace, "build/out.bin"), "x\n")
+	writeFile(t, filepath.Join(workspace, ".venv/lib.py"), "x\n")
+	writeFile(t, filepath.Join(workspace, "main.go"), "package main\n")
+
+	pulled, err := Sync(source, workspace, Options{Direction: DirectionPull})
+	if err != nil {
+		t.Fatalf("Sync pull: %v", err)
+	}
+	if pulled.Changed() || len(pulled.Pending) != 2 {
+		t.Fatalf("expected workspace changes held back, got %+v", pulled)
+	}
+
+	pushed, err := Sync(source, workspace, Options{Direction: DirectionPush})
+	if err != nil {
+		t.Fatalf("Sync push: %v", err)
+	}
+	if len(pushed.Pushed) != 2 || pushed.Pushed[0] != "keep.log" || pushed.Pushed[1] != "main.go" {
+		t.Fatalf("unexpected pushed files: %v", pushed.Pushed)
+	}
+}
+
+func TestSync_ExcludesRenderedAndCiloFiles(t *testing.T) {
+	source, workspace := setupTrees(t, map[string]string{"config/.env": "API_URL=http://localhost:3000\n"})
+
+	// Rendering rewrites the env file in the workspace only
+	writeFile(t, filepath.Join(workspace, "config/.env"), "API_URL=http://api.myapp.feature.test\n")
+	touch(t, filepath.Join(workspace, "config/.env"))
+	writeFile(t, filepath.Join(workspace, ".cilo/override.yml"), "services: {}\n")
+
+	result, err := Sync(source, workspace, Options{
+		Direction: DirectionBoth,
+		Prefer:    PreferWorkspace,
+		Rules:     Rules{Exclude: []string{"config/.env"}},
+	})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if result.Changed() || len(result.Conflicts) != 0 {
+		t.Fatalf("expected nothing synced, got %+v", result)
+	}
+	if got := readFile(t, filepath.Join(source, "config/.env")); got != "API_URL=http://localhost:3000\n" {
+		t.Fatalf("rendered env file pushed to source: %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(source, ".cilo")); !os.IsNotExist(err) {
+		t.Fatalf("expected .cilo not pushed, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package filesync
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// debounce is how long the watcher waits for activity to settle before
+// running a pass, so editors saving several files trigger one sync
+const debounce = 300 * time.Millisecond
+
+// Watch runs a sync pass, then another each time either tree changes,
+// until ctx is cancelled. onPass is called after every pass.
+func Watch(ctx context.Context, source, workspace string, opts Options, onPass func(*Result, error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to start file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	roots := []string{source, workspace}
+	for _, root := range roots {
+		if err := watchTree(watcher, root, root, opts.Rules); err != nil {
+			return err
+		}
+	}
+
+	onPass(Sync(source, workspace, opts))
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					for _, root := range roots {
+						if strings.HasPrefix(event.Name, root+string(filepath.Separator)) {
+							watchTree(watcher, root, event.Name, opts.Rules)
+							break
+						}
+					}
+				}
+			}
+			timer.Reset(debounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			onPass(nil, fmt.Errorf("file watcher: %w", err))
+
+		case <-timer.C:
+			onPass(Sync(source, workspace, opts))
+		}
+	}
+}
+
+// watchTree adds dir and every non-ignored directory below it to the watcher
+func watchTree(watcher *fsnotify.Watcher, root, dir string, rules Rules) error {
+	m := newMatcher(root, rules)
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Directories can vanish between the event and the walk
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && m.ignored(filepath.ToSlash(rel), true) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		return nil
+	})
+}
@@ -35,6 +35,33 @@ func CopyFile(src, dst string) error {
 	return err
 }
 
+// SkipDotDir reports whether a dot directory should be left out of a
+// workspace. .cilo, .git and .devcontainer (which can define the project's
+// services) are always kept, ignoreDotDirs wins over copyDotDirs, and with
+// no copyDotDirs every other dot directory is skipped.
+func SkipDotDir(name string, copyDotDirs, ignoreDotDirs []string) bool {
+	if name == ".cilo" || name == ".git" || name == ".devcontainer" {
+		return false
+	}
+	for _, ignore := range ignoreDotDirs {
+		if ignore == name {
+			return true
+		}
+	}
+
+	if len(copyDotDirs) == 0 {
+		return true
+	}
+
+	for _, allowed := range copyDotDirs {
+		if allowed == name {
+			return false
+		}
+	}
+
+	return true
+}
+
 func tryReflink(src, dst *os.File) bool {
 	switch runtime.GOOS {
 	case "linux":
@@ -0,0 +1,379 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DiffOptions controls what Diff and DiffTree compare and how they print it
+type DiffOptions struct {
+	Stat     bool     // Print a diffstat instead of a patch
+	NameOnly bool     // Print only the names of changed files
+	Base     string   // Commit to compare against; defaults to the host's HEAD
+	Paths    []string // Limit the diff to these paths (relative to the workspace root)
+}
+
+func (o DiffOptions) formatArgs() []string {
+	switch {
+	case o.NameOnly:
+		return []string{"--name-only"}
+	case o.Stat:
+		return []string{"--stat"}
+	default:
+		return nil
+	}
+}
+
+// Diff compares a base commit with the environment repo's working tree,
+// including uncommitted and untracked changes. The base defaults to the
+// host repo's HEAD. The env repo's real index is never modified.
+func Diff(hostRoot, envRoot string, repo Repo, opts DiffOptions) (string, error) {
+	envPath, _ := filepath.Abs(filepath.Join(envRoot, repo.Path))
+
+	pathspecs, ok := repoPathspecs(repo, opts.Paths)
+	if !ok {
+		return "", nil
+	}
+
+	base := opts.Base
+	if base == "" {
+		hostPath, _ := filepath.Abs(filepath.Join(hostRoot, repo.Path))
+		if _, err := os.Stat(filepath.Join(hostPath, ".git")); err != nil {
+			return "", fmt.Errorf("host repo not found at %s", hostPath)
+		}
+		// FETCH_HEAD is the only ref this writes in the env repo
+		if _, err := run(envPath, "fetch", "--no-tags", hostPath, "HEAD"); err != nil {
+			return "", fmt.Errorf("failed to fetch from host: %w", err)
+		}
+		base = "FETCH_HEAD"
+	}
+
+	// Stage the whole working tree into a throwaway index so untracked
+	// files show up without touching what the agent has staged
+	indexPath, err := run(envPath, "rev-parse", "--path-format=absolute", "--git-path", "index")
+	if err != nil {
+		return "", fmt.Errorf("failed to locate index: %w", err)
+	}
+	tmpIndex, err := os.CreateTemp("", "cilo-diff-index-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp index: %w", err)
+	}
+	tmpIndex.Close()
+	defer os.Remove(tmpIndex.Name())
+
+	if data, err := os.ReadFile(indexPath); err == nil {
+		if err := os.WriteFile(tmpIndex.Name(), data, 0644); err != nil {
+			return "", fmt.Errorf("failed to copy index: %w", err)
+		}
+	} else {
+		os.Remove(tmpIndex.Name())
+	}
+
+	indexEnv := []string{"GIT_INDEX_FILE=" + tmpIndex.Name()}
+	addArgs := append([]string{"add", "-A", "--", "."}, excludeGenerated(repo)...)
+	if _, err := runRaw(envPath, indexEnv, addArgs...); err != nil {
+		return "", fmt.Errorf("failed to snapshot working tree: %w", err)
+	}
+
+	args := append([]string{"diff", "--cached", "--no-color"}, opts.formatArgs()...)
+	args = append(args, base, "--")
+	if len(pathspecs) == 0 {
+		args = append(args, ".")
+	}
+	args = append(args, pathspecs...)
+	args = append(args, excludeGenerated(repo)...)
+	output, err := runRaw(envPath, indexEnv, args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate diff: %w", err)
+	}
+
+	return strings.TrimRight(output, "\n"), nil
+}
+
+// repoPathspecs converts workspace-relative path filters into pathspecs for
+// a repo. It returns false when filters were given but none touch the repo.
+func repoPathspecs(repo Repo, paths []string) ([]string, bool) {
+	if len(paths) == 0 {
+		return nil, true
+	}
+
+	var specs []string
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		if repo.Path == "." {
+			specs = append(specs, p)
+			continue
+		}
+		if p == "." || p == repo.Path || strings.HasPrefix(repo.Path, p+string(filepath.Separator)) {
+			return nil, true
+		}
+		if rel, err := filepath.Rel(repo.Path, p); err == nil && !strings.HasPrefix(rel, "..") {
+			specs = append(specs, rel)
+		}
+	}
+	return specs, len(specs) > 0
+}
+
+// DiffTree compares files that are not part of any git repo between the
+// source directory and the workspace. Repos, .git and .cilo directories are
+// skipped, as are dot directories that were never copied into the workspace
+// and files matched by .gitignore, as the repo diff skips them.
+func DiffTree(sourceRoot, workspaceRoot string, opts DiffOptions) (string, error) {
+	sourceFiles, err := listPlainFiles(sourceRoot, workspaceRoot)
+	if err != nil {
+		return "", err
+	}
+	workspaceFiles, err := listPlainFiles(workspaceRoot, "")
+	if err != nil {
+		return "", err
+	}
+
+	changed := map[string]bool{}
+	for rel := range workspaceFiles {
+		if !sourceFiles[rel] {
+			changed[rel] = true
+			continue
+		}
+		same, err := sameContent(filepath.Join(sourceRoot, rel), filepath.Join(workspaceRoot, rel))
+		if err != nil {
+			return "", err
+		}
+		if !same {
+			changed[rel] = true
+		}
+	}
+	for rel := range sourceFiles {
+		if !workspaceFiles[rel] {
+			changed[rel] = true
+		}
+	}
+
+	var names []string
+	for rel := range changed {
+		if matchesPaths(rel, opts.Paths) {
+			names = append(names, rel)
+		}
+	}
+	sort.Strings(names)
+
+	if opts.NameOnly {
+		return strings.Join(names, "\n"), nil
+	}
+
+	var out strings.Builder
+	for _, rel := range names {
+		src := filepath.Join(sourceRoot, rel)
+		dst := filepath.Join(workspaceRoot, rel)
+		if !sourceFiles[rel] {
+			src = os.DevNull
+		}
+		if !workspaceFiles[rel] {
+			dst = os.DevNull
+		}
+
+		if opts.Stat {
+			numstat, err := diffNoIndex(src, dst, "--numstat")
+			if err != nil {
+				return "", fmt.Errorf("failed to diff %s: %w", rel, err)
+			}
+			added, deleted := "0", "0"
+			if fields := strings.Fields(numstat); len(fields) >= 2 {
+				added, deleted = fields[0], fields[1]
+			}
+			fmt.Fprintf(&out, " %s | +%s -%s\n", rel, added, deleted)
+			continue
+		}
+
+		diff, err := diffNoIndex(src, dst)
+		if err != nil {
+			return "", fmt.Errorf("failed to diff %s: %w", rel, err)
+		}
+		// git prints the absolute paths it was given; show them relative instead
+		diff = strings.ReplaceAll(diff, sourceRoot+string(filepath.Separator), "")
+		diff = strings.ReplaceAll(diff, workspaceRoot+string(filepath.Separator), "")
+		out.WriteString(diff)
+	}
+
+	if opts.Stat && len(names) > 0 {
+		fmt.Fprintf(&out, " %d file(s) changed\n", len(names))
+	}
+
+	return strings.TrimRight(out.String(), "\n"), nil
+}
+
+// listPlainFiles returns files under root that are not inside a git repo and
+// not ignored by .gitignore. When mirror is set, dot directories missing from
+// mirror are skipped.
+func listPlainFiles(root, mirror string) (map[string]bool, error) {
+	files := map[string]bool{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if rel == "." {
+				if isRepoRoot(path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			name := d.Name()
+			if name == ".git" || name == ".cilo" || isRepoRoot(path) {
+				return filepath.SkipDir
+			}
+			if mirror != "" && strings.HasPrefix(name, ".") {
+				if _, err := os.Stat(filepath.Join(mirror, rel)); err != nil {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		if d.Type().IsRegular() {
+			files[rel] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := dropIgnored(root, files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// dropIgnored removes the files .gitignore patterns under root match. Root
+// is no repo, so git checks them against a throwaway one.
+func dropIgnored(root string, files map[string]bool) error {
+	if len(files) == 0 {
+		return nil
+	}
+	gitDir, err := os.MkdirTemp("", "cilo-ignore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp repo: %w", err)
+	}
+	defer os.RemoveAll(gitDir)
+	if _, err := run(gitDir, "init", "-q", "--bare"); err != nil {
+		return err
+	}
+
+	var input strings.Builder
+	for rel := range files {
+		input.WriteString(filepath.ToSlash(rel))
+		input.WriteByte(0)
+	}
+	cmd := exec.Command("git", "--git-dir="+gitDir, "--work-tree="+root, "check-ignore", "--no-index", "--stdin", "-z")
+	cmd.Dir = root
+	cmd.Stdin = strings.NewReader(input.String())
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	// check-ignore exits 1 when nothing is ignored
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return fmt.Errorf("failed to check ignored files: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	for _, rel := range strings.Split(stdout.String(), "\x00") {
+		if rel != "" {
+			delete(files, filepath.FromSlash(rel))
+		}
+	}
+	return nil
+}
+
+func isRepoRoot(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+func sameContent(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+
+	dataA, err := os.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	dataB, err := os.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(dataA, dataB), nil
+}
+
+func matchesPaths(rel string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		if p == "." || rel == p || strings.HasPrefix(rel, p+string(filepath.Separator)) {
+			return true
+		}
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// diffNoIndex runs git diff --no-index, which exits 1 when files differ
+func diffNoIndex(a, b string, extra ...string) (string, error) {
+	args := append([]string{"diff", "--no-index", "--no-color"}, extra...)
+	args = append(args, a, b)
+
+	cmd := exec.Command("git", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// DiffFiles returns a unified diff from file a to file b, naming both path
+// in its headers. A file that doesn't exist diffs as empty.
+func DiffFiles(a, b, path string) (string, error) {
+	for _, f := range []*string{&a, &b} {
+		if _, err := os.Stat(*f); os.IsNotExist(err) {
+			*f = os.DevNull
+		}
+	}
+	out, err := diffNoIndex(a, b)
+	if err != nil {
+		return "", err
+	}
+	// git names the files by their paths without the leading slash
+	for _, f := range []string{a, b} {
+		if f != os.DevNull {
+			out = strings.ReplaceAll(out, strings.TrimPrefix(filepath.ToSlash(f), "/"), path)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,118 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiff_IncludesUncommittedAndUntracked(t *testing.T) {
+	host, env := setupRepos(t)
+
+	writeFile(t, filepath.Join(env, "file.txt"), "edited\n")
+	writeFile(t, filepath.Join(env, "untracked.txt"), "new\n")
+	if err := os.MkdirAll(filepath.Join(env, ".cilo"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	writeFile(t, filepath.Join(env, ".cilo", "override.yml"), "services: {}\n")
+
+	repo := Repo{Name: "root", Path: "."}
+	names, err := Diff(host, env, repo, DiffOptions{NameOnly: true})
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if names != "file.txt\nuntracked.txt" {
+		t.Fatalf("unexpected changed files: %q", names)
+	}
+
+	// The agent's real index must be left alone
+	staged, err := run(env, "diff", "--cached", "--name-only")
+	if err != nil {
+		t.Fatalf("diff --cached: %v", err)
+	}
+	if staged != "" {
+		t.Fatalf("expected env index untouched, got %q", staged)
+	}
+
+	filtered, err := Diff(host, env, repo, DiffOptions{NameOnly: true, Paths: []string{"untracked.txt"}})
+	if err != nil {
+		t.Fatalf("Diff with paths: %v", err)
+	}
+	if filtered != "untracked.txt" {
+		t.Fatalf("unexpected filtered files: %q", filtered)
+	}
+}
+
+func TestDiff_SinceCreate(t *testing.T) {
+	host, env := setupRepos(t)
+
+	snapshots, err := Snapshot(host)
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("Snapshot: %v %v", snapshots, err)
+	}
+
+	// Host moves on; comparing against the creation commit should still
+	// show only the env's own change
+	writeFile(t, filepath.Join(host, "host.txt"), "host\n")
+	gitCmd(t, host, "add", ".")
+	gitCmd(t, host, "commit", "-q", "-m", "host change")
+	writeFile(t, filepath.Join(env, "file.txt"), "edited\n")
+
+	names, err := Diff(host, env, Repo{Name: "root", Path: "."}, DiffOptions{
+		NameOnly: true,
+		Base:     snapshots[0].Commit,
+	})
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if names != "file.txt" {
+		t.Fatalf("unexpected changed files: %q", names)
+	}
+}
+
+func TestDiffTree_PlainFiles(t *testing.T) {
+	source := t.TempDir()
+	workspace := t.TempDir()
+
+	writeFile(t, filepath.Join(source, "same.txt"), "same\n")
+	writeFile(t, filepath.Join(workspace, "same.txt"), "same\n")
+	writeFile(t, filepath.Join(source, "changed.txt"), "before\n")
+	writeFile(t, filepath.Join(workspace, "changed.txt"), "after\n")
+	writeFile(t, filepath.Join(source, "removed.txt"), "gone\n")
+	writeFile(t, filepath.Join(workspace, "added.txt"), "added\n")
+
+	// Dot dirs that were never copied are not reported as deleted
+	if err := os.MkdirAll(filepath.Join(source, ".venv"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	writeFile(t, filepath.Join(source, ".venv", "lib.py"), "x\n")
+
+	// Nor are files .gitignore leaves out
+	for _, root := range []string{source, workspace} {
+		writeFile(t, filepath.Join(root, ".gitignore"), "build/\n")
+	}
+	if err := os.MkdirAll(filepath.Join(workspace, "build"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	writeFile(t, filepath.Join(workspace, "build", "out.bin"), "x\n")
+
+	names, err := DiffTree(source, workspace, DiffOptions{NameOnly: true})
+	if err != nil {
+		t.Fatalf("DiffTree: %v", err)
+	}
+	if names != "added.txt\nchanged.txt\nremoved.txt" {
+		t.Fatalf("unexpected changed files: %q", names)
+	}
+
+	diff, err := DiffTree(source, workspace, DiffOptions{Paths: []string{"changed.txt"}})
+	if err != nil {
+		t.Fatalf("DiffTree: %v", err)
+	}
+	if !strings.Contains(diff, "-before") || !strings.Contains(diff, "+after") {
+		t.Fatalf("unexpected diff: %q", diff)
+	}
+	if strings.Contains(diff, source) {
+		t.Fatalf("expected relative paths in diff: %q", diff)
+	}
+}
@@ -0,0 +1,289 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mergeRef is the temporary ref env commits are fetched into before merging
+const mergeRef = "refs/cilo/merge"
+
+// Merge modes reported in MergeResult
+const (
+	ModeMerge  = "merge"
+	ModeSquash = "squash"
+	ModeBranch = "branch"
+	ModePatch  = "patch"
+)
+
+// MergeOptions controls how changes are brought from an env back to the host
+type MergeOptions struct {
+	Branch             string // Fetch env HEAD into this branch only; host checkout is untouched
+	Squash             bool   // Squash env commits into a single commit on the host
+	PatchDir           string // Write a format-patch series here instead of merging
+	IncludeUncommitted bool   // Auto-commit the env working tree before merging
+	Message            string // Commit message for squash and auto-commit
+	Base               string // Commit the env was created from; patches and host drift start here
+}
+
+// Mode returns the merge mode selected by the options
+func (o MergeOptions) Mode() string {
+	switch {
+	case o.PatchDir != "":
+		return ModePatch
+	case o.Branch != "":
+		return ModeBranch
+	case o.Squash:
+		return ModeSquash
+	default:
+		return ModeMerge
+	}
+}
+
+// TouchesCheckout reports whether the mode modifies the host working tree
+func (o MergeOptions) TouchesCheckout() bool {
+	mode := o.Mode()
+	return mode == ModeMerge || mode == ModeSquash
+}
+
+// MergeResult describes the outcome of merging a single repo
+type MergeResult struct {
+	Repo          Repo
+	Mode          string
+	Commits       int      // Env commits not yet on the host
+	Patches       []string // Files written in patch mode
+	AutoCommitted bool     // Env working tree was committed first
+	Uncommitted   bool     // Env had uncommitted changes that were left behind
+	HostAhead     int      // Host commits made since the env was created (requires Base)
+}
+
+// UpToDate reports whether there was nothing to bring over
+func (r *MergeResult) UpToDate() bool {
+	return r.Commits == 0
+}
+
+// ConflictError is returned when a merge conflicts. The host repo has
+// already been reset to its pre-merge state when this is returned.
+type ConflictError struct {
+	Repo  string
+	Files []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("merge conflict in %s (%d file(s)): %s", e.Repo, len(e.Files), strings.Join(e.Files, ", "))
+}
+
+// DirtyError is returned when the host repo has uncommitted changes
+type DirtyError struct {
+	Repo string
+	Path string
+}
+
+func (e *DirtyError) Error() string {
+	return fmt.Sprintf("host repo %s has uncommitted changes (%s); commit or stash them first", e.Repo, e.Path)
+}
+
+// CheckHostClean verifies that no host repo matching the env repos is dirty.
+// It is meant to run before any repo is merged so a dirty tree aborts the
+// whole operation rather than leaving some repos merged.
+func CheckHostClean(hostRoot string, repos []Repo) error {
+	for _, repo := range repos {
+		hostPath := filepath.Join(hostRoot, repo.Path)
+		if _, err := os.Stat(hostPath); err != nil {
+			continue
+		}
+		dirty, err := IsDirty(hostPath)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return &DirtyError{Repo: repo.Name, Path: hostPath}
+		}
+	}
+	return nil
+}
+
+// Merge brings changes from env to host according to opts.
+// A conflicting merge is aborted and reported as a *ConflictError so the
+// host repo is never left half-merged. A host that no longer has opts.Base
+// gets only the env commits after it, replayed with cherry-pick, which
+// refuses env histories holding merge commits.
+func Merge(hostRoot, envRoot string, repo Repo, opts MergeOptions) (*MergeResult, error) {
+	hostPath := filepath.Join(hostRoot, repo.Path)
+	envPath, _ := filepath.Abs(filepath.Join(envRoot, repo.Path))
+
+	if _, err := os.Stat(filepath.Join(hostPath, ".git")); err != nil {
+		return nil, fmt.Errorf("host repo not found at %s", hostPath)
+	}
+
+	result := &MergeResult{Repo: repo, Mode: opts.Mode()}
+
+	envDirty, err := isDirty(envPath, excludeGenerated(repo))
+	if err != nil {
+		return nil, err
+	}
+	if envDirty {
+		if opts.IncludeUncommitted {
+			if err := commitAll(repo, envPath, opts.Message); err != nil {
+				return nil, fmt.Errorf("failed to commit env changes: %w", err)
+			}
+			result.AutoCommitted = true
+		} else {
+			result.Uncommitted = true
+		}
+	}
+
+	if opts.TouchesCheckout() {
+		dirty, err := IsDirty(hostPath)
+		if err != nil {
+			return nil, err
+		}
+		if dirty {
+			return nil, &DirtyError{Repo: repo.Name, Path: hostPath}
+		}
+	}
+
+	// The temp ref is force-updated; a named branch only fast-forwards so an
+	// existing branch with unrelated work is never clobbered.
+	target := mergeRef
+	refspec := "+HEAD:" + mergeRef
+	if result.Mode == ModeBranch {
+		target = "refs/heads/" + opts.Branch
+		refspec = "HEAD:" + target
+	}
+	if _, err := run(hostPath, "fetch", "--no-tags", envPath, refspec); err != nil {
+		return nil, fmt.Errorf("failed to fetch from env: %w", err)
+	}
+	if target == mergeRef {
+		defer run(hostPath, "update-ref", "-d", mergeRef)
+	}
+
+	count, err := run(hostPath, "rev-list", "--count", "HEAD.."+target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count env commits: %w", err)
+	}
+	result.Commits, _ = strconv.Atoi(count)
+
+	// The creation commit is the env's true fork point even if the host has
+	// since been rebased; only trust it when it is in both histories
+	base := "HEAD"
+	replay := false
+	if opts.Base != "" && isAncestor(hostPath, opts.Base, target) {
+		base = opts.Base
+		if ahead, err := run(hostPath, "rev-list", "--count", opts.Base+"..HEAD"); err == nil {
+			result.HostAhead, _ = strconv.Atoi(ahead)
+		}
+		// A host that was rebased or reset no longer has the creation
+		// commit; merging would bring back the history the env forked
+		// from, so only the env's own commits are replayed onto it
+		if opts.TouchesCheckout() && !isAncestor(hostPath, opts.Base, "HEAD") {
+			replay = true
+			count, err := run(hostPath, "rev-list", "--count", opts.Base+".."+target)
+			if err != nil {
+				return nil, fmt.Errorf("failed to count env commits: %w", err)
+			}
+			result.Commits, _ = strconv.Atoi(count)
+			// cherry-pick can't replay a merge commit without knowing
+			// which parent to diff against
+			merges, err := run(hostPath, "rev-list", "--merges", "--count", opts.Base+".."+target)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list env commits: %w", err)
+			}
+			if merges != "0" {
+				return nil, fmt.Errorf("%s: the host no longer has the commit the env was created from, and the env's %s merge commit(s) can't be replayed onto it; use --branch or --patch instead", repo.Name, merges)
+			}
+		}
+	}
+
+	if result.UpToDate() {
+		return result, nil
+	}
+
+	switch result.Mode {
+	case ModeBranch:
+		return result, nil
+
+	case ModePatch:
+		dir := filepath.Join(opts.PatchDir, repo.Name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create patch directory: %w", err)
+		}
+		out, err := run(hostPath, "format-patch", "-o", dir, base+".."+target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write patches: %w", err)
+		}
+		result.Patches = strings.Fields(out)
+		return result, nil
+	}
+
+	mergeArgs := []string{"merge", "--no-edit"}
+	if result.Mode == ModeSquash {
+		mergeArgs = append(mergeArgs, "--squash")
+	}
+	mergeArgs = append(mergeArgs, target)
+	abortArgs := []string{"reset", "--merge"}
+	if replay {
+		mergeArgs = []string{"cherry-pick", "--allow-empty"}
+		if result.Mode == ModeSquash {
+			mergeArgs = append(mergeArgs, "--no-commit")
+		}
+		mergeArgs = append(mergeArgs, base+".."+target)
+		abortArgs = []string{"cherry-pick", "--abort"}
+	}
+
+	if _, mergeErr := run(hostPath, mergeArgs...); mergeErr != nil {
+		conflicts, _ := run(hostPath, "diff", "--name-only", "--diff-filter=U")
+		if _, err := run(hostPath, abortArgs...); err != nil {
+			return nil, fmt.Errorf("merge failed (%v) and could not be aborted: %w", mergeErr, err)
+		}
+		if conflicts == "" {
+			return nil, fmt.Errorf("failed to merge from env: %w", mergeErr)
+		}
+		return nil, &ConflictError{Repo: repo.Name, Files: strings.Fields(conflicts)}
+	}
+
+	if result.Mode == ModeSquash {
+		if _, err := run(hostPath, "commit", "-m", squashMessage(opts.Message)); err != nil {
+			run(hostPath, "reset", "--merge")
+			return nil, fmt.Errorf("failed to commit squashed changes: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// isAncestor reports whether commit exists in repoPath and is an ancestor of ref
+func isAncestor(repoPath, commit, ref string) bool {
+	_, err := run(repoPath, "merge-base", "--is-ancestor", commit, ref)
+	return err == nil
+}
+
+// commitAll stages and commits everything in the working tree, falling
+// back to a cilo identity when the repo has none configured
+func commitAll(repo Repo, repoPath, message string) error {
+	if message == "" {
+		message = "cilo: commit uncommitted workspace changes"
+	}
+	addArgs := append([]string{"add", "-A", "--", "."}, excludeGenerated(repo)...)
+	if _, err := run(repoPath, addArgs...); err != nil {
+		return err
+	}
+
+	args := []string{}
+	if email, _ := run(repoPath, "config", "user.email"); email == "" {
+		args = append(args, "-c", "user.name=cilo", "-c", "user.email=cilo@localhost")
+	}
+	args = append(args, "commit", "--no-verify", "-m", message)
+	_, err := run(repoPath, args...)
+	return err
+}
+
+func squashMessage(message string) string {
+	if message == "" {
+		return "Squashed changes from cilo environment"
+	}
+	return message
+}
@@ -0,0 +1,247 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func gitCmd(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+// setupRepos creates a host repo with one commit and an env copy of it
+func setupRepos(t *testing.T) (host, env string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	host = t.TempDir()
+	gitCmd(t, host, "init", "-q")
+	gitCmd(t, host, "config", "user.email", "test@example.com")
+	gitCmd(t, host, "config", "user.name", "Test User")
+	writeFile(t, filepath.Join(host, "file.txt"), "one\n")
+	gitCmd(t, host, "add", ".")
+	gitCmd(t, host, "commit", "-q", "-m", "initial")
+
+	env = filepath.Join(t.TempDir(), "env")
+	if out, err := exec.Command("cp", "-r", host, env).CombinedOutput(); err != nil {
+		t.Fatalf("cp: %v\n%s", err, out)
+	}
+	return host, env
+}
+
+func TestMerge_ConflictLeavesHostClean(t *testing.T) {
+	host, env := setupRepos(t)
+
+	writeFile(t, filepath.Join(env, "file.txt"), "env\n")
+	gitCmd(t, env, "commit", "-q", "-am", "env change")
+	writeFile(t, filepath.Join(host, "file.txt"), "host\n")
+	gitCmd(t, host, "commit", "-q", "-am", "host change")
+
+	_, err := Merge(host, env, Repo{Name: "root", Path: "."}, MergeOptions{})
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+	if len(conflict.Files) != 1 || conflict.Files[0] != "file.txt" {
+		t.Fatalf("unexpected conflict files: %v", conflict.Files)
+	}
+
+	dirty, err := IsDirty(host)
+	if err != nil {
+		t.Fatalf("IsDirty: %v", err)
+	}
+	if dirty {
+		t.Fatalf("expected host to be reset after conflict")
+	}
+}
+
+func TestMerge_SquashIncludeUncommitted(t *testing.T) {
+	host, env := setupRepos(t)
+
+	writeFile(t, filepath.Join(env, "file.txt"), "two\n")
+	gitCmd(t, env, "commit", "-q", "-am", "env change")
+	writeFile(t, filepath.Join(env, "new.txt"), "untracked\n")
+
+	result, err := Merge(host, env, Repo{Name: "root", Path: "."}, MergeOptions{
+		Squash:             true,
+		IncludeUncommitted: true,
+		Message:            "squashed",
+	})
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if !result.AutoCommitted {
+		t.Fatalf("expected env changes to be auto-committed")
+	}
+	if result.Commits != 2 {
+		t.Fatalf("expected 2 commits, got %d", result.Commits)
+	}
+
+	subject, err := run(host, "log", "-1", "--format=%s")
+	if err != nil {
+		t.Fatalf("log: %v", err)
+	}
+	if subject != "squashed" {
+		t.Fatalf("expected squash commit, got %q", subject)
+	}
+	if _, err := os.Stat(filepath.Join(host, "new.txt")); err != nil {
+		t.Fatalf("expected untracked env file to be merged: %v", err)
+	}
+}
+
+func TestMerge_RefusesDirtyHost(t *testing.T) {
+	host, env := setupRepos(t)
+
+	writeFile(t, filepath.Join(host, "file.txt"), "local edit\n")
+
+	err := CheckHostClean(host, []Repo{{Name: "root", Path: "."}})
+	var dirty *DirtyError
+	if !errors.As(err, &dirty) {
+		t.Fatalf("expected DirtyError, got %v", err)
+	}
+
+	// Branch mode doesn't touch the checkout, so it is still allowed
+	gitCmd(t, env, "commit", "-q", "--allow-empty", "-m", "env change")
+	result, err := Merge(host, env, Repo{Name: "root", Path: "."}, MergeOptions{Branch: "agent"})
+	if err != nil {
+		t.Fatalf("Merge --branch: %v", err)
+	}
+	if result.Commits != 1 {
+		t.Fatalf("expected 1 commit, got %d", result.Commits)
+	}
+}
+
+func TestMerge_ReplaysOntoRewrittenHost(t *testing.T) {
+	host, env := setupRepos(t)
+
+	snapshots, err := Snapshot(host)
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("Snapshot: %v %v", snapshots, err)
+	}
+
+	writeFile(t, filepath.Join(env, "env.txt"), "env\n")
+	gitCmd(t, env, "add", ".")
+	gitCmd(t, env, "commit", "-q", "-m", "env change")
+
+	// The host rewrites its history, dropping the creation commit
+	writeFile(t, filepath.Join(host, "file.txt"), "rewritten\n")
+	gitCmd(t, host, "commit", "-q", "--amend", "-am", "rewritten initial")
+
+	result, err := Merge(host, env, Repo{Name: "root", Path: "."}, MergeOptions{
+		Squash:  true,
+		Message: "squashed",
+		Base:    snapshots[0].Commit,
+	})
+	if err != nil {
+		t.Fatalf("Merge --squash: %v", err)
+	}
+	if result.Commits != 1 {
+		t.Fatalf("expected only the env commit, got %d", result.Commits)
+	}
+	data, err := os.ReadFile(filepath.Join(host, "file.txt"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != "rewritten\n" {
+		t.Fatalf("expected host history kept, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(host, "env.txt")); err != nil {
+		t.Fatalf("expected env change merged: %v", err)
+	}
+	count, err := run(host, "rev-list", "--count", "HEAD")
+	if err != nil {
+		t.Fatalf("rev-list: %v", err)
+	}
+	if count != "2" {
+		t.Fatalf("expected one squash commit on the rewritten host, got %s commits", count)
+	}
+}
+
+func TestMerge_ReplayRefusesMergeCommits(t *testing.T) {
+	host, env := setupRepos(t)
+
+	snapshots, err := Snapshot(host)
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("Snapshot: %v %v", snapshots, err)
+	}
+
+	// The env merges a side branch
+	gitCmd(t, env, "checkout", "-q", "-b", "side")
+	writeFile(t, filepath.Join(env, "side.txt"), "side\n")
+	gitCmd(t, env, "add", ".")
+	gitCmd(t, env, "commit", "-q", "-m", "side change")
+	gitCmd(t, env, "checkout", "-q", "-")
+	writeFile(t, filepath.Join(env, "env.txt"), "env\n")
+	gitCmd(t, env, "add", ".")
+	gitCmd(t, env, "commit", "-q", "-m", "env change")
+	gitCmd(t, env, "merge", "-q", "--no-edit", "side")
+
+	writeFile(t, filepath.Join(host, "file.txt"), "rewritten\n")
+	gitCmd(t, host, "commit", "-q", "--amend", "-am", "rewritten initial")
+	head, err := run(host, "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatalf("rev-parse: %v", err)
+	}
+
+	_, err = Merge(host, env, Repo{Name: "root", Path: "."}, MergeOptions{Base: snapshots[0].Commit})
+	if err == nil || !strings.Contains(err.Error(), "merge commit") {
+		t.Fatalf("expected merge commits to be refused, got %v", err)
+	}
+	if after, _ := run(host, "rev-parse", "HEAD"); after != head {
+		t.Fatalf("host HEAD moved to %s", after)
+	}
+	if dirty, err := IsDirty(host); err != nil || dirty {
+		t.Fatalf("host left dirty: %v %v", dirty, err)
+	}
+}
+
+func TestMerge_PatchFromCreationPoint(t *testing.T) {
+	host, env := setupRepos(t)
+
+	snapshots, err := Snapshot(host)
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("Snapshot: %v %v", snapshots, err)
+	}
+	if snapshots[0].Branch == "" || snapshots[0].Dirty {
+		t.Fatalf("unexpected snapshot: %+v", snapshots[0])
+	}
+
+	writeFile(t, filepath.Join(host, "host.txt"), "host\n")
+	gitCmd(t, host, "add", ".")
+	gitCmd(t, host, "commit", "-q", "-m", "host change")
+	writeFile(t, filepath.Join(env, "env.txt"), "env\n")
+	gitCmd(t, env, "add", ".")
+	gitCmd(t, env, "commit", "-q", "-m", "env change")
+
+	result, err := Merge(host, env, Repo{Name: "root", Path: "."}, MergeOptions{
+		PatchDir: t.TempDir(),
+		Base:     snapshots[0].Commit,
+	})
+	if err != nil {
+		t.Fatalf("Merge --patch: %v", err)
+	}
+	if result.HostAhead != 1 {
+		t.Fatalf("expected host 1 commit ahead, got %d", result.HostAhead)
+	}
+	if len(result.Patches) != 1 {
+		t.Fatalf("expected 1 patch, got %v", result.Patches)
+	}
+}
@@ -1,10 +1,14 @@
 package git
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/models"
 )
 
 // Repo represents a git repository in the project
@@ -41,51 +45,96 @@ func FindRepos(root string) ([]Repo, error) {
 	return repos, err
 }
 
-// Diff compares the host repo with the environment repo
-func Diff(hostRoot, envRoot string, repo Repo) (string, error) {
-	hostPath := filepath.Join(hostRoot, repo.Path)
-	envPath := filepath.Join(envRoot, repo.Path)
-
-	// Fetch from env to host as a temp branch
-	branchName := "cilo-diff-tmp"
-	// Ensure we are working with absolute paths for git fetch
-	absEnvPath, _ := filepath.Abs(envPath)
+// generatedPaths are files cilo writes into a workspace. They are never part
+// of an agent's changes and are left out of diffs and auto-commits.
+var generatedPaths = []string{
+	".cilo/override.yml",
+	".cilo/meta.json",
+	".cilo/refresh.json",
+	".cilo/build-hashes.json",
+	".cilo/sync-journal.json",
+	".cilo/sync.lock",
+}
 
-	fetchCmd := exec.Command("git", "fetch", absEnvPath, "HEAD:"+branchName)
-	fetchCmd.Dir = hostPath
-	if err := fetchCmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to fetch from env: %w", err)
+// excludeGenerated returns pathspecs excluding cilo's generated files.
+// They only live at the workspace root, so nested repos need none.
+func excludeGenerated(repo Repo) []string {
+	if repo.Path != "." {
+		return nil
+	}
+	specs := make([]string, 0, len(generatedPaths))
+	for _, p := range generatedPaths {
+		specs = append(specs, ":(exclude)"+p)
 	}
-	defer func() {
-		exec.Command("git", "branch", "-D", branchName).Run()
-	}()
+	return specs
+}
 
-	// Generate diff
-	diffCmd := exec.Command("git", "diff", "HEAD.."+branchName)
-	diffCmd.Dir = hostPath
-	output, err := diffCmd.Output()
+// Snapshot records the HEAD commit, branch and dirty state of every repo
+// under root
+func Snapshot(root string) ([]models.RepoSnapshot, error) {
+	repos, err := FindRepos(root)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate diff: %w", err)
+		return nil, err
+	}
+
+	var snapshots []models.RepoSnapshot
+	for _, repo := range repos {
+		repoPath := filepath.Join(root, repo.Path)
+		// Both are empty rather than errors for unborn or detached HEADs
+		commit, _ := run(repoPath, "rev-parse", "--verify", "-q", "HEAD")
+		branch, _ := run(repoPath, "symbolic-ref", "--short", "-q", "HEAD")
+		dirty, err := IsDirty(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, models.RepoSnapshot{
+			Path:   repo.Path,
+			Commit: commit,
+			Branch: branch,
+			Dirty:  dirty,
+		})
 	}
+	return snapshots, nil
+}
 
-	return string(output), nil
+// IsDirty reports whether a repo has uncommitted or untracked changes
+func IsDirty(repoPath string) (bool, error) {
+	return isDirty(repoPath, nil)
 }
 
-// Merge brings changes from env to host
-func Merge(hostRoot, envRoot string, repo Repo) error {
-	hostPath := filepath.Join(hostRoot, repo.Path)
-	envPath := filepath.Join(envRoot, repo.Path)
-	absEnvPath, _ := filepath.Abs(envPath)
+// isDirty is IsDirty restricted by pathspecs
+func isDirty(repoPath string, pathspecs []string) (bool, error) {
+	args := append([]string{"status", "--porcelain", "--", "."}, pathspecs...)
+	out, err := run(repoPath, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to get status of %s: %w", repoPath, err)
+	}
+	return out != "", nil
+}
 
-	// Pull from env
-	cmd := exec.Command("git", "pull", absEnvPath, "HEAD")
-	cmd.Dir = hostPath
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// run executes a git command in dir and returns its trimmed stdout.
+// On failure the error includes git's stderr.
+func run(dir string, args ...string) (string, error) {
+	out, err := runRaw(dir, nil, args...)
+	return strings.TrimSpace(out), err
+}
 
+// runRaw is run with extra environment variables and untrimmed output
+func runRaw(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to merge from env: %w", err)
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return "", fmt.Errorf("git %s: %w", args[0], err)
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
 	}
-
-	return nil
+	return stdout.String(), nil
 }
@@ -0,0 +1,153 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	envpkg "github.com/sharedco/cilo/pkg/env"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/runtime"
+)
+
+// Lifecycle events hooks can be attached to
+const (
+	PostCreate  = "post_create"
+	PreUp       = "pre_up"
+	PostUp      = "post_up"
+	PreDown     = "pre_down"
+	PreDestroy  = "pre_destroy"
+	PostDestroy = "post_destroy"
+)
+
+// Events lists the lifecycle events in the order they happen
+var Events = []string{PostCreate, PreUp, PostUp, PreDown, PreDestroy, PostDestroy}
+
+// Failure policies
+const (
+	OnFailureAbort = "abort"
+	OnFailureWarn  = "warn"
+)
+
+// Context describes the environment hooks run for
+type Context struct {
+	Project   string
+	Env       string
+	DNSSuffix string
+	Workspace string
+	Dir       string           // Host hooks run here; defaults to Workspace
+	Provider  runtime.Provider // Runs service hooks
+	Stdout    io.Writer        // Hook output and progress; defaults to os.Stdout
+	Stderr    io.Writer        // Defaults to os.Stderr
+}
+
+// ForEvent returns the hooks configured for an event, in order
+func ForEvent(cfg *models.ProjectConfig, event string) []models.Hook {
+	if cfg == nil || cfg.Hooks == nil {
+		return nil
+	}
+	switch event {
+	case PostCreate:
+		return cfg.Hooks.PostCreate
+	case PreUp:
+		return cfg.Hooks.PreUp
+	case PostUp:
+		return cfg.Hooks.PostUp
+	case PreDown:
+		return cfg.Hooks.PreDown
+	case PreDestroy:
+		return cfg.Hooks.PreDestroy
+	case PostDestroy:
+		return cfg.Hooks.PostDestroy
+	default:
+		return nil
+	}
+}
+
+// Run executes the hooks for an event in order. A failing hook with the
+// abort policy stops the remaining hooks and returns its error; with the
+// warn policy the failure is printed and the next hook runs.
+func Run(ctx context.Context, cfg *models.ProjectConfig, event string, hctx Context) error {
+	hookList := ForEvent(cfg, event)
+	if len(hookList) == 0 {
+		return nil
+	}
+
+	vars := envpkg.Variables(envpkg.RenderContext{
+		Project:   hctx.Project,
+		Env:       hctx.Env,
+		DNSSuffix: hctx.DNSSuffix,
+	}, hctx.Workspace)
+	vars["CILO_HOOK"] = event
+	if hctx.Stdout == nil {
+		hctx.Stdout = os.Stdout
+	}
+	if hctx.Stderr == nil {
+		hctx.Stderr = os.Stderr
+	}
+
+	for i, hook := range hookList {
+		policy := strings.ToLower(strings.TrimSpace(hook.OnFailure))
+		if policy == "" {
+			policy = OnFailureAbort
+		}
+		if policy != OnFailureAbort && policy != OnFailureWarn {
+			return fmt.Errorf("%s hook %d: invalid on_failure %q (must be abort or warn)", event, i+1, hook.OnFailure)
+		}
+
+		if strings.TrimSpace(hook.Run) == "" {
+			continue
+		}
+
+		where := "host"
+		if hook.Service != "" {
+			where = "service " + hook.Service
+		}
+		fmt.Fprintf(hctx.Stdout, "Running %s hook (%s): %s\n", event, where, hook.Run)
+
+		err := runHook(ctx, hook, event, hctx, vars)
+		if err == nil {
+			continue
+		}
+		if policy == OnFailureWarn {
+			fmt.Fprintf(hctx.Stdout, "Warning: %s hook failed: %v\n", event, err)
+			continue
+		}
+		return fmt.Errorf("%s hook %q failed: %w", event, hook.Run, err)
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, hook models.Hook, event string, hctx Context, vars map[string]string) error {
+	if hook.Service == "" {
+		cmd := exec.CommandContext(ctx, "bash", "-lc", hook.Run)
+		cmd.Dir = hctx.Dir
+		if cmd.Dir == "" {
+			cmd.Dir = hctx.Workspace
+		}
+		cmd.Env = os.Environ()
+		for key, value := range vars {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+		cmd.Stdout = hctx.Stdout
+		cmd.Stderr = hctx.Stderr
+		return cmd.Run()
+	}
+
+	// Containers don't exist yet (or any more) around create and destroy
+	if event == PostCreate || event == PostDestroy {
+		return fmt.Errorf("service hooks are not supported for %s", event)
+	}
+	if hctx.Provider == nil {
+		return fmt.Errorf("no runtime available to exec in service %s", hook.Service)
+	}
+	return hctx.Provider.Exec(ctx, hctx.Project, hctx.Env, hook.Service, []string{"sh", "-c", hook.Run}, runtime.ExecOptions{
+		Env:    vars,
+		Stdin:  strings.NewReader(""),
+		Stdout: hctx.Stdout,
+		Stderr: hctx.Stderr,
+	})
+}
@@ -0,0 +1,64 @@
+package hooks
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+func TestRun_HostHookGetsCiloVariables(t *testing.T) {
+	workspace := t.TempDir()
+	cfg := &models.ProjectConfig{
+		Hooks: &models.HooksConfig{
+			PostCreate: []models.Hook{
+				{Run: `echo "$CILO_PROJECT/$CILO_ENV $CILO_HOOK $CILO_BASE_URL" > out.txt`},
+			},
+		},
+	}
+
+	err := Run(context.Background(), cfg, PostCreate, Context{
+		Project:   "proj",
+		Env:       "dev",
+		Workspace: workspace,
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(workspace, "out.txt"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "proj/dev post_create http://proj.dev.test" {
+		t.Fatalf("unexpected hook output: %q", got)
+	}
+}
+
+func TestRun_FailurePolicy(t *testing.T) {
+	workspace := t.TempDir()
+	cfg := &models.ProjectConfig{
+		Hooks: &models.HooksConfig{
+			PreDown: []models.Hook{
+				{Run: "exit 1", OnFailure: OnFailureWarn},
+				{Run: "touch ran"},
+				{Run: "exit 2"},
+				{Run: "touch not-ran"},
+			},
+		},
+	}
+
+	err := Run(context.Background(), cfg, PreDown, Context{Workspace: workspace})
+	if err == nil {
+		t.Fatalf("expected aborting hook to fail")
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "ran")); err != nil {
+		t.Fatalf("expected hook after warn failure to run: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "not-ran")); !os.IsNotExist(err) {
+		t.Fatalf("expected hooks after abort to be skipped, err=%v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sharedco/cilo/pkg/engine"
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+// fakeBackend serves a canned environment and records what it was asked
+type fakeBackend struct {
+	restarted []string
+	logs      string
+}
+
+func (f *fakeBackend) Status(ctx context.Context) (*engine.Result, error) {
+	return &engine.Result{
+		Environment: &models.Environment{
+			Name:    "dev",
+			Project: "shop",
+			Status:  "running",
+			Services: map[string]*models.Service{
+				"web": {Name: "web", IP: "10.224.1.2", IsIngress: true},
+				"db":  {Name: "db", IP: "10.224.1.3"},
+			},
+		},
+		Workspace: "/tmp/shop/dev",
+		URLs:      []engine.URL{{URL: "http://web.dev.test", Service: "web"}},
+	}, nil
+}
+
+func (f *fakeBackend) Logs(ctx context.Context, service string, tail int, w io.Writer) error {
+	_, err := io.WriteString(w, f.logs)
+	return err
+}
+
+func (f *fakeBackend) Exec(ctx context.Context, service string, command []string, stdout, stderr io.Writer) (int, error) {
+	fmt.Fprintf(stdout, "%s ran %s", service, strings.Join(command, " "))
+	return 3, nil
+}
+
+func (f *fakeBackend) Restart(ctx context.Context, service string) error {
+	if service == "missing" {
+		return fmt.Errorf("service %q not found", service)
+	}
+	f.restarted = append(f.restarted, service)
+	return nil
+}
+
+func (f *fakeBackend) Snapshot(ctx context.Context, name string) (*engine.Snapshot, error) {
+	return &engine.Snapshot{Name: name, Volumes: []string{"cilo_dev_pgdata"}}, nil
+}
+
+func (f *fakeBackend) Reset(ctx context.Context, name string) (*engine.Snapshot, error) {
+	return &engine.Snapshot{Name: name, Volumes: []string{"cilo_dev_pgdata"}}, nil
+}
+
+// session sends requests to a server and returns its responses by id
+func session(t *testing.T, b Backend, messages ...string) map[string]response {
+	t.Helper()
+	var out strings.Builder
+	server := NewServer("cilo", "test", Tools(b))
+	if err := server.Serve(context.Background(), strings.NewReader(strings.Join(messages, "\n")+"\n"), &out); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+
+	responses := make(map[string]response)
+	scanner := bufio.NewScanner(strings.NewReader(out.String()))
+	for scanner.Scan() {
+		var resp struct {
+			ID     json.RawMessage `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  *rpcError       `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("Unmarshal response %q: %v", scanner.Text(), err)
+		}
+		responses[string(resp.ID)] = response{ID: resp.ID, Result: resp.Result, Error: resp.Error}
+	}
+	return responses
+}
+
+func call(id int, tool string, args string) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":%q,"arguments":%s}}`, id, tool, args)
+}
+
+// toolText extracts the text and error flag of a tools/call result
+func toolText(t *testing.T, resp response) (string, bool) {
+	t.Helper()
+	if resp.Error != nil {
+		t.Fatalf("protocol error: %+v", resp.Error)
+	}
+	var result struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+		IsError bool `json:"isError"`
+	}
+	if err := json.Unmarshal(resp.Result.(json.RawMessage), &result); err != nil {
+		t.Fatalf("Unmarshal result: %v", err)
+	}
+	if len(result.Content) != 1 {
+		t.Fatalf("content = %+v, want one item", result.Content)
+	}
+	return result.Content[0].Text, result.IsError
+}
+
+func TestServer_Handshake(t *testing.T) {
+	responses := session(t, &fakeBackend{},
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"resources/list"}`,
+		`not json`,
+	)
+
+	if len(responses) != 4 {
+		t.Fatalf("got %d responses, want 4 (notifications get none)", len(responses))
+	}
+
+	var init struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	json.Unmarshal(responses["1"].Result.(json.RawMessage), &init)
+	if init.ProtocolVersion != "2024-11-05" {
+		t.Fatalf("protocolVersion = %q, want the client's", init.ProtocolVersion)
+	}
+
+	var list struct {
+		Tools []struct {
+			Name string `json:"name"`
+		} `json:"tools"`
+	}
+	json.Unmarshal(responses["2"].Result.(json.RawMessage), &list)
+	var names []string
+	for _, tool := range list.Tools {
+		names = append(names, tool.Name)
+	}
+	if got := strings.Join(names, ","); got != "status,list_urls,logs,exec,restart_service,snapshot,reset" {
+		t.Fatalf("tools = %s", got)
+	}
+
+	if err := responses["3"].Error; err == nil || err.Code != codeMethodNotFound {
+		t.Fatalf("resources/list error = %+v, want method not found", err)
+	}
+	if err := responses["null"].Error; err == nil || err.Code != codeParseError {
+		t.Fatalf("bad line error = %+v, want parse error", err)
+	}
+}
+
+func TestServer_Tools(t *testing.T) {
+	backend := &fakeBackend{logs: "web | GET / 200\nweb | GET /boom 500\ndb  | ready\n"}
+	responses := session(t, backend,
+		call(1, "status", `{}`),
+		call(2, "list_urls", `{}`),
+		call(3, "logs", `{"grep":" 5\\d\\d$"}`),
+		call(4, "exec", `{"service":"db","command":["psql","-c","select 1"]}`),
+		call(5, "restart_service", `{"service":"web"}`),
+		call(6, "restart_service", `{"service":"missing"}`),
+		call(7, "restart_service", `{"service":"web","env":"other"}`),
+		call(8, "snapshot", `{"name":"clean"}`),
+	)
+
+	if text, _ := toolText(t, responses["1"]); !strings.Contains(text, `"kind": "environment"`) || !strings.Contains(text, `"10.224.1.3"`) {
+		t.Fatalf("status = %s", text)
+	}
+	if text, _ := toolText(t, responses["2"]); !strings.Contains(text, "http://web.dev.test -> web") || !strings.Contains(text, "db.dev.test -> db (10.224.1.3, isolated)") {
+		t.Fatalf("list_urls = %s", text)
+	}
+	if text, _ := toolText(t, responses["3"]); text != "web | GET /boom 500" {
+		t.Fatalf("logs = %q", text)
+	}
+	if text, _ := toolText(t, responses["4"]); !strings.Contains(text, `"exit_code": 3`) || !strings.Contains(text, "db ran psql -c select 1") {
+		t.Fatalf("exec = %s", text)
+	}
+	if text, isErr := toolText(t, responses["5"]); isErr || text != "Restarted web" {
+		t.Fatalf("restart = %q (error %v)", text, isErr)
+	}
+	if _, isErr := toolText(t, responses["6"]); !isErr {
+		t.Fatalf("restart of missing service succeeded")
+	}
+	// Tools have no way to name another environment
+	if text, isErr := toolText(t, responses["7"]); !isErr || !strings.Contains(text, "env") {
+		t.Fatalf("restart with env argument = %q (error %v), want rejection", text, isErr)
+	}
+	if len(backend.restarted) != 1 {
+		t.Fatalf("restarted = %v, want only web", backend.restarted)
+	}
+	if text, _ := toolText(t, responses["8"]); !strings.Contains(text, `"clean"`) {
+		t.Fatalf("snapshot = %s", text)
+	}
+}
@@ -0,0 +1,276 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/engine"
+	"github.com/sharedco/cilo/pkg/output"
+)
+
+// Backend acts on the one environment the server is scoped to. Tools take
+// no environment argument, so an agent can't reach any other.
+type Backend interface {
+	Status(ctx context.Context) (*engine.Result, error)
+	Logs(ctx context.Context, service string, tail int, w io.Writer) error
+	Exec(ctx context.Context, service string, command []string, stdout, stderr io.Writer) (int, error)
+	Restart(ctx context.Context, service string) error
+	Snapshot(ctx context.Context, name string) (*engine.Snapshot, error)
+	Reset(ctx context.Context, name string) (*engine.Snapshot, error)
+}
+
+const (
+	defaultLogTail = 200
+	maxToolOutput  = 256 * 1024
+)
+
+// Tools returns the cilo tools backed by b
+func Tools(b Backend) []Tool {
+	return []Tool{
+		{
+			Name:        "status",
+			Description: "Show this environment's status, services, IPs and URLs.",
+			InputSchema: object(nil),
+			Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+				result, err := b.Status(ctx)
+				if err != nil {
+					return "", err
+				}
+				return marshal(output.NewEnvironment(result.Environment, result.Workspace))
+			},
+		},
+		{
+			Name:        "list_urls",
+			Description: "List the URLs this environment serves on, and the hostname and IP of each service.",
+			InputSchema: object(nil),
+			Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+				result, err := b.Status(ctx)
+				if err != nil {
+					return "", err
+				}
+				return describeURLs(result), nil
+			},
+		},
+		{
+			Name:        "logs",
+			Description: "Read recent logs from one service, or all services when service is omitted. Optionally keep only lines matching a regular expression.",
+			InputSchema: object(map[string]interface{}{
+				"service": stringProp("Service name; omit for all services"),
+				"tail":    map[string]interface{}{"type": "integer", "description": fmt.Sprintf("Number of lines per service (default %d)", defaultLogTail)},
+				"grep":    stringProp("Regular expression lines must match"),
+			}),
+			Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+				var params struct {
+					Service string `json:"service"`
+					Tail    int    `json:"tail"`
+					Grep    string `json:"grep"`
+				}
+				if err := decode(args, &params); err != nil {
+					return "", err
+				}
+				if params.Tail <= 0 {
+					params.Tail = defaultLogTail
+				}
+				var pattern *regexp.Regexp
+				if params.Grep != "" {
+					var err error
+					if pattern, err = regexp.Compile(params.Grep); err != nil {
+						return "", fmt.Errorf("invalid grep pattern: %w", err)
+					}
+				}
+
+				var buf bytes.Buffer
+				if err := b.Logs(ctx, params.Service, params.Tail, &buf); err != nil {
+					return "", err
+				}
+				return filterLines(buf.String(), pattern), nil
+			},
+		},
+		{
+			Name:        "exec",
+			Description: "Run a command in a service's container and return its exit code and output.",
+			InputSchema: object(map[string]interface{}{
+				"service": stringProp("Service name"),
+				"command": map[string]interface{}{
+					"type":        "array",
+					"items":       map[string]string{"type": "string"},
+					"description": `Program and arguments, e.g. ["psql", "-c", "select 1"]`,
+				},
+			}, "service", "command"),
+			Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+				var params struct {
+					Service string   `json:"service"`
+					Command []string `json:"command"`
+				}
+				if err := decode(args, &params); err != nil {
+					return "", err
+				}
+				if params.Service == "" || len(params.Command) == 0 {
+					return "", fmt.Errorf("service and command are required")
+				}
+
+				var stdout, stderr bytes.Buffer
+				code, err := b.Exec(ctx, params.Service, params.Command, &stdout, &stderr)
+				if err != nil {
+					return "", err
+				}
+				return marshal(output.NewExecResult(code, truncate(stdout.String()), truncate(stderr.String())))
+			},
+		},
+		{
+			Name:        "restart_service",
+			Description: "Restart one of this environment's services.",
+			InputSchema: object(map[string]interface{}{
+				"service": stringProp("Service name"),
+			}, "service"),
+			Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+				var params struct {
+					Service string `json:"service"`
+				}
+				if err := decode(args, &params); err != nil {
+					return "", err
+				}
+				if params.Service == "" {
+					return "", fmt.Errorf("service is required")
+				}
+				if err := b.Restart(ctx, params.Service); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Restarted %s", params.Service), nil
+			},
+		},
+		{
+			Name:        "snapshot",
+			Description: "Save the contents of this environment's volumes (databases, uploads) under a name so they can be restored with reset. Services are briefly stopped.",
+			InputSchema: object(map[string]interface{}{
+				"name": stringProp(fmt.Sprintf("Snapshot name (default %q)", engine.DefaultSnapshot)),
+			}),
+			Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+				var params struct {
+					Name string `json:"name"`
+				}
+				if err := decode(args, &params); err != nil {
+					return "", err
+				}
+				snap, err := b.Snapshot(ctx, params.Name)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Saved snapshot %q of volumes: %s", snap.Name, strings.Join(snap.Volumes, ", ")), nil
+			},
+		},
+		{
+			Name:        "reset",
+			Description: "Restore this environment's volumes from a snapshot, discarding changes made since. Services are briefly stopped.",
+			InputSchema: object(map[string]interface{}{
+				"name": stringProp(fmt.Sprintf("Snapshot name (default %q)", engine.DefaultSnapshot)),
+			}),
+			Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+				var params struct {
+					Name string `json:"name"`
+				}
+				if err := decode(args, &params); err != nil {
+					return "", err
+				}
+				snap, err := b.Reset(ctx, params.Name)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Restored snapshot %q to volumes: %s", snap.Name, strings.Join(snap.Volumes, ", ")), nil
+			},
+		},
+	}
+}
+
+func object(properties map[string]interface{}, required ...string) map[string]interface{} {
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func stringProp(description string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "description": description}
+}
+
+func decode(args json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+func marshal(v interface{}) (string, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// describeURLs lists the ingress URLs then each service's own hostname
+func describeURLs(result *engine.Result) string {
+	env := result.Environment
+	suffix := env.DNSSuffix
+	if suffix == "" {
+		suffix = ".test"
+	}
+
+	var sb strings.Builder
+	for _, u := range result.URLs {
+		fmt.Fprintf(&sb, "%s -> %s\n", u.URL, u.Service)
+	}
+	doc := output.NewEnvironment(env, result.Workspace)
+	for _, svc := range doc.Services {
+		fmt.Fprintf(&sb, "%s.%s%s -> %s (%s, %s)\n", svc.Name, env.Name, suffix, svc.Name, svc.IP, svc.Type)
+	}
+	if sb.Len() == 0 {
+		return "No services are running"
+	}
+	return sb.String()
+}
+
+// filterLines keeps the lines matching pattern (all lines when it is nil),
+// dropping the oldest when the result would be too long
+func filterLines(text string, pattern *regexp.Regexp) string {
+	var kept []string
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if pattern == nil || pattern.MatchString(line) {
+			kept = append(kept, line)
+		}
+	}
+	out := strings.Join(kept, "\n")
+	if len(out) > maxToolOutput {
+		out = out[len(out)-maxToolOutput:]
+		if i := strings.IndexByte(out, '\n'); i >= 0 {
+			out = out[i+1:]
+		}
+		out = "[earlier lines truncated]\n" + out
+	}
+	if out == "" {
+		return "No matching log lines"
+	}
+	return out
+}
+
+func truncate(s string) string {
+	if len(s) <= maxToolOutput {
+		return s
+	}
+	return s[:maxToolOutput] + "\n[output truncated]"
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -13,24 +14,44 @@ func LoadProjectConfig() (*ProjectConfig, error) {
 	return LoadProjectConfigFromPath(".")
 }
 
-// LoadProjectConfigFromPath loads .cilo/config.yml from a specific directory
+// LoadProjectConfigFromPath loads the config of a specific directory, with
+// the user's defaults and .cilo/config.local.yml layered over it
 // Returns nil, nil if no config exists
 func LoadProjectConfigFromPath(path string) (*ProjectConfig, error) {
-	configPath := filepath.Join(path, ".cilo", "config.yml")
-
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		}
+	layered, err := LoadLayeredConfig(path, "")
+	if err != nil || layered == nil {
 		return nil, err
 	}
+	return layered.Config, nil
+}
 
-	var config ProjectConfig
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, err
+// ProjectName returns the project an environment created from dir belongs
+// to when none is given: the config's project, or else dir's name
+func ProjectName(dir string, config *ProjectConfig) string {
+	if config != nil && config.Project != "" {
+		return config.Project
 	}
+	return filepath.Base(dir)
+}
 
+// ParseProjectConfig decodes a project config, failing with a *ConfigError
+// that gives the line of each unknown field or invalid value. file names
+// the config in errors.
+func ParseProjectConfig(file string, data []byte) (*ProjectConfig, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	if problems := validateConfig(file, &doc); len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+
+	var config ProjectConfig
+	if len(doc.Content) > 0 {
+		if err := doc.Decode(&config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+	}
 	return &config, nil
 }
 
@@ -39,3 +60,13 @@ func ProjectConfigured() bool {
 	_, err := os.Stat(filepath.Join(".cilo", "config.yml"))
 	return err == nil
 }
+
+// UnmarshalYAML accepts either a command string or a full hook mapping
+func (h *Hook) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		h.Run = value.Value
+		return nil
+	}
+	type plain Hook
+	return value.Decode((*plain)(h))
+}
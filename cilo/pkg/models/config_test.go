@@ -1,8 +1,11 @@
 package models
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -32,3 +35,131 @@ func TestLoadProjectConfigFromPath(t *testing.T) {
 		t.Fatalf("unexpected compose files: %v", config.ComposeFiles)
 	}
 }
+
+func TestLoadProjectConfigFromPath_Hooks(t *testing.T) {
+	root := t.TempDir()
+	configDir := filepath.Join(root, ".cilo")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	content := `project: demo
+hooks:
+  post_create:
+    - npm install
+  post_up:
+    - run: npm run migrate
+      service: api
+      on_failure: warn
+`
+	if err := os.WriteFile(filepath.Join(configDir, "config.yml"), []byte(content), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	config, err := LoadProjectConfigFromPath(root)
+	if err != nil {
+		t.Fatalf("LoadProjectConfigFromPath: %v", err)
+	}
+	if config.Hooks == nil {
+		t.Fatalf("expected hooks")
+	}
+	if len(config.Hooks.PostCreate) != 1 || config.Hooks.PostCreate[0].Run != "npm install" {
+		t.Fatalf("unexpected post_create hooks: %+v", config.Hooks.PostCreate)
+	}
+	want := Hook{Run: "npm run migrate", Service: "api", OnFailure: "warn"}
+	if len(config.Hooks.PostUp) != 1 || config.Hooks.PostUp[0] != want {
+		t.Fatalf("unexpected post_up hooks: %+v", config.Hooks.PostUp)
+	}
+}
+
+func TestParseProjectConfigStrict(t *testing.T) {
+	content := `project: demo
+compose_file:
+  - docker-compose.yml
+env:
+  copy_mode: allow-list
+hooks:
+  post_up:
+    - npm test
+    - run: migrate
+      servce: api
+    - service: api
+resources:
+  pids: many
+profiles:
+  web:
+    service: [web]
+`
+	_, err := ParseProjectConfig(".cilo/config.yml", []byte(content))
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("ParseProjectConfig: %v, want a ConfigError", err)
+	}
+	want := []string{
+		`.cilo/config.yml:2: unknown field "compose_file" (did you mean "compose_files"?)`,
+		`.cilo/config.yml:5: env.copy_mode: "allow-list" is not one of all, none, allowlist`,
+		`.cilo/config.yml:10: unknown field "hooks.post_up[1].servce" (did you mean "service"?)`,
+		`.cilo/config.yml:11: hooks.post_up[2]: missing required field "run"`,
+		`.cilo/config.yml:13: resources.pids: expected an integer`,
+		`.cilo/config.yml:16: unknown field "profiles.web.service" (did you mean "services"?)`,
+	}
+	if len(configErr.Problems) != len(want) {
+		t.Fatalf("problems = %v, want %d", configErr.Problems, len(want))
+	}
+	for i, problem := range configErr.Problems {
+		if problem.String() != want[i] {
+			t.Errorf("problem %d = %s, want %s", i, problem, want[i])
+		}
+	}
+
+	// Empty values and numbers where strings go are fine
+	cfg, err := ParseProjectConfig(".cilo/config.yml", []byte("project: 42\nenv:\nresources:\n  cpus: 1.5\n"))
+	if err != nil {
+		t.Fatalf("ParseProjectConfig: %v", err)
+	}
+	if cfg.Project != "42" || cfg.Resources.CPUs != "1.5" {
+		t.Fatalf("config = %+v", cfg)
+	}
+}
+
+// TestConfigSchemaCoversProjectConfig keeps config.schema.json in step with
+// the structs it describes
+func TestConfigSchemaCoversProjectConfig(t *testing.T) {
+	var walk func(typ reflect.Type, s *schema, path string)
+	walk = func(typ reflect.Type, s *schema, path string) {
+		for typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Map {
+			if typ.Kind() == reflect.Map {
+				s = s.AdditionalProperties.Schema
+			}
+			typ = typ.Elem()
+		}
+		if s.Ref != "" {
+			s = configSchema.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+		}
+		if s.Items != nil {
+			s = s.Items
+		}
+		if len(s.OneOf) > 0 {
+			s = s.OneOf[len(s.OneOf)-1]
+		}
+		if typ.Kind() != reflect.Struct {
+			return
+		}
+		fields := map[string]bool{}
+		for i := 0; i < typ.NumField(); i++ {
+			name, _, _ := strings.Cut(typ.Field(i).Tag.Get("yaml"), ",")
+			fields[name] = true
+			prop, ok := s.Properties[name]
+			if !ok {
+				t.Errorf("schema has no %s%s", path, name)
+				continue
+			}
+			walk(typ.Field(i).Type, prop, path+name+".")
+		}
+		for name := range s.Properties {
+			if !fields[name] {
+				t.Errorf("schema has %s%s, which %s doesn't", path, name, typ.Name())
+			}
+		}
+	}
+	walk(reflect.TypeOf(ProjectConfig{}), configSchema, "")
+}
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is one config layer read as YAML nodes, so it can be changed
+// and written back with its comments, key order and blank lines
+type ConfigFile struct {
+	Path string // On disk
+	Name string // In messages, e.g. .cilo/config.yml
+	Doc  yaml.Node
+
+	indent int
+	spaced map[string]bool // Top-level keys with a blank line above them
+}
+
+// OpenConfigFile reads a config file. A file that doesn't exist yet opens
+// empty and is created on Save.
+func OpenConfigFile(path, name string) (*ConfigFile, error) {
+	f := &ConfigFile{Path: path, Name: name, indent: 2, spaced: map[string]bool{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		f.Doc = yaml.Node{Kind: yaml.DocumentNode}
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if err := f.parse(data); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// parse reads data into Doc, noting how it is laid out
+func (f *ConfigFile) parse(data []byte) error {
+	f.Doc = yaml.Node{}
+	if err := yaml.Unmarshal(data, &f.Doc); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", f.Name, err)
+	}
+	if f.Doc.Kind == 0 {
+		f.Doc = yaml.Node{Kind: yaml.DocumentNode}
+	}
+	f.indent = detectIndent(data)
+	f.spaced = map[string]bool{}
+
+	lines := strings.Split(string(data), "\n")
+	root := configRoot(&f.Doc)
+	if root == nil || root.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i < len(root.Content); i += 2 {
+		key := root.Content[i]
+		// The line above the key and the comments over it
+		above := key.Line - 2
+		if key.HeadComment != "" {
+			above -= strings.Count(key.HeadComment, "\n") + 1
+		}
+		if above >= 0 && above < len(lines) && strings.TrimSpace(lines[above]) == "" {
+			f.spaced[key.Value] = true
+		}
+	}
+	return nil
+}
+
+// Marshal returns the document as YAML, laid out as it was read
+func (f *ConfigFile) Marshal() ([]byte, error) {
+	root := configRoot(&f.Doc)
+	if root == nil || (root.Kind == yaml.MappingNode && len(root.Content) == 0 && f.Doc.HeadComment == "") {
+		return []byte{}, nil
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(f.indent)
+	if err := enc.Encode(&f.Doc); err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", f.Name, err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", f.Name, err)
+	}
+	return f.restoreSpacing(buf.Bytes()), nil
+}
+
+// topLevelKey matches a top-level key of an encoded document
+var topLevelKey = regexp.MustCompile(`^([^\s#-][^:]*):`)
+
+// restoreSpacing puts back the blank lines above top-level keys, and above
+// the comments over them, that the encoder drops
+func (f *ConfigFile) restoreSpacing(data []byte) []byte {
+	lines := strings.Split(string(data), "\n")
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		out = append(out, line)
+		m := topLevelKey.FindStringSubmatch(line)
+		if m == nil || !f.spaced[strings.Trim(m[1], `"'`)] {
+			continue
+		}
+		// Insert the blank line above the key's comments
+		at := len(out) - 1
+		for at > 0 && strings.HasPrefix(out[at-1], "#") {
+			at--
+		}
+		if at > 0 && out[at-1] != "" {
+			out = append(out[:at], append([]string{""}, out[at:]...)...)
+		}
+	}
+	return []byte(strings.Join(out, "\n"))
+}
+
+// Save checks the document against the schema and writes it, replacing
+// the file in one step. It fails with a *ConfigError, leaving the file as
+// it was, if the document is invalid.
+func (f *ConfigFile) Save() error {
+	data, err := f.Marshal()
+	if err != nil {
+		return err
+	}
+	if _, err := ParseProjectConfig(f.Name, data); err != nil {
+		return err
+	}
+	return writeFileAtomic(f.Path, data)
+}
+
+// SaveBytes replaces the file with data, as edited by hand, if it matches
+// the schema
+func (f *ConfigFile) SaveBytes(data []byte) error {
+	if _, err := ParseProjectConfig(f.Name, data); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(f.Path, data); err != nil {
+		return err
+	}
+	return f.parse(data)
+}
+
+// writeFileAtomic writes a file through a temporary file and a rename,
+// keeping the mode of the file it replaces
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// detectIndent returns the indentation of the first indented line of a
+// YAML document, or 2
+func detectIndent(data []byte) int {
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if n := len(line) - len(trimmed); n >= 2 && trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			return n
+		}
+	}
+	return 2
+}
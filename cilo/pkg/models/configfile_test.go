@@ -0,0 +1,85 @@
+package models
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFileSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	content := `# cilo project config
+
+# Project name
+project: shop
+
+# Services every environment shares
+shared_services:
+    - db # the database
+
+idle:
+    timeout: 2h
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	file, err := OpenConfigFile(path, ".cilo/config.yml")
+	if err != nil {
+		t.Fatalf("OpenConfigFile: %v", err)
+	}
+	redis, _ := ParseConfigValue("redis")
+	if added, err := AddConfigListItem(&file.Doc, "shared_services", redis); err != nil || !added {
+		t.Fatalf("AddConfigListItem = %v, %v", added, err)
+	}
+	if removed, err := RemoveConfigListItem(&file.Doc, "shared_services", "db"); err != nil || !removed {
+		t.Fatalf("RemoveConfigListItem = %v, %v", removed, err)
+	}
+	stop, _ := ParseConfigValue("stop")
+	if err := SetConfigPath(&file.Doc, "idle.action", stop); err != nil {
+		t.Fatalf("SetConfigPath: %v", err)
+	}
+	if err := file.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	want := `# cilo project config
+
+# Project name
+project: shop
+
+# Services every environment shares
+shared_services:
+    - redis
+
+idle:
+    timeout: 2h
+    action: stop
+`
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(data) != want {
+		t.Fatalf("saved:\n%s\nwant:\n%s", data, want)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("mode = %v, %v; want 0600", info.Mode(), err)
+	}
+
+	// An invalid change leaves the file as it was
+	if _, err := AddConfigListItem(&file.Doc, "idle", redis); err == nil {
+		t.Fatalf("expected adding to a mapping to fail")
+	}
+	sleep, _ := ParseConfigValue("sleep")
+	if err := SetConfigPath(&file.Doc, "idle.action", sleep); err != nil {
+		t.Fatalf("SetConfigPath: %v", err)
+	}
+	if err := file.Save(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Save = %v, want ErrInvalidConfig", err)
+	}
+	if after, _ := os.ReadFile(path); string(after) != want {
+		t.Fatalf("invalid save changed the file:\n%s", after)
+	}
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParseConfigValue reads a value given on the command line as YAML, so
+// "3", "true", "[a, b]" and "{timeout: 2h}" keep their types. An empty
+// string is an empty string, not null.
+func ParseConfigValue(value string) (*yaml.Node, error) {
+	if value == "" {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: ""}, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err != nil {
+		return nil, fmt.Errorf("invalid value %q: %w", value, err)
+	}
+	return doc.Content[0], nil
+}
+
+// GetConfigPath returns the node at a dotted path, such as idle.timeout or
+// env.render.0.file, in a config document, or nil if nothing is set there
+func GetConfigPath(doc *yaml.Node, path string) *yaml.Node {
+	node := configRoot(doc)
+	for _, key := range strings.Split(path, ".") {
+		if node == nil {
+			return nil
+		}
+		node = configChild(node, key)
+	}
+	return node
+}
+
+// SetConfigPath sets the value at a dotted path in a config document,
+// adding the mappings above it that don't exist yet. Keys already there
+// keep their place and comments.
+func SetConfigPath(doc *yaml.Node, path string, value *yaml.Node) error {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	node := configRoot(doc)
+	keys := strings.Split(path, ".")
+	for i, key := range keys {
+		last := i == len(keys)-1
+		switch node.Kind {
+		case yaml.MappingNode:
+			child := mappingValue(node, key)
+			if last {
+				if child != nil {
+					// Keep the comments of the value replaced
+					value.LineComment, value.HeadComment = child.LineComment, child.HeadComment
+				}
+				setMappingValue(node, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+				return nil
+			}
+			if child == nil || (child.Kind == yaml.ScalarNode && child.Tag == "!!null") {
+				child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				setMappingValue(node, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+			}
+			node = child
+		case yaml.SequenceNode:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node.Content) {
+				return fmt.Errorf("%s: no item %s in %s", path, key, strings.Join(keys[:i], "."))
+			}
+			if last {
+				node.Content[index] = value
+				return nil
+			}
+			node = node.Content[index]
+		default:
+			return fmt.Errorf("%s: %s is not a mapping", path, strings.Join(keys[:i], "."))
+		}
+	}
+	return nil
+}
+
+// UnsetConfigPath removes the value at a dotted path from a config
+// document, reporting whether it was set
+func UnsetConfigPath(doc *yaml.Node, path string) bool {
+	keys := strings.Split(path, ".")
+	parent := configRoot(doc)
+	if len(keys) > 1 {
+		parent = GetConfigPath(doc, strings.Join(keys[:len(keys)-1], "."))
+	}
+	if parent == nil {
+		return false
+	}
+	key := keys[len(keys)-1]
+	switch parent.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(parent.Content); i += 2 {
+			if parent.Content[i].Value == key {
+				parent.Content = append(parent.Content[:i], parent.Content[i+2:]...)
+				return true
+			}
+		}
+	case yaml.SequenceNode:
+		if index, err := strconv.Atoi(key); err == nil && index >= 0 && index < len(parent.Content) {
+			parent.Content = append(parent.Content[:index], parent.Content[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// configRoot returns the top-level node of a document, or nil if it's empty
+func configRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return nil
+		}
+		return doc.Content[0]
+	}
+	return doc
+}
+
+// configChild returns a key of a mapping or an index of a list
+func configChild(node *yaml.Node, key string) *yaml.Node {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return mappingValue(node, key)
+	case yaml.SequenceNode:
+		if index, err := strconv.Atoi(key); err == nil && index >= 0 && index < len(node.Content) {
+			return node.Content[index]
+		}
+	}
+	return nil
+}
+
+// AddConfigListItem appends value to the list at a dotted path, starting
+// the list if nothing is set there. It reports false if the list already
+// has the value.
+func AddConfigListItem(doc *yaml.Node, path string, value *yaml.Node) (bool, error) {
+	list := GetConfigPath(doc, path)
+	if list == nil || (list.Kind == yaml.ScalarNode && list.Tag == "!!null") {
+		return true, SetConfigPath(doc, path, &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{value}})
+	}
+	if list.Kind != yaml.SequenceNode {
+		return false, fmt.Errorf("%s is not a list", path)
+	}
+	for _, item := range list.Content {
+		if item.Kind == yaml.ScalarNode && value.Kind == yaml.ScalarNode && item.Value == value.Value {
+			return false, nil
+		}
+	}
+	list.Content = append(list.Content, value)
+	return true, nil
+}
+
+// RemoveConfigListItem removes every item equal to value from the list at
+// a dotted path, reporting whether there was one
+func RemoveConfigListItem(doc *yaml.Node, path, value string) (bool, error) {
+	list := GetConfigPath(doc, path)
+	if list == nil {
+		return false, nil
+	}
+	if list.Kind != yaml.SequenceNode {
+		return false, fmt.Errorf("%s is not a list", path)
+	}
+	kept := list.Content[:0]
+	for _, item := range list.Content {
+		if item.Kind != yaml.ScalarNode || item.Value != value {
+			kept = append(kept, item)
+		}
+	}
+	removed := len(kept) < len(list.Content)
+	list.Content = kept
+	return removed, nil
+}
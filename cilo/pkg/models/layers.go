@@ -0,0 +1,228 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Config layers, in the order they apply. Each overrides the ones before.
+const (
+	LayerUser        = "user"        // ~/.cilo/config.yml, defaults for every project
+	LayerProject     = "project"     // .cilo/config.yml, committed with the project
+	LayerLocal       = "local"       // .cilo/config.local.yml, this machine only
+	LayerEnvironment = "environment" // Overrides stored with an environment in state
+)
+
+// envOverridesFile names an environment's overrides in errors and sources
+const envOverridesFile = "environment overrides"
+
+// ConfigSource is where a config value was set
+type ConfigSource struct {
+	Layer string `json:"layer" yaml:"layer"`
+	File  string `json:"file" yaml:"file"`
+	Line  int    `json:"line,omitempty" yaml:"line,omitempty"`
+}
+
+func (s ConfigSource) String() string {
+	if s.Line > 0 {
+		return fmt.Sprintf("%s (%s:%d)", s.Layer, s.File, s.Line)
+	}
+	return fmt.Sprintf("%s (%s)", s.Layer, s.File)
+}
+
+// LayeredConfig is a project config merged from its layers
+type LayeredConfig struct {
+	Config *ProjectConfig
+	// Node is the merged document. Its nodes keep the lines of the layer
+	// they came from.
+	Node *yaml.Node
+	// Sources maps the dotted path of each value set, down to scalars and
+	// whole lists, to where it was set
+	Sources map[string]ConfigSource
+	Layers  []ConfigSource // Layers present, in the order they applied
+}
+
+// Source returns where the value at a dotted path, or the nearest value
+// containing it, was set
+func (c *LayeredConfig) Source(path string) (ConfigSource, bool) {
+	for path != "" {
+		if source, ok := c.Sources[path]; ok {
+			return source, true
+		}
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return ConfigSource{}, false
+}
+
+// UserConfigPath is the config file with a user's defaults for every
+// project
+func UserConfigPath() string {
+	return filepath.Join(config.GetCiloHome(), "config.yml")
+}
+
+// LoadLayeredConfig merges the config layers of a project directory: the
+// user's defaults, the project's .cilo/config.yml, then its
+// .cilo/config.local.yml, and finally overrides, a YAML document of an
+// environment's own settings. Mappings merge key by key; scalars and lists
+// replace what earlier layers set, and empty values leave it as it was.
+// Every layer must match the schema. It returns nil, nil if the directory
+// has no .cilo/config.yml, whatever the other layers hold.
+func LoadLayeredConfig(dir, overrides string) (*LayeredConfig, error) {
+	files := []ConfigSource{
+		{Layer: LayerUser, File: UserConfigPath()},
+		{Layer: LayerProject, File: filepath.Join(".cilo", "config.yml")},
+		{Layer: LayerLocal, File: filepath.Join(".cilo", "config.local.yml")},
+	}
+
+	merged := &LayeredConfig{
+		Node:    &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"},
+		Sources: map[string]ConfigSource{},
+	}
+	var problems []ConfigProblem
+	inProject := false
+	for _, layer := range files {
+		path := layer.File
+		if layer.Layer != LayerUser {
+			path = filepath.Join(dir, layer.File)
+		}
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", layer.File, err)
+		}
+		if layer.Layer == LayerProject {
+			inProject = true
+		}
+		layerProblems, err := merged.apply(layer, data)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, layerProblems...)
+	}
+	if !inProject {
+		return nil, nil
+	}
+	if strings.TrimSpace(overrides) != "" {
+		layerProblems, err := merged.apply(ConfigSource{Layer: LayerEnvironment, File: envOverridesFile}, []byte(overrides))
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, layerProblems...)
+	}
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+
+	merged.Config = &ProjectConfig{}
+	if err := merged.Node.Decode(merged.Config); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+	return merged, nil
+}
+
+// LoadEnvironmentConfig loads the config an environment runs with: the
+// layers of its workspace, then the overrides stored with it
+func LoadEnvironmentConfig(env *Environment) (*ProjectConfig, error) {
+	layered, err := LoadLayeredConfig(config.GetEnvPath(env.Project, env.Name), env.Config)
+	if err != nil || layered == nil {
+		return nil, err
+	}
+	return layered.Config, nil
+}
+
+// apply checks one layer against the schema and merges it over the layers
+// before it
+func (c *LayeredConfig) apply(layer ConfigSource, data []byte) ([]ConfigProblem, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", layer.File, err)
+	}
+	c.Layers = append(c.Layers, layer)
+	if problems := validateConfig(layer.File, &doc); len(problems) > 0 {
+		return problems, nil
+	}
+	if len(doc.Content) > 0 {
+		mergeConfigNode(c.Node, doc.Content[0], "", layer, c.Sources)
+	}
+	return nil, nil
+}
+
+// mergeConfigNode merges the mapping src over dst, recording where each
+// value it sets came from
+func mergeConfigNode(dst, src *yaml.Node, prefix string, layer ConfigSource, sources map[string]ConfigSource) {
+	if src.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+		if value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+			continue
+		}
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + key.Value
+		}
+
+		existing := mappingValue(dst, key.Value)
+		if value.Kind == yaml.MappingNode {
+			if existing == nil || existing.Kind != yaml.MappingNode {
+				existing = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: value.Line}
+				setMappingValue(dst, key, existing)
+				clearSources(sources, path)
+			}
+			mergeConfigNode(existing, value, path, layer, sources)
+			continue
+		}
+
+		setMappingValue(dst, key, value)
+		clearSources(sources, path)
+		source := layer
+		source.Line = key.Line
+		sources[path] = source
+	}
+}
+
+// mappingValue returns the value of a key in a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue sets a key of a mapping node, adding it at the end if it
+// isn't there
+func setMappingValue(node, key, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key.Value {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, key, value)
+}
+
+// clearSources forgets where a path and everything under it came from
+func clearSources(sources map[string]ConfigSource, path string) {
+	for p := range sources {
+		if p == path || strings.HasPrefix(p, path+".") {
+			delete(sources, p)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoadLayeredConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("CILO_USER_HOME", home)
+	root := t.TempDir()
+	files := map[string]string{
+		filepath.Join(home, ".cilo", "config.yml"):       "dns_suffix: .localhost\nshared_services: [redis]\nidle:\n  timeout: 1h\n  action: stop\n",
+		filepath.Join(root, ".cilo", "config.yml"):       "project: shop\ncompose_files: [compose.yaml]\nshared_services: [postgres]\nidle:\n  timeout: 2h\n",
+		filepath.Join(root, ".cilo", "config.local.yml"): "dns_suffix:\nhostnames:\n  - admin\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	layered, err := LoadLayeredConfig(root, "idle:\n  action: pause\n")
+	if err != nil {
+		t.Fatalf("LoadLayeredConfig: %v", err)
+	}
+	cfg := layered.Config
+	if cfg.Project != "shop" || cfg.DNSSuffix != ".localhost" {
+		t.Fatalf("project, dns_suffix = %q, %q", cfg.Project, cfg.DNSSuffix)
+	}
+	// Lists replace; mappings merge
+	if len(cfg.SharedServices) != 1 || cfg.SharedServices[0] != "postgres" {
+		t.Fatalf("shared_services = %v, want [postgres]", cfg.SharedServices)
+	}
+	if cfg.Idle == nil || cfg.Idle.Timeout != "2h" || cfg.Idle.Action != "pause" {
+		t.Fatalf("idle = %+v, want 2h and pause", cfg.Idle)
+	}
+
+	want := map[string]string{
+		"dns_suffix":      LayerUser,
+		"shared_services": LayerProject,
+		"idle.timeout":    LayerProject,
+		"idle.action":     LayerEnvironment,
+		"hostnames":       LayerLocal,
+	}
+	for path, layer := range want {
+		if source, ok := layered.Source(path); !ok || source.Layer != layer {
+			t.Errorf("source of %s = %v, want %s", path, source, layer)
+		}
+	}
+	if source := layered.Sources["idle.timeout"]; source.Line != 5 {
+		t.Errorf("idle.timeout line = %d, want 5", source.Line)
+	}
+
+	// Each layer is checked on its own
+	_, err = LoadLayeredConfig(root, "idle:\n  acton: pause\n")
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Problems[0].File != "environment overrides" {
+		t.Fatalf("err = %v, want a problem in the environment overrides", err)
+	}
+
+	// The user's defaults alone don't configure a project
+	layered, err = LoadLayeredConfig(t.TempDir(), "")
+	if err != nil || layered != nil {
+		t.Fatalf("LoadLayeredConfig without a project = %v, %v; want nil", layered, err)
+	}
+}
+
+func TestSetConfigPath(t *testing.T) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte("# Project\nproject: shop # the name\nidle:\n  timeout: 2h\nhostnames: [a, b]\n"), &doc); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	set := map[string]string{"project": "store", "idle.action": "stop", "resources.memory": "2g", "hostnames.1": "c"}
+	for path, raw := range set {
+		value, err := ParseConfigValue(raw)
+		if err != nil {
+			t.Fatalf("ParseConfigValue: %v", err)
+		}
+		if err := SetConfigPath(&doc, path, value); err != nil {
+			t.Fatalf("SetConfigPath(%s): %v", path, err)
+		}
+	}
+	if err := SetConfigPath(&doc, "project.name", &yaml.Node{Kind: yaml.ScalarNode, Value: "x"}); err == nil {
+		t.Fatalf("expected setting under a scalar to fail")
+	}
+	if !UnsetConfigPath(&doc, "idle.timeout") || UnsetConfigPath(&doc, "ttl") {
+		t.Fatalf("UnsetConfigPath reported the wrong result")
+	}
+
+	data, err := yaml.Marshal(&doc)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	got := string(data)
+	for _, want := range []string{"# Project\nproject: store # the name\n", "idle:\n    action: stop\n", "hostnames: [a, c]\n", "resources:\n    memory: 2g\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("document missing %q:\n%s", want, got)
+		}
+	}
+	if GetConfigPath(&doc, "resources.memory").Value != "2g" || GetConfigPath(&doc, "idle.timeout") != nil {
+		t.Errorf("GetConfigPath returned the wrong values")
+	}
+}
@@ -17,11 +17,22 @@ type State struct {
 
 // Host represents a machine or server where environments run
 type Host struct {
-	ID           string                  `json:"id"`
-	Provider     string                  `json:"provider,omitempty"`
-	MeshProvider string                  `json:"mesh_provider,omitempty"`
-	MeshID       string                  `json:"mesh_id,omitempty"`
-	Environments map[string]*Environment `json:"environments"`
+	ID            string                  `json:"id"`
+	Provider      string                  `json:"provider,omitempty"`
+	MeshProvider  string                  `json:"mesh_provider,omitempty"`
+	MeshID        string                  `json:"mesh_id,omitempty"`
+	DockerHost    string                  `json:"docker_host,omitempty"`    // DOCKER_HOST of the host's daemon, e.g. ssh://me@build1
+	DockerContext string                  `json:"docker_context,omitempty"` // Or the docker context that reaches it
+	SSH           string                  `json:"ssh,omitempty"`            // [user@]host[:port] workspaces are synced to over SSH
+	Environments  map[string]*Environment `json:"environments"`
+	Budget        *HostBudget             `json:"budget,omitempty"` // Caps what running environments may use together
+}
+
+// HostBudget caps the environments running on a host at once. Zero values
+// are unlimited.
+type HostBudget struct {
+	MaxRunningEnvs int    `json:"max_running_envs,omitempty"`
+	MaxMemory      string `json:"max_memory,omitempty"` // Total of running services' memory limits, e.g. "16g"
 }
 
 // SharedNetwork represents a network shared across multiple environments
@@ -48,7 +59,11 @@ type SharedService struct {
 type Environment struct {
 	Name               string              `json:"name"`
 	Project            string              `json:"project,omitempty"`
+	Host               string              `json:"host,omitempty"` // ID of the host it runs on; empty for the local host
 	CreatedAt          time.Time           `json:"created_at"`
+	LastActivity       time.Time           `json:"last_activity,omitempty"` // Last run/exec, DNS query or container activity
+	ExpiresAt          time.Time           `json:"expires_at,omitempty"`    // When 'cilo prune' may destroy it; zero never expires
+	SuspendedAt        time.Time           `json:"suspended_at,omitempty"`  // When Suspend last paused or stopped it
 	Subnet             string              `json:"subnet"`
 	DNSSuffix          string              `json:"dns_suffix,omitempty"`
 	Status             string              `json:"status"`
@@ -56,6 +71,31 @@ type Environment struct {
 	Services           map[string]*Service `json:"services"`
 	SharedNetworks     []string            `json:"shared_networks,omitempty"`      // Names of shared networks
 	UsesSharedServices []string            `json:"uses_shared_services,omitempty"` // Names of shared services this env consumes
+	SourceRepos        []RepoSnapshot      `json:"source_repos,omitempty"`         // Source git repos as of creation
+	Resources          *ResourceLimits     `json:"resources,omitempty"`            // Overrides the project's resource policy
+	Config             string              `json:"config,omitempty"`               // YAML config overriding the workspace's, set with 'cilo config override'
+	Profile            string              `json:"profile,omitempty"`              // Config or compose profile chosen with 'cilo up --profile'
+	Images             map[string]string   `json:"images,omitempty"`               // Prebuilt images swapped in with 'cilo up --image', by service
+}
+
+// RepoSnapshot records a source git repo at environment creation
+type RepoSnapshot struct {
+	Path   string `json:"path"`             // Relative to the source root
+	Commit string `json:"commit,omitempty"` // Empty for repos with no commits yet
+	Branch string `json:"branch,omitempty"` // Empty when HEAD was detached
+	Dirty  bool   `json:"dirty"`            // Uncommitted or untracked changes were copied too
+}
+
+// WorkspaceMeta is the content of a workspace's .cilo/meta.json, which
+// describes the environment independently of state
+type WorkspaceMeta struct {
+	Name        string         `json:"name"`
+	Project     string         `json:"project"`
+	CreatedAt   time.Time      `json:"created_at"`
+	Source      string         `json:"source"`
+	Subnet      string         `json:"subnet"`
+	Host        string         `json:"host,omitempty"`
+	SourceRepos []RepoSnapshot `json:"source_repos,omitempty"`
 }
 
 // Service represents a service within an environment
@@ -66,6 +106,17 @@ type Service struct {
 	URL       string   `json:"url,omitempty"`
 	IsIngress bool     `json:"is_ingress,omitempty"`
 	Hostnames []string `json:"hostnames,omitempty"`
+	Memory    int64    `json:"memory,omitempty"` // Memory limit in bytes, counted against the host budget
+}
+
+// ResourceLimits bounds what each service in an environment may use. Empty
+// fields are unlimited. Limits a service sets itself in its compose file
+// (deploy.resources, mem_limit, ...) take precedence.
+type ResourceLimits struct {
+	CPUs    string `yaml:"cpus,omitempty" json:"cpus,omitempty"`       // e.g. "1.5"
+	Memory  string `yaml:"memory,omitempty" json:"memory,omitempty"`   // e.g. "512m", "2g"
+	Pids    int    `yaml:"pids,omitempty" json:"pids,omitempty"`       // Max processes per container
+	Storage string `yaml:"storage,omitempty" json:"storage,omitempty"` // Container writable layer size, e.g. "10g"
 }
 
 // ComposeService represents a service in a docker-compose file
@@ -81,6 +132,7 @@ type ComposeService struct {
 	Command       interface{}       `yaml:"command,omitempty"`
 	WorkingDir    string            `yaml:"working_dir,omitempty"`
 	Labels        map[string]string `yaml:"labels,omitempty"`
+	Healthcheck   interface{}       `yaml:"healthcheck,omitempty"`
 }
 
 // ComposeFile represents a docker-compose.yml structure
@@ -105,18 +157,63 @@ type ComposeIPAMConfig struct {
 // ProjectConfig represents a .cilo/config.yml file
 // This configures how cilo works for a specific project
 type ProjectConfig struct {
-	Project               string     `yaml:"project"`
-	BuildTool             string     `yaml:"build_tool,omitempty"`
-	ComposeFiles          []string   `yaml:"compose_files"`
-	EnvFiles              []string   `yaml:"env_files,omitempty"`
-	DNSSuffix             string     `yaml:"dns_suffix,omitempty"`
-	DefaultEnvironment    string     `yaml:"default_environment,omitempty"`
-	DefaultIngressService string     `yaml:"default_ingress_service,omitempty"`
-	Hostnames             []string   `yaml:"hostnames,omitempty"`
-	Environments          []string   `yaml:"environments,omitempty"`
-	CopyDotDirs           []string   `yaml:"copy_dot_dirs,omitempty"`
-	IgnoreDotDirs         []string   `yaml:"ignore_dot_dirs,omitempty"`
-	Env                   *EnvConfig `yaml:"env,omitempty"`
+	Project               string              `yaml:"project"`
+	BuildTool             string              `yaml:"build_tool,omitempty"`
+	ComposeFiles          []string            `yaml:"compose_files"`
+	EnvFiles              []string            `yaml:"env_files,omitempty"`
+	DNSSuffix             string              `yaml:"dns_suffix,omitempty"`
+	DefaultEnvironment    string              `yaml:"default_environment,omitempty"`
+	DefaultIngressService string              `yaml:"default_ingress_service,omitempty"`
+	Hostnames             []string            `yaml:"hostnames,omitempty"`
+	SharedServices        []string            `yaml:"shared_services,omitempty"` // Shared as if labelled cilo.share
+	Profiles              map[string]*Profile `yaml:"profiles,omitempty"`
+	Environments          []string            `yaml:"environments,omitempty"`
+	CopyDotDirs           []string            `yaml:"copy_dot_dirs,omitempty"`
+	IgnoreDotDirs         []string            `yaml:"ignore_dot_dirs,omitempty"`
+	Env                   *EnvConfig          `yaml:"env,omitempty"`
+	Hooks                 *HooksConfig        `yaml:"hooks,omitempty"`
+	Resources             *ResourceLimits     `yaml:"resources,omitempty"`
+	Idle                  *IdleConfig         `yaml:"idle,omitempty"`
+	TTL                   string              `yaml:"ttl,omitempty"` // Environments expire this long after creation, e.g. "3d"
+	Procfile              *ProcfileConfig     `yaml:"procfile,omitempty"`
+}
+
+// Profile is a named set of services for 'cilo up --profile' to run, and
+// how to run them
+type Profile struct {
+	Services []string `yaml:"services,omitempty"` // Depended-on services run too; empty runs them all
+	Shared   []string `yaml:"shared,omitempty"`
+	Isolated []string `yaml:"isolated,omitempty"`
+}
+
+// ProcfileConfig configures how a project without a compose file runs its
+// Procfile
+type ProcfileConfig struct {
+	Image string `yaml:"image,omitempty"` // Image every process runs in; guessed from the project's files if empty
+}
+
+// IdleConfig suspends environments nobody has used for a while
+type IdleConfig struct {
+	Timeout string `yaml:"timeout,omitempty"` // e.g. "2h"; empty never suspends
+	Action  string `yaml:"action,omitempty"`  // "pause" (default) or "stop"
+}
+
+// HooksConfig lists commands to run at points in an environment's lifecycle
+type HooksConfig struct {
+	PostCreate  []Hook `yaml:"post_create,omitempty"`
+	PreUp       []Hook `yaml:"pre_up,omitempty"`
+	PostUp      []Hook `yaml:"post_up,omitempty"` // Runs once containers are healthy
+	PreDown     []Hook `yaml:"pre_down,omitempty"`
+	PreDestroy  []Hook `yaml:"pre_destroy,omitempty"`
+	PostDestroy []Hook `yaml:"post_destroy,omitempty"`
+}
+
+// Hook is a single lifecycle command. A plain string in YAML is shorthand
+// for a host hook with only Run set.
+type Hook struct {
+	Run       string `yaml:"run"`
+	Service   string `yaml:"service,omitempty"`    // Exec inside this service instead of on the host
+	OnFailure string `yaml:"on_failure,omitempty"` // "abort" (default) or "warn"
 }
 
 // EnvConfig controls env file handling for a project
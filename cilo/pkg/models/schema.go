@@ -0,0 +1,324 @@
+package models
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigSchema is the JSON Schema of .cilo/config.yml
+//
+//go:embed config.schema.json
+var ConfigSchema []byte
+
+// ErrInvalidConfig is wrapped by errors for a config that doesn't match
+// its schema
+var ErrInvalidConfig = errors.New("invalid config")
+
+// ConfigProblem is one thing wrong with a config file. Line is 0 when it
+// isn't about one place in the file.
+type ConfigProblem struct {
+	File    string `json:"file" yaml:"file"`
+	Line    int    `json:"line,omitempty" yaml:"line,omitempty"`
+	Message string `json:"message" yaml:"message"`
+}
+
+func (p ConfigProblem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Message)
+}
+
+// ConfigError lists what's wrong with a config file
+type ConfigError struct {
+	Problems []ConfigProblem
+}
+
+func (e *ConfigError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = p.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *ConfigError) Unwrap() error { return ErrInvalidConfig }
+
+// schema is the subset of JSON Schema config.schema.json uses
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 schemaTypes        `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *additionalProps   `json:"additionalProperties"`
+	Required             []string           `json:"required"`
+	Items                *schema            `json:"items"`
+	Enum                 []string           `json:"enum"`
+	OneOf                []*schema          `json:"oneOf"`
+	Minimum              *float64           `json:"minimum"`
+	Pattern              string             `json:"pattern"`
+	Defs                 map[string]*schema `json:"$defs"`
+}
+
+// additionalProps is an additionalProperties keyword: false to reject
+// unknown fields, or the schema their values must match
+type additionalProps struct {
+	Allowed bool
+	Schema  *schema
+}
+
+func (a *additionalProps) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	return json.Unmarshal(data, &a.Schema)
+}
+
+// schemaTypes is a type keyword, a single type or a list of them
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*t = many
+	return nil
+}
+
+var configSchema = func() *schema {
+	var s schema
+	if err := json.Unmarshal(ConfigSchema, &s); err != nil {
+		panic(fmt.Sprintf("invalid config schema: %v", err))
+	}
+	return &s
+}()
+
+// validateConfig checks a parsed config document against the schema,
+// returning a problem for each unknown field and wrong value
+func validateConfig(file string, doc *yaml.Node) []ConfigProblem {
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return nil
+		}
+		doc = doc.Content[0]
+	}
+	v := &schemaValidator{file: file, root: configSchema}
+	v.check(configSchema, doc, "")
+	sort.SliceStable(v.problems, func(i, j int) bool { return v.problems[i].Line < v.problems[j].Line })
+	return v.problems
+}
+
+// CheckSchema checks a document against a JSON Schema written in the subset
+// of the standard config.schema.json uses. It also checks cilo's output
+// documents against the schemas published for them.
+func CheckSchema(schemaJSON []byte, file string, doc *yaml.Node) ([]ConfigProblem, error) {
+	var s schema
+	if err := json.Unmarshal(schemaJSON, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return nil, nil
+		}
+		doc = doc.Content[0]
+	}
+	v := &schemaValidator{file: file, root: &s}
+	v.check(&s, doc, "")
+	return v.problems, nil
+}
+
+type schemaValidator struct {
+	file     string
+	root     *schema // Resolves $ref
+	problems []ConfigProblem
+}
+
+func (v *schemaValidator) fail(node *yaml.Node, path, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if path != "" {
+		message = path + ": " + message
+	}
+	v.problems = append(v.problems, ConfigProblem{File: v.file, Line: node.Line, Message: message})
+}
+
+func (v *schemaValidator) check(s *schema, node *yaml.Node, path string) {
+	if s.Ref != "" {
+		s = v.root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	// An empty value is the same as leaving the field out
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	if len(s.OneOf) > 0 {
+		for _, option := range s.OneOf {
+			if typeMatches(option.Type, node) {
+				v.check(option, node, path)
+				return
+			}
+		}
+		var types []string
+		for _, option := range s.OneOf {
+			types = append(types, option.Type...)
+		}
+		v.fail(node, path, "expected %s", describeTypes(types))
+		return
+	}
+	if len(s.Type) > 0 && !typeMatches(s.Type, node) {
+		v.fail(node, path, "expected %s", describeTypes(s.Type))
+		return
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		v.checkObject(s, node, path)
+	case yaml.SequenceNode:
+		if s.Items != nil {
+			for i, item := range node.Content {
+				v.check(s.Items, item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case yaml.ScalarNode:
+		if len(s.Enum) > 0 && !contains(s.Enum, node.Value) {
+			v.fail(node, path, "%q is not one of %s", node.Value, strings.Join(s.Enum, ", "))
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(node.Value) {
+			v.fail(node, path, "%q doesn't match %s", node.Value, s.Pattern)
+		}
+		if s.Minimum != nil {
+			if n, err := strconv.ParseFloat(node.Value, 64); err == nil && n < *s.Minimum {
+				v.fail(node, path, "%s is below the minimum %v", node.Value, *s.Minimum)
+			}
+		}
+	}
+}
+
+func (v *schemaValidator) checkObject(s *schema, node *yaml.Node, path string) {
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		field := key.Value
+		if path != "" {
+			field = path + "." + key.Value
+		}
+		if seen[key.Value] {
+			v.fail(key, "", "%s is set twice", field)
+			continue
+		}
+		seen[key.Value] = true
+
+		prop, ok := s.Properties[key.Value]
+		if !ok && s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+			prop, ok = s.AdditionalProperties.Schema, true
+		}
+		if !ok {
+			if s.AdditionalProperties != nil && !s.AdditionalProperties.Allowed {
+				message := fmt.Sprintf("unknown field %q", field)
+				if guess := closest(key.Value, s.Properties); guess != "" {
+					message += fmt.Sprintf(" (did you mean %q?)", guess)
+				}
+				v.fail(key, "", "%s", message)
+			}
+			continue
+		}
+		v.check(prop, value, field)
+	}
+	for _, name := range s.Required {
+		if !seen[name] {
+			v.fail(node, path, "missing required field %q", name)
+		}
+	}
+}
+
+// typeMatches reports whether a node is one of the JSON Schema types.
+// Scalars read as strings whatever they look like, as yaml.v3 decodes them.
+func typeMatches(types schemaTypes, node *yaml.Node) bool {
+	for _, t := range types {
+		switch {
+		case t == "object" && node.Kind == yaml.MappingNode,
+			t == "array" && node.Kind == yaml.SequenceNode,
+			t == "string" && node.Kind == yaml.ScalarNode,
+			t == "boolean" && node.Kind == yaml.ScalarNode && node.Tag == "!!bool",
+			t == "integer" && node.Kind == yaml.ScalarNode && node.Tag == "!!int",
+			t == "number" && node.Kind == yaml.ScalarNode && (node.Tag == "!!int" || node.Tag == "!!float"):
+			return true
+		}
+	}
+	return false
+}
+
+func describeTypes(types []string) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		switch t {
+		case "object":
+			names[i] = "a mapping"
+		case "array":
+			names[i] = "a list"
+		case "integer":
+			names[i] = "an integer"
+		default:
+			names[i] = "a " + t
+		}
+	}
+	return strings.Join(names, " or ")
+}
+
+// closest returns the known field a misspelt one is most likely meant to
+// be, or "" if none is close
+func closest(name string, known map[string]*schema) string {
+	best, bestDistance := "", 3
+	for candidate := range known {
+		if d := editDistance(name, candidate); d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func contains(slice []string, value string) bool {
+	for _, s := range slice {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
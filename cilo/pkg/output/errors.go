@@ -0,0 +1,83 @@
+package output
+
+import (
+	"errors"
+
+	"github.com/sharedco/cilo/pkg/git"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/runtime"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// Error codes reported in error documents. Each maps to a process exit code
+// (see ExitCode); both are part of cilo's interface, so don't renumber them.
+const (
+	CodeError              = "error"
+	CodeNotFound           = "not_found"           // Environment (or other named object) doesn't exist
+	CodeConflict           = "conflict"            // Already exists, merge/sync conflict, dirty host repo
+	CodeRuntimeUnavailable = "runtime_unavailable" // Docker daemon unreachable
+	CodeNotInitialized     = "not_initialized"     // `cilo init` hasn't been run
+	CodeOverBudget         = "over_budget"         // Starting the environment would exceed the host budget
+	CodeStateCorrupt       = "state_corrupt"       // state.json can't be parsed; `cilo state repair` rebuilds it
+	CodeInvalidConfig      = "invalid_config"      // .cilo/config.yml doesn't match its schema; `cilo config validate` lists why
+)
+
+var exitCodes = map[string]int{
+	CodeError:              1,
+	CodeNotFound:           2,
+	CodeConflict:           3,
+	CodeRuntimeUnavailable: 4,
+	CodeNotInitialized:     5,
+	CodeOverBudget:         6,
+	CodeStateCorrupt:       7,
+	CodeInvalidConfig:      8,
+}
+
+// ExitCode returns the process exit code for an error code
+func ExitCode(code string) int {
+	if exitCode, ok := exitCodes[code]; ok {
+		return exitCode
+	}
+	return exitCodes[CodeError]
+}
+
+// codedError attaches an error code to an error no sentinel covers
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// WithCode tags err with an error code
+func WithCode(code string, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+// ErrorCode classifies an error
+func ErrorCode(err error) string {
+	var coded *codedError
+	var conflict *git.ConflictError
+	var dirty *git.DirtyError
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, state.ErrNotInitialized):
+		return CodeNotInitialized
+	case errors.Is(err, runtime.ErrUnavailable):
+		return CodeRuntimeUnavailable
+	case errors.Is(err, state.ErrNotFound):
+		return CodeNotFound
+	case errors.Is(err, state.ErrOverBudget):
+		return CodeOverBudget
+	case errors.Is(err, state.ErrCorrupt):
+		return CodeStateCorrupt
+	case errors.Is(err, models.ErrInvalidConfig):
+		return CodeInvalidConfig
+	case errors.Is(err, state.ErrAlreadyExists), errors.As(err, &conflict), errors.As(err, &dirty):
+		return CodeConflict
+	default:
+		return CodeError
+	}
+}
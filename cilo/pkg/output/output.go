@@ -0,0 +1,362 @@
+// Package output defines cilo's machine-readable documents.
+//
+// Every document carries schema_version and kind. Fields are only added
+// within a schema version; renaming or removing a field, or changing its
+// meaning, bumps SchemaVersion. output.schema.json describes each kind and
+// is kept in step with the types here.
+package output
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/state"
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaVersion is the version of every document in this package
+const SchemaVersion = 1
+
+// Schema is the JSON Schema of the documents, with one $defs entry per kind
+//
+//go:embed output.schema.json
+var Schema []byte
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Document kinds
+const (
+	KindEnvironment      = "environment"
+	KindEnvironmentList  = "environment_list"
+	KindDoctorReport     = "doctor_report"
+	KindNetworkStatus    = "network_status"
+	KindProjectConfig    = "project_config"
+	KindConfigValidation = "config_validation"
+	KindExecResult       = "exec_result"
+	KindError            = "error"
+)
+
+// ValidateFormat checks a user-supplied --output value
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatText, FormatJSON, FormatYAML:
+		return nil
+	default:
+		return fmt.Errorf("invalid output format %q (must be text, json or yaml)", format)
+	}
+}
+
+// Header identifies a document's schema
+type Header struct {
+	SchemaVersion int    `json:"schema_version" yaml:"schema_version"`
+	Kind          string `json:"kind" yaml:"kind"`
+}
+
+func header(kind string) Header {
+	return Header{SchemaVersion: SchemaVersion, Kind: kind}
+}
+
+// Service is a service within an environment
+type Service struct {
+	Name      string   `json:"name" yaml:"name"`
+	Type      string   `json:"type" yaml:"type"` // "isolated" or "shared"
+	IP        string   `json:"ip" yaml:"ip"`
+	Container string   `json:"container,omitempty" yaml:"container,omitempty"`
+	URL       string   `json:"url,omitempty" yaml:"url,omitempty"`
+	Ingress   bool     `json:"ingress" yaml:"ingress"`
+	Hostnames []string `json:"hostnames,omitempty" yaml:"hostnames,omitempty"`
+}
+
+// Environment is a single environment
+type Environment struct {
+	Header       `yaml:",inline"`
+	Name         string                `json:"name" yaml:"name"`
+	Project      string                `json:"project" yaml:"project"`
+	Status       string                `json:"status" yaml:"status"`
+	CreatedAt    time.Time             `json:"created_at" yaml:"created_at"`
+	LastActivity *time.Time            `json:"last_activity,omitempty" yaml:"last_activity,omitempty"`
+	ExpiresAt    *time.Time            `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+	Host         string                `json:"host,omitempty" yaml:"host,omitempty"`
+	Subnet       string                `json:"subnet" yaml:"subnet"`
+	DNSSuffix    string                `json:"dns_suffix,omitempty" yaml:"dns_suffix,omitempty"`
+	Source       string                `json:"source,omitempty" yaml:"source,omitempty"`
+	Workspace    string                `json:"workspace" yaml:"workspace"`
+	Services     []Service             `json:"services" yaml:"services"`
+	SourceRepos  []models.RepoSnapshot `json:"source_repos,omitempty" yaml:"source_repos,omitempty"`
+}
+
+// NewEnvironment builds an environment document. Services are sorted by name.
+func NewEnvironment(env *models.Environment, workspace string) Environment {
+	doc := Environment{
+		Header:      header(KindEnvironment),
+		Name:        env.Name,
+		Project:     env.Project,
+		Status:      env.Status,
+		CreatedAt:   env.CreatedAt,
+		Host:        env.Host,
+		Subnet:      env.Subnet,
+		DNSSuffix:   env.DNSSuffix,
+		Source:      env.Source,
+		Workspace:   workspace,
+		Services:    []Service{},
+		SourceRepos: env.SourceRepos,
+	}
+	if !env.LastActivity.IsZero() {
+		lastActivity := env.LastActivity
+		doc.LastActivity = &lastActivity
+	}
+	if !env.ExpiresAt.IsZero() {
+		expiresAt := env.ExpiresAt
+		doc.ExpiresAt = &expiresAt
+	}
+
+	names := make([]string, 0, len(env.Services))
+	for name := range env.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		svc := env.Services[name]
+		serviceType := "isolated"
+		for _, shared := range env.UsesSharedServices {
+			if shared == name {
+				serviceType = "shared"
+			}
+		}
+		doc.Services = append(doc.Services, Service{
+			Name:      svc.Name,
+			Type:      serviceType,
+			IP:        svc.IP,
+			Container: svc.Container,
+			URL:       svc.URL,
+			Ingress:   svc.IsIngress,
+			Hostnames: svc.Hostnames,
+		})
+	}
+	return doc
+}
+
+// EnvironmentList is a list of environments
+type EnvironmentList struct {
+	Header `yaml:",inline"`
+	Items  []Environment `json:"items" yaml:"items"`
+}
+
+// NewEnvironmentList wraps environment documents in a list
+func NewEnvironmentList(items []Environment) EnvironmentList {
+	if items == nil {
+		items = []Environment{}
+	}
+	return EnvironmentList{Header: header(KindEnvironmentList), Items: items}
+}
+
+// SharedService is a container shared between environments
+type SharedService struct {
+	Name      string    `json:"name" yaml:"name"`
+	Project   string    `json:"project" yaml:"project"`
+	Container string    `json:"container" yaml:"container"`
+	IP        string    `json:"ip" yaml:"ip"`
+	Image     string    `json:"image,omitempty" yaml:"image,omitempty"`
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+	UsedBy    []string  `json:"used_by" yaml:"used_by"`
+	// Set while the service waits out its grace period after the last env left
+	StopsAt *time.Time `json:"stops_at,omitempty" yaml:"stops_at,omitempty"`
+}
+
+// NewSharedService builds a shared service document
+func NewSharedService(svc *models.SharedService) SharedService {
+	doc := SharedService{
+		Name:      svc.Name,
+		Project:   svc.Project,
+		Container: svc.Container,
+		IP:        svc.IP,
+		Image:     svc.Image,
+		CreatedAt: svc.CreatedAt,
+		UsedBy:    svc.UsedBy,
+	}
+	if doc.UsedBy == nil {
+		doc.UsedBy = []string{}
+	}
+	if !svc.DisconnectTimeout.IsZero() {
+		stopsAt := svc.DisconnectTimeout
+		doc.StopsAt = &stopsAt
+	}
+	return doc
+}
+
+// Check is the outcome of one doctor check
+type Check struct {
+	Name   string `json:"name" yaml:"name"`
+	OK     bool   `json:"ok" yaml:"ok"`
+	Detail string `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+// Issue is a problem doctor found
+type Issue struct {
+	Category string `json:"category" yaml:"category"` // "reconcile", "orphan" or "shared_service"
+	Type     string `json:"type" yaml:"type"`
+	Name     string `json:"name,omitempty" yaml:"name,omitempty"`
+	Detail   string `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+// DoctorReport is the result of `cilo doctor`. Healthy reflects what was
+// found before any fixes; Fixes lists the repairs --fix attempted.
+type DoctorReport struct {
+	Header         `yaml:",inline"`
+	Healthy        bool            `json:"healthy" yaml:"healthy"`
+	Checks         []Check         `json:"checks" yaml:"checks"`
+	Environments   int             `json:"environments" yaml:"environments"`
+	NotRunning     []string        `json:"not_running" yaml:"not_running"`
+	Issues         []Issue         `json:"issues" yaml:"issues"`
+	SharedServices []SharedService `json:"shared_services" yaml:"shared_services"`
+	Fixes          []Check         `json:"fixes" yaml:"fixes"`
+}
+
+// NewDoctorReport returns an empty doctor report
+func NewDoctorReport() *DoctorReport {
+	return &DoctorReport{
+		Header:         header(KindDoctorReport),
+		Checks:         []Check{},
+		NotRunning:     []string{},
+		Issues:         []Issue{},
+		SharedServices: []SharedService{},
+		Fixes:          []Check{},
+	}
+}
+
+// AddCheck records a check's outcome
+func (r *DoctorReport) AddCheck(name string, err error) {
+	check := Check{Name: name, OK: err == nil}
+	if err != nil {
+		check.Detail = err.Error()
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+// AddFix records a repair's outcome
+func (r *DoctorReport) AddFix(name string, detail string, err error) {
+	fix := Check{Name: name, OK: err == nil, Detail: detail}
+	if err != nil {
+		fix.Detail = err.Error()
+	}
+	r.Fixes = append(r.Fixes, fix)
+}
+
+// Finish computes Healthy from the checks and issues recorded so far
+func (r *DoctorReport) Finish() {
+	r.Healthy = len(r.Issues) == 0
+	for _, check := range r.Checks {
+		if !check.OK {
+			r.Healthy = false
+		}
+	}
+}
+
+// NetworkStatus is the result of `cilo network status`
+type NetworkStatus struct {
+	Header     `yaml:",inline"`
+	BaseSubnet string `json:"base_subnet" yaml:"base_subnet"`
+	DNSPort    int    `json:"dns_port" yaml:"dns_port"`
+	NextSubnet string `json:"next_subnet" yaml:"next_subnet"`
+}
+
+// NewNetworkStatus builds a network status document
+func NewNetworkStatus(st *models.State) NetworkStatus {
+	return NetworkStatus{
+		Header:     header(KindNetworkStatus),
+		BaseSubnet: st.BaseSubnet,
+		DNSPort:    st.DNSPort,
+		NextSubnet: state.NextSubnet(st),
+	}
+}
+
+// ProjectConfig wraps a project's config, merged from its layers. The
+// config keeps its YAML key names in every format. Sources, set by
+// 'cilo config --explain', gives the layer of each value.
+type ProjectConfig struct {
+	Header  `yaml:",inline"`
+	Config  map[string]interface{}         `json:"config" yaml:"config"`
+	Sources map[string]models.ConfigSource `json:"sources,omitempty" yaml:"sources,omitempty"`
+}
+
+// NewProjectConfig builds a project config document
+func NewProjectConfig(cfg *models.ProjectConfig) (ProjectConfig, error) {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return ProjectConfig{}, err
+	}
+	fields := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return ProjectConfig{}, err
+	}
+	return ProjectConfig{Header: header(KindProjectConfig), Config: fields}, nil
+}
+
+// ConfigValidation is the outcome of checking a project's config
+type ConfigValidation struct {
+	Header   `yaml:",inline"`
+	Valid    bool                   `json:"valid" yaml:"valid"`
+	Problems []models.ConfigProblem `json:"problems" yaml:"problems"`
+}
+
+// NewConfigValidation builds a config validation document
+func NewConfigValidation(problems []models.ConfigProblem) ConfigValidation {
+	if problems == nil {
+		problems = []models.ConfigProblem{}
+	}
+	return ConfigValidation{Header: header(KindConfigValidation), Valid: len(problems) == 0, Problems: problems}
+}
+
+// ExecResult is the outcome of a command run in a service
+type ExecResult struct {
+	Header   `yaml:",inline"`
+	ExitCode int    `json:"exit_code" yaml:"exit_code"`
+	Stdout   string `json:"stdout" yaml:"stdout"`
+	Stderr   string `json:"stderr" yaml:"stderr"`
+}
+
+// NewExecResult builds an exec result document
+func NewExecResult(exitCode int, stdout, stderr string) ExecResult {
+	return ExecResult{Header: header(KindExecResult), ExitCode: exitCode, Stdout: stdout, Stderr: stderr}
+}
+
+// Error describes a failed command
+type Error struct {
+	Header   `yaml:",inline"`
+	Code     string `json:"code" yaml:"code"`
+	ExitCode int    `json:"exit_code" yaml:"exit_code"`
+	Message  string `json:"message" yaml:"message"`
+}
+
+// NewError builds an error document
+func NewError(err error) Error {
+	code := ErrorCode(err)
+	return Error{Header: header(KindError), Code: code, ExitCode: ExitCode(code), Message: err.Error()}
+}
+
+// Write encodes a document as JSON or YAML
+func Write(w io.Writer, format string, doc interface{}) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(doc)
+	case FormatYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		return encoder.Encode(doc)
+	default:
+		return fmt.Errorf("format %q is not a structured output format", format)
+	}
+}
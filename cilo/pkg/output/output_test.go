@@ -0,0 +1,203 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/git"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/runtime"
+	"github.com/sharedco/cilo/pkg/state"
+	"gopkg.in/yaml.v3"
+)
+
+func TestEnvironmentDocument(t *testing.T) {
+	env := &models.Environment{
+		Name:    "feat",
+		Project: "shop",
+		Status:  "running",
+		Subnet:  "10.224.3.0/24",
+		Services: map[string]*models.Service{
+			"web": {Name: "web", IP: "10.224.3.2", IsIngress: true},
+			"db":  {Name: "db", IP: "10.224.3.3"},
+		},
+		UsesSharedServices: []string{"db"},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatJSON, NewEnvironment(env, "/ws")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded["schema_version"] != float64(SchemaVersion) || decoded["kind"] != KindEnvironment {
+		t.Fatalf("unexpected header: %v", decoded)
+	}
+	services := decoded["services"].([]interface{})
+	first := services[0].(map[string]interface{})
+	if first["name"] != "db" || first["type"] != "shared" {
+		t.Fatalf("expected services sorted with db shared first, got %v", services)
+	}
+
+	buf.Reset()
+	if err := Write(&buf, FormatYAML, NewEnvironment(env, "/ws")); err != nil {
+		t.Fatalf("Write yaml: %v", err)
+	}
+	var fromYAML map[string]interface{}
+	if err := yaml.Unmarshal(buf.Bytes(), &fromYAML); err != nil {
+		t.Fatalf("unmarshal yaml: %v", err)
+	}
+	if fromYAML["schema_version"] != SchemaVersion || fromYAML["workspace"] != "/ws" {
+		t.Fatalf("expected inline header in yaml, got %v", fromYAML)
+	}
+}
+
+func TestProjectConfigUsesYAMLKeys(t *testing.T) {
+	doc, err := NewProjectConfig(&models.ProjectConfig{Project: "shop", ComposeFiles: []string{"compose.yml"}, DNSSuffix: ".dev"})
+	if err != nil {
+		t.Fatalf("NewProjectConfig: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatJSON, doc); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"dns_suffix": ".dev"`) {
+		t.Fatalf("expected yaml key names in json, got:\n%s", buf.String())
+	}
+}
+
+func TestDoctorReportHealth(t *testing.T) {
+	report := NewDoctorReport()
+	report.AddCheck("docker", nil)
+	report.Finish()
+	if !report.Healthy {
+		t.Fatalf("expected healthy report")
+	}
+
+	report.AddCheck("dnsmasq", errors.New("dnsmasq not running"))
+	report.Finish()
+	if report.Healthy {
+		t.Fatalf("expected failed check to make report unhealthy")
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	cases := map[error]string{
+		state.ErrNotInitialized:                                  CodeNotInitialized,
+		fmt.Errorf("lookup: %w", state.ErrNotFound):              CodeNotFound,
+		fmt.Errorf("%w: docker: down", runtime.ErrUnavailable):   CodeRuntimeUnavailable,
+		&git.ConflictError{Repo: "app", Files: []string{"a.go"}}: CodeConflict,
+		WithCode(CodeConflict, errors.New("sync conflict")):      CodeConflict,
+		fmt.Errorf("admit: %w", state.ErrOverBudget):             CodeOverBudget,
+		fmt.Errorf("load: %w", state.ErrCorrupt):                 CodeStateCorrupt,
+		&models.ConfigError{}:                                    CodeInvalidConfig,
+		errors.New("boom"):                                       CodeError,
+	}
+	for err, want := range cases {
+		if got := ErrorCode(err); got != want {
+			t.Errorf("ErrorCode(%v) = %s, want %s", err, got, want)
+		}
+	}
+	if doc := NewError(state.ErrNotInitialized); doc.ExitCode != 5 {
+		t.Fatalf("expected not_initialized to exit 5, got %d", doc.ExitCode)
+	}
+}
+
+// checkSchema validates a document, written as JSON, against its kind's
+// definition in the published schema
+func checkSchema(t *testing.T, kind string, doc interface{}) {
+	t.Helper()
+	var published map[string]interface{}
+	if err := json.Unmarshal(Schema, &published); err != nil {
+		t.Fatalf("unmarshal schema: %v", err)
+	}
+	defs := published["$defs"].(map[string]interface{})
+	if _, ok := defs[kind]; !ok {
+		t.Fatalf("schema has no definition for kind %q", kind)
+	}
+	kindSchema, err := json.Marshal(map[string]interface{}{"$ref": "#/$defs/" + kind, "$defs": defs})
+	if err != nil {
+		t.Fatalf("marshal schema: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatJSON, doc); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(buf.Bytes(), &node); err != nil {
+		t.Fatalf("unmarshal document: %v", err)
+	}
+	problems, err := models.CheckSchema(kindSchema, kind, &node)
+	if err != nil {
+		t.Fatalf("CheckSchema: %v", err)
+	}
+	for _, problem := range problems {
+		t.Errorf("%s document doesn't match the schema: %s", kind, problem.Message)
+	}
+}
+
+func TestDocumentsMatchSchema(t *testing.T) {
+	now := time.Now().UTC()
+	env := &models.Environment{
+		Name:         "feat",
+		Project:      "shop",
+		Status:       "running",
+		CreatedAt:    now,
+		LastActivity: now,
+		ExpiresAt:    now.Add(time.Hour),
+		Host:         "build1",
+		Subnet:       "10.224.3.0/24",
+		DNSSuffix:    ".test",
+		Source:       "/src/shop",
+		Services: map[string]*models.Service{
+			"web": {Name: "web", IP: "10.224.3.2", Container: "shop_feat_web_1", URL: "http://shop.feat.test", IsIngress: true, Hostnames: []string{"www"}},
+			"db":  {Name: "db", IP: "10.224.3.3"},
+		},
+		UsesSharedServices: []string{"db"},
+		SourceRepos:        []models.RepoSnapshot{{Path: ".", Commit: "abc123", Branch: "main", Dirty: true}},
+	}
+	environment := NewEnvironment(env, "/ws")
+
+	report := NewDoctorReport()
+	report.AddCheck("docker", nil)
+	report.AddCheck("dnsmasq", errors.New("dnsmasq not running"))
+	report.Environments = 1
+	report.NotRunning = []string{"shop/feat"}
+	report.Issues = append(report.Issues, Issue{Category: "orphan", Type: "workspace", Name: "shop/old", Detail: "no state entry"})
+	report.SharedServices = append(report.SharedServices, NewSharedService(&models.SharedService{
+		Name: "db", Project: "shop", Container: "cilo_shared_shop_db", IP: "10.224.3.3", Image: "postgres:16",
+		CreatedAt: now, UsedBy: []string{"feat"}, DisconnectTimeout: now.Add(time.Minute),
+	}))
+	report.AddFix("orphans", "", nil)
+	report.Finish()
+
+	config, err := NewProjectConfig(&models.ProjectConfig{Project: "shop", ComposeFiles: []string{"compose.yml"}, DNSSuffix: ".test"})
+	if err != nil {
+		t.Fatalf("NewProjectConfig: %v", err)
+	}
+	config.Sources = map[string]models.ConfigSource{"project": {Layer: "project", File: ".cilo/config.yml", Line: 1}}
+
+	docs := map[string]interface{}{
+		KindEnvironment:      environment,
+		KindEnvironmentList:  NewEnvironmentList([]Environment{environment}),
+		KindDoctorReport:     report,
+		KindNetworkStatus:    NetworkStatus{Header: header(KindNetworkStatus), BaseSubnet: "10.224.", DNSPort: 5354, NextSubnet: "10.224.4.0/24"},
+		KindProjectConfig:    config,
+		KindConfigValidation: NewConfigValidation([]models.ConfigProblem{{File: ".cilo/config.yml", Line: 3, Message: "unknown field"}}),
+		KindExecResult:       NewExecResult(1, "out", "err"),
+		KindError:            NewError(state.ErrNotFound),
+	}
+	for kind, doc := range docs {
+		checkSchema(t, kind, doc)
+	}
+	checkSchema(t, KindEnvironmentList, NewEnvironmentList(nil))
+	checkSchema(t, KindConfigValidation, NewConfigValidation(nil))
+}
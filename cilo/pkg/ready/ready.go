@@ -0,0 +1,226 @@
+package ready
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Readiness labels read from compose services
+const (
+	LabelTCP     = "cilo.ready.tcp"     // Port to dial, e.g. "5432"
+	LabelHTTP    = "cilo.ready.http"    // Port and optional path, e.g. "8080/healthz"
+	LabelTimeout = "cilo.ready.timeout" // Per-service timeout, e.g. "90s"
+)
+
+// DefaultTimeout is how long a service may take to become ready
+const DefaultTimeout = 2 * time.Minute
+
+const (
+	pollInterval = 500 * time.Millisecond
+	probeTimeout = 2 * time.Second
+)
+
+// Checker reports a container's state ("running", "exited", ...), its
+// healthcheck status, which is empty when the container has no healthcheck,
+// and its exit code once it has exited
+type Checker interface {
+	GetContainerHealth(ctx context.Context, containerName string) (status, health string, exitCode int, err error)
+}
+
+// Target is a service to wait for
+type Target struct {
+	Service   string
+	Container string
+	IP        string            // Address probes are sent to
+	Labels    map[string]string // Compose labels, for cilo.ready.* probes
+}
+
+// Update reports progress for one service
+type Update struct {
+	Service string
+	Status  string // What the service is waiting on
+	Ready   bool
+	Elapsed time.Duration
+}
+
+// Options controls Wait
+type Options struct {
+	Timeout  time.Duration // Per-service default; cilo.ready.timeout overrides it
+	Progress func(Update)  // Called when a service's status changes; may be nil
+}
+
+// Error lists the services that did not become ready
+type Error struct {
+	Failures map[string]string // Service -> reason
+}
+
+func (e *Error) Error() string {
+	names := make([]string, 0, len(e.Failures))
+	for name := range e.Failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s (%s)", name, e.Failures[name]))
+	}
+	return "services not ready: " + strings.Join(parts, ", ")
+}
+
+// Wait blocks until every target is ready. A target is ready once its
+// container is running, its compose healthcheck (if any) reports healthy,
+// and its cilo.ready.tcp/cilo.ready.http probes (if any) succeed. As with
+// docker compose up --wait, a container that exited with code 0, such as a
+// migration, is done rather than failed.
+func Wait(ctx context.Context, checker Checker, targets []Target, opts Options) error {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		failures = map[string]string{}
+	)
+	report := func(u Update) {
+		if opts.Progress == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		opts.Progress(u)
+	}
+
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target Target) {
+			defer wg.Done()
+			if err := waitOne(ctx, checker, target, opts.Timeout, report); err != nil {
+				mu.Lock()
+				failures[target.Service] = err.Error()
+				mu.Unlock()
+			}
+		}(target)
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		return &Error{Failures: failures}
+	}
+	return nil
+}
+
+func waitOne(ctx context.Context, checker Checker, target Target, timeout time.Duration, report func(Update)) error {
+	if value := target.Labels[LabelTimeout]; value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", LabelTimeout, value)
+		}
+		timeout = d
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	lastStatus := ""
+	for {
+		status, done, err := check(ctx, checker, target)
+		if err != nil {
+			return err
+		}
+		if done {
+			if status == "" {
+				status = "ready"
+			}
+			report(Update{Service: target.Service, Status: status, Ready: true, Elapsed: time.Since(start)})
+			return nil
+		}
+		if status != lastStatus {
+			report(Update{Service: target.Service, Status: status, Elapsed: time.Since(start)})
+			lastStatus = status
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s: %s", timeout, lastStatus)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// check runs one round of checks. It returns what the target is waiting on,
+// whether it is ready, and an error only for failures that won't recover.
+func check(ctx context.Context, checker Checker, target Target) (string, bool, error) {
+	state, health, exitCode, err := checker.GetContainerHealth(ctx, target.Container)
+	if err != nil {
+		return "waiting for container", false, nil
+	}
+	switch state {
+	case "running":
+	case "exited":
+		if exitCode == 0 {
+			return "completed", true, nil
+		}
+		return "", false, fmt.Errorf("container exited with code %d", exitCode)
+	case "dead":
+		return "", false, fmt.Errorf("container %s", state)
+	default:
+		return "container " + state, false, nil
+	}
+	if health != "" && health != "healthy" {
+		return "health: " + health, false, nil
+	}
+
+	if port := target.Labels[LabelTCP]; port != "" {
+		if err := probeTCP(ctx, target.IP, port); err != nil {
+			return "tcp " + port, false, nil
+		}
+	}
+	if spec := target.Labels[LabelHTTP]; spec != "" {
+		if err := probeHTTP(ctx, target.IP, spec); err != nil {
+			return "http " + spec, false, nil
+		}
+	}
+	return "", true, nil
+}
+
+func probeTCP(ctx context.Context, ip, port string) error {
+	dialer := net.Dialer{Timeout: probeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// probeHTTP expects a 2xx or 3xx from http://<ip>:<spec>, where spec is a
+// port optionally followed by a path
+func probeHTTP(ctx context.Context, ip, spec string) error {
+	port, path := spec, "/"
+	if i := strings.Index(spec, "/"); i >= 0 {
+		port, path = spec[:i], spec[i:]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+net.JoinHostPort(ip, port)+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package ready
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeChecker returns canned container states, advancing one step per call
+type fakeChecker struct {
+	mu        sync.Mutex
+	states    map[string][][2]string
+	exitCodes map[string]int
+}
+
+func (f *fakeChecker) GetContainerHealth(ctx context.Context, container string) (string, string, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	steps := f.states[container]
+	if len(steps) == 0 {
+		return "", "", 0, errors.New("no such container")
+	}
+	step := steps[0]
+	if len(steps) > 1 {
+		f.states[container] = steps[1:]
+	}
+	return step[0], step[1], f.exitCodes[container], nil
+}
+
+func TestWait_HealthcheckAndProbes(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	_, tcpPort, _ := net.SplitHostPort(listener.Addr().String())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	_, httpPort, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	checker := &fakeChecker{states: map[string][][2]string{
+		"db":  {{"running", "starting"}, {"running", "healthy"}},
+		"tcp": {{"created", ""}, {"running", ""}},
+		"api": {{"running", ""}},
+	}}
+	targets := []Target{
+		{Service: "db", Container: "db"},
+		{Service: "tcp", Container: "tcp", IP: "127.0.0.1", Labels: map[string]string{LabelTCP: tcpPort}},
+		{Service: "api", Container: "api", IP: "127.0.0.1", Labels: map[string]string{LabelHTTP: httpPort + "/healthz"}},
+	}
+
+	readyServices := map[string]bool{}
+	err = Wait(context.Background(), checker, targets, Options{
+		Timeout: 5 * time.Second,
+		Progress: func(u Update) {
+			if u.Ready {
+				readyServices[u.Service] = true
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if len(readyServices) != 3 {
+		t.Fatalf("expected all services reported ready, got %v", readyServices)
+	}
+}
+
+func TestWait_FailuresAndTimeouts(t *testing.T) {
+	checker := &fakeChecker{
+		states: map[string][][2]string{
+			"crashed": {{"exited", ""}},
+			"slow":    {{"running", "starting"}},
+		},
+		exitCodes: map[string]int{"crashed": 1},
+	}
+	targets := []Target{
+		{Service: "crashed", Container: "crashed"},
+		{Service: "slow", Container: "slow", Labels: map[string]string{LabelTimeout: "200ms"}},
+	}
+
+	err := Wait(context.Background(), checker, targets, Options{Timeout: time.Minute})
+	var readyErr *Error
+	if !errors.As(err, &readyErr) {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	if !strings.Contains(readyErr.Failures["crashed"], "exited with code 1") {
+		t.Fatalf("unexpected crashed failure: %q", readyErr.Failures["crashed"])
+	}
+	if !strings.Contains(readyErr.Failures["slow"], "timed out after 200ms") {
+		t.Fatalf("unexpected slow failure: %q", readyErr.Failures["slow"])
+	}
+}
+
+func TestWait_OneShotServiceCompletes(t *testing.T) {
+	checker := &fakeChecker{states: map[string][][2]string{
+		"migrate": {{"created", ""}, {"exited", ""}},
+	}}
+
+	var last Update
+	err := Wait(context.Background(), checker, []Target{{Service: "migrate", Container: "migrate"}}, Options{
+		Timeout:  5 * time.Second,
+		Progress: func(u Update) { last = u },
+	})
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if !last.Ready || last.Status != "completed" {
+		t.Fatalf("expected migrate reported completed, got %+v", last)
+	}
+}
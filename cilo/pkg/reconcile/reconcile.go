@@ -3,12 +3,19 @@ package reconcile
 import (
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/sharedco/cilo/pkg/config"
+	"github.com/sharedco/cilo/pkg/dns"
 	"github.com/sharedco/cilo/pkg/models"
 	"github.com/sharedco/cilo/pkg/runtime"
 	"github.com/sharedco/cilo/pkg/runtime/docker"
+	"github.com/sharedco/cilo/pkg/state"
 )
 
 // Result contains reconciliation findings
@@ -19,11 +26,13 @@ type Result struct {
 	Errors         []error
 }
 
-// OrphanedResource represents a Docker resource not tracked in state
+// OrphanedResource represents a resource left behind by an environment or
+// shared service that is no longer tracked in state
 type OrphanedResource struct {
-	Type string // "network", "container"
-	Name string
-	ID   string
+	Type    string // "container", "shared_container", "network", "volume", "workspace" or "dns_entry"
+	Name    string // Workspaces are named by their path, DNS entries by hostname
+	ID      string
+	Project string // Compose project (cilo_<env>) of a container or volume
 }
 
 // Environment reconciles a single environment's state with runtime
@@ -35,11 +44,14 @@ func Environment(ctx context.Context, env *models.Environment, provider runtime.
 	}
 
 	// Update environment status based on running containers
-	hasRunning := false
+	hasRunning, hasPaused := false, false
 	for svcName, svcStatus := range status {
 		if svcStatus == "running" {
 			hasRunning = true
 		}
+		if svcStatus == "paused" {
+			hasPaused = true
+		}
 
 		// Update service in env
 		if svc, exists := env.Services[svcName]; exists {
@@ -54,37 +66,43 @@ func Environment(ctx context.Context, env *models.Environment, provider runtime.
 	}
 
 	// Update environment status
+	// An environment stopped for being idle stays suspended, so the next
+	// use resumes it
 	if hasRunning {
 		env.Status = "running"
-	} else if len(status) > 0 {
+	} else if hasPaused {
+		env.Status = "paused"
+	} else if len(status) > 0 && env.Status != "suspended" {
 		env.Status = "stopped"
 	}
 
 	return nil
 }
 
-// All reconciles all environments in state
+// All reconciles all environments in state, asking each host's daemon about
+// its own environments
 func All(ctx context.Context, state *models.State) *Result {
 	result := &Result{}
-	var provider runtime.Provider = docker.NewProvider()
-
-	// Collect all environments from all hosts
-	environments := make(map[string]*models.Environment)
 
 	for _, host := range state.Hosts {
+		provider := docker.NewProviderForHost(host)
+		environments := host.Environments
+		provider.SetEnvironmentResolver(func(project, name string) (*models.ProjectConfig, string, error) {
+			env, ok := environments[project+"/"+name]
+			if !ok {
+				return nil, "", nil
+			}
+			projectConfig, err := models.LoadEnvironmentConfig(env)
+			return projectConfig, env.Profile, err
+		})
 		for envKey, env := range host.Environments {
-			environments[envKey] = env
-		}
-	}
-
-	// Reconcile all environments
-	for envKey, env := range environments {
-		if err := Environment(ctx, env, provider); err != nil {
-			result.Errors = append(result.Errors, fmt.Errorf("%s: %w", envKey, err))
-		} else {
-			result.EnvsReconciled++
-			if env.Status != "running" {
-				result.EnvsNotRunning = append(result.EnvsNotRunning, envKey)
+			if err := Environment(ctx, env, provider); err != nil {
+				result.Errors = append(result.Errors, fmt.Errorf("%s: %w", envKey, err))
+			} else {
+				result.EnvsReconciled++
+				if env.Status != "running" {
+					result.EnvsNotRunning = append(result.EnvsNotRunning, envKey)
+				}
 			}
 		}
 	}
@@ -92,36 +110,57 @@ func All(ctx context.Context, state *models.State) *Result {
 	return result
 }
 
-// FindOrphans finds Docker resources with cilo labels not tracked in state
+// FindOrphans finds what environments and shared services leave behind once
+// they are no longer in state: the containers, networks and volumes of
+// environments, shared service containers, workspace directories and DNS
+// entries.
+//
+// Only the local daemon is asked; remote hosts are skipped, so what an
+// environment left on one has to be removed there. Environments on every
+// host still own what FindOrphans looks at, since their workspaces and DNS
+// entries live on this machine and a host may share the local daemon.
 func FindOrphans(ctx context.Context, state *models.State) ([]OrphanedResource, error) {
 	var orphans []OrphanedResource
+	owned := ownersOf(state)
+	tracked, workspaces := owned.networks, owned.workspaces
 
-	// Find orphaned networks
-	networks, err := findCiloNetworks(ctx)
+	// Containers and volumes carry their compose project, cilo_<env>
+	containers, err := findComposeResources(ctx, "container")
 	if err != nil {
 		return nil, err
 	}
+	for name, project := range containers {
+		if !tracked[project] {
+			orphans = append(orphans, OrphanedResource{Type: "container", Name: name, Project: project})
+		}
+	}
 
-	// Collect all environments for checking
-	environments := make(map[string]*models.Environment)
-
-	for _, host := range state.Hosts {
-		for envKey, env := range host.Environments {
-			environments[envKey] = env
+	shared, err := listSharedContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range shared {
+		if owned.shared[c.Name] {
+			continue
+		}
+		// Still attached to a live environment's network, so still in use
+		// even though state lost track of it
+		inUse := false
+		for _, net := range c.Networks {
+			inUse = inUse || tracked[net]
+		}
+		if !inUse {
+			orphans = append(orphans, OrphanedResource{Type: "shared_container", Name: c.Name})
 		}
 	}
 
+	// Find orphaned networks
+	networks, err := findCiloNetworks(ctx)
+	if err != nil {
+		return nil, err
+	}
 	for _, net := range networks {
-		// Check if network belongs to a tracked environment
-		found := false
-		for _, env := range environments {
-			expectedNetName := fmt.Sprintf("cilo_%s", env.Name)
-			if net == expectedNetName {
-				found = true
-				break
-			}
-		}
-		if !found {
+		if !tracked[net] {
 			orphans = append(orphans, OrphanedResource{
 				Type: "network",
 				Name: net,
@@ -129,9 +168,254 @@ func FindOrphans(ctx context.Context, state *models.State) ([]OrphanedResource,
 		}
 	}
 
+	volumes, err := findComposeResources(ctx, "volume")
+	if err != nil {
+		return nil, err
+	}
+	for name, project := range volumes {
+		if !tracked[project] {
+			orphans = append(orphans, OrphanedResource{Type: "volume", Name: name, Project: project})
+		}
+	}
+
+	dirs, err := findWorkspaces(workspaces)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if rel, _ := filepath.Rel(config.GetEnvsDir(), dir); !workspaces[rel] {
+			orphans = append(orphans, OrphanedResource{Type: "workspace", Name: dir})
+		}
+	}
+
+	stale, err := dns.StaleEntries(state)
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range stale {
+		orphans = append(orphans, OrphanedResource{Type: "dns_entry", Name: host})
+	}
+
+	sort.SliceStable(orphans, func(i, j int) bool {
+		return orphanOrder[orphans[i].Type] < orphanOrder[orphans[j].Type] ||
+			orphanOrder[orphans[i].Type] == orphanOrder[orphans[j].Type] && orphans[i].Name < orphans[j].Name
+	})
 	return orphans, nil
 }
 
+// owners is what state says exists, for telling orphans apart
+type owners struct {
+	networks   map[string]bool // cilo_<env>, the network and compose project of each environment
+	workspaces map[string]bool // <project>/<env>, each workspace's path under the envs dir
+	shared     map[string]bool // Shared service containers
+}
+
+func ownersOf(st *models.State) owners {
+	owned := owners{networks: map[string]bool{}, workspaces: map[string]bool{}, shared: map[string]bool{}}
+	for _, host := range st.Hosts {
+		for _, env := range host.Environments {
+			owned.networks[fmt.Sprintf("cilo_%s", env.Name)] = true
+			owned.workspaces[filepath.Join(env.Project, env.Name)] = true
+		}
+	}
+	for _, svc := range st.SharedServices {
+		owned.shared[svc.Container] = true
+	}
+	return owned
+}
+
+// orphaned reports whether o still belongs to nothing in state. A shared
+// container attached to a live environment's network is taken to have
+// been claimed again when FindOrphans found it.
+func (owned owners) orphaned(o OrphanedResource) bool {
+	switch o.Type {
+	case "container", "volume":
+		return !owned.networks[o.Project]
+	case "network":
+		return !owned.networks[o.Name]
+	case "shared_container":
+		return !owned.shared[o.Name]
+	case "workspace":
+		rel, _ := filepath.Rel(config.GetEnvsDir(), o.Name)
+		return !owned.workspaces[rel] && !kept(o.Name)
+	}
+	return true
+}
+
+// orphanOrder is the order orphans can be removed in: containers hold
+// their networks and volumes
+var orphanOrder = map[string]int{
+	"container":        0,
+	"shared_container": 1,
+	"network":          2,
+	"volume":           3,
+	"workspace":        4,
+	"dns_entry":        5,
+}
+
+// RemoveOrphans removes orphaned resources, containers first, returning
+// the ones it removed and an error for each it couldn't. Each is checked
+// against state again under the state lock, and removed while the lock is
+// held, so a resource an environment created since FindOrphans is kept.
+// Workspaces may hold work that was never merged, so they are moved under
+// OrphanedWorkspacesDir rather than deleted. DNS entries are removed by
+// regenerating the DNS config from state.
+func RemoveOrphans(ctx context.Context, orphans []OrphanedResource) ([]OrphanedResource, []error) {
+	sorted := append([]OrphanedResource(nil), orphans...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return orphanOrder[sorted[i].Type] < orphanOrder[sorted[j].Type]
+	})
+
+	var removed, staleDNS []OrphanedResource
+	var errs []error
+	for _, o := range sorted {
+		if o.Type == "dns_entry" {
+			staleDNS = append(staleDNS, o)
+			continue
+		}
+		err := state.WithReadLock(func(st *models.State) error {
+			if !ownersOf(st).orphaned(o) {
+				return nil
+			}
+			if err := removeOrphan(ctx, o); err != nil {
+				return err
+			}
+			removed = append(removed, o)
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(staleDNS) > 0 {
+		if err := state.WithReadLock(dns.UpdateDNSFromState); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove stale DNS entries: %w", err))
+		} else {
+			removed = append(removed, staleDNS...)
+		}
+	}
+	return removed, errs
+}
+
+// removeOrphan removes one orphaned resource other than a DNS entry
+func removeOrphan(ctx context.Context, o OrphanedResource) error {
+	var args []string
+	switch o.Type {
+	case "container", "shared_container":
+		args = []string{"rm", "-f", o.Name}
+	case "network":
+		args = []string{"network", "rm", o.Name}
+	case "volume":
+		args = []string{"volume", "rm", o.Name}
+	case "workspace":
+		return moveWorkspace(o.Name)
+	default:
+		return fmt.Errorf("%s %s: don't know how to remove it", o.Type, o.Name)
+	}
+	if output, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove %s %s: %s", o.Type, o.Name, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// OrphanedWorkspacesDir is where RemoveOrphans moves workspaces without an
+// environment
+func OrphanedWorkspacesDir() string {
+	return filepath.Join(config.GetCiloHome(), "orphaned")
+}
+
+// moveWorkspace moves a workspace from the envs directory to the same
+// project/name under OrphanedWorkspacesDir, freeing the name for a new
+// environment
+func moveWorkspace(dir string) error {
+	rel, err := filepath.Rel(config.GetEnvsDir(), dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("workspace %s is outside %s", dir, config.GetEnvsDir())
+	}
+	target := filepath.Join(OrphanedWorkspacesDir(), rel)
+	if _, err := os.Stat(target); err == nil {
+		target = fmt.Sprintf("%s-%d", target, time.Now().Unix())
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to move workspace %s: %w", dir, err)
+	}
+	if err := os.Rename(dir, target); err != nil {
+		return fmt.Errorf("failed to move workspace %s: %w", dir, err)
+	}
+	// Drop the project directory once its last workspace is gone
+	os.Remove(filepath.Dir(dir))
+	return nil
+}
+
+// KeptMarker is the file cilo destroy --keep-workspace leaves in a workspace,
+// relative to it. Marked workspaces belong to the user, so they are neither
+// orphans nor environments to recover.
+var KeptMarker = filepath.Join(".cilo", "kept")
+
+// findWorkspaces lists the <project>/<name> directories under the envs
+// directory, other than those marked with KeptMarker. A tracked environment
+// without a project has its workspace one level up, so its subdirectories
+// are skipped.
+func findWorkspaces(tracked map[string]bool) ([]string, error) {
+	projects, err := os.ReadDir(config.GetEnvsDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+
+	var dirs []string
+	for _, project := range projects {
+		if !project.IsDir() || tracked[project.Name()] {
+			continue
+		}
+		projectDir := filepath.Join(config.GetEnvsDir(), project.Name())
+		entries, err := os.ReadDir(projectDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list workspaces: %w", err)
+		}
+		for _, entry := range entries {
+			dir := filepath.Join(projectDir, entry.Name())
+			if entry.IsDir() && !kept(dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// kept reports whether a workspace was kept by cilo destroy --keep-workspace
+func kept(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, KeptMarker))
+	return err == nil
+}
+
+// findComposeResources maps the names of cilo environments' containers or
+// volumes to their compose project
+func findComposeResources(ctx context.Context, kind string) (map[string]string, error) {
+	const projectLabel = `{{.Label "com.docker.compose.project"}}`
+	args := []string{"ps", "-a", "--format", "{{.Names}}\t" + projectLabel}
+	if kind == "volume" {
+		args = []string{"volume", "ls", "--format", "{{.Name}}\t" + projectLabel}
+	}
+	args = append(args, "--filter", "label=com.docker.compose.project")
+
+	output, err := exec.CommandContext(ctx, "docker", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %ss: %w", kind, err)
+	}
+	resources := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		name, project, ok := strings.Cut(line, "\t")
+		if ok && strings.HasPrefix(project, "cilo_") {
+			resources[name] = project
+		}
+	}
+	return resources, nil
+}
+
 // findCiloNetworks finds all Docker networks with cilo label
 func findCiloNetworks(ctx context.Context) ([]string, error) {
 	cmd := exec.CommandContext(ctx, "docker", "network", "ls",
@@ -0,0 +1,163 @@
+package reconcile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sharedco/cilo/pkg/config"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+func TestOrphanedWorkspaces(t *testing.T) {
+	t.Setenv("CILO_USER_HOME", t.TempDir())
+	for _, dir := range []string{"shop/dev", "shop/old", "shop/kept/.cilo", "legacy/api"} {
+		if err := os.MkdirAll(filepath.Join(config.GetEnvsDir(), dir), 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+	}
+	// shop/kept was left by cilo destroy --keep-workspace
+	keptDir := filepath.Join(config.GetEnvsDir(), "shop", "kept")
+	if err := os.WriteFile(filepath.Join(keptDir, KeptMarker), nil, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// legacy is the workspace of an environment without a project
+	dirs, err := findWorkspaces(map[string]bool{"shop/dev": true, "legacy": true})
+	if err != nil {
+		t.Fatalf("findWorkspaces: %v", err)
+	}
+	var names []string
+	for _, dir := range dirs {
+		rel, _ := filepath.Rel(config.GetEnvsDir(), dir)
+		names = append(names, rel)
+	}
+	if got := strings.Join(names, ","); got != "shop/dev,shop/old" {
+		t.Fatalf("workspaces = %s", got)
+	}
+	if (owners{}).orphaned(OrphanedResource{Type: "workspace", Name: keptDir}) {
+		t.Fatalf("kept workspace is orphaned")
+	}
+
+	old := filepath.Join(config.GetEnvsDir(), "shop", "old")
+	if err := os.WriteFile(filepath.Join(old, "notes.txt"), []byte("unmerged"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := moveWorkspace(old); err != nil {
+		t.Fatalf("moveWorkspace: %v", err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("workspace still in envs dir: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(OrphanedWorkspacesDir(), "shop", "old", "notes.txt"))
+	if err != nil || string(data) != "unmerged" {
+		t.Fatalf("moved workspace content = %q, %v", data, err)
+	}
+
+	if err := moveWorkspace(t.TempDir()); err == nil {
+		t.Fatalf("moveWorkspace outside the envs dir succeeded")
+	}
+}
+
+func TestRemoveOrphansRechecksState(t *testing.T) {
+	t.Setenv("CILO_USER_HOME", t.TempDir())
+	if err := os.MkdirAll(config.GetCiloHome(), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := state.InitializeState("10.224.", 5354); err != nil {
+		t.Fatalf("InitializeState: %v", err)
+	}
+	var orphans []OrphanedResource
+	for _, dir := range []string{"shop/old", "shop/new"} {
+		path := filepath.Join(config.GetEnvsDir(), dir)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		orphans = append(orphans, OrphanedResource{Type: "workspace", Name: path})
+	}
+
+	// shop/new was created after the orphans were found
+	if _, err := state.CreateEnvironment("new", "/src/shop", "shop", ""); err != nil {
+		t.Fatalf("CreateEnvironment: %v", err)
+	}
+	removed, errs := RemoveOrphans(context.Background(), orphans)
+	if len(errs) > 0 {
+		t.Fatalf("RemoveOrphans: %v", errs)
+	}
+	if len(removed) != 1 || removed[0].Name != orphans[0].Name {
+		t.Fatalf("removed = %+v, want only shop/old", removed)
+	}
+	if _, err := os.Stat(orphans[1].Name); err != nil {
+		t.Fatalf("workspace of the new environment was moved: %v", err)
+	}
+
+	st, err := state.LoadState()
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	owned := ownersOf(st)
+	if owned.orphaned(OrphanedResource{Type: "container", Name: "cilo_new_web", Project: "cilo_new"}) {
+		t.Fatal("container of the new environment counted as orphaned")
+	}
+	if !owned.orphaned(OrphanedResource{Type: "volume", Name: "cilo_old_data", Project: "cilo_old"}) {
+		t.Fatal("volume of a destroyed environment not counted as orphaned")
+	}
+}
+
+func TestRestoreServices(t *testing.T) {
+	env := &models.Environment{Name: "dev", Project: "shop", Status: "created", Services: map[string]*models.Service{}}
+	containers := []composeContainer{
+		{Name: "cilo_dev_web", Env: "dev", Service: "web", State: "running"},
+		{Name: "cilo_dev_db", Env: "dev", Service: "db", State: "exited"},
+	}
+	addresses := map[string]string{
+		"web.dev.localhost":   "10.224.1.2",
+		"web.devx.localhost":  "10.224.9.2",
+		".shop.dev.localhost": "10.224.1.2",
+	}
+	restoreServices(env, containers, addresses)
+
+	if env.Status != "running" || env.DNSSuffix != ".localhost" {
+		t.Fatalf("status %s, suffix %s", env.Status, env.DNSSuffix)
+	}
+	if web := env.Services["web"]; web == nil || web.IP != "10.224.1.2" || web.Container != "cilo_dev_web" {
+		t.Fatalf("web = %+v", env.Services["web"])
+	}
+	if db := env.Services["db"]; db == nil || db.IP != "" {
+		t.Fatalf("db = %+v", env.Services["db"])
+	}
+
+	restoreServices(env, nil, addresses)
+	if env.Status != "stopped" {
+		t.Fatalf("status without containers = %s", env.Status)
+	}
+}
+
+func TestRestoreSharedServices(t *testing.T) {
+	st := &models.State{SharedServices: map[string]*models.SharedService{
+		"shop/redis": {Name: "redis", Container: "cilo_shared_shop_redis"},
+	}}
+	host := &models.Host{Environments: map[string]*models.Environment{
+		"shop/dev": {Name: "dev", Project: "shop"},
+		"shop/qa":  {Name: "qa", Project: "shop"},
+	}}
+	shared := []sharedContainer{
+		{Name: "cilo_shared_shop_redis", Project: "shop", Service: "redis", Networks: []string{"cilo_dev"}},
+		{Name: "cilo_shared_shop_db", Project: "shop", Service: "db", Image: "postgres:16", Networks: []string{"bridge", "cilo_qa", "cilo_dev"}},
+	}
+
+	notes := restoreSharedServices(st, host, shared)
+	if len(notes) != 1 {
+		t.Fatalf("notes = %v", notes)
+	}
+	db := st.SharedServices["shop/db"]
+	if db == nil || db.Image != "postgres:16" || strings.Join(db.UsedBy, ",") != "shop/dev,shop/qa" {
+		t.Fatalf("db = %+v", db)
+	}
+	if uses := host.Environments["shop/qa"].UsesSharedServices; len(uses) != 1 || uses[0] != "db" {
+		t.Fatalf("qa uses %v", uses)
+	}
+}
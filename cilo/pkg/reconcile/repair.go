@@ -0,0 +1,288 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/config"
+	"github.com/sharedco/cilo/pkg/dns"
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/state"
+)
+
+// composeContainer is a container Docker Compose started for an environment
+type composeContainer struct {
+	Name    string
+	Env     string // From the cilo_<env> compose project
+	Service string
+	State   string
+}
+
+// sharedContainer is a shared service container, from its labels
+type sharedContainer struct {
+	Name     string
+	Project  string
+	Service  string
+	Image    string
+	Networks []string
+}
+
+// Rebuild reconstructs the local host's environments and the shared
+// services from what lives outside state: workspace .cilo/meta.json files,
+// the containers' Docker labels and the DNS config. Entries already in base
+// (an empty state, or a backup) are kept while their workspace or
+// containers exist, and gaps in them are filled in. It returns the notes
+// describing each change it made to base.
+func Rebuild(ctx context.Context, base *models.State) ([]string, error) {
+	var notes []string
+	host := base.Hosts["local"]
+	if host == nil {
+		host = &models.Host{ID: "local", Provider: "docker", Environments: make(map[string]*models.Environment)}
+		base.Hosts["local"] = host
+	}
+
+	containers, err := listComposeContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byEnv := make(map[string][]composeContainer)
+	for _, c := range containers {
+		byEnv[c.Env] = append(byEnv[c.Env], c)
+	}
+	addresses, err := dns.ConfigAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	// Environments whose workspace and containers are both gone were
+	// destroyed after base was written
+	for key, env := range host.Environments {
+		if _, err := os.Stat(config.GetEnvPath(env.Project, env.Name)); err == nil || len(byEnv[env.Name]) > 0 {
+			continue
+		}
+		delete(host.Environments, key)
+		notes = append(notes, fmt.Sprintf("dropped %s: its workspace and containers are gone", key))
+	}
+
+	dirs, err := findWorkspaces(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		meta, err := readWorkspaceMeta(dir)
+		if err != nil {
+			rel, _ := filepath.Rel(config.GetEnvsDir(), dir)
+			notes = append(notes, fmt.Sprintf("skipped workspace %s: %v", rel, err))
+			continue
+		}
+		key := fmt.Sprintf("%s/%s", meta.Project, meta.Name)
+		// Remote environments keep their workspace here too, but their
+		// containers are out of reach, so only the host they're on is set
+		target := host
+		if meta.Host != "" && meta.Host != host.ID {
+			target = base.Hosts[meta.Host]
+			if target == nil {
+				notes = append(notes, fmt.Sprintf("skipped workspace %s: host %s isn't registered", key, meta.Host))
+				continue
+			}
+		}
+		if _, exists := target.Environments[key]; exists {
+			continue
+		}
+		target.Environments[key] = &models.Environment{
+			Name:        meta.Name,
+			Project:     meta.Project,
+			CreatedAt:   meta.CreatedAt,
+			Subnet:      meta.Subnet,
+			Status:      "created",
+			Source:      meta.Source,
+			Host:        meta.Host,
+			Services:    make(map[string]*models.Service),
+			SourceRepos: meta.SourceRepos,
+		}
+		notes = append(notes, fmt.Sprintf("recovered %s from its workspace", key))
+	}
+
+	for _, env := range host.Environments {
+		if env.Services == nil {
+			env.Services = make(map[string]*models.Service)
+		}
+		if base.BaseSubnet == "" {
+			if i := strings.LastIndex(strings.TrimSuffix(env.Subnet, ".0/24"), "."); i > 0 {
+				base.BaseSubnet = env.Subnet[:i+1]
+			}
+		}
+		restoreServices(env, byEnv[env.Name], addresses)
+	}
+	state.RecountSubnets(base)
+
+	shared, err := listSharedContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	notes = append(notes, restoreSharedServices(base, host, shared)...)
+
+	sort.Strings(notes)
+	return notes, nil
+}
+
+// restoreServices fills in an environment's services from its containers,
+// and their addresses and the environment's DNS suffix from the DNS
+// config, then sets its status from the containers'
+func restoreServices(env *models.Environment, containers []composeContainer, addresses map[string]string) {
+	running, paused := false, false
+	for _, c := range containers {
+		running = running || c.State == "running"
+		paused = paused || c.State == "paused"
+
+		svc := env.Services[c.Service]
+		if svc == nil {
+			svc = &models.Service{Name: c.Service, Container: c.Name}
+			env.Services[c.Service] = svc
+		}
+		if svc.IP != "" {
+			continue
+		}
+		prefix := fmt.Sprintf("%s.%s.", c.Service, env.Name)
+		for hostname, ip := range addresses {
+			if suffix, ok := strings.CutPrefix(hostname, prefix); ok {
+				svc.IP = ip
+				if env.DNSSuffix == "" {
+					env.DNSSuffix = "." + suffix
+				}
+				break
+			}
+		}
+	}
+
+	switch {
+	case running:
+		env.Status = "running"
+	case paused:
+		env.Status = "paused"
+	case len(containers) > 0 && env.Status != "suspended":
+		env.Status = "stopped"
+	case len(containers) == 0 && env.Status != "created":
+		env.Status = "stopped"
+	}
+}
+
+// restoreSharedServices adds shared service containers missing from state,
+// with the environments whose networks they are attached to as users
+func restoreSharedServices(st *models.State, host *models.Host, shared []sharedContainer) []string {
+	envsByNetwork := make(map[string]*models.Environment)
+	for _, env := range host.Environments {
+		envsByNetwork["cilo_"+env.Name] = env
+	}
+
+	var notes []string
+	for _, c := range shared {
+		key := fmt.Sprintf("%s/%s", c.Project, c.Service)
+		if c.Project == "" || c.Service == "" || st.SharedServices[key] != nil {
+			continue
+		}
+		svc := &models.SharedService{
+			Name:      c.Service,
+			Container: c.Name,
+			Project:   c.Project,
+			Image:     c.Image,
+			CreatedAt: time.Now(),
+			UsedBy:    []string{},
+		}
+		for _, network := range c.Networks {
+			env := envsByNetwork[network]
+			if env == nil {
+				continue
+			}
+			svc.UsedBy = append(svc.UsedBy, fmt.Sprintf("%s/%s", env.Project, env.Name))
+			if !contains(env.UsesSharedServices, c.Service) {
+				env.UsesSharedServices = append(env.UsesSharedServices, c.Service)
+			}
+		}
+		sort.Strings(svc.UsedBy)
+		st.SharedServices[key] = svc
+		notes = append(notes, fmt.Sprintf("recovered shared service %s from container %s", key, c.Name))
+	}
+	return notes
+}
+
+// readWorkspaceMeta reads the .cilo/meta.json cilo create writes
+func readWorkspaceMeta(dir string) (*models.WorkspaceMeta, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ".cilo", "meta.json"))
+	if err != nil {
+		return nil, fmt.Errorf("no readable .cilo/meta.json")
+	}
+	var meta models.WorkspaceMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid .cilo/meta.json: %w", err)
+	}
+	if meta.Name == "" || meta.Project == "" || meta.Subnet == "" {
+		return nil, fmt.Errorf(".cilo/meta.json lacks the name, project or subnet")
+	}
+	return &meta, nil
+}
+
+// listComposeContainers lists the containers of cilo environments' compose
+// projects
+func listComposeContainers(ctx context.Context) ([]composeContainer, error) {
+	output, err := exec.CommandContext(ctx, "docker", "ps", "-a",
+		"--filter", "label=com.docker.compose.project",
+		"--format", `{{.Names}}\t{{.Label "com.docker.compose.project"}}\t{{.Label "com.docker.compose.service"}}\t{{.State}}`).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	var containers []composeContainer
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			continue
+		}
+		env, ok := strings.CutPrefix(fields[1], "cilo_")
+		if !ok || fields[2] == "" {
+			continue
+		}
+		containers = append(containers, composeContainer{Name: fields[0], Env: env, Service: fields[2], State: fields[3]})
+	}
+	return containers, nil
+}
+
+// listSharedContainers lists shared service containers with their labels
+func listSharedContainers(ctx context.Context) ([]sharedContainer, error) {
+	output, err := exec.CommandContext(ctx, "docker", "ps", "-a",
+		"--filter", "label=cilo.shared=true",
+		"--format", `{{.Names}}\t{{.Label "cilo.project"}}\t{{.Label "cilo.service"}}\t{{.Image}}\t{{.Networks}}`).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shared containers: %w", err)
+	}
+	var containers []sharedContainer
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 {
+			continue
+		}
+		containers = append(containers, sharedContainer{
+			Name:     fields[0],
+			Project:  fields[1],
+			Service:  fields[2],
+			Image:    fields[3],
+			Networks: strings.Split(fields[4], ","),
+		})
+	}
+	return containers, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
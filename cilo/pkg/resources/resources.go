@@ -0,0 +1,191 @@
+// Package resources handles resource limits for environments and the
+// host-wide budget that bounds how many can run at once
+package resources
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+// ParseSize parses a size in docker's notation ("512m", "2g", "1024") into
+// bytes. Units are powers of 1024; a trailing "b" or "ib" is accepted.
+func ParseSize(s string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "b"), "i")
+
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		case 't':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			value = value[:len(value)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (use e.g. 512m or 2g)", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// FormatSize formats bytes the way ParseSize reads them
+func FormatSize(bytes int64) string {
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"t", 1 << 40}, {"g", 1 << 30}, {"m", 1 << 20}, {"k", 1 << 10}}
+	for _, unit := range units {
+		if bytes >= unit.size {
+			value := strconv.FormatFloat(float64(bytes)/float64(unit.size), 'f', 1, 64)
+			return strings.TrimSuffix(value, ".0") + unit.suffix
+		}
+	}
+	return strconv.FormatInt(bytes, 10)
+}
+
+// Merge returns base with override's non-empty fields applied. Either may
+// be nil; the result is nil when neither sets anything.
+func Merge(base, override *models.ResourceLimits) *models.ResourceLimits {
+	var merged models.ResourceLimits
+	for _, limits := range []*models.ResourceLimits{base, override} {
+		if limits == nil {
+			continue
+		}
+		if limits.CPUs != "" {
+			merged.CPUs = limits.CPUs
+		}
+		if limits.Memory != "" {
+			merged.Memory = limits.Memory
+		}
+		if limits.Pids != 0 {
+			merged.Pids = limits.Pids
+		}
+		if limits.Storage != "" {
+			merged.Storage = limits.Storage
+		}
+	}
+	if merged == (models.ResourceLimits{}) {
+		return nil
+	}
+	return &merged
+}
+
+// Validate checks that limits are well formed
+func Validate(limits *models.ResourceLimits) error {
+	if limits == nil {
+		return nil
+	}
+	if limits.CPUs != "" {
+		if n, err := strconv.ParseFloat(limits.CPUs, 64); err != nil || n <= 0 {
+			return fmt.Errorf("invalid cpus %q (use e.g. 0.5 or 2)", limits.CPUs)
+		}
+	}
+	if limits.Memory != "" {
+		if _, err := ParseSize(limits.Memory); err != nil {
+			return fmt.Errorf("invalid memory limit: %w", err)
+		}
+	}
+	if limits.Pids < 0 {
+		return fmt.Errorf("invalid pids limit %d", limits.Pids)
+	}
+	if limits.Storage != "" {
+		if _, err := ParseSize(limits.Storage); err != nil {
+			return fmt.Errorf("invalid storage limit: %w", err)
+		}
+	}
+	return nil
+}
+
+// EnvMemory returns the total memory limit of an environment's own
+// services. Shared services are not counted; their owner pays for them.
+func EnvMemory(env *models.Environment) int64 {
+	var total int64
+	for name, svc := range env.Services {
+		if isShared(env, name) {
+			continue
+		}
+		total += svc.Memory
+	}
+	return total
+}
+
+// Unlimited returns the names of an environment's own services that have
+// no memory limit, and so aren't counted against a memory budget
+func Unlimited(env *models.Environment) []string {
+	var names []string
+	for name, svc := range env.Services {
+		if svc.Memory == 0 && !isShared(env, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func isShared(env *models.Environment, service string) bool {
+	for _, shared := range env.UsesSharedServices {
+		if shared == service {
+			return true
+		}
+	}
+	return false
+}
+
+// Usage is what a host's running environments use together
+type Usage struct {
+	RunningEnvs int
+	Memory      int64
+}
+
+// Running reports whether an environment counts against the budget. Paused
+// environments still hold their memory, so they count.
+func Running(env *models.Environment) bool {
+	return env.Status == "running" || env.Status == "starting" || env.Status == "paused"
+}
+
+// HostUsage totals the environments counting against a host's budget,
+// skipping the one identified by exclude ("project/name")
+func HostUsage(host *models.Host, exclude string) Usage {
+	var usage Usage
+	for key, env := range host.Environments {
+		if key == exclude || !Running(env) {
+			continue
+		}
+		usage.RunningEnvs++
+		usage.Memory += EnvMemory(env)
+	}
+	return usage
+}
+
+// CheckBudget reports why env can't start alongside usage under budget, or
+// nil if it fits
+func CheckBudget(budget *models.HostBudget, usage Usage, env *models.Environment) error {
+	if budget == nil {
+		return nil
+	}
+	if budget.MaxRunningEnvs > 0 && usage.RunningEnvs >= budget.MaxRunningEnvs {
+		return fmt.Errorf("%d of %d environments are already running", usage.RunningEnvs, budget.MaxRunningEnvs)
+	}
+	if budget.MaxMemory != "" {
+		max, err := ParseSize(budget.MaxMemory)
+		if err != nil {
+			return fmt.Errorf("host budget: %w", err)
+		}
+		need := EnvMemory(env)
+		if usage.Memory+need > max {
+			return fmt.Errorf("%s needs %s but running environments already use %s of %s", env.Name, FormatSize(need), FormatSize(usage.Memory), FormatSize(max))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package resources
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"1024", 1024},
+		{"512m", 512 << 20},
+		{"2g", 2 << 30},
+		{"2GiB", 2 << 30},
+		{"1.5g", 3 << 29},
+		{"64kb", 64 << 10},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if err != nil {
+			t.Fatalf("ParseSize(%q): %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Fatalf("ParseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+		if back, _ := ParseSize(FormatSize(got)); back != got {
+			t.Fatalf("FormatSize(%d) = %q does not round-trip", got, FormatSize(got))
+		}
+	}
+
+	for _, bad := range []string{"", "lots", "-1g", "g"} {
+		if _, err := ParseSize(bad); err == nil {
+			t.Fatalf("ParseSize(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	if Merge(nil, nil) != nil {
+		t.Fatalf("Merge(nil, nil) should be nil")
+	}
+	got := Merge(
+		&models.ResourceLimits{CPUs: "1", Memory: "1g", Pids: 100},
+		&models.ResourceLimits{Memory: "2g", Storage: "5g"},
+	)
+	want := models.ResourceLimits{CPUs: "1", Memory: "2g", Pids: 100, Storage: "5g"}
+	if got == nil || *got != want {
+		t.Fatalf("Merge = %+v, want %+v", got, want)
+	}
+}
+
+func TestCheckBudget(t *testing.T) {
+	running := func(name string, memory int64) *models.Environment {
+		return &models.Environment{
+			Name:     name,
+			Status:   "running",
+			Services: map[string]*models.Service{"web": {Name: "web", Memory: memory}},
+		}
+	}
+	host := &models.Host{Environments: map[string]*models.Environment{
+		"shop/a": running("a", 1<<30),
+		"shop/b": running("b", 1<<30),
+		"shop/c": {Name: "c", Status: "stopped", Services: map[string]*models.Service{"web": {Memory: 8 << 30}}},
+	}}
+
+	usage := HostUsage(host, "shop/b")
+	if usage.RunningEnvs != 1 || usage.Memory != 1<<30 {
+		t.Fatalf("HostUsage = %+v, want only a counted", usage)
+	}
+
+	candidate := &models.Environment{
+		Name: "d",
+		Services: map[string]*models.Service{
+			"web": {Memory: 1 << 30},
+			"db":  {Memory: 4 << 30}, // Provided by a shared container
+		},
+		UsesSharedServices: []string{"db"},
+	}
+	usage = HostUsage(host, "")
+
+	if err := CheckBudget(nil, usage, candidate); err != nil {
+		t.Fatalf("no budget: %v", err)
+	}
+	if err := CheckBudget(&models.HostBudget{MaxRunningEnvs: 3, MaxMemory: "3g"}, usage, candidate); err != nil {
+		t.Fatalf("fits: %v", err)
+	}
+	if err := CheckBudget(&models.HostBudget{MaxRunningEnvs: 2}, usage, candidate); err == nil || !strings.Contains(err.Error(), "2 of 2") {
+		t.Fatalf("env cap = %v, want refusal", err)
+	}
+	if err := CheckBudget(&models.HostBudget{MaxMemory: "2.5g"}, usage, candidate); err == nil || !strings.Contains(err.Error(), "needs 1g") {
+		t.Fatalf("memory cap = %v, want refusal", err)
+	}
+}
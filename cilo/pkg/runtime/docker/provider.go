@@ -1,11 +1,15 @@
 package docker
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/sharedco/cilo/pkg/compose"
@@ -14,17 +18,103 @@ import (
 	"github.com/sharedco/cilo/pkg/runtime"
 )
 
-type Provider struct{}
+// Provider runs environments with the docker CLI, on the local daemon or
+// on a registered host's
+type Provider struct {
+	env     []string // DOCKER_HOST or DOCKER_CONTEXT for a host's daemon
+	stdout  io.Writer
+	stderr  io.Writer
+	resolve EnvironmentResolver
+}
+
+// EnvironmentResolver returns the project config and profile an
+// environment's compose commands run with. A nil config means the one in
+// its workspace.
+type EnvironmentResolver func(project, envName string) (*models.ProjectConfig, string, error)
 
 func NewProvider() *Provider {
 	return &Provider{}
 }
 
+// NewProviderForHost returns a provider for a host's Docker daemon, which
+// is reached through its DOCKER_HOST (e.g. ssh://me@build1) or docker
+// context. The local host's is the default daemon.
+func NewProviderForHost(host *models.Host) *Provider {
+	switch {
+	case host == nil:
+		return NewProvider()
+	case host.DockerHost != "":
+		return &Provider{env: []string{"DOCKER_HOST=" + host.DockerHost}}
+	case host.DockerContext != "":
+		return &Provider{env: []string{"DOCKER_CONTEXT=" + host.DockerContext}}
+	default:
+		return NewProvider()
+	}
+}
+
+// SetOutput sends the output of the docker commands the provider runs for
+// lifecycle operations, such as compose up, to stdout and stderr instead of
+// the process's own
+func (p *Provider) SetOutput(stdout, stderr io.Writer) {
+	p.stdout, p.stderr = stdout, stderr
+}
+
+// SetEnvironmentResolver sets how commands given an environment's project
+// and name, such as Logs and Exec, find its config and profile. Without one
+// they use the workspace's config and no profile.
+func (p *Provider) SetEnvironmentResolver(resolve EnvironmentResolver) {
+	p.resolve = resolve
+}
+
+func (p *Provider) outputs() (io.Writer, io.Writer) {
+	stdout, stderr := p.stdout, p.stderr
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	return stdout, stderr
+}
+
+// docker returns a docker CLI command aimed at the provider's daemon
+func (p *Provider) docker(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	if len(p.env) > 0 {
+		// DOCKER_HOST wins over DOCKER_CONTEXT, so clear whichever the
+		// caller's environment sets
+		cmd.Env = append(withoutDockerTarget(os.Environ()), p.env...)
+	}
+	return cmd
+}
+
+func withoutDockerTarget(environ []string) []string {
+	var kept []string
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, "DOCKER_HOST=") && !strings.HasPrefix(kv, "DOCKER_CONTEXT=") {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
+
+func (p *Provider) Ping(ctx context.Context) error {
+	cmd := p.docker(ctx, "version", "--format", "{{.Server.Version}}")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		detail := strings.TrimSpace(string(output))
+		if detail == "" {
+			detail = err.Error()
+		}
+		return fmt.Errorf("%w: docker: %s", runtime.ErrUnavailable, detail)
+	}
+	return nil
+}
+
 func (p *Provider) CreateNetwork(ctx context.Context, env *models.Environment) error {
 	networkName := getNetworkName(env.Name)
 	subnet := env.Subnet
 
-	cmd := exec.CommandContext(ctx, "docker", "network", "inspect", networkName)
+	cmd := p.docker(ctx, "network", "inspect", networkName)
 	if err := cmd.Run(); err == nil {
 		if err := p.RemoveNetwork(ctx, env.Name); err != nil {
 			return fmt.Errorf("failed to remove existing network: %w", err)
@@ -40,9 +130,8 @@ func (p *Provider) CreateNetwork(ctx context.Context, env *models.Environment) e
 		networkName,
 	}
 
-	cmd = exec.CommandContext(ctx, "docker", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd = p.docker(ctx, args...)
+	cmd.Stdout, cmd.Stderr = p.outputs()
 
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to create network: %w", err)
@@ -53,7 +142,7 @@ func (p *Provider) CreateNetwork(ctx context.Context, env *models.Environment) e
 
 func (p *Provider) RemoveNetwork(ctx context.Context, envName string) error {
 	networkName := getNetworkName(envName)
-	cmd := exec.CommandContext(ctx, "docker", "network", "rm", networkName)
+	cmd := p.docker(ctx, "network", "rm", networkName)
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to remove network %s: %w", networkName, err)
 	}
@@ -61,7 +150,7 @@ func (p *Provider) RemoveNetwork(ctx context.Context, envName string) error {
 }
 
 func (p *Provider) Up(ctx context.Context, env *models.Environment, opts runtime.UpOptions) error {
-	workspace, args, err := buildComposeArgs(env.Project, env.Name)
+	workspace, args, err := envComposeArgs(env)
 	if err != nil {
 		return err
 	}
@@ -75,10 +164,9 @@ func (p *Provider) Up(ctx context.Context, env *models.Environment, opts runtime
 		args = append(args, "--force-recreate")
 	}
 
-	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd := p.docker(ctx, args...)
 	cmd.Dir = workspace
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout, cmd.Stderr = p.outputs()
 
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to start environment: %w", err)
@@ -90,16 +178,15 @@ func (p *Provider) Up(ctx context.Context, env *models.Environment, opts runtime
 }
 
 func (p *Provider) Down(ctx context.Context, env *models.Environment) error {
-	workspace, args, err := buildComposeArgs(env.Project, env.Name)
+	workspace, args, err := envComposeArgs(env)
 	if err != nil {
 		return err
 	}
 	args = append(args, "down")
 
-	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd := p.docker(ctx, args...)
 	cmd.Dir = workspace
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout, cmd.Stderr = p.outputs()
 
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to stop environment: %w", err)
@@ -110,24 +197,24 @@ func (p *Provider) Down(ctx context.Context, env *models.Environment) error {
 }
 
 func (p *Provider) Destroy(ctx context.Context, env *models.Environment) error {
-	workspace, args, err := buildComposeArgs(env.Project, env.Name)
+	workspace, args, err := envComposeArgs(env)
 	if err != nil {
 		return err
 	}
 	overridePath := filepath.Join(workspace, ".cilo", "override.yml")
 	if _, err := os.Stat(overridePath); err == nil {
 		args = append(args, "down", "-v")
-		cmd := exec.CommandContext(ctx, "docker", args...)
+		cmd := p.docker(ctx, args...)
 		cmd.Dir = workspace
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		stdout, stderr := p.outputs()
+		cmd.Stdout, cmd.Stderr = stdout, stderr
 
 		if err := cmd.Run(); err != nil {
-			fmt.Printf("Warning: could not stop containers: %v\n", err)
+			fmt.Fprintf(stderr, "Warning: could not stop containers: %v\n", err)
 		}
 
 		if err := p.RemoveNetwork(ctx, env.Name); err != nil {
-			fmt.Printf("Warning: could not remove network: %v\n", err)
+			fmt.Fprintf(stderr, "Warning: could not remove network: %v\n", err)
 		}
 	}
 
@@ -138,7 +225,7 @@ func (p *Provider) Destroy(ctx context.Context, env *models.Environment) error {
 func (p *Provider) GetContainerIP(ctx context.Context, envName, serviceName string) (string, error) {
 	containerName := fmt.Sprintf("cilo_%s_%s", envName, serviceName)
 
-	cmd := exec.CommandContext(ctx, "docker", "inspect", "-f", "{{range .NetworkSettings.Networks}}{{.IPAddress}}{{end}}", containerName)
+	cmd := p.docker(ctx, "inspect", "-f", "{{range .NetworkSettings.Networks}}{{.IPAddress}}{{end}}", containerName)
 	output, err := cmd.Output()
 	if err != nil {
 		return "", fmt.Errorf("failed to get IP for container %s: %w", containerName, err)
@@ -167,12 +254,12 @@ func (p *Provider) GetContainerIPs(ctx context.Context, envName string, services
 }
 
 func (p *Provider) GetServiceStatus(ctx context.Context, project, envName string) (map[string]string, error) {
-	workspace, args, err := buildComposeArgs(project, envName)
+	workspace, args, err := p.composeArgs(project, envName)
 	if err != nil {
 		return nil, err
 	}
 	args = append(args, "ps", "-q")
-	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd := p.docker(ctx, args...)
 	cmd.Dir = workspace
 	output, err := cmd.Output()
 	if err != nil {
@@ -183,7 +270,7 @@ func (p *Provider) GetServiceStatus(ctx context.Context, project, envName string
 	status := make(map[string]string)
 
 	for _, container := range containers {
-		infoCmd := exec.CommandContext(ctx, "docker", "inspect", "-f", "{{.Name}} {{.State.Status}}", container)
+		infoCmd := p.docker(ctx, "inspect", "-f", "{{.Name}} {{.State.Status}}", container)
 		info, err := infoCmd.Output()
 		if err != nil {
 			continue
@@ -204,7 +291,7 @@ func (p *Provider) GetServiceStatus(ctx context.Context, project, envName string
 }
 
 func (p *Provider) Logs(ctx context.Context, project, envName, serviceName string, opts runtime.LogOptions) error {
-	workspace, args, err := buildComposeArgs(project, envName)
+	workspace, args, err := p.composeArgs(project, envName)
 	if err != nil {
 		return err
 	}
@@ -222,7 +309,7 @@ func (p *Provider) Logs(ctx context.Context, project, envName, serviceName strin
 		args = append(args, serviceName)
 	}
 
-	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd := p.docker(ctx, args...)
 	cmd.Dir = workspace
 
 	if opts.Stdout != nil {
@@ -241,7 +328,7 @@ func (p *Provider) Logs(ctx context.Context, project, envName, serviceName strin
 }
 
 func (p *Provider) Exec(ctx context.Context, project, envName, serviceName string, command []string, opts runtime.ExecOptions) error {
-	workspace, args, err := buildComposeArgs(project, envName)
+	workspace, args, err := p.composeArgs(project, envName)
 	if err != nil {
 		return err
 	}
@@ -253,12 +340,23 @@ func (p *Provider) Exec(ctx context.Context, project, envName, serviceName strin
 
 	if opts.TTY {
 		args = append(args, "-t")
+	} else {
+		args = append(args, "-T")
+	}
+
+	keys := make([]string, 0, len(opts.Env))
+	for key := range opts.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "-e", key+"="+opts.Env[key])
 	}
 
 	args = append(args, serviceName)
 	args = append(args, command...)
 
-	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd := p.docker(ctx, args...)
 	cmd.Dir = workspace
 
 	if opts.Stdout != nil {
@@ -281,13 +379,13 @@ func (p *Provider) Exec(ctx context.Context, project, envName, serviceName strin
 }
 
 func (p *Provider) Compose(ctx context.Context, project, envName string, opts runtime.ComposeOptions) error {
-	workspace, args, err := buildComposeArgs(project, envName)
+	workspace, args, err := p.composeArgs(project, envName)
 	if err != nil {
 		return err
 	}
 	fullArgs := append(args, opts.Args...)
 
-	cmd := exec.CommandContext(ctx, "docker", fullArgs...)
+	cmd := p.docker(ctx, fullArgs...)
 	cmd.Dir = workspace
 
 	if opts.Stdout != nil {
@@ -318,9 +416,8 @@ func (p *Provider) ConnectContainerToNetwork(ctx context.Context, containerName,
 	}
 	args = append(args, networkName, containerName)
 
-	cmd := exec.CommandContext(ctx, "docker", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd := p.docker(ctx, args...)
+	cmd.Stdout, cmd.Stderr = p.outputs()
 
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to connect container %s to network %s: %w", containerName, networkName, err)
@@ -331,9 +428,8 @@ func (p *Provider) ConnectContainerToNetwork(ctx context.Context, containerName,
 
 // DisconnectContainerFromNetwork removes a container from a network
 func (p *Provider) DisconnectContainerFromNetwork(ctx context.Context, containerName, networkName string) error {
-	cmd := exec.CommandContext(ctx, "docker", "network", "disconnect", networkName, containerName)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd := p.docker(ctx, "network", "disconnect", networkName, containerName)
+	cmd.Stdout, cmd.Stderr = p.outputs()
 
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to disconnect container %s from network %s: %w", containerName, networkName, err)
@@ -345,7 +441,7 @@ func (p *Provider) DisconnectContainerFromNetwork(ctx context.Context, container
 // GetContainerIPForNetwork returns the IP address of a container on a specific network
 func (p *Provider) GetContainerIPForNetwork(ctx context.Context, containerName, networkName string) (string, error) {
 	// Get the network ID first
-	networkIDCmd := exec.CommandContext(ctx, "docker", "network", "inspect", "-f", "{{.Id}}", networkName)
+	networkIDCmd := p.docker(ctx, "network", "inspect", "-f", "{{.Id}}", networkName)
 	networkIDOutput, err := networkIDCmd.Output()
 	if err != nil {
 		return "", fmt.Errorf("failed to get network ID for %s: %w", networkName, err)
@@ -354,7 +450,7 @@ func (p *Provider) GetContainerIPForNetwork(ctx context.Context, containerName,
 
 	// Get the IP for this specific network
 	template := fmt.Sprintf("{{range .NetworkSettings.Networks}}{{if eq .NetworkID \"%s\"}}{{.IPAddress}}{{end}}{{end}}", networkID)
-	cmd := exec.CommandContext(ctx, "docker", "inspect", "-f", template, containerName)
+	cmd := p.docker(ctx, "inspect", "-f", template, containerName)
 	output, err := cmd.Output()
 	if err != nil {
 		return "", fmt.Errorf("failed to get IP for container %s on network %s: %w", containerName, networkName, err)
@@ -371,7 +467,7 @@ func (p *Provider) GetContainerIPForNetwork(ctx context.Context, containerName,
 // ListContainersWithLabel returns container names that have the specified label
 func (p *Provider) ListContainersWithLabel(ctx context.Context, labelKey, labelValue string) ([]string, error) {
 	label := fmt.Sprintf("%s=%s", labelKey, labelValue)
-	cmd := exec.CommandContext(ctx, "docker", "ps", "-a", "--filter", fmt.Sprintf("label=%s", label), "--format", "{{.Names}}")
+	cmd := p.docker(ctx, "ps", "-a", "--filter", fmt.Sprintf("label=%s", label), "--format", "{{.Names}}")
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list containers with label %s: %w", label, err)
@@ -390,7 +486,7 @@ func (p *Provider) ListContainersWithLabel(ctx context.Context, labelKey, labelV
 
 // ContainerExists checks if a container with the given name exists
 func (p *Provider) ContainerExists(ctx context.Context, containerName string) (bool, error) {
-	cmd := exec.CommandContext(ctx, "docker", "inspect", containerName)
+	cmd := p.docker(ctx, "inspect", containerName)
 	err := cmd.Run()
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
@@ -403,7 +499,7 @@ func (p *Provider) ContainerExists(ctx context.Context, containerName string) (b
 
 // GetContainerStatus returns the status of a container (running, stopped, etc.)
 func (p *Provider) GetContainerStatus(ctx context.Context, containerName string) (string, error) {
-	cmd := exec.CommandContext(ctx, "docker", "inspect", "-f", "{{.State.Status}}", containerName)
+	cmd := p.docker(ctx, "inspect", "-f", "{{.State.Status}}", containerName)
 	output, err := cmd.Output()
 	if err != nil {
 		return "", fmt.Errorf("failed to get status for container %s: %w", containerName, err)
@@ -412,11 +508,33 @@ func (p *Provider) GetContainerStatus(ctx context.Context, containerName string)
 	return strings.TrimSpace(string(output)), nil
 }
 
+// GetContainerHealth returns a container's status, its healthcheck status,
+// which is empty when the container defines no healthcheck, and its exit code
+func (p *Provider) GetContainerHealth(ctx context.Context, containerName string) (string, string, int, error) {
+	cmd := p.docker(ctx, "inspect", "-f", "{{.State.Status}} {{.State.ExitCode}} {{if .State.Health}}{{.State.Health.Status}}{{end}}", containerName)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to get health for container %s: %w", containerName, err)
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) < 2 {
+		return "", "", 0, fmt.Errorf("no status for container %s", containerName)
+	}
+	exitCode, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", "", 0, fmt.Errorf("invalid exit code for container %s: %q", containerName, fields[1])
+	}
+	if len(fields) == 2 {
+		return fields[0], "", exitCode, nil
+	}
+	return fields[0], fields[2], exitCode, nil
+}
+
 // StopContainer stops a running container
 func (p *Provider) StopContainer(ctx context.Context, containerName string) error {
-	cmd := exec.CommandContext(ctx, "docker", "stop", containerName)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd := p.docker(ctx, "stop", containerName)
+	cmd.Stdout, cmd.Stderr = p.outputs()
 
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to stop container %s: %w", containerName, err)
@@ -427,9 +545,8 @@ func (p *Provider) StopContainer(ctx context.Context, containerName string) erro
 
 // RemoveContainer removes a container
 func (p *Provider) RemoveContainer(ctx context.Context, containerName string) error {
-	cmd := exec.CommandContext(ctx, "docker", "rm", containerName)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd := p.docker(ctx, "rm", containerName)
+	cmd.Stdout, cmd.Stderr = p.outputs()
 
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to remove container %s: %w", containerName, err)
@@ -438,6 +555,165 @@ func (p *Provider) RemoveContainer(ctx context.Context, containerName string) er
 	return nil
 }
 
+// volumeHelperImage runs tar against volumes for export and import
+const volumeHelperImage = "busybox:stable"
+
+// ListVolumes returns the names of an environment's compose volumes
+func (p *Provider) ListVolumes(ctx context.Context, envName string) ([]string, error) {
+	label := fmt.Sprintf("com.docker.compose.project=cilo_%s", envName)
+	cmd := p.docker(ctx, "volume", "ls", "-q", "--filter", "label="+label)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes for %s: %w", envName, err)
+	}
+	volumes := strings.Fields(string(output))
+	sort.Strings(volumes)
+	return volumes, nil
+}
+
+// ExportVolume writes a tar of a volume's contents to w
+func (p *Provider) ExportVolume(ctx context.Context, volume string, w io.Writer) error {
+	var stderr bytes.Buffer
+	cmd := p.docker(ctx, "run", "--rm", "-v", volume+":/data:ro", volumeHelperImage,
+		"tar", "-C", "/data", "-cf", "-", ".")
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to export volume %s: %w: %s", volume, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// ImportVolume replaces a volume's contents with the tar read from r
+func (p *Provider) ImportVolume(ctx context.Context, volume string, r io.Reader) error {
+	var stderr bytes.Buffer
+	cmd := p.docker(ctx, "run", "--rm", "-i", "-v", volume+":/data", volumeHelperImage,
+		"sh", "-c", "find /data -mindepth 1 -delete && tar -C /data -xf -")
+	cmd.Stdin = r
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to import volume %s: %w: %s", volume, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// ContainerStats samples the counters of an environment's running
+// containers, keyed by container name
+func (p *Provider) ContainerStats(ctx context.Context, envName string) (map[string]runtime.ContainerStats, error) {
+	label := fmt.Sprintf("com.docker.compose.project=cilo_%s", envName)
+	output, err := p.docker(ctx, "ps", "-q", "--filter", "label="+label, "--filter", "status=running").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers for %s: %w", envName, err)
+	}
+	ids := strings.Fields(string(output))
+	stats := make(map[string]runtime.ContainerStats)
+	if len(ids) == 0 {
+		return stats, nil
+	}
+
+	args := append([]string{"stats", "--no-stream", "--format", "{{.Name}}\t{{.CPUPerc}}\t{{.NetIO}}"}, ids...)
+	output, err = p.docker(ctx, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read container stats for %s: %w", envName, err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		var sample runtime.ContainerStats
+		fmt.Sscanf(strings.TrimSuffix(fields[1], "%"), "%g", &sample.CPUPercent)
+		for _, part := range strings.Split(fields[2], "/") {
+			sample.NetBytes += parseStatsSize(strings.TrimSpace(part))
+		}
+		stats[fields[0]] = sample
+	}
+	return stats, nil
+}
+
+// ImageExists reports whether the daemon has an image, without pulling it
+func (p *Provider) ImageExists(ctx context.Context, ref string) (bool, error) {
+	err := p.docker(ctx, "image", "inspect", ref).Run()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// CheckStorageLimits asks the daemon for its storage driver. Only some
+// drivers take a size in storage_opt; overlay2 needs an xfs backing
+// filesystem (mounted with pquota, which docker info doesn't show).
+func (p *Provider) CheckStorageLimits(ctx context.Context) error {
+	cmd := p.docker(ctx, "info", "--format", `{{.Driver}}{{range .DriverStatus}}|{{index . 0}}={{index . 1}}{{end}}`)
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to get docker storage driver: %w", err)
+	}
+	fields := strings.Split(strings.TrimSpace(string(output)), "|")
+	driver := fields[0]
+	switch driver {
+	case "btrfs", "zfs", "devicemapper", "windowsfilter":
+		return nil
+	case "overlay2":
+		for _, field := range fields[1:] {
+			if key, value, _ := strings.Cut(field, "="); key == "Backing Filesystem" {
+				if value == "xfs" {
+					return nil
+				}
+				return fmt.Errorf("docker's overlay2 storage driver only supports size limits on xfs, and it runs on %s", value)
+			}
+		}
+	}
+	return fmt.Errorf("docker's %s storage driver doesn't support size limits", driver)
+}
+
+// BuildImage builds and tags an image with docker build
+func (p *Provider) BuildImage(ctx context.Context, opts runtime.BuildOptions) error {
+	args := []string{"build", "-t", opts.Tag}
+	if opts.Dockerfile != "" {
+		args = append(args, "-f", opts.Dockerfile)
+	}
+	if opts.Target != "" {
+		args = append(args, "--target", opts.Target)
+	}
+	keys := make([]string, 0, len(opts.Args))
+	for k := range opts.Args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--build-arg", k+"="+opts.Args[k])
+	}
+	args = append(args, opts.Context)
+
+	cmd := p.docker(ctx, args...)
+	cmd.Stdout, cmd.Stderr = p.outputs()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to build %s: %w", opts.Tag, err)
+	}
+	return nil
+}
+
+// parseStatsSize parses the sizes docker stats prints ("1.5kB", "3MB",
+// "12B"), which use decimal units
+func parseStatsSize(s string) int64 {
+	units := []struct {
+		suffix string
+		size   float64
+	}{{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"kB", 1e3}, {"B", 1}}
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			var n float64
+			fmt.Sscanf(strings.TrimSuffix(s, unit.suffix), "%g", &n)
+			return int64(n * unit.size)
+		}
+	}
+	return 0
+}
+
 func getNetworkName(envName string) string {
 	return fmt.Sprintf("cilo_%s", envName)
 }
@@ -446,29 +722,75 @@ func getWorkspacePath(project, envName string) string {
 	return config.GetEnvPath(project, envName)
 }
 
-func buildComposeArgs(project, envName string) (string, []string, error) {
-	workspace := getWorkspacePath(project, envName)
-	projectConfig, err := models.LoadProjectConfigFromPath(workspace)
+// envComposeArgs is buildComposeArgs for an environment in hand, whose
+// stored overrides can change its compose files
+func envComposeArgs(env *models.Environment) (string, []string, error) {
+	projectConfig, err := models.LoadEnvironmentConfig(env)
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to load project config: %w", err)
 	}
+	return buildComposeArgs(env.Project, env.Name, projectConfig, env.Profile)
+}
+
+// composeArgs is buildComposeArgs for an environment known by name, with
+// the config and profile the resolver gives
+func (p *Provider) composeArgs(project, envName string) (string, []string, error) {
+	var projectConfig *models.ProjectConfig
+	var profile string
+	if p.resolve != nil {
+		var err error
+		if projectConfig, profile, err = p.resolve(project, envName); err != nil {
+			return "", nil, fmt.Errorf("failed to load project config: %w", err)
+		}
+	}
+	return buildComposeArgs(project, envName, projectConfig, profile)
+}
 
-	composeFiles, projectDir, err := compose.ResolveComposeFiles(workspace, nil)
-	if err == nil && projectConfig != nil {
-		composeFiles, projectDir, err = compose.ResolveComposeFiles(workspace, projectConfig.ComposeFiles)
+// buildComposeArgs returns an environment's workspace and the docker
+// compose arguments selecting its project, files and profiles. A nil
+// projectConfig is loaded from the workspace.
+func buildComposeArgs(project, envName string, projectConfig *models.ProjectConfig, profile string) (string, []string, error) {
+	workspace := getWorkspacePath(project, envName)
+	if projectConfig == nil {
+		var err error
+		if projectConfig, err = models.LoadProjectConfigFromPath(workspace); err != nil {
+			return "", nil, fmt.Errorf("failed to load project config: %w", err)
+		}
 	}
+
+	var configured []string
+	if projectConfig != nil {
+		configured = projectConfig.ComposeFiles
+	}
+	composeProject, err := compose.LoadProject(workspace, configured)
 	if err != nil {
 		return "", nil, err
 	}
 
 	args := []string{"compose", "-p", fmt.Sprintf("cilo_%s", envName)}
-	if projectDir != "" {
-		args = append(args, "--project-directory", projectDir)
+	if composeProject.Dir != "" {
+		args = append(args, "--project-directory", composeProject.Dir)
 	}
-	for _, file := range composeFiles {
+	for _, file := range composeProject.ComposeFiles {
 		args = append(args, "-f", file)
 	}
 	args = append(args, "-f", filepath.Join(workspace, ".cilo", "override.yml"))
+	profiles := composeProject.Profiles
+	// A profile the environment runs may enable more of compose's
+	if profile != "" {
+		services, err := compose.LoadServices(composeProject.ComposeFiles)
+		if err != nil {
+			return "", nil, err
+		}
+		selection, err := compose.SelectProfile(projectConfig, services, profile)
+		if err != nil {
+			return "", nil, err
+		}
+		profiles = append(append([]string{}, profiles...), selection.ComposeProfiles...)
+	}
+	for _, profile := range profiles {
+		args = append(args, "--profile", profile)
+	}
 
 	if projectConfig != nil {
 		for _, envFile := range projectConfig.EnvFiles {
@@ -2,11 +2,20 @@ package runtime
 
 import (
 	"context"
+	"errors"
+	"io"
 
 	"github.com/sharedco/cilo/pkg/models"
 )
 
+// ErrUnavailable is returned when the container runtime can't be reached
+var ErrUnavailable = errors.New("container runtime unavailable")
+
 type Provider interface {
+	// Ping checks the runtime is reachable, returning an error wrapping
+	// ErrUnavailable when it isn't
+	Ping(ctx context.Context) error
+
 	Up(ctx context.Context, env *models.Environment, opts UpOptions) error
 	Down(ctx context.Context, env *models.Environment) error
 	Destroy(ctx context.Context, env *models.Environment) error
@@ -29,6 +38,23 @@ type Provider interface {
 	ListContainersWithLabel(ctx context.Context, labelKey, labelValue string) ([]string, error)
 	ContainerExists(ctx context.Context, containerName string) (bool, error)
 	GetContainerStatus(ctx context.Context, containerName string) (string, error)
+	GetContainerHealth(ctx context.Context, containerName string) (status, health string, exitCode int, err error)
 	StopContainer(ctx context.Context, containerName string) error
 	RemoveContainer(ctx context.Context, containerName string) error
+
+	// Volume snapshot support methods
+	ListVolumes(ctx context.Context, envName string) ([]string, error)
+	ExportVolume(ctx context.Context, volume string, w io.Writer) error
+	ImportVolume(ctx context.Context, volume string, r io.Reader) error
+
+	// Idle detection support methods
+	ContainerStats(ctx context.Context, envName string) (map[string]ContainerStats, error)
+
+	// Image build support methods
+	ImageExists(ctx context.Context, ref string) (bool, error)
+	BuildImage(ctx context.Context, opts BuildOptions) error
+
+	// CheckStorageLimits reports why containers can't be given a
+	// writable-layer size (storage_opt size), or nil if they can
+	CheckStorageLimits(ctx context.Context) error
 }
@@ -53,6 +53,21 @@ type ComposeOptions struct {
 	Stderr io.Writer
 }
 
+// BuildOptions for building an image outside compose
+type BuildOptions struct {
+	Tag        string
+	Context    string
+	Dockerfile string // Absolute path
+	Target     string
+	Args       map[string]string
+}
+
+// ContainerStats is a sample of a running container's resource counters
+type ContainerStats struct {
+	CPUPercent float64 // Since the previous sample taken by the runtime
+	NetBytes   int64   // Received plus sent since the container started
+}
+
 // Status represents environment status
 type Status struct {
 	State       EnvironmentState
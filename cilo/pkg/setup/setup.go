@@ -0,0 +1,348 @@
+// Package setup proposes a project's .cilo/config.yml from what is in its
+// directory, and writes the config with comments explaining each setting.
+package setup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/compose"
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+// Proposal is the config setup suggests for a project, with the reasons
+// for each suggestion
+type Proposal struct {
+	Format        string
+	ComposeFiles  []string // Relative to the project; empty for formats detected on up
+	Services      []string // Services compose starts, sorted
+	Ingress       string
+	IngressReason string
+	Hostnames     []string
+	Shared        []string
+	SharedReasons map[string]string
+	EnvRender     []models.EnvRenderRule
+	CopyDotDirs   []string
+	Notes         []string // Things found that setup can't act on
+}
+
+// ingressNames are the service names taken for the ingress, in order, as
+// the override generator does
+var ingressNames = []string{"nginx", "web", "app", "frontend"}
+
+// proxyImages are reverse proxies, which route other services by hostname
+var proxyImages = []string{"nginx", "traefik", "caddy", "haproxy", "envoy"}
+
+// sharedImages are heavy infrastructure worth running once for all
+// environments. Databases aren't among them: each environment keeps its own
+// data.
+var sharedImages = []string{
+	"elasticsearch", "opensearch", "kafka", "zookeeper", "rabbitmq",
+	"localstack", "minio", "clickhouse", "mailhog", "mailpit", "jaeger",
+}
+
+// imagePorts are the ports well-known images listen on, for services whose
+// compose definition doesn't list them
+var imagePorts = map[string]int{
+	"postgres":      5432,
+	"mysql":         3306,
+	"mariadb":       3306,
+	"redis":         6379,
+	"valkey":        6379,
+	"mongo":         27017,
+	"memcached":     11211,
+	"elasticsearch": 9200,
+	"opensearch":    9200,
+	"rabbitmq":      5672,
+	"kafka":         9092,
+	"minio":         9000,
+	"clickhouse":    8123,
+}
+
+// webPorts are ports an HTTP service usually listens on
+var webPorts = map[int]bool{80: true, 443: true, 3000: true, 4200: true, 5000: true, 5173: true, 8000: true, 8080: true, 8888: true}
+
+// copyDotDirs are dot directories a build commonly needs, copied whether
+// or not the compose files mention them
+var copyDotDirs = []string{".docker", ".yarn", ".config"}
+
+// localURL matches a host:port on the local machine in an env file
+var localURL = regexp.MustCompile(`\b(localhost|127\.0\.0\.1|0\.0\.0\.0):(\d{2,5})\b`)
+
+// Detect proposes config for the project in dir. Compose files are used as
+// given, or discovered the way docker compose finds them; without any, the
+// project's devcontainer.json or Procfile is read.
+func Detect(dir string, composeFiles []string) (*Proposal, error) {
+	p := &Proposal{Format: compose.FormatCompose, SharedReasons: map[string]string{}}
+	if len(composeFiles) == 0 {
+		discovered, warnings, err := compose.DiscoverComposeFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		composeFiles = discovered
+		p.Notes = append(p.Notes, warnings...)
+	}
+	// A devcontainer.json or Procfile is turned into a compose file outside
+	// the project, which setup only reads
+	generated, err := os.MkdirTemp("", "cilo-setup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(generated)
+	project, err := compose.ReadProject(dir, composeFiles, generated)
+	if err != nil {
+		return nil, err
+	}
+	if project.Format == compose.FormatCompose {
+		p.ComposeFiles = composeFiles
+	}
+	p.Format = project.Format
+	p.Notes = append(p.Notes, project.Warnings...)
+
+	all, err := compose.LoadServices(project.ComposeFiles)
+	if err != nil {
+		return nil, err
+	}
+	services := map[string]*compose.ServiceMeta{}
+	for name, svc := range all {
+		if svc.Enabled(project.Profiles) {
+			services[name] = svc
+		}
+	}
+	p.Services = compose.SortedServiceNames(services)
+
+	p.proposeShared(services)
+	p.proposeIngress(services)
+	p.proposeHostnames(services)
+	if err := p.proposeEnvRender(dir, services); err != nil {
+		return nil, err
+	}
+	if err := p.proposeCopyDotDirs(dir, project.ComposeFiles); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Proposal) proposeShared(services map[string]*compose.ServiceMeta) {
+	for _, name := range p.Services {
+		svc := services[name]
+		switch {
+		case svc.Labels["cilo.share"] == "true":
+			p.SharedReasons[name] = "labelled cilo.share"
+		case imageMatches(svc.Image, sharedImages) != "":
+			p.SharedReasons[name] = fmt.Sprintf("%s is heavy to run per environment", imageMatches(svc.Image, sharedImages))
+		default:
+			continue
+		}
+		p.Shared = append(p.Shared, name)
+	}
+}
+
+func (p *Proposal) proposeIngress(services map[string]*compose.ServiceMeta) {
+	candidates := make([]string, 0, len(p.Services))
+	for _, name := range p.Services {
+		if !contains(p.Shared, name) {
+			candidates = append(candidates, name)
+		}
+	}
+
+	for _, name := range candidates {
+		if services[name].Labels["cilo.ingress"] == "true" {
+			p.Ingress, p.IngressReason = name, "labelled cilo.ingress"
+			return
+		}
+	}
+	for _, want := range ingressNames {
+		if contains(candidates, want) {
+			p.Ingress, p.IngressReason = want, "conventional ingress name"
+			return
+		}
+	}
+	for _, name := range candidates {
+		if proxy := imageMatches(services[name].Image, proxyImages); proxy != "" {
+			p.Ingress, p.IngressReason = name, fmt.Sprintf("runs %s, a reverse proxy", proxy)
+			return
+		}
+	}
+	for _, name := range candidates {
+		for _, port := range services[name].Ports {
+			if webPorts[port.Target] {
+				p.Ingress, p.IngressReason = name, fmt.Sprintf("listens on port %d", port.Target)
+				return
+			}
+		}
+	}
+	if len(candidates) > 0 {
+		p.Ingress, p.IngressReason = candidates[0], "first service"
+	}
+}
+
+// proposeHostnames suggests the other web services behind an ingress that
+// is a reverse proxy, which can route them by hostname
+func (p *Proposal) proposeHostnames(services map[string]*compose.ServiceMeta) {
+	if p.Ingress == "" || imageMatches(services[p.Ingress].Image, proxyImages) == "" {
+		return
+	}
+	for _, name := range p.Services {
+		if name == p.Ingress || contains(p.Shared, name) {
+			continue
+		}
+		for _, port := range services[name].Ports {
+			if webPorts[port.Target] {
+				p.Hostnames = append(p.Hostnames, name)
+				break
+			}
+		}
+	}
+}
+
+// proposeEnvRender suggests rewriting the localhost URLs in the project's
+// .env files to the DNS name of the service on that port, so they reach
+// the environment's own services
+func (p *Proposal) proposeEnvRender(dir string, services map[string]*compose.ServiceMeta) error {
+	files, err := envFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		rule := models.EnvRenderRule{File: file}
+		seen := map[string]bool{}
+		for _, m := range localURL.FindAllStringSubmatch(string(data), -1) {
+			if seen[m[0]] {
+				continue
+			}
+			seen[m[0]] = true
+			port, _ := strconv.Atoi(m[2])
+			name, target := serviceOnPort(p.Services, services, port)
+			if name == "" {
+				p.Notes = append(p.Notes, fmt.Sprintf("%s in %s matches no service; left as is", m[0], file))
+				continue
+			}
+			rule.Replace = append(rule.Replace, models.EnvReplace{
+				From: m[0],
+				To:   fmt.Sprintf("%s.${CILO_ENV}${CILO_DNS_SUFFIX}:%d", name, target),
+			})
+		}
+		if len(rule.Replace) > 0 {
+			p.EnvRender = append(p.EnvRender, rule)
+		}
+	}
+	return nil
+}
+
+// serviceOnPort returns the service a localhost port reaches, and the port
+// it listens on inside the environment: the service publishing it, else one
+// listening on it
+func serviceOnPort(names []string, services map[string]*compose.ServiceMeta, port int) (string, int) {
+	for _, name := range names {
+		for _, p := range services[name].Ports {
+			if p.Published == port {
+				return name, p.Target
+			}
+		}
+	}
+	for _, name := range names {
+		for _, p := range services[name].Ports {
+			if p.Target == port {
+				return name, port
+			}
+		}
+	}
+	for _, name := range names {
+		if image := imageMatches(services[name].Image, keys(imagePorts)); image != "" && imagePorts[image] == port {
+			return name, port
+		}
+	}
+	return "", 0
+}
+
+// envFiles returns the .env files in dir, leaving out templates like
+// .env.example that nothing reads
+func envFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasPrefix(name, ".env") {
+			continue
+		}
+		switch filepath.Ext(name) {
+		case ".example", ".sample", ".template", ".dist":
+			continue
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+// proposeCopyDotDirs suggests the dot directories the compose files refer
+// to, or that builds commonly need, besides .cilo itself
+func (p *Proposal) proposeCopyDotDirs(dir string, composeFiles []string) error {
+	var referenced strings.Builder
+	for _, file := range composeFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read compose file %s: %w", file, err)
+		}
+		referenced.Write(data)
+	}
+
+	p.CopyDotDirs = []string{".cilo"}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !strings.HasPrefix(name, ".") || name == ".cilo" || name == ".git" || name == ".devcontainer" {
+			continue
+		}
+		if contains(copyDotDirs, name) || strings.Contains(referenced.String(), name+"/") {
+			p.CopyDotDirs = append(p.CopyDotDirs, name)
+		}
+	}
+	return nil
+}
+
+// imageMatches returns the first of names that an image's repository name
+// contains, ignoring its registry, namespace and tag
+func imageMatches(image string, names []string) string {
+	repo := image[strings.LastIndex(image, "/")+1:]
+	repo, _, _ = strings.Cut(repo, ":")
+	for _, name := range names {
+		if strings.Contains(repo, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+func keys(m map[string]int) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func contains(slice []string, value string) bool {
+	for _, s := range slice {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
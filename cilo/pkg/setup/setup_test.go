@@ -0,0 +1,149 @@
+package setup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+func TestDetect(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"compose.yaml": `services:
+  proxy:
+    image: traefik:v3
+    ports: ["80:80"]
+    volumes: ["./.traefik/dynamic.yml:/etc/traefik/dynamic.yml"]
+  api:
+    image: node:20
+    expose: ["3000"]
+  db:
+    image: postgres:16
+    ports: ["15432:5432"]
+  search:
+    image: docker.elastic.co/elasticsearch/elasticsearch:8.12.0
+  cache:
+    image: redis:7
+    labels:
+      cilo.share: "true"
+`,
+		".env":                 "DATABASE_URL=postgres://u:p@localhost:15432/app\nES=http://127.0.0.1:9200\nX=http://localhost:9999\nDB2=localhost:15432\n",
+		".env.local":           "REDIS_URL=redis://localhost:6379\n",
+		".env.example":         "DATABASE_URL=postgres://localhost:5432/app\n",
+		".traefik/dynamic.yml": "http: {}\n",
+		".idea/workspace.xml":  "<project/>\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	p, err := Detect(dir, nil)
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	if strings.Join(p.ComposeFiles, ",") != "compose.yaml" {
+		t.Fatalf("ComposeFiles = %v, want compose.yaml", p.ComposeFiles)
+	}
+	if p.Ingress != "proxy" || !strings.Contains(p.IngressReason, "traefik") {
+		t.Fatalf("Ingress = %q (%s), want proxy as a reverse proxy", p.Ingress, p.IngressReason)
+	}
+	if strings.Join(p.Hostnames, ",") != "api" {
+		t.Fatalf("Hostnames = %v, want the api behind the proxy", p.Hostnames)
+	}
+	if strings.Join(p.Shared, ",") != "cache,search" {
+		t.Fatalf("Shared = %v, want the labelled cache and elasticsearch", p.Shared)
+	}
+	if strings.Join(p.CopyDotDirs, ",") != ".cilo,.traefik" {
+		t.Fatalf("CopyDotDirs = %v, want .cilo and the mounted .traefik", p.CopyDotDirs)
+	}
+
+	if len(p.EnvRender) != 2 || p.EnvRender[0].File != ".env" || p.EnvRender[1].File != ".env.local" {
+		t.Fatalf("EnvRender = %+v, want .env and .env.local", p.EnvRender)
+	}
+	want := []models.EnvReplace{
+		{From: "localhost:15432", To: "db.${CILO_ENV}${CILO_DNS_SUFFIX}:5432"},
+		{From: "127.0.0.1:9200", To: "search.${CILO_ENV}${CILO_DNS_SUFFIX}:9200"},
+	}
+	if got := p.EnvRender[0].Replace; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf(".env replacements = %+v, want %+v", got, want)
+	}
+	if len(p.Notes) != 1 || !strings.Contains(p.Notes[0], "localhost:9999") {
+		t.Fatalf("Notes = %v, want the unmatched port", p.Notes)
+	}
+}
+
+func TestDetectProcfile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"Procfile": "web: npm start\n", "package.json": "{}\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	p, err := Detect(dir, nil)
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	if p.Format != "procfile" || strings.Join(p.Services, ",") != "web" {
+		t.Fatalf("Format = %q, Services = %v; want the Procfile's web", p.Format, p.Services)
+	}
+	// Detecting only reads the project
+	if _, err := os.Stat(filepath.Join(dir, ".cilo")); !os.IsNotExist(err) {
+		t.Fatalf("Detect wrote to the project's .cilo directory (%v)", err)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	cfg := &models.ProjectConfig{
+		Project:               "shop",
+		ComposeFiles:          []string{"compose.yaml"},
+		DefaultIngressService: "proxy",
+		SharedServices:        []string{"search"},
+		CopyDotDirs:           []string{".cilo"},
+	}
+	proposal := &Proposal{
+		Ingress:       "proxy",
+		IngressReason: "runs traefik, a reverse proxy",
+		SharedReasons: map[string]string{"search": "elasticsearch is heavy to run per environment"},
+		Notes:         []string{"localhost:9999 in .env matches no service; left as is"},
+	}
+	data, err := Marshal(cfg, proposal)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	for _, want := range []string{
+		"\n# Detected: proxy (runs traefik, a reverse proxy)\ndefault_ingress_service: proxy\n",
+		"# Detected: search (elasticsearch",
+		"\n\n# Dot directories copied",
+		"# - localhost:9999",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("config missing %q:\n%s", want, data)
+		}
+	}
+
+	// The comments don't change what the config says
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".cilo"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".cilo", "config.yml"), data, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	loaded, err := models.LoadProjectConfigFromPath(dir)
+	if err != nil {
+		t.Fatalf("LoadProjectConfigFromPath: %v", err)
+	}
+	if loaded.Project != "shop" || loaded.DefaultIngressService != "proxy" || len(loaded.SharedServices) != 1 {
+		t.Fatalf("loaded = %+v, want the marshalled config", loaded)
+	}
+}
@@ -0,0 +1,89 @@
+package setup
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// comments explain each top-level key of a written config
+var comments = map[string]string{
+	"project":                 "Project name, used in DNS names: <project>.<env>.test",
+	"build_tool":              "Container tool: docker, podman or nerdctl",
+	"compose_files":           "Compose files, in the order compose reads them. Empty runs the devcontainer.json\nor Procfile found on each up.",
+	"env_files":               "Env files passed to docker compose",
+	"dns_suffix":              "Suffix of every DNS name (default .test)",
+	"default_environment":     "Environment commands use when none is named",
+	"default_ingress_service": "Service answering <project>.<env>.test, unless a service is labelled cilo.ingress",
+	"hostnames":               "Extra names of the ingress service, served as <hostname>.<project>.<env>.test",
+	"shared_services":         "Services run once and shared by every environment, as if labelled cilo.share.\nOverride per up with --shared and --isolate.",
+	"copy_dot_dirs":           "Dot directories copied into workspaces; others are skipped",
+	"env":                     "Env files rewritten in each workspace on up, so URLs reach that\nenvironment's services. Tokens: ${CILO_ENV}, ${CILO_PROJECT}, ${CILO_DNS_SUFFIX}\nand ${CILO_BASE_URL}.",
+}
+
+// Marshal returns cfg as YAML with a comment above each setting. proposal,
+// when given, adds why setup chose the ingress and shared services, and
+// what it couldn't act on.
+func Marshal(cfg *models.ProjectConfig, proposal *Proposal) ([]byte, error) {
+	var doc yaml.Node
+	if err := doc.Encode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		key := doc.Content[i]
+		comment := comments[key.Value]
+		if proposal != nil {
+			comment = withReasons(comment, key.Value, cfg, proposal)
+		}
+		key.HeadComment = comment
+	}
+	if proposal != nil && len(proposal.Notes) > 0 {
+		doc.FootComment = "Setup notes:\n- " + strings.Join(proposal.Notes, "\n- ")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# cilo project config, written by 'cilo setup'.\n# See docs/OPERATIONS.md for hooks, resources, idle and ttl.\n\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return spaceSections(buf.Bytes()), nil
+}
+
+// withReasons adds why setup proposed a setting's value to its comment
+func withReasons(comment, key string, cfg *models.ProjectConfig, p *Proposal) string {
+	switch key {
+	case "default_ingress_service":
+		if cfg.DefaultIngressService == p.Ingress && p.IngressReason != "" {
+			comment += fmt.Sprintf("\nDetected: %s (%s)", p.Ingress, p.IngressReason)
+		}
+	case "shared_services":
+		for _, name := range cfg.SharedServices {
+			if reason := p.SharedReasons[name]; reason != "" {
+				comment += fmt.Sprintf("\nDetected: %s (%s)", name, reason)
+			}
+		}
+	}
+	return comment
+}
+
+// spaceSections puts a blank line before each top-level comment that
+// follows a setting, so each section stands apart
+func spaceSections(data []byte) []byte {
+	lines := strings.Split(string(data), "\n")
+	out := make([]string, 0, len(lines))
+	for i, line := range lines {
+		if i > 0 && strings.HasPrefix(line, "#") && lines[i-1] != "" && !strings.HasPrefix(lines[i-1], "#") {
+			out = append(out, "")
+		}
+		out = append(out, line)
+	}
+	return []byte(strings.Join(out, "\n"))
+}
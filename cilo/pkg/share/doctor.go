@@ -3,6 +3,7 @@ package share
 import (
 	"context"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/sharedco/cilo/pkg/models"
@@ -79,8 +80,9 @@ func CheckSharedServices(st *models.State, provider runtime.Provider, ctx contex
 	return issues, nil
 }
 
-// FixOrphanedServices stops and removes orphaned shared service containers
-func FixOrphanedServices(st *models.State, provider runtime.Provider, ctx context.Context) (int, error) {
+// FixOrphanedServices stops and removes orphaned shared service containers,
+// writing a warning to warnings for each one it can't
+func FixOrphanedServices(st *models.State, provider runtime.Provider, ctx context.Context, warnings io.Writer) (int, error) {
 	fixed := 0
 
 	for key, sharedSvc := range st.SharedServices {
@@ -94,13 +96,13 @@ func FixOrphanedServices(st *models.State, provider runtime.Provider, ctx contex
 			if exists {
 				// Stop container
 				if err := provider.StopContainer(ctx, sharedSvc.Container); err != nil {
-					fmt.Printf("Warning: failed to stop %s: %v\n", sharedSvc.Container, err)
+					fmt.Fprintf(warnings, "Warning: failed to stop %s: %v\n", sharedSvc.Container, err)
 					continue
 				}
 
 				// Remove container
 				if err := provider.RemoveContainer(ctx, sharedSvc.Container); err != nil {
-					fmt.Printf("Warning: failed to remove %s: %v\n", sharedSvc.Container, err)
+					fmt.Fprintf(warnings, "Warning: failed to remove %s: %v\n", sharedSvc.Container, err)
 					continue
 				}
 
@@ -114,8 +116,9 @@ func FixOrphanedServices(st *models.State, provider runtime.Provider, ctx contex
 	return fixed, nil
 }
 
-// FixStaleGracePeriods cleans up services past their grace period
-func FixStaleGracePeriods(st *models.State, provider runtime.Provider, ctx context.Context) (int, error) {
+// FixStaleGracePeriods cleans up services past their grace period, writing
+// a warning to warnings for each container it can't remove
+func FixStaleGracePeriods(st *models.State, provider runtime.Provider, ctx context.Context, warnings io.Writer) (int, error) {
 	fixed := 0
 
 	for key, sharedSvc := range st.SharedServices {
@@ -129,13 +132,13 @@ func FixStaleGracePeriods(st *models.State, provider runtime.Provider, ctx conte
 			if exists {
 				// Stop container
 				if err := provider.StopContainer(ctx, sharedSvc.Container); err != nil {
-					fmt.Printf("Warning: failed to stop %s: %v\n", sharedSvc.Container, err)
+					fmt.Fprintf(warnings, "Warning: failed to stop %s: %v\n", sharedSvc.Container, err)
 					continue
 				}
 
 				// Remove container
 				if err := provider.RemoveContainer(ctx, sharedSvc.Container); err != nil {
-					fmt.Printf("Warning: failed to remove %s: %v\n", sharedSvc.Container, err)
+					fmt.Fprintf(warnings, "Warning: failed to remove %s: %v\n", sharedSvc.Container, err)
 					continue
 				}
 			}
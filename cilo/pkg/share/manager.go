@@ -5,13 +5,16 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/sharedco/cilo/pkg/compose"
 	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/ready"
 	"github.com/sharedco/cilo/pkg/runtime"
 	"github.com/sharedco/cilo/pkg/state"
 	"gopkg.in/yaml.v3"
@@ -21,16 +24,27 @@ import (
 type Manager struct {
 	provider runtime.Provider
 	ctx      context.Context
+	stdout   io.Writer
+	stderr   io.Writer
 }
 
-// NewManager creates a new shared service manager
+// NewManager creates a new shared service manager. Its output goes to
+// os.Stdout and os.Stderr until SetOutput says otherwise.
 func NewManager(provider runtime.Provider, ctx context.Context) *Manager {
 	return &Manager{
 		provider: provider,
 		ctx:      ctx,
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
 	}
 }
 
+// SetOutput sends the manager's progress and docker's output to stdout,
+// and warnings and docker's errors to stderr
+func (m *Manager) SetOutput(stdout, stderr io.Writer) {
+	m.stdout, m.stderr = stdout, stderr
+}
+
 // EnsureSharedService creates or returns existing shared container
 // Returns: container name, IP address, error
 func (m *Manager) EnsureSharedService(serviceName, project string, composeFiles []string) (containerName, ip string, err error) {
@@ -141,6 +155,9 @@ func (m *Manager) createSharedService(serviceName, project string, composeFiles
 	if serviceConfig.WorkingDir != "" {
 		service["working_dir"] = serviceConfig.WorkingDir
 	}
+	if serviceConfig.Healthcheck != nil {
+		service["healthcheck"] = serviceConfig.Healthcheck
+	}
 
 	// Write to temp file
 	composeData, err := yaml.Marshal(sharedComposeFile)
@@ -155,21 +172,28 @@ func (m *Manager) createSharedService(serviceName, project string, composeFiles
 
 	// Start the container using docker compose
 	cmd := exec.CommandContext(m.ctx, "docker", "compose", "-f", composePath, "up", "-d")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = m.stdout
+	cmd.Stderr = m.stderr
 	if err := cmd.Run(); err != nil {
 		return "", "", fmt.Errorf("failed to start shared service: %w", err)
 	}
 
-	// Wait a moment for container to initialize
-	time.Sleep(2 * time.Second)
-
 	// Get the container IP
 	ip, err = m.getContainerPrimaryIP(containerName)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to get container IP: %w", err)
 	}
 
+	// Environments connecting to it expect it to be usable, not just started
+	target := ready.Target{Service: serviceName, Container: containerName, IP: ip}
+	if services, err := compose.LoadServices(composeFiles); err == nil && services[serviceName] != nil {
+		target.Labels = services[serviceName].Labels
+	}
+	fmt.Fprintf(m.stdout, "  Waiting for shared service %s to be ready...\n", serviceName)
+	if err := ready.Wait(m.ctx, m.provider, []ready.Target{target}, ready.Options{}); err != nil {
+		return "", "", err
+	}
+
 	return containerName, ip, nil
 }
 
@@ -393,10 +417,10 @@ func (m *Manager) StopSharedServiceIfUnused(serviceName, project string) error {
 	if len(sharedService.UsedBy) == 0 && !sharedService.DisconnectTimeout.IsZero() && time.Now().After(sharedService.DisconnectTimeout) {
 		// Stop and remove the container
 		if err := m.provider.StopContainer(m.ctx, sharedService.Container); err != nil {
-			fmt.Printf("Warning: failed to stop shared service container: %v\n", err)
+			fmt.Fprintf(m.stderr, "Warning: failed to stop shared service container: %v\n", err)
 		}
 		if err := m.provider.RemoveContainer(m.ctx, sharedService.Container); err != nil {
-			fmt.Printf("Warning: failed to remove shared service container: %v\n", err)
+			fmt.Fprintf(m.stderr, "Warning: failed to remove shared service container: %v\n", err)
 		}
 
 		// Remove from state
@@ -444,8 +468,8 @@ func (m *Manager) getContainerPrimaryIP(containerName string) (string, error) {
 // startContainer starts a stopped container
 func (m *Manager) startContainer(containerName string) error {
 	cmd := exec.CommandContext(m.ctx, "docker", "start", containerName)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = m.stdout
+	cmd.Stderr = m.stderr
 	return cmd.Run()
 }
 
@@ -0,0 +1,22 @@
+package state
+
+import (
+	"time"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+// RecordActivity notes that an environment was used at the given time. An
+// earlier time than the one recorded is ignored.
+func RecordActivity(project, name string, at time.Time) error {
+	return WithLock(func(state *models.State) error {
+		_, env := findEnvironment(state, makeEnvKey(project, name))
+		if env == nil {
+			return errorOf(ErrNotFound, "environment %q does not exist in project %q", name, project)
+		}
+		if at.After(env.LastActivity) {
+			env.LastActivity = at.UTC()
+		}
+		return nil
+	})
+}
@@ -0,0 +1,80 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+// stateBackups is how many replaced versions of state.json are kept, as
+// state.json.1 (the newest) to state.json.5
+const stateBackups = 5
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// backupState keeps the state file about to be replaced as state.json.1,
+// shifting older backups along and dropping the oldest
+func backupState(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	for n := stateBackups - 1; n >= 1; n-- {
+		if err := os.Rename(backupPath(path, n), backupPath(path, n+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate state backups: %w", err)
+		}
+	}
+
+	// The new state is renamed over path, so a hard link keeps the old
+	// file without copying it
+	if err := os.Link(path, backupPath(path, 1)); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = os.WriteFile(backupPath(path, 1), data, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to back up state: %w", err)
+	}
+	return nil
+}
+
+// LoadBackup loads the newest state backup that can be parsed, returning
+// it with its path. It fails with ErrNotInitialized if there is none.
+func LoadBackup() (*models.State, string, error) {
+	path := getStatePath()
+	for n := 1; n <= stateBackups; n++ {
+		data, err := os.ReadFile(backupPath(path, n))
+		if err != nil {
+			continue
+		}
+		if state, err := parseState(data); err == nil {
+			return state, backupPath(path, n), nil
+		}
+	}
+	return nil, "", ErrNotInitialized
+}
+
+// Restore replaces the state file with state, as 'cilo state repair' does.
+// A state file that can't be parsed is moved aside to
+// state.json.corrupt-<time> rather than kept as state.json.1, where it
+// would push a good backup out; Restore returns where it went, or "".
+func Restore(state *models.State) (string, error) {
+	var corrupt string
+	err := withFileLock(func() error {
+		path := getStatePath()
+		if _, err := LoadState(); errors.Is(err, ErrCorrupt) {
+			corrupt = fmt.Sprintf("%s.corrupt-%s", path, time.Now().UTC().Format("20060102T150405Z"))
+			if err := os.Rename(path, corrupt); err != nil {
+				return fmt.Errorf("failed to move corrupt state aside: %w", err)
+			}
+		}
+		return atomicWriteState(state)
+	})
+	return corrupt, err
+}
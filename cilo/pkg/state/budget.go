@@ -0,0 +1,69 @@
+package state
+
+import (
+	"github.com/sharedco/cilo/pkg/models"
+	"github.com/sharedco/cilo/pkg/resources"
+)
+
+// GetBudget returns the local host's resource budget, or nil if none is set
+func GetBudget() (*models.HostBudget, error) {
+	state, err := LoadState()
+	if err != nil {
+		return nil, err
+	}
+	return getLocalHost(state).Budget, nil
+}
+
+// SetBudget replaces the local host's resource budget; nil removes it
+func SetBudget(budget *models.HostBudget) error {
+	return WithLock(func(state *models.State) error {
+		getLocalHost(state).Budget = budget
+		return nil
+	})
+}
+
+// GetUsage returns what the local host's running environments use together
+func GetUsage() (resources.Usage, error) {
+	state, err := LoadState()
+	if err != nil {
+		return resources.Usage{}, err
+	}
+	return resources.HostUsage(getLocalHost(state), ""), nil
+}
+
+// AdmitEnvironment checks that env fits in its host's budget alongside
+// the environments already running and, if it does, records it as starting
+// so concurrent admissions see it. It returns an error wrapping
+// ErrOverBudget when env doesn't fit. Other environments left starting by
+// an up that died part way are released first (see releaseStale).
+func AdmitEnvironment(env *models.Environment) error {
+	return WithLock(func(state *models.State) error {
+		key := makeEnvKey(env.Project, env.Name)
+		host, existing := findEnvironment(state, key)
+		if existing == nil {
+			return errorOf(ErrNotFound, "environment %q does not exist in project %q", env.Name, env.Project)
+		}
+		releaseStale(host, key)
+
+		usage := resources.HostUsage(host, key)
+		if err := resources.CheckBudget(host.Budget, usage, env); err != nil {
+			return errorOf(ErrOverBudget, "host budget exhausted: %v", err)
+		}
+
+		env.Status = "starting"
+		host.Environments[key] = env
+		return nil
+	})
+}
+
+// releaseStale marks environments of host as stopped when they're still
+// starting but no process holds their operation lock, i.e. the up that
+// admitted them exited without finishing. Their reservation would
+// otherwise count against the budget forever.
+func releaseStale(host *models.Host, exclude string) {
+	for key, env := range host.Environments {
+		if key != exclude && env.Status == "starting" && !operationRunning(env.Project, env.Name) {
+			env.Status = "stopped"
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors callers can match with errors.Is
+var (
+	ErrNotInitialized = errors.New("cilo not initialized (run 'cilo init')")
+	ErrNotFound       = errors.New("environment does not exist")
+	ErrAlreadyExists  = errors.New("environment already exists")
+	ErrOverBudget     = errors.New("host resource budget exhausted")
+	ErrCorrupt        = errors.New("state file is corrupt")
+)
+
+// kindError keeps a specific message while matching a sentinel
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+func errorOf(kind error, format string, args ...interface{}) error {
+	return &kindError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
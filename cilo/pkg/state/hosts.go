@@ -0,0 +1,78 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+// AddHost registers a remote host to run environments on
+func AddHost(host *models.Host) error {
+	if host.ID == "" || host.ID == LocalHostID || strings.ContainsAny(host.ID, "/ ") {
+		return fmt.Errorf("invalid host ID %q", host.ID)
+	}
+	return WithLock(func(state *models.State) error {
+		if _, exists := state.Hosts[host.ID]; exists {
+			return errorOf(ErrAlreadyExists, "host %q is already registered", host.ID)
+		}
+		if host.Provider == "" {
+			host.Provider = "docker"
+		}
+		if host.Environments == nil {
+			host.Environments = make(map[string]*models.Environment)
+		}
+		state.Hosts[host.ID] = host
+		return nil
+	})
+}
+
+// RemoveHost unregisters a remote host. Its environments must have been
+// destroyed first.
+func RemoveHost(id string) error {
+	if id == LocalHostID {
+		return fmt.Errorf("the local host can't be removed")
+	}
+	return WithLock(func(state *models.State) error {
+		host, exists := state.Hosts[id]
+		if !exists {
+			return errorOf(ErrNotFound, "host %q is not registered", id)
+		}
+		if len(host.Environments) > 0 {
+			return errorOf(ErrAlreadyExists, "host %q still has %d environments (destroy them first)", id, len(host.Environments))
+		}
+		delete(state.Hosts, id)
+		return nil
+	})
+}
+
+// GetHost returns a registered host. An empty id means the local host.
+func GetHost(id string) (*models.Host, error) {
+	state, err := LoadState()
+	if err != nil {
+		return nil, err
+	}
+	return hostOf(state, &models.Environment{Host: id})
+}
+
+// ListHosts returns every host, the local one first
+func ListHosts() ([]*models.Host, error) {
+	state, err := LoadState()
+	if err != nil {
+		return nil, err
+	}
+	getLocalHost(state)
+
+	hosts := make([]*models.Host, 0, len(state.Hosts))
+	for _, host := range state.Hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if (hosts[i].ID == LocalHostID) != (hosts[j].ID == LocalHostID) {
+			return hosts[i].ID == LocalHostID
+		}
+		return hosts[i].ID < hosts[j].ID
+	})
+	return hosts, nil
+}
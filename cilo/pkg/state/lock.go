@@ -16,6 +16,39 @@ const lockTimeout = 30 * time.Second
 
 // WithLock executes fn with exclusive state lock
 func WithLock(fn func(*models.State) error) error {
+	return withFileLock(func() error {
+		// Load current state (we already have the exclusive lock)
+		state, err := LoadState()
+		if err != nil {
+			return err
+		}
+
+		// Execute mutation
+		if err := fn(state); err != nil {
+			return err
+		}
+
+		// Save atomically
+		return atomicWriteState(state)
+	})
+}
+
+// WithReadLock runs fn on the current state while holding the state lock,
+// without saving it. fn can act on things outside state that must agree
+// with it, e.g. remove docker resources no environment owns before an
+// environment claims them.
+func WithReadLock(fn func(*models.State) error) error {
+	return withFileLock(func() error {
+		state, err := LoadState()
+		if err != nil {
+			return err
+		}
+		return fn(state)
+	})
+}
+
+// withFileLock runs fn holding the state file lock
+func withFileLock(fn func() error) error {
 	statePath := getStatePath()
 	lockPath := statePath + ".lock"
 	fileLock := flock.New(lockPath)
@@ -32,49 +65,11 @@ func WithLock(fn func(*models.State) error) error {
 	}
 	defer fileLock.Unlock()
 
-	// Load current state (without lock - we already have exclusive lock)
-	state, err := loadStateUnsafe()
-	if err != nil {
-		return err
-	}
-
-	// Execute mutation
-	if err := fn(state); err != nil {
-		return err
-	}
-
-	// Save atomically
-	return atomicWriteState(state)
-}
-
-// loadStateUnsafe loads state without acquiring lock (caller must hold lock)
-func loadStateUnsafe() (*models.State, error) {
-	path := getStatePath()
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("cilo not initialized (run 'cilo init')")
-		}
-		return nil, err
-	}
-
-	var state models.State
-	if err := json.Unmarshal(data, &state); err != nil {
-		return nil, fmt.Errorf("failed to parse state: %w", err)
-	}
-
-	// Initialize maps if nil
-	if state.Hosts == nil {
-		state.Hosts = make(map[string]*models.Host)
-	}
-	if state.SharedNetworks == nil {
-		state.SharedNetworks = make(map[string]*models.SharedNetwork)
-	}
-
-	return &state, nil
+	return fn()
 }
 
-// atomicWriteState writes state atomically using temp file + rename
+// atomicWriteState writes state atomically using temp file + rename, after
+// keeping the state it replaces as a backup (see backupState)
 func atomicWriteState(state *models.State) error {
 	path := getStatePath()
 	dir := filepath.Dir(path)
@@ -100,16 +95,83 @@ func atomicWriteState(state *models.State) error {
 		return fmt.Errorf("failed to write state: %w", err)
 	}
 
+	// Flush before the rename so a crash can't leave an empty state.json
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync state: %w", err)
+	}
+
 	if err := tmpFile.Close(); err != nil {
 		os.Remove(tmpPath)
 		return fmt.Errorf("failed to close temp file: %w", err)
 	}
 
+	if err := backupState(path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
 	// Atomic rename
 	if err := os.Rename(tmpPath, path); err != nil {
 		os.Remove(tmpPath)
 		return fmt.Errorf("failed to rename state file: %w", err)
 	}
 
+	// Persist the rename itself
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
 	return nil
 }
+
+// LockEnvironment takes the operation lock of one environment and returns
+// the function that releases it. Create, up, down, destroy and the other
+// lifecycle operations hold it from their first state read to their last
+// write, so two processes (or two API requests) can't interleave changes
+// to the same environment. Operations can run for minutes, so it's a lock
+// file of its own rather than the state lock, which still guards each
+// write. waiting, if set, is called once when another operation holds it;
+// the wait ends when ctx does.
+func LockEnvironment(ctx context.Context, project, name string, waiting func()) (func(), error) {
+	lockPath := envLockPath(project, name)
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	fileLock := flock.New(lockPath)
+
+	locked, err := fileLock.TryLock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock environment %s/%s: %w", project, name, err)
+	}
+	if !locked {
+		if waiting != nil {
+			waiting()
+		}
+		if _, err := fileLock.TryLockContext(ctx, 100*time.Millisecond); err != nil {
+			return nil, fmt.Errorf("failed to lock environment %s/%s: %w", project, name, err)
+		}
+	}
+	return func() { fileLock.Unlock() }, nil
+}
+
+// operationRunning reports whether some process holds the operation lock of
+// project/name
+func operationRunning(project, name string) bool {
+	fileLock := flock.New(envLockPath(project, name))
+	locked, err := fileLock.TryLock()
+	if err != nil {
+		// A missing lock directory means no operation ever ran
+		return false
+	}
+	if locked {
+		fileLock.Unlock()
+	}
+	return !locked
+}
+
+func envLockPath(project, name string) string {
+	return filepath.Join(filepath.Dir(getStatePath()), "locks", project, name+".lock")
+}
@@ -0,0 +1,77 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+// CurrentVersion is the state format this cilo reads and writes
+const CurrentVersion = 2
+
+// migrations upgrade state from the version they are keyed on to the next
+// one. State files written before Version existed count as version 1.
+var migrations = map[int]func(*models.State) error{
+	1: keyEnvironmentsByProject,
+}
+
+// migrate upgrades state in place to CurrentVersion. A state written by a
+// newer cilo is refused rather than rewritten without the fields this one
+// doesn't know.
+func migrate(state *models.State) error {
+	if state.Version == 0 {
+		state.Version = 1
+	}
+	if state.Version > CurrentVersion {
+		return fmt.Errorf("state version %d is newer than this cilo supports (%d); upgrade cilo", state.Version, CurrentVersion)
+	}
+	for state.Version < CurrentVersion {
+		upgrade, ok := migrations[state.Version]
+		if !ok {
+			return fmt.Errorf("no migration from state version %d", state.Version)
+		}
+		if err := upgrade(state); err != nil {
+			return fmt.Errorf("failed to migrate state from version %d: %w", state.Version, err)
+		}
+		state.Version++
+	}
+	return nil
+}
+
+// keyEnvironmentsByProject moves environments keyed by name alone, from
+// before projects, to project/name keys, and fills in fields version 1
+// hosts could leave empty
+func keyEnvironmentsByProject(state *models.State) error {
+	for id, host := range state.Hosts {
+		if host == nil {
+			delete(state.Hosts, id)
+			continue
+		}
+		if host.ID == "" {
+			host.ID = id
+		}
+		if host.Environments == nil {
+			host.Environments = make(map[string]*models.Environment)
+		}
+
+		for key, env := range host.Environments {
+			if env == nil {
+				delete(host.Environments, key)
+				continue
+			}
+			if env.Name == "" {
+				env.Name = key
+			}
+			want := makeEnvKey(env.Project, env.Name)
+			if key == want {
+				continue
+			}
+			if _, taken := host.Environments[want]; taken {
+				return fmt.Errorf("environments %q and %q both map to %q", key, want, want)
+			}
+			delete(host.Environments, key)
+			host.Environments[want] = env
+		}
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package state
+
+import (
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+// SetEnvironmentConfig stores the config overrides of an environment, a
+// YAML document layered over its workspace config. An empty one removes
+// them.
+func SetEnvironmentConfig(project, name, overrides string) error {
+	return WithLock(func(state *models.State) error {
+		_, env := findEnvironment(state, makeEnvKey(project, name))
+		if env == nil {
+			return errorOf(ErrNotFound, "environment %q does not exist in project %q", name, project)
+		}
+		env.Config = overrides
+		return nil
+	})
+}
@@ -38,20 +38,46 @@ func parseEnvKey(key string) (project, name string, err error) {
 	return parts[0], parts[1], nil
 }
 
+// LocalHostID is the ID of the host cilo runs on
+const LocalHostID = "local"
+
 // getLocalHost returns the local host, creating it if needed
 func getLocalHost(state *models.State) *models.Host {
-	host, exists := state.Hosts["local"]
+	host, exists := state.Hosts[LocalHostID]
 	if !exists {
 		host = &models.Host{
-			ID:           "local",
+			ID:           LocalHostID,
 			Provider:     "docker",
 			Environments: make(map[string]*models.Environment),
 		}
-		state.Hosts["local"] = host
+		state.Hosts[LocalHostID] = host
 	}
 	return host
 }
 
+// findEnvironment looks an environment up on every host, returning the
+// host it is on too. Both are nil if it doesn't exist.
+func findEnvironment(state *models.State, key string) (*models.Host, *models.Environment) {
+	for _, host := range state.Hosts {
+		if env, exists := host.Environments[key]; exists {
+			return host, env
+		}
+	}
+	return nil, nil
+}
+
+// hostOf returns the host an environment's Host field names
+func hostOf(state *models.State, env *models.Environment) (*models.Host, error) {
+	if env.Host == "" || env.Host == LocalHostID {
+		return getLocalHost(state), nil
+	}
+	host, exists := state.Hosts[env.Host]
+	if !exists {
+		return nil, errorOf(ErrNotFound, "host %q is not registered (see 'cilo host list')", env.Host)
+	}
+	return host, nil
+}
+
 // InitializeState creates initial state file if it doesn't exist
 func InitializeState(baseSubnetFlag string, dnsPortFlag int) error {
 	path := getStatePath()
@@ -91,11 +117,15 @@ func InitializeState(baseSubnetFlag string, dnsPortFlag int) error {
 		dnsPortFlag = 5354
 	}
 
-	st := &models.State{
-		Version:        2,
-		BaseSubnet:     baseSubnetFlag,
-		DNSPort:        dnsPortFlag,
-		SubnetCounter:  0,
+	return SaveState(New(baseSubnetFlag, dnsPortFlag))
+}
+
+// New returns an empty state with the local host
+func New(baseSubnet string, dnsPort int) *models.State {
+	return &models.State{
+		Version:    CurrentVersion,
+		BaseSubnet: baseSubnet,
+		DNSPort:    dnsPort,
 		Hosts: map[string]*models.Host{
 			"local": {
 				ID:           "local",
@@ -106,23 +136,29 @@ func InitializeState(baseSubnetFlag string, dnsPortFlag int) error {
 		SharedNetworks: make(map[string]*models.SharedNetwork),
 		SharedServices: make(map[string]*models.SharedService),
 	}
-	return SaveState(st)
 }
 
-// LoadState loads state from disk
+// LoadState loads state from disk, upgrading it to CurrentVersion. It
+// fails with an error wrapping ErrCorrupt if state.json can't be parsed.
 func LoadState() (*models.State, error) {
-	path := getStatePath()
-	data, err := os.ReadFile(path)
+	data, err := os.ReadFile(getStatePath())
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("cilo not initialized (run 'cilo init')")
+			return nil, ErrNotInitialized
 		}
 		return nil, err
 	}
+	return parseState(data)
+}
 
+// parseState decodes and upgrades a state file
+func parseState(data []byte) (*models.State, error) {
 	var state models.State
 	if err := json.Unmarshal(data, &state); err != nil {
-		return nil, fmt.Errorf("failed to parse state: %w", err)
+		return nil, errorOf(ErrCorrupt, "failed to parse state: %v (run 'cilo state repair')", err)
+	}
+	if err := migrate(&state); err != nil {
+		return nil, err
 	}
 
 	// Initialize maps if nil
@@ -139,19 +175,12 @@ func LoadState() (*models.State, error) {
 	return &state, nil
 }
 
-// SaveState saves state to disk
+// SaveState replaces the state on disk. Changes to state already on disk
+// should go through WithLock instead, so concurrent changes aren't lost.
 func SaveState(state *models.State) error {
-	path := getStatePath()
-	data, err := json.MarshalIndent(state, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		return err
-	}
-
-	return nil
+	return withFileLock(func() error {
+		return atomicWriteState(state)
+	})
 }
 
 // GetEnvironment retrieves an environment by project and name
@@ -161,11 +190,9 @@ func GetEnvironment(project, name string) (*models.Environment, error) {
 		return nil, err
 	}
 
-	key := makeEnvKey(project, name)
-	host := getLocalHost(state)
-	env, exists := host.Environments[key]
-	if !exists {
-		return nil, fmt.Errorf("environment %q does not exist in project %q", name, project)
+	_, env := findEnvironment(state, makeEnvKey(project, name))
+	if env == nil {
+		return nil, errorOf(ErrNotFound, "environment %q does not exist in project %q", name, project)
 	}
 
 	return env, nil
@@ -178,10 +205,9 @@ func GetEnvironmentByKey(key string) (*models.Environment, error) {
 		return nil, err
 	}
 
-	host := getLocalHost(state)
-	env, exists := host.Environments[key]
-	if !exists {
-		return nil, fmt.Errorf("environment %q does not exist", key)
+	_, env := findEnvironment(state, key)
+	if env == nil {
+		return nil, errorOf(ErrNotFound, "environment %q does not exist", key)
 	}
 
 	return env, nil
@@ -194,29 +220,37 @@ func EnvironmentExists(project, name string) (bool, error) {
 		return false, err
 	}
 
-	key := makeEnvKey(project, name)
-	host := getLocalHost(state)
-	_, exists := host.Environments[key]
-	return exists, nil
+	_, env := findEnvironment(state, makeEnvKey(project, name))
+	return env != nil, nil
 }
 
-// CreateEnvironment creates a new environment and allocates resources
-func CreateEnvironment(name string, source string, project string) (*models.Environment, error) {
+// CreateEnvironment creates a new environment on a host and allocates
+// resources. An empty hostID means the local host.
+func CreateEnvironment(name string, source string, project string, hostID string) (*models.Environment, error) {
 	var env *models.Environment
 
 	err := WithLock(func(state *models.State) error {
-		host := getLocalHost(state)
 		key := makeEnvKey(project, name)
-		if _, exists := host.Environments[key]; exists {
-			return fmt.Errorf("environment %q already exists in project %q", name, project)
+		if _, existing := findEnvironment(state, key); existing != nil {
+			return errorOf(ErrAlreadyExists, "environment %q already exists in project %q", name, project)
+		}
+		if hostID == LocalHostID {
+			hostID = ""
+		}
+		host, err := hostOf(state, &models.Environment{Host: hostID})
+		if err != nil {
+			return err
 		}
 
 		if err := validateName(name); err != nil {
 			return err
 		}
 
-		state.SubnetCounter++
-		subnet := fmt.Sprintf("%s%d.0/24", state.BaseSubnet, state.SubnetCounter)
+		index, err := allocateSubnet(state, 0)
+		if err != nil {
+			return err
+		}
+		subnet := fmt.Sprintf("%s%d.0/24", state.BaseSubnet, index)
 
 		// Check for collisions with existing Docker networks
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -224,11 +258,14 @@ func CreateEnvironment(name string, source string, project string) (*models.Envi
 
 		collision, collidingNet, err := network.CheckSubnetCollision(ctx, subnet)
 		if err != nil {
-			fmt.Printf("Warning: could not check subnet collision: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Warning: could not check subnet collision: %v\n", err)
 		} else if collision {
-			fmt.Printf("Subnet %s conflicts with Docker network %s, trying next...\n", subnet, collidingNet)
-			state.SubnetCounter++
-			subnet = fmt.Sprintf("%s%d.0/24", baseSubnet, state.SubnetCounter)
+			fmt.Fprintf(os.Stderr, "Subnet %s conflicts with Docker network %s, trying next...\n", subnet, collidingNet)
+			index, err = allocateSubnet(state, index)
+			if err != nil {
+				return err
+			}
+			subnet = fmt.Sprintf("%s%d.0/24", state.BaseSubnet, index)
 
 			collision2, collidingNet2, _ := network.CheckSubnetCollision(ctx, subnet)
 			if collision2 {
@@ -239,6 +276,7 @@ func CreateEnvironment(name string, source string, project string) (*models.Envi
 		env = &models.Environment{
 			Name:      name,
 			Project:   project,
+			Host:      hostID,
 			CreatedAt: time.Now(),
 			Subnet:    subnet,
 			Status:    "created",
@@ -253,31 +291,30 @@ func CreateEnvironment(name string, source string, project string) (*models.Envi
 	return env, err
 }
 
-// UpdateEnvironment updates an environment in state
+// UpdateEnvironment updates an environment in state, on the host its Host
+// field names
 func UpdateEnvironment(env *models.Environment) error {
 	return WithLock(func(state *models.State) error {
-		host := getLocalHost(state)
-		key := makeEnvKey(env.Project, env.Name)
-		host.Environments[key] = env
+		host, err := hostOf(state, env)
+		if err != nil {
+			return err
+		}
+		host.Environments[makeEnvKey(env.Project, env.Name)] = env
 		return nil
 	})
 }
 
 // DeleteEnvironment removes an environment from state
 func DeleteEnvironment(project, name string) error {
-	return WithLock(func(state *models.State) error {
-		host := getLocalHost(state)
-		key := makeEnvKey(project, name)
-		delete(host.Environments, key)
-		return nil
-	})
+	return DeleteEnvironmentByKey(makeEnvKey(project, name))
 }
 
 // DeleteEnvironmentByKey removes an environment by its full key
 func DeleteEnvironmentByKey(key string) error {
 	return WithLock(func(state *models.State) error {
-		host := getLocalHost(state)
-		delete(host.Environments, key)
+		for _, host := range state.Hosts {
+			delete(host.Environments, key)
+		}
 		return nil
 	})
 }
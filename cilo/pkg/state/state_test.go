@@ -0,0 +1,241 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sharedco/cilo/pkg/config"
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+func setupState(t *testing.T) {
+	t.Helper()
+	t.Setenv("CILO_USER_HOME", t.TempDir())
+	if err := os.MkdirAll(config.GetCiloHome(), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := InitializeState("10.224.", 5354); err != nil {
+		t.Fatalf("InitializeState: %v", err)
+	}
+}
+
+func TestLoadStateMigrates(t *testing.T) {
+	setupState(t)
+	v1 := `{"hosts": {"local": {"environments": {
+		"dev": {"name": "dev", "project": "shop", "subnet": "10.224.1.0/24"},
+		"shop/api": {"name": "api", "project": "shop", "subnet": "10.224.2.0/24"}
+	}}}}`
+	if err := os.WriteFile(getStatePath(), []byte(v1), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	st, err := LoadState()
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if st.Version != CurrentVersion || st.SharedServices == nil {
+		t.Fatalf("state not upgraded: version %d", st.Version)
+	}
+	host := st.Hosts["local"]
+	if host.ID != "local" || host.Environments["shop/dev"] == nil || host.Environments["shop/api"] == nil || len(host.Environments) != 2 {
+		t.Fatalf("environments not rekeyed: %v", host.Environments)
+	}
+
+	if err := os.WriteFile(getStatePath(), []byte(`{"version": 99, "hosts": {}}`), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := LoadState(); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("LoadState of a newer state = %v", err)
+	}
+}
+
+func TestStateBackups(t *testing.T) {
+	setupState(t)
+	for i := 0; i < stateBackups+2; i++ {
+		if err := SetBudget(&models.HostBudget{MaxRunningEnvs: i + 1}); err != nil {
+			t.Fatalf("SetBudget: %v", err)
+		}
+	}
+	if _, err := os.Stat(backupPath(getStatePath(), stateBackups)); err != nil {
+		t.Fatalf("oldest backup missing: %v", err)
+	}
+	if _, err := os.Stat(backupPath(getStatePath(), stateBackups+1)); !os.IsNotExist(err) {
+		t.Fatalf("kept more than %d backups", stateBackups)
+	}
+
+	// A truncated state file is reported, and the newest backup is the
+	// state before the last change
+	if err := os.WriteFile(getStatePath(), []byte(`{"version": 2, "ho`), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := LoadState(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("LoadState of a truncated state = %v, want ErrCorrupt", err)
+	}
+	if err := SetBudget(nil); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("WithLock on a truncated state = %v, want ErrCorrupt", err)
+	}
+	backup, path, err := LoadBackup()
+	if err != nil {
+		t.Fatalf("LoadBackup: %v", err)
+	}
+	if filepath.Base(path) != "state.json.1" || backup.Hosts["local"].Budget.MaxRunningEnvs != stateBackups+1 {
+		t.Fatalf("LoadBackup = %s with budget %+v", path, backup.Hosts["local"].Budget)
+	}
+
+	// Restoring moves the truncated file aside instead of into the backups
+	corrupt, err := Restore(backup)
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if data, err := os.ReadFile(corrupt); err != nil || string(data) != `{"version": 2, "ho` {
+		t.Fatalf("corrupt copy %s = %q, %v", corrupt, data, err)
+	}
+	if _, path, err := LoadBackup(); err != nil || filepath.Base(path) != "state.json.1" {
+		t.Fatalf("LoadBackup after Restore = %s, %v", path, err)
+	}
+	restored, err := LoadState()
+	if err != nil || restored.Hosts["local"].Budget.MaxRunningEnvs != stateBackups+1 {
+		t.Fatalf("LoadState after Restore = %+v, %v", restored, err)
+	}
+}
+
+func TestHosts(t *testing.T) {
+	setupState(t)
+	if err := AddHost(&models.Host{ID: "build1", DockerHost: "ssh://me@build1"}); err != nil {
+		t.Fatalf("AddHost: %v", err)
+	}
+	if err := AddHost(&models.Host{ID: "build1"}); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("second AddHost: %v, want ErrAlreadyExists", err)
+	}
+	if err := AddHost(&models.Host{ID: LocalHostID}); err == nil {
+		t.Fatal("AddHost(local) succeeded")
+	}
+
+	if _, err := CreateEnvironment("api", "/src/shop", "shop", "build1"); err != nil {
+		t.Fatalf("CreateEnvironment: %v", err)
+	}
+	if _, err := CreateEnvironment("api", "/src/shop", "shop", ""); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("CreateEnvironment on another host: %v, want ErrAlreadyExists", err)
+	}
+	if _, err := CreateEnvironment("web", "/src/shop", "shop", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("CreateEnvironment on unknown host: %v, want ErrNotFound", err)
+	}
+
+	env, err := GetEnvironment("shop", "api")
+	if err != nil {
+		t.Fatalf("GetEnvironment: %v", err)
+	}
+	if env.Host != "build1" {
+		t.Fatalf("Host = %q, want build1", env.Host)
+	}
+	env.Status = "running"
+	if err := UpdateEnvironment(env); err != nil {
+		t.Fatalf("UpdateEnvironment: %v", err)
+	}
+
+	st, err := LoadState()
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if got := st.Hosts["build1"].Environments["shop/api"]; got == nil || got.Status != "running" {
+		t.Fatalf("build1 environments = %+v, want shop/api running", st.Hosts["build1"].Environments)
+	}
+	if len(st.Hosts[LocalHostID].Environments) != 0 {
+		t.Fatalf("local environments = %+v, want none", st.Hosts[LocalHostID].Environments)
+	}
+
+	if err := RemoveHost("build1"); err == nil {
+		t.Fatal("RemoveHost succeeded with an environment on the host")
+	}
+	if err := DeleteEnvironment("shop", "api"); err != nil {
+		t.Fatalf("DeleteEnvironment: %v", err)
+	}
+	if err := RemoveHost("build1"); err != nil {
+		t.Fatalf("RemoveHost: %v", err)
+	}
+	hosts, err := ListHosts()
+	if err != nil {
+		t.Fatalf("ListHosts: %v", err)
+	}
+	if len(hosts) != 1 || hosts[0].ID != LocalHostID {
+		t.Fatalf("hosts = %+v, want only local", hosts)
+	}
+}
+
+func TestLockEnvironment(t *testing.T) {
+	setupState(t)
+	unlock, err := LockEnvironment(context.Background(), "shop", "api", nil)
+	if err != nil {
+		t.Fatalf("LockEnvironment: %v", err)
+	}
+
+	// Another environment isn't held up
+	other, err := LockEnvironment(context.Background(), "shop", "web", nil)
+	if err != nil {
+		t.Fatalf("LockEnvironment other: %v", err)
+	}
+	other()
+
+	waited := false
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := LockEnvironment(ctx, "shop", "api", func() { waited = true }); err == nil {
+		t.Fatal("LockEnvironment succeeded while the lock was held")
+	}
+	if !waited {
+		t.Fatal("waiting wasn't called while the lock was held")
+	}
+
+	unlock()
+	again, err := LockEnvironment(context.Background(), "shop", "api", nil)
+	if err != nil {
+		t.Fatalf("LockEnvironment after unlock: %v", err)
+	}
+	again()
+}
+
+func TestAdmitReleasesStaleStarts(t *testing.T) {
+	setupState(t)
+	if err := SetBudget(&models.HostBudget{MaxRunningEnvs: 1}); err != nil {
+		t.Fatalf("SetBudget: %v", err)
+	}
+	var envs []*models.Environment
+	for _, name := range []string{"api", "web"} {
+		env, err := CreateEnvironment(name, "/src/shop", "shop", "")
+		if err != nil {
+			t.Fatalf("CreateEnvironment: %v", err)
+		}
+		envs = append(envs, env)
+	}
+	api, web := envs[0], envs[1]
+
+	// api's up is still running
+	unlock, err := LockEnvironment(context.Background(), "shop", "api", nil)
+	if err != nil {
+		t.Fatalf("LockEnvironment: %v", err)
+	}
+	if err := AdmitEnvironment(api); err != nil {
+		t.Fatalf("AdmitEnvironment api: %v", err)
+	}
+	if err := AdmitEnvironment(web); !errors.Is(err, ErrOverBudget) {
+		t.Fatalf("AdmitEnvironment web = %v, want ErrOverBudget", err)
+	}
+
+	// api's up died without releasing its reservation
+	unlock()
+	if err := AdmitEnvironment(web); err != nil {
+		t.Fatalf("AdmitEnvironment web after api's up exited: %v", err)
+	}
+	got, err := GetEnvironment("shop", "api")
+	if err != nil {
+		t.Fatalf("GetEnvironment: %v", err)
+	}
+	if got.Status != "stopped" {
+		t.Fatalf("api status = %q, want stopped", got.Status)
+	}
+}
@@ -0,0 +1,76 @@
+package state
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+// maxSubnetIndex is the last /24 under the base subnet
+const maxSubnetIndex = 255
+
+// allocateSubnet returns the lowest subnet index above after that no
+// environment uses, so subnets of destroyed environments are reused.
+// SubnetCounter tracks the highest index handed out.
+func allocateSubnet(state *models.State, after int) (int, error) {
+	index := nextFreeSubnet(state, after)
+	if index == 0 {
+		return 0, fmt.Errorf("no free subnets left under %s0.0/16", state.BaseSubnet)
+	}
+	if index > state.SubnetCounter {
+		state.SubnetCounter = index
+	}
+	return index, nil
+}
+
+// nextFreeSubnet returns the lowest unused subnet index above after, or 0
+// if there is none
+func nextFreeSubnet(state *models.State, after int) int {
+	used := make(map[int]bool)
+	for _, host := range state.Hosts {
+		for _, env := range host.Environments {
+			if index, ok := subnetIndex(state.BaseSubnet, env.Subnet); ok {
+				used[index] = true
+			}
+		}
+	}
+	for index := after + 1; index <= maxSubnetIndex; index++ {
+		if !used[index] {
+			return index
+		}
+	}
+	return 0
+}
+
+// subnetIndex extracts N from a "<base>N.0/24" subnet
+func subnetIndex(base, subnet string) (int, bool) {
+	rest, ok := strings.CutPrefix(subnet, base)
+	if !ok {
+		return 0, false
+	}
+	rest, ok = strings.CutSuffix(rest, ".0/24")
+	if !ok {
+		return 0, false
+	}
+	index, err := strconv.Atoi(rest)
+	return index, err == nil
+}
+
+// NextSubnet returns the subnet the next environment will get
+func NextSubnet(state *models.State) string {
+	return fmt.Sprintf("%s%d.0/24", state.BaseSubnet, nextFreeSubnet(state, 0))
+}
+
+// RecountSubnets raises SubnetCounter to the highest subnet index in use,
+// for state put together from elsewhere
+func RecountSubnets(state *models.State) {
+	for _, host := range state.Hosts {
+		for _, env := range host.Environments {
+			if index, ok := subnetIndex(state.BaseSubnet, env.Subnet); ok && index > state.SubnetCounter {
+				state.SubnetCounter = index
+			}
+		}
+	}
+}
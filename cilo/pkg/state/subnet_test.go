@@ -0,0 +1,39 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/sharedco/cilo/pkg/models"
+)
+
+func TestAllocateSubnet(t *testing.T) {
+	st := &models.State{
+		BaseSubnet:    "10.224.",
+		SubnetCounter: 3,
+		Hosts: map[string]*models.Host{
+			"local": {Environments: map[string]*models.Environment{
+				"shop/a": {Subnet: "10.224.1.0/24"},
+				"shop/c": {Subnet: "10.224.3.0/24"},
+			}},
+		},
+	}
+
+	// The subnet of a destroyed env is handed out again
+	if index, err := allocateSubnet(st, 0); err != nil || index != 2 {
+		t.Fatalf("allocateSubnet = %d, %v; want 2", index, err)
+	}
+	// Skipping past a collision
+	if index, err := allocateSubnet(st, 2); err != nil || index != 4 {
+		t.Fatalf("allocateSubnet after 2 = %d, %v; want 4", index, err)
+	}
+	if st.SubnetCounter != 4 {
+		t.Fatalf("SubnetCounter = %d, want 4", st.SubnetCounter)
+	}
+	if next := NextSubnet(st); next != "10.224.2.0/24" {
+		t.Fatalf("NextSubnet = %s", next)
+	}
+
+	if _, err := allocateSubnet(st, maxSubnetIndex); err == nil {
+		t.Fatalf("allocateSubnet past the last index succeeded")
+	}
+}